@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	s3v2 "github.com/aws/aws-sdk-go-v2/service/s3"
+	s3v2types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+var (
+	ErrACLUnsupported   = errors.New("public-read ACLs are only supported on S3 and GCS destinations")
+	ErrGrantUnsupported = errors.New("-grant-read is only supported on S3 destinations")
+)
+
+// a WriterOptions.BeforeWrite that makes the object readable by anyone.
+func publicRead(as func(interface{}) bool) error {
+	var v1 *s3manager.UploadInput
+	var v2 *s3v2.PutObjectInput
+	var gw *storage.Writer
+	switch {
+	case as(&v1):
+		v1.ACL = aws.String(s3.ObjectCannedACLPublicRead)
+	case as(&v2):
+		v2.ACL = s3v2types.ObjectCannedACLPublicRead
+	case as(&gw):
+		gw.PredefinedACL = "publicRead"
+	default:
+		return ErrACLUnsupported
+	}
+	return nil
+}
+
+// the S3 canonical user ids of -grant-read, which look like 64 hex digits.
+type grantList []string
+
+func (g *grantList) String() string {
+	return strings.Join(*g, ",")
+}
+
+func (g *grantList) Set(id string) error {
+	if !validCanonicalID(id) {
+		return fmt.Errorf("bad canonical user id %q. it's the 64 hex digits S3 shows for an account", id)
+	}
+	*g = append(*g, strings.ToLower(id))
+	return nil
+}
+
+func validCanonicalID(id string) bool {
+	if len(id) != 64 {
+		return false
+	}
+	for _, c := range strings.ToLower(id) {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// the x-amz-grant-read header for the ids, like id="abc…", id="def…".
+func (g grantList) header() string {
+	grants := make([]string, len(g))
+	for i, id := range g {
+		grants[i] = fmt.Sprintf("id=%q", id)
+	}
+	return strings.Join(grants, ", ")
+}
+
+// a WriterOptions.BeforeWrite that gives read access to the accounts in g. the
+// object's owner keeps full control.
+func grantRead(g grantList) func(func(interface{}) bool) error {
+	return func(as func(interface{}) bool) error {
+		var v1 *s3manager.UploadInput
+		var v2 *s3v2.PutObjectInput
+		switch {
+		case as(&v1):
+			v1.GrantRead = aws.String(g.header())
+		case as(&v2):
+			v2.GrantRead = aws.String(g.header())
+		default:
+			return ErrGrantUnsupported
+		}
+		return nil
+	}
+}
+
+// reports whether objects written to bkt can have grants.
+func grantSupported(bkt Bucket) bool {
+	var v1 *s3.S3
+	var v2 *s3v2.Client
+	return bkt.As(&v1) || bkt.As(&v2)
+}
+
+// reports whether objects written to bkt can be made public-read.
+func aclSupported(bkt Bucket) bool {
+	var v1 *s3.S3
+	var v2 *s3v2.Client
+	var gcs *storage.Client
+	return bkt.As(&v1) || bkt.As(&v2) || bkt.As(&gcs)
+}
+
+// runs several BeforeWrite hooks in order. nil hooks are left out.
+func chainBeforeWrite(hooks ...func(func(interface{}) bool) error) func(func(interface{}) bool) error {
+	var chain []func(func(interface{}) bool) error
+	for _, h := range hooks {
+		if h != nil {
+			chain = append(chain, h)
+		}
+	}
+	if len(chain) == 0 {
+		return nil
+	}
+	return func(as func(interface{}) bool) error {
+		for _, h := range chain {
+			if err := h(as); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	s3v2 "github.com/aws/aws-sdk-go-v2/service/s3"
+	s3v2types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"gocloud.dev/blob"
+)
+
+// an As func like a writer's, exposing v as the type it points to.
+func testAs[T any](v T) func(interface{}) bool {
+	return func(i interface{}) bool {
+		p, ok := i.(*T)
+		if ok {
+			*p = v
+		}
+		return ok
+	}
+}
+
+func TestPublicRead(t *testing.T) {
+	v1 := &s3manager.UploadInput{}
+	if err := publicRead(testAs(v1)); err != nil {
+		t.Fatal(err)
+	}
+	if aws.StringValue(v1.ACL) != "public-read" {
+		t.Errorf("unexpected v1 ACL %v", v1.ACL)
+	}
+
+	v2 := &s3v2.PutObjectInput{}
+	if err := publicRead(testAs(v2)); err != nil {
+		t.Fatal(err)
+	}
+	if v2.ACL != s3v2types.ObjectCannedACLPublicRead {
+		t.Errorf("unexpected v2 ACL %v", v2.ACL)
+	}
+
+	gw := &storage.Writer{}
+	if err := publicRead(testAs(gw)); err != nil {
+		t.Fatal(err)
+	}
+	if gw.PredefinedACL != "publicRead" {
+		t.Errorf("unexpected GCS ACL %q", gw.PredefinedACL)
+	}
+
+	if err := publicRead(func(interface{}) bool { return false }); !errors.Is(err, ErrACLUnsupported) {
+		t.Errorf("expected ErrACLUnsupported, got %v", err)
+	}
+	_, dbkt := testMemBuckets(t)
+	if aclSupported(dbkt) {
+		t.Error("memory buckets don't have ACLs")
+	}
+}
+
+func TestChainBeforeWrite(t *testing.T) {
+	if chainBeforeWrite(nil, nil) != nil {
+		t.Error("expected no hook without any hooks")
+	}
+	// the lock and the ACL both end up on the upload.
+	v1 := &s3manager.UploadInput{}
+	hook := chainBeforeWrite(objectLock{legalHold: true}.beforeWrite, nil, publicRead)
+	if err := hook(testAs(v1)); err != nil {
+		t.Fatal(err)
+	}
+	if aws.StringValue(v1.ACL) != "public-read" || aws.StringValue(v1.ObjectLockLegalHoldStatus) != "ON" {
+		t.Errorf("unexpected upload %v %v", v1.ACL, v1.ObjectLockLegalHoldStatus)
+	}
+	// the first failure stops the chain.
+	calls := 0
+	failing := func(func(interface{}) bool) error { return errInjected }
+	counting := func(func(interface{}) bool) error { calls++; return nil }
+	if err := chainBeforeWrite(failing, counting)(testAs(v1)); !errors.Is(err, errInjected) || calls != 0 {
+		t.Errorf("expected the chain to stop at the failure, got %v after %d calls", err, calls)
+	}
+}
+
+func TestGrantRead(t *testing.T) {
+	var g grantList
+	for _, id := range []string{"", "abc", strings.Repeat("g", 64), strings.Repeat("a", 63)} {
+		if err := g.Set(id); err == nil {
+			t.Errorf("%q: expected a bad canonical user id", id)
+		}
+	}
+	a, b := strings.Repeat("ab", 32), strings.Repeat("CD", 32)
+	if err := g.Set(a); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Set(b); err != nil {
+		t.Fatal(err)
+	}
+	want := `id="` + a + `", id="` + strings.ToLower(b) + `"`
+
+	v1 := &s3manager.UploadInput{}
+	if err := grantRead(g)(testAs(v1)); err != nil {
+		t.Fatal(err)
+	}
+	if aws.StringValue(v1.GrantRead) != want || v1.ACL != nil {
+		t.Errorf("unexpected v1 grant %v", v1.GrantRead)
+	}
+	v2 := &s3v2.PutObjectInput{}
+	if err := grantRead(g)(testAs(v2)); err != nil {
+		t.Fatal(err)
+	}
+	if aws.StringValue(v2.GrantRead) != want {
+		t.Errorf("unexpected v2 grant %v", v2.GrantRead)
+	}
+	if err := grantRead(g)(testAs(&storage.Writer{})); !errors.Is(err, ErrGrantUnsupported) {
+		t.Errorf("expected ErrGrantUnsupported for GCS, got %v", err)
+	}
+	_, mem := testMemBuckets(t)
+	if grantSupported(mem) {
+		t.Error("memory buckets don't have grants")
+	}
+}
+
+// every object copied to an S3 destination gets the grants.
+func TestGrantReadCopy(t *testing.T) {
+	ctx := context.Background()
+	sbkt, mem := testMemBuckets(t)
+	for _, key := range []string{"a", "b"} {
+		if err := sbkt.WriteAll(ctx, key, []byte(key), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ub := &s3UploadBucket{faultBucket: faultBucket{bkt: mem}, uploads: make(map[string]*s3manager.UploadInput)}
+	dbkt := blob.NewBucket(ub)
+	defer dbkt.Close()
+	if !grantSupported(dbkt) {
+		t.Fatal("expected an S3 bucket to support grants")
+	}
+	g := grantList{strings.Repeat("0", 64)}
+	errs := make(chan error)
+	go func() {
+		for err := range errs {
+			t.Error(err)
+		}
+	}()
+	if n := mirror(ctx, sbkt, dbkt, nil, mirrorOpts{grants: g}, errs); n != 2 {
+		t.Fatalf("expected 2 objects copied, got %d", n)
+	}
+	close(errs)
+	for _, key := range []string{"a", "b"} {
+		if in := ub.uploads[key]; in == nil || aws.StringValue(in.GrantRead) != g.header() {
+			t.Errorf("%s: expected the grant, got %+v", key, in)
+		}
+	}
+}
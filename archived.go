@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	s3v2 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"gocloud.dev/blob"
+)
+
+var ErrArchived = errors.New("archived and not restored, restore it first or use -skip-archived")
+
+// the S3 storage classes that can't be read until the object is restored.
+// glacier instant retrieval can be read directly, so it isn't one of them.
+var archiveClasses = map[string]bool{
+	s3.StorageClassGlacier:     true,
+	s3.StorageClassDeepArchive: true,
+}
+
+// the archive storage class of a source object that can't be read as it is,
+// or "" if it can. A restored copy of an archived object can be read.
+func archivedClass(attrs *blob.Attributes) string {
+	var class, restore string
+	var v1 s3.HeadObjectOutput
+	var v2 s3v2.HeadObjectOutput
+	switch {
+	case attrs.As(&v1):
+		class, restore = aws.StringValue(v1.StorageClass), aws.StringValue(v1.Restore)
+	case attrs.As(&v2):
+		class, restore = string(v2.StorageClass), aws.StringValue(v2.Restore)
+	default:
+		return ""
+	}
+	// a finished restore looks like ongoing-request="false", expiry-date="..."
+	if !archiveClasses[class] || strings.Contains(restore, `ongoing-request="false"`) {
+		return ""
+	}
+	return class
+}
+
+func archivedError(key, class string) error {
+	return fmt.Errorf("%s is in %s: %w", key, class, ErrArchived)
+}
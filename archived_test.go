@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	s3v2 "github.com/aws/aws-sdk-go-v2/service/s3"
+	s3v2types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"gocloud.dev/blob/driver"
+)
+
+// a source where some keys look like S3 objects in an archive storage class.
+func testArchivedSource(t *testing.T) (*faultBucket, map[string]string) {
+	t.Helper()
+	heads := map[string]func(interface{}) bool{
+		"glacier":  testAs(s3.HeadObjectOutput{StorageClass: aws.String("GLACIER")}),
+		"deep":     testAs(s3v2.HeadObjectOutput{StorageClass: s3v2types.StorageClassDeepArchive}),
+		"restored": testAs(s3.HeadObjectOutput{StorageClass: aws.String("GLACIER"), Restore: aws.String(`ongoing-request="false", expiry-date="Fri, 21 Dec 2012 00:00:00 GMT"`)}),
+		"instant":  testAs(s3.HeadObjectOutput{StorageClass: aws.String("GLACIER_IR")}),
+		"standard": testAs(s3.HeadObjectOutput{StorageClass: aws.String("STANDARD")}),
+	}
+	fb := &faultBucket{
+		attrs: func(key string, a *driver.Attributes) {
+			a.AsFunc = heads[key]
+		},
+		read: func(key string) error {
+			if key == "glacier" || key == "deep" {
+				return errInjected
+			}
+			return nil
+		},
+	}
+	bkt := testFaultBucket(t, fb)
+	classes := map[string]string{"glacier": "GLACIER", "deep": "DEEP_ARCHIVE", "restored": "", "instant": "", "standard": "", "plain": ""}
+	for key := range classes {
+		if err := bkt.WriteAll(context.Background(), key, []byte(key), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return fb, classes
+}
+
+func TestArchivedClass(t *testing.T) {
+	ctx := context.Background()
+	fb, classes := testArchivedSource(t)
+	bkt := testFaultBucket(t, fb)
+	for key, expected := range classes {
+		attrs, err := bkt.Attributes(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := archivedClass(attrs); got != expected {
+			t.Errorf("%s: got %q, expected %q", key, got, expected)
+		}
+	}
+}
+
+func TestSkipArchived(t *testing.T) {
+	ctx := context.Background()
+	fb, _ := testArchivedSource(t)
+	sbkt := testFaultBucket(t, fb)
+	for _, skip := range []bool{false, true} {
+		_, dbkt := testMemBuckets(t)
+		errs := make(chan error)
+		done := make(chan []error)
+		go func() {
+			var got []error
+			for err := range errs {
+				got = append(got, err)
+			}
+			done <- got
+		}()
+		n := mirror(ctx, sbkt, dbkt, nil, mirrorOpts{skipArchived: skip}, errs)
+		close(errs)
+		got := <-done
+		if n != 4 {
+			t.Errorf("skip %v: expected the 4 readable objects copied, got %d", skip, n)
+		}
+		expected := 2
+		if skip {
+			expected = 0
+		}
+		if len(got) != expected {
+			t.Errorf("skip %v: expected %d errors, got %v", skip, expected, got)
+		}
+		for _, err := range got {
+			if !errors.Is(err, ErrArchived) {
+				t.Errorf("expected ErrArchived, got %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"context"
+
+	"gocloud.dev/gcerrors"
+)
+
+// with -atomic-dest, objects are written under this suffix first,
+// and only show up under their own key once they're complete.
+const atomicSuffix = ".blobcopy-tmp"
+
+// moves a completely written tmpKey to key. go-cloud has no rename, so it's a
+// server side copy and a delete. A backend that can't copy falls back to
+// direct, which writes key directly, after calling unsupported.
+// tmpKey is removed either way. A tmpKey that isn't visible yet is looked for again with retry.
+func finishAtomic(ctx context.Context, dbkt Bucket, tmpKey, key string, retry consistencyRetry, direct func() error, unsupported func()) error {
+	err := retry.do(ctx, func() error { return dbkt.Copy(ctx, key, tmpKey, nil) })
+	if gcerrors.Code(err) == gcerrors.Unimplemented {
+		unsupported()
+		err = direct()
+	}
+	if err != nil {
+		_ = dbkt.Delete(ctx, tmpKey)
+		return err
+	}
+	return dbkt.Delete(ctx, tmpKey)
+}
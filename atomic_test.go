@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gocloud.dev/blob"
+)
+
+func testAtomicMirror(t *testing.T, sbkt, dbkt *blob.Bucket) int {
+	t.Helper()
+	errs := make(chan error)
+	done := make(chan struct{})
+	go func() {
+		for err := range errs {
+			t.Error(err)
+		}
+		close(done)
+	}()
+	n := mirror(context.Background(), sbkt, dbkt, nil, mirrorOpts{atomicDest: true}, errs)
+	close(errs)
+	<-done
+	return n
+}
+
+func testNoTmpKeys(t *testing.T, bkt *blob.Bucket) {
+	t.Helper()
+	iter := bkt.List(nil)
+	for {
+		obj, err := iter.Next(context.Background())
+		if err != nil {
+			break
+		}
+		if strings.HasSuffix(obj.Key, atomicSuffix) {
+			t.Errorf("temporary object %s left behind", obj.Key)
+		}
+	}
+}
+
+func TestAtomicDest(t *testing.T) {
+	ctx := context.Background()
+	sbkt, _ := testMemBuckets(t)
+	dbkt, err := blob.OpenBucket(ctx, "file://"+filepath.ToSlash(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbkt.Close()
+	data := testRandomData(t)
+	for _, key := range []string{"file", "dir/file"} {
+		if err := sbkt.WriteAll(ctx, key, data, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := testAtomicMirror(t, sbkt, dbkt); n != 2 {
+		t.Errorf("expected 2 copied, got %d", n)
+	}
+	for _, key := range []string{"file", "dir/file"} {
+		if got := testReadString(t, dbkt, key); got != string(data) {
+			t.Errorf("%s: wrong content", key)
+		}
+	}
+	testNoTmpKeys(t, dbkt)
+}
+
+func TestAtomicDestNoCopy(t *testing.T) {
+	ctx := context.Background()
+	sbkt, _ := testMemBuckets(t)
+	copies := 0
+	fb := &faultBucket{
+		copy: func(dstKey, srcKey string) error {
+			copies++
+			return errUnimplemented
+		},
+	}
+	dbkt := testFaultBucket(t, fb)
+	data := testRandomData(t)
+	for _, key := range []string{"a", "b"} {
+		if err := sbkt.WriteAll(ctx, key, data, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// falls back to writing the final key directly.
+	if n := testAtomicMirror(t, sbkt, dbkt); n != 2 {
+		t.Errorf("expected 2 copied, got %d", n)
+	}
+	if copies != 2 {
+		t.Errorf("expected a copy attempt per object, got %d", copies)
+	}
+	for _, key := range []string{"a", "b"} {
+		if got := testReadString(t, dbkt, key); got != string(data) {
+			t.Errorf("%s: wrong content", key)
+		}
+	}
+	testNoTmpKeys(t, dbkt)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"strconv"
+	"testing"
+
+	"gocloud.dev/blob"
+)
+
+var benchSizes = []int{1 << 10, 1 << 20, 16 << 20}
+
+func benchBucket(b *testing.B) *blob.Bucket {
+	b.Helper()
+	bkt, err := blob.OpenBucket(context.Background(), "mem://")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { bkt.Close() })
+	return bkt
+}
+
+func benchData(b *testing.B, size int) []byte {
+	b.Helper()
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		b.Fatal(err)
+	}
+	return buf
+}
+
+func BenchmarkCopyObj(b *testing.B) {
+	ctx := context.Background()
+	for _, size := range benchSizes {
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) {
+			src := benchBucket(b)
+			dst := benchBucket(b)
+			if err := src.WriteAll(ctx, "obj", benchData(b, size), nil); err != nil {
+				b.Fatal(err)
+			}
+			b.SetBytes(int64(size))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, _, _, err := copyObj(ctx, src, dst, "obj", nil, nil, nil); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// mirrors 100 objects of each size into an empty destination every iteration.
+func BenchmarkMirror(b *testing.B) {
+	ctx := context.Background()
+	level := logLevel
+	logLevel = logQuiet
+	defer func() { logLevel = level }()
+
+	nobjs := 100
+	for _, size := range benchSizes[:2] {
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) {
+			src := benchBucket(b)
+			data := benchData(b, size)
+			for i := 0; i < nobjs; i++ {
+				if err := src.WriteAll(ctx, "obj"+strconv.Itoa(i), data, nil); err != nil {
+					b.Fatal(err)
+				}
+			}
+			errs := make(chan error)
+			go func() {
+				for err := range errs {
+					b.Error(err)
+				}
+			}()
+			defer close(errs)
+			b.SetBytes(int64(size * nobjs))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				dst := benchBucket(b)
+				b.StartTimer()
+				if n := mirror(ctx, src, dst, nil, mirrorOpts{}, errs); n != nobjs {
+					b.Fatalf("expected %d objects copied, got %d", nobjs, n)
+				}
+			}
+		})
+	}
+}
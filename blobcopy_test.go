@@ -2,12 +2,19 @@ package main
 
 import (
 	"context"
+	"crypto/md5"
 	"crypto/rand"
+	"errors"
 	"log"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"testing"
 
 	"gocloud.dev/blob"
+	"gocloud.dev/blob/driver"
+	"gocloud.dev/gcerrors"
 )
 
 func testRandomData(t *testing.T) []byte {
@@ -25,6 +32,31 @@ func testAuthentication(t *testing.T) []byte {
 	return testRandomData(t)[:32]
 }
 
+func testMemBuckets(t *testing.T) (*blob.Bucket, *blob.Bucket) {
+	t.Helper()
+	ctx := context.Background()
+	sbkt, err := blob.OpenBucket(ctx, "mem://")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sbkt.Close() })
+	dbkt, err := blob.OpenBucket(ctx, "mem://")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { dbkt.Close() })
+	return sbkt, dbkt
+}
+
+func testReadString(t *testing.T, bkt *blob.Bucket, key string) string {
+	t.Helper()
+	data, err := bkt.ReadAll(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
 func TestEncryptEecryptOpposite(t *testing.T) {
 	text := make([]byte, 1024)
 	_, err := rand.Read(text)
@@ -87,7 +119,7 @@ func TestMirror(t *testing.T) {
 		}
 	}()
 
-	n := mirror(ctx, bkt1, bkt2, nil, nil, nil, 0, errs)
+	n := mirror(ctx, bkt1, bkt2, nil, mirrorOpts{}, errs)
 	if n != nfiles {
 		t.Fatalf("unexpected number of objects copied. expected %d, got %d", nfiles, n)
 	}
@@ -131,8 +163,8 @@ func TestEncryptBucket(t *testing.T) {
 		}
 	}()
 
-	// encrypt--------------------------------------\/
-	_ = mirror(ctx, initialBkt, encryptedBkt, nil, encKey, nil, 0, errs)
+	// encrypt
+	_ = mirror(ctx, initialBkt, encryptedBkt, nil, mirrorOpts{bytesEncrypt: encKey, nameEncrypt: encKey}, errs)
 
 	decryptedBkt, err := blob.OpenBucket(ctx, "mem://")
 	if err != nil {
@@ -140,8 +172,8 @@ func TestEncryptBucket(t *testing.T) {
 	}
 	defer decryptedBkt.Close()
 
-	// decrypt ---------------------------------------------\/
-	_ = mirror(ctx, encryptedBkt, decryptedBkt, nil, nil, encKey, 0, errs)
+	// decrypt
+	_ = mirror(ctx, encryptedBkt, decryptedBkt, nil, mirrorOpts{bytesDecrypt: encKey, nameDecrypt: encKey}, errs)
 
 	rdr, err := decryptedBkt.NewReader(ctx, fileName, nil)
 	if err != nil {
@@ -195,3 +227,1187 @@ func TestSafety(t *testing.T) {
 		t.Error("safety check should fail when a different key is used")
 	}
 }
+
+// symlinks in a local source are copied, skipped or reported
+// depending on the symlinks option.
+func TestSymlinks(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "file"), testRandomData(t), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(dir, "file"), filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	sbkt, err := blob.OpenBucket(ctx, "file://"+dir+"?metadata=skip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sbkt.Close()
+
+	for _, tc := range []struct {
+		mode    string
+		copied  int
+		errorsN int
+	}{
+		{symlinksFollow, 2, 0},
+		{symlinksSkip, 1, 0},
+		{symlinksError, 1, 1},
+	} {
+		dbkt, err := blob.OpenBucket(ctx, "mem://")
+		if err != nil {
+			t.Fatal(err)
+		}
+		errs := make(chan error)
+		errsN := 0
+		done := make(chan bool)
+		go func() {
+			for err := range errs {
+				if !errors.Is(err, ErrSymlink) {
+					t.Error(err)
+				}
+				errsN++
+			}
+			close(done)
+		}()
+		n := mirror(ctx, sbkt, dbkt, nil, mirrorOpts{symlinks: tc.mode}, errs)
+		close(errs)
+		<-done
+		if n != tc.copied {
+			t.Errorf("%s: expected %d objects copied, got %d", tc.mode, tc.copied, n)
+		}
+		if errsN != tc.errorsN {
+			t.Errorf("%s: expected %d errors, got %d", tc.mode, tc.errorsN, errsN)
+		}
+		dbkt.Close()
+	}
+}
+
+// generating the safety check more than once is harmless.
+func TestSafetyIdempotent(t *testing.T) {
+	ctx := context.Background()
+	encKey := testAuthentication(t)
+
+	bkt, err := blob.OpenBucket(ctx, "mem://")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bkt.Close()
+
+	for i := 0; i < 2; i++ {
+		if err := enableSafetyCheck(ctx, bkt, encKey); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pass, err := safetyCheck(ctx, bkt, encKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pass {
+		t.Error("safety check should pass after being generated twice")
+	}
+}
+
+// with sendContentMD5 the destination writer is given the source md5,
+// and a write that doesn't match it is rejected.
+func TestSendContentMD5(t *testing.T) {
+	ctx := context.Background()
+	sbkt, err := blob.OpenBucket(ctx, "mem://")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sbkt.Close()
+	dbkt, err := blob.OpenBucket(ctx, "mem://")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbkt.Close()
+	if err := sbkt.WriteAll(ctx, "file", testRandomData(t), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	errs := make(chan error)
+	go func() {
+		for err := range errs {
+			t.Error(err)
+		}
+	}()
+	n := mirror(ctx, sbkt, dbkt, nil, mirrorOpts{sendContentMD5: true}, errs)
+	if n != 1 {
+		t.Fatalf("expected 1 object copied, got %d", n)
+	}
+
+	wrongMD5 := make([]byte, 16)
+	_, _, _, err = copyObj(ctx, sbkt, dbkt, "file", nil, nil, &blob.WriterOptions{ContentMD5: wrongMD5})
+	if err == nil {
+		t.Fatal("expected an error writing with the wrong md5")
+	}
+}
+
+// update-only refreshes changed objects that exist in the destination,
+// leaves unchanged ones alone, and never creates new keys.
+func TestUpdateOnly(t *testing.T) {
+	ctx := context.Background()
+	sbkt, err := blob.OpenBucket(ctx, "mem://")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sbkt.Close()
+	dbkt, err := blob.OpenBucket(ctx, "mem://")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbkt.Close()
+
+	same := testRandomData(t)
+	changed := testRandomData(t)
+	for key, data := range map[string][]byte{"same": same, "changed": changed, "new": testRandomData(t)} {
+		if err := sbkt.WriteAll(ctx, key, data, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := dbkt.WriteAll(ctx, "same", same, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := dbkt.WriteAll(ctx, "changed", testRandomData(t), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	errs := make(chan error)
+	go func() {
+		for err := range errs {
+			t.Error(err)
+		}
+	}()
+	n := mirror(ctx, sbkt, dbkt, nil, mirrorOpts{updateOnly: true}, errs)
+	if n != 1 {
+		t.Errorf("expected only the changed object to be copied, got %d", n)
+	}
+	got, err := dbkt.ReadAll(ctx, "changed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(changed) {
+		t.Error("changed object was not updated")
+	}
+	exists, err := dbkt.Exists(ctx, "new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Error("update-only should not create new objects")
+	}
+}
+
+func TestErrorBreakdown(t *testing.T) {
+	codes := map[gcerrors.ErrorCode]int{
+		gcerrors.PermissionDenied: 1,
+		gcerrors.NotFound:         3,
+		gcerrors.Unknown:          1,
+	}
+	expected := "NotFound: 3, PermissionDenied: 1, Unknown: 1"
+	if got := errorBreakdown(codes); got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}
+
+// the interaction of -safety, -gen-safety and -require-safety
+// when the destination has no safety file yet.
+func TestSafetyModes(t *testing.T) {
+	ctx := context.Background()
+	encKey := testAuthentication(t)
+
+	for _, tc := range []struct {
+		name      string
+		gen       bool
+		require   bool
+		expectErr bool
+		generated bool
+	}{
+		{"safety", false, false, true, false},
+		{"gen-safety", true, false, false, true},
+		{"require-safety", false, true, true, false},
+	} {
+		bkt, err := blob.OpenBucket(ctx, "mem://")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = runSafetyCheck(ctx, bkt, encKey, tc.gen, tc.require)
+		if tc.expectErr && !errors.Is(err, ErrSafetyCheckFailed) {
+			t.Errorf("%s: expected ErrSafetyCheckFailed, got %v", tc.name, err)
+		}
+		if !tc.expectErr && err != nil {
+			t.Errorf("%s: unexpected error %v", tc.name, err)
+		}
+		pass, err := safetyCheck(ctx, bkt, encKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pass != tc.generated {
+			t.Errorf("%s: expected safety file generated to be %v", tc.name, tc.generated)
+		}
+		// once it exists, every mode passes.
+		if err := enableSafetyCheck(ctx, bkt, encKey); err != nil {
+			t.Fatal(err)
+		}
+		if err := runSafetyCheck(ctx, bkt, encKey, tc.gen, tc.require); err != nil {
+			t.Errorf("%s: unexpected error with a valid safety file %v", tc.name, err)
+		}
+		bkt.Close()
+	}
+}
+
+// an object copied in ranges arrives whole, in order.
+func TestCopyObjRanges(t *testing.T) {
+	ctx := context.Background()
+	sbkt, err := blob.OpenBucket(ctx, "mem://")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sbkt.Close()
+	dbkt, err := blob.OpenBucket(ctx, "mem://")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbkt.Close()
+
+	var text []byte
+	for i := 0; i < 10; i++ {
+		text = append(text, testRandomData(t)...)
+	}
+	if err := sbkt.WriteAll(ctx, "big", text, nil); err != nil {
+		t.Fatal(err)
+	}
+	// 10240 bytes in 1000 byte chunks leaves a short last chunk.
+	n, err := copyObjRanges(ctx, sbkt, dbkt, "big", "big", int64(len(text)), 3, 1000, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(text) {
+		t.Errorf("expected %d bytes written, got %d", len(text), n)
+	}
+	got, err := dbkt.ReadAll(ctx, "big")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(text) {
+		t.Error("object copied in ranges is not equal to the original")
+	}
+}
+
+// a range that comes back short fails the copy rather than leaving a short object.
+func TestCopyObjRangesShort(t *testing.T) {
+	ctx := context.Background()
+	sbkt := testFaultBucket(t, &faultBucket{short: func(string) int64 { return 1 }})
+	dbkt, err := blob.OpenBucket(ctx, "mem://")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbkt.Close()
+	text := testRandomData(t)
+	if err := sbkt.WriteAll(ctx, "big", text, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := copyObjRanges(ctx, sbkt, dbkt, "big", "big", int64(len(text)), 2, 100, nil); err == nil {
+		t.Error("expected the short range to fail the copy")
+	}
+	if ok, err := dbkt.Exists(ctx, "big"); err != nil || ok {
+		t.Errorf("expected nothing written, exists %v err %v", ok, err)
+	}
+}
+
+func TestCopyRange(t *testing.T) {
+	ctx := context.Background()
+	sbkt, dbkt := testMemBuckets(t)
+	text := testRandomData(t)
+	if err := sbkt.WriteAll(ctx, "file", text, &blob.WriterOptions{ContentType: "application/x-test"}); err != nil {
+		t.Fatal(err)
+	}
+	n, err := copyRange(ctx, sbkt, dbkt, "file", byteRange{start: 100, end: 299})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 200 || testReadString(t, dbkt, "file") != string(text[100:300]) {
+		t.Errorf("expected bytes 100 to 299 copied, got %d bytes", n)
+	}
+	if attrs, err := dbkt.Attributes(ctx, "file"); err != nil || attrs.ContentType != "application/x-test" {
+		t.Errorf("expected the source's content type, got %v", err)
+	}
+	// to the end.
+	if _, err := copyRange(ctx, sbkt, dbkt, "file", byteRange{start: 1000, end: -1}); err != nil {
+		t.Fatal(err)
+	}
+	if got := testReadString(t, dbkt, "file"); got != string(text[1000:]) {
+		t.Errorf("expected the last 24 bytes, got %d", len(got))
+	}
+	for _, r := range []byteRange{{1024, -1}, {1000, 1024}, {2000, 3000}} {
+		if _, err := copyRange(ctx, sbkt, dbkt, "file", r); !errors.Is(err, ErrBadRange) {
+			t.Errorf("%s: expected ErrBadRange, got %v", r, err)
+		}
+	}
+	if got := testReadString(t, dbkt, "file"); got != string(text[1000:]) {
+		t.Error("a bad range changed the destination")
+	}
+}
+
+// -object copies its object even when an earlier run's listing got past it.
+func TestObjectInventory(t *testing.T) {
+	ctx := context.Background()
+	sbkt, dbkt := testMemBuckets(t)
+	for _, key := range []string{"a", "b", "c"} {
+		if err := sbkt.WriteAll(ctx, key, []byte(key), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	errs := make(chan error)
+	go func() {
+		for err := range errs {
+			t.Error(err)
+		}
+	}()
+	st := newState()
+	st.LastKey = "c"
+	inv := objectInventory("b")
+	if n := mirror(ctx, sbkt, dbkt, nil, mirrorOpts{state: st, inventory: inv}, errs); n != 1 || testReadString(t, dbkt, "b") != "b" {
+		t.Errorf("expected b copied, got %d copied", n)
+	}
+	close(errs)
+	if len(inv.missing) != 0 {
+		t.Errorf("expected nothing missing, got %v", inv.missing)
+	}
+}
+
+func TestParseByteRange(t *testing.T) {
+	for s, want := range map[string]byteRange{"0-0": {0, 0}, "10-99": {10, 99}, "5-": {5, -1}} {
+		if r, err := parseByteRange(s); err != nil || r != want {
+			t.Errorf("%q: expected %v, got %v %v", s, want, r, err)
+		}
+	}
+	for _, s := range []string{"", "10", "-10", "a-b", "10-5", "1-x"} {
+		if _, err := parseByteRange(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+// with storeOrigKey the encrypted key name travels in metadata,
+// and decrypting recovers the original name from it even if the object was renamed.
+func TestStoreOrigKey(t *testing.T) {
+	ctx := context.Background()
+	sbkt, encryptedBkt := testMemBuckets(t)
+	tmpBkt, decryptedBkt := testMemBuckets(t)
+	encKey := testAuthentication(t)
+	text := testRandomData(t)
+	if err := sbkt.WriteAll(ctx, "dir/file", text, nil); err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error)
+	go func() {
+		for err := range errs {
+			t.Error(err)
+		}
+	}()
+	mirror(ctx, sbkt, encryptedBkt, tmpBkt, mirrorOpts{bytesEncrypt: encKey, nameEncrypt: encKey, storeOrigKey: true}, errs)
+
+	encName, err := makeKey("dir/file", encKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attrs, err := encryptedBkt.Attributes(ctx, encName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs.Metadata[origKeyMeta] != encName {
+		t.Fatalf("expected the encrypted key name in metadata, got %v", attrs.Metadata)
+	}
+
+	// the encrypted object loses its name.
+	cypherText, err := encryptedBkt.ReadAll(ctx, encName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := encryptedBkt.Delete(ctx, encName); err != nil {
+		t.Fatal(err)
+	}
+	err = encryptedBkt.WriteAll(ctx, "renamed", cypherText, &blob.WriterOptions{Metadata: attrs.Metadata})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mirror(ctx, encryptedBkt, decryptedBkt, tmpBkt, mirrorOpts{bytesDecrypt: encKey, nameDecrypt: encKey}, errs)
+	if got := testReadString(t, decryptedBkt, "dir/file"); got != string(text) {
+		t.Error("decrypted object doesn't match the original")
+	}
+}
+
+// a key too long for S3 once encrypted is stored under a short hash,
+// and decrypts back to the original name.
+func TestKeyHash(t *testing.T) {
+	ctx := context.Background()
+	sbkt, encryptedBkt := testMemBuckets(t)
+	tmpBkt, decryptedBkt := testMemBuckets(t)
+	encKey := testAuthentication(t)
+	longKey := strings.Repeat("very/long/directory/", 50) + "file"
+	text := testRandomData(t)
+	if err := sbkt.WriteAll(ctx, longKey, text, nil); err != nil {
+		t.Fatal(err)
+	}
+	encName, err := makeKey(longKey, encKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(encName) <= 1024 {
+		t.Fatalf("test key should overflow S3's limit once encrypted, it's %d bytes", len(encName))
+	}
+	errs := make(chan error)
+	go func() {
+		for err := range errs {
+			t.Error(err)
+		}
+	}()
+	mirror(ctx, sbkt, encryptedBkt, tmpBkt, mirrorOpts{bytesEncrypt: encKey, nameEncrypt: encKey, keyHash: keyHashSHA256}, errs)
+
+	objs, err := listAll(ctx, encryptedBkt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objs) != 1 {
+		t.Fatalf("expected one encrypted object, got %d", len(objs))
+	}
+	for key := range objs {
+		if len(key) != 64 {
+			t.Errorf("expected a sha256 hex key, got %d bytes", len(key))
+		}
+	}
+
+	mirror(ctx, encryptedBkt, decryptedBkt, tmpBkt, mirrorOpts{bytesDecrypt: encKey, nameDecrypt: encKey}, errs)
+	if got := testReadString(t, decryptedBkt, longKey); got != string(text) {
+		t.Error("decrypted object doesn't match the original")
+	}
+}
+
+// a key that the source lists twice is only handled once.
+func TestDedupeList(t *testing.T) {
+	ctx := context.Background()
+	attributesN := 0
+	fb := &faultBucket{
+		attributes: func(key string) error {
+			attributesN++
+			return nil
+		},
+		list: func(page *driver.ListPage) {
+			if len(page.Objects) > 0 {
+				page.Objects = append(page.Objects, page.Objects[0])
+			}
+		},
+	}
+	sbkt := testFaultBucket(t, fb)
+	if err := sbkt.WriteAll(ctx, "file", testRandomData(t), nil); err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error)
+	go func() {
+		for err := range errs {
+			t.Error(err)
+		}
+	}()
+
+	for _, tc := range []struct {
+		seen        *keySet
+		attributesN int
+	}{
+		{nil, 2},
+		{newKeySet(), 1},
+	} {
+		_, dbkt := testMemBuckets(t)
+		attributesN = 0
+		n := mirror(ctx, sbkt, dbkt, nil, mirrorOpts{seen: tc.seen, verifymd5: true}, errs)
+		if n != 1 {
+			t.Errorf("expected the object to be copied once, got %d", n)
+		}
+		if attributesN != tc.attributesN {
+			t.Errorf("expected the source object to be looked at %d times, got %d", tc.attributesN, attributesN)
+		}
+	}
+}
+
+// key names and content can be encrypted independently, and decrypt back to the original.
+func TestPartialEncryption(t *testing.T) {
+	ctx := context.Background()
+	encKey := testAuthentication(t)
+	text := testRandomData(t)
+	errs := make(chan error)
+	go func() {
+		for err := range errs {
+			t.Error(err)
+		}
+	}()
+
+	for _, tc := range []struct {
+		name         string
+		keys         bool
+		content      bool
+		plainKey     bool
+		plainContent bool
+	}{
+		{"neither", false, false, true, true},
+		{"keys", true, false, false, true},
+		{"content", false, true, true, false},
+		{"both", true, true, false, false},
+	} {
+		sbkt, encryptedBkt := testMemBuckets(t)
+		tmpBkt, decryptedBkt := testMemBuckets(t)
+		if err := sbkt.WriteAll(ctx, "file", text, nil); err != nil {
+			t.Fatal(err)
+		}
+		var encOpts, decOpts mirrorOpts
+		if tc.keys {
+			encOpts.nameEncrypt = encKey
+			decOpts.nameDecrypt = encKey
+		}
+		if tc.content {
+			encOpts.bytesEncrypt = encKey
+			decOpts.bytesDecrypt = encKey
+		}
+		mirror(ctx, sbkt, encryptedBkt, tmpBkt, encOpts, errs)
+
+		objs, err := listAll(ctx, encryptedBkt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(objs) != 1 {
+			t.Fatalf("%s: expected one object, got %d", tc.name, len(objs))
+		}
+		for key := range objs {
+			if (key == "file") != tc.plainKey {
+				t.Errorf("%s: unexpected key %q", tc.name, key)
+			}
+			if (testReadString(t, encryptedBkt, key) == string(text)) != tc.plainContent {
+				t.Errorf("%s: content encryption not as expected", tc.name)
+			}
+		}
+
+		mirror(ctx, encryptedBkt, decryptedBkt, tmpBkt, decOpts, errs)
+		if got := testReadString(t, decryptedBkt, "file"); got != string(text) {
+			t.Errorf("%s: decrypted object doesn't match the original", tc.name)
+		}
+	}
+}
+
+// a failure reading attributes back from the temporary bucket is reported,
+// and the object isn't copied.
+func TestTmpAttributesFailure(t *testing.T) {
+	ctx := context.Background()
+	sbkt, dbkt := testMemBuckets(t)
+	fb := &faultBucket{
+		attributes: func(key string) error {
+			return errInjected
+		},
+	}
+	tmpBkt := testFaultBucket(t, fb)
+	if err := sbkt.WriteAll(ctx, "file", testRandomData(t), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	errs := make(chan error)
+	done := make(chan int)
+	go func() {
+		n := 0
+		for err := range errs {
+			if !errors.Is(err, errInjected) {
+				t.Error(err)
+			}
+			n++
+		}
+		done <- n
+	}()
+	n := mirror(ctx, sbkt, dbkt, tmpBkt, mirrorOpts{}, errs)
+	close(errs)
+	if errN := <-done; errN != 1 {
+		t.Errorf("expected one error, got %d", errN)
+	}
+	if n != 0 {
+		t.Errorf("expected nothing copied, got %d", n)
+	}
+	if exists, err := dbkt.Exists(ctx, "file"); err != nil || exists {
+		t.Errorf("object copied to destination despite the failure, exists %v err %v", exists, err)
+	}
+	if exists, err := fb.bkt.Exists(ctx, "file"); err != nil || exists {
+		t.Errorf("object left in the temporary bucket, exists %v err %v", exists, err)
+	}
+}
+
+// encrypt with key A, re-encrypt A -> B, then decrypting with B gives back the original.
+func TestReencrypt(t *testing.T) {
+	ctx := context.Background()
+	keyA := testAuthentication(t)
+	keyB := append([]byte{}, keyA...)
+	keyB[0] ^= 0xff
+	text := testRandomData(t)
+	errs := make(chan error)
+	go func() {
+		for err := range errs {
+			t.Error(err)
+		}
+	}()
+
+	if name, err := makeKey("dir/file", keyA, nil); err != nil {
+		t.Fatal(err)
+	} else if renamed, err := makeKey(name, keyB, keyA); err != nil {
+		t.Fatal(err)
+	} else if plain, err := makeKey(renamed, nil, keyB); err != nil || plain != "dir/file" {
+		t.Errorf("key name didn't survive re-encryption, got %q err %v", plain, err)
+	}
+
+	for _, keyHash := range []string{"", keyHashSHA256} {
+		sbkt, aBkt := testMemBuckets(t)
+		bBkt, plainBkt := testMemBuckets(t)
+		tmpBkt, _ := testMemBuckets(t)
+		for _, key := range []string{"file", "dir/other"} {
+			if err := sbkt.WriteAll(ctx, key, text, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		mirror(ctx, sbkt, aBkt, tmpBkt, mirrorOpts{bytesEncrypt: keyA, nameEncrypt: keyA, keyHash: keyHash}, errs)
+		n := mirror(ctx, aBkt, bBkt, tmpBkt, mirrorOpts{
+			bytesDecrypt: keyA,
+			nameDecrypt:  keyA,
+			bytesEncrypt: keyB,
+			nameEncrypt:  keyB,
+			keyHash:      keyHash,
+		}, errs)
+		if n != 2 {
+			t.Errorf("hash %q: expected 2 objects re-encrypted, got %d", keyHash, n)
+		}
+		aObjs, err := listAll(ctx, aBkt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bObjs, err := listAll(ctx, bBkt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for key := range bObjs {
+			if _, ok := aObjs[key]; ok {
+				t.Errorf("hash %q: re-encrypted key %s is the same as before", keyHash, key)
+			}
+			if testReadString(t, bBkt, key) == string(text) {
+				t.Errorf("hash %q: %s is not encrypted", keyHash, key)
+			}
+		}
+
+		mirror(ctx, bBkt, plainBkt, tmpBkt, mirrorOpts{bytesDecrypt: keyB, nameDecrypt: keyB}, errs)
+		for _, key := range []string{"file", "dir/other"} {
+			if got := testReadString(t, plainBkt, key); got != string(text) {
+				t.Errorf("hash %q: %s doesn't decrypt to the original", keyHash, key)
+			}
+		}
+	}
+}
+
+// errors and progress share one collector, like main's.
+func TestProgressEvents(t *testing.T) {
+	ctx := context.Background()
+	sbkt, dbkt := testMemBuckets(t)
+	sizes := map[string]int{"a": 10, "b": 200, "c": 3000}
+	for key, size := range sizes {
+		if err := sbkt.WriteAll(ctx, key, make([]byte, size), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// already there, so not copied.
+	if err := dbkt.WriteAll(ctx, "c", make([]byte, sizes["c"]), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	errs := make(chan error)
+	progress := make(chan progressEvent)
+	stop := make(chan bool)
+	stopped := make(chan bool)
+	copied := make(map[string]int64)
+	go func() {
+		for {
+			select {
+			case err := <-errs:
+				t.Error(err)
+			case ev := <-progress:
+				copied[ev.key] += ev.bytes
+			case <-stop:
+				close(stopped)
+				return
+			}
+		}
+	}()
+	n := mirror(ctx, sbkt, dbkt, nil, mirrorOpts{progress: progress}, errs)
+	close(stop)
+	<-stopped
+	if n != 2 || len(copied) != 2 {
+		t.Fatalf("expected 2 objects copied and 2 events, got %d and %d", n, len(copied))
+	}
+	for _, key := range []string{"a", "b"} {
+		if copied[key] != int64(sizes[key]) {
+			t.Errorf("%s: expected %d bytes, got %d", key, sizes[key], copied[key])
+		}
+	}
+}
+
+// a destination that reports the source's md5 for different content fools the
+// md5 check, unless md5s are recomputed locally.
+func TestDistrustProviderMD5(t *testing.T) {
+	ctx := context.Background()
+	text := testRandomData(t)
+	other := testRandomData(t)
+	sum := md5.Sum(text)
+
+	for _, distrust := range []bool{false, true} {
+		sbkt, _ := testMemBuckets(t)
+		fb := &faultBucket{
+			attrs: func(key string, a *driver.Attributes) {
+				a.MD5 = sum[:]
+			},
+		}
+		dbkt := testFaultBucket(t, fb)
+		if err := sbkt.WriteAll(ctx, "file", text, nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := fb.bkt.WriteAll(ctx, "file", other, nil); err != nil {
+			t.Fatal(err)
+		}
+		errs := make(chan error)
+		go func() {
+			for err := range errs {
+				t.Error(err)
+			}
+		}()
+		n := mirror(ctx, sbkt, dbkt, nil, mirrorOpts{verifymd5: true, distrustMD5: distrust}, errs)
+		copied := testReadString(t, fb.bkt, "file") == string(text)
+		if copied != distrust || (n == 1) != distrust {
+			t.Errorf("distrust %v: expected copied %v, got %v (%d objects)", distrust, distrust, copied, n)
+		}
+	}
+}
+
+// data that's too short to have been encrypted is an error, not a panic.
+func TestDecryptShort(t *testing.T) {
+	encKey := testAuthentication(t)
+	for _, text := range []string{"", "plain", "0123456789abcdef0123456"} {
+		if _, err := decrypt([]byte(text), encKey); !errors.Is(err, ErrShortCyphertext) {
+			t.Errorf("%q: expected ErrShortCyphertext, got %v", text, err)
+		}
+	}
+	// long enough, but not ours.
+	if _, err := decrypt([]byte("this is plain text and not encrypted at all"), encKey); err == nil {
+		t.Error("expected an error decrypting plain text")
+	}
+	// an empty plaintext encrypts to exactly nonce and tag.
+	cypherText, err := encrypt([]byte{}, encKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text, err := decrypt(cypherText, encKey); err != nil || len(text) != 0 {
+		t.Errorf("expected an empty plaintext back, got %q err %v", text, err)
+	}
+}
+
+// an encrypted bucket with a file someone put there by hand.
+func TestDecryptPassthrough(t *testing.T) {
+	ctx := context.Background()
+	sbkt, encBkt := testMemBuckets(t)
+	encKey := testAuthentication(t)
+	text := testRandomData(t)
+	if err := sbkt.WriteAll(ctx, "secret", text, nil); err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error)
+	go func() {
+		for err := range errs {
+			t.Error(err)
+		}
+	}()
+	if n := mirror(ctx, sbkt, encBkt, nil, mirrorOpts{bytesEncrypt: encKey, nameEncrypt: encKey}, errs); n != 1 {
+		t.Fatalf("expected 1 object encrypted, got %d", n)
+	}
+	// not base64, base64 but too short to be ciphertext, and long enough but not ours.
+	plain := map[string]string{
+		"README.txt":               "put here by hand",
+		"abcd":                     "short",
+		strings.Repeat("QUJD", 12): "not ours",
+	}
+	for key, content := range plain {
+		if err := encBkt.WriteAll(ctx, key, []byte(content), nil); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := makeKey(key, nil, encKey); !errors.Is(err, ErrNotEncryptedName) {
+			t.Errorf("%s: expected ErrNotEncryptedName, got %v", key, err)
+		}
+	}
+
+	var warnings strings.Builder
+	errLogger.SetOutput(&warnings)
+	defer errLogger.SetOutput(os.Stderr)
+	_, dbkt := testMemBuckets(t)
+	if n := mirror(ctx, encBkt, dbkt, nil, mirrorOpts{bytesDecrypt: encKey, nameDecrypt: encKey}, errs); n != 1 {
+		t.Errorf("expected only the encrypted object copied, got %d", n)
+	}
+	if testReadString(t, dbkt, "secret") != string(text) {
+		t.Error("expected the encrypted object decrypted")
+	}
+	for key := range plain {
+		if !strings.Contains(warnings.String(), "skipping "+key+":") {
+			t.Errorf("expected a warning about %s, got:\n%s", key, warnings.String())
+		}
+	}
+	// verify and repair skip them the same way.
+	decOpts := mirrorOpts{bytesDecrypt: encKey, nameDecrypt: encKey}
+	if report := verify(ctx, encBkt, dbkt, decOpts, errs); !report.ok() || report.checked != 1 {
+		t.Errorf("expected only the encrypted object verified, got %+v", report)
+	}
+	if report := repair(ctx, encBkt, dbkt, decOpts, errs); !report.ok() || report.checked != 1 {
+		t.Errorf("expected only the encrypted object checked, got %+v", report)
+	}
+
+	_, dbkt = testMemBuckets(t)
+	opts := mirrorOpts{bytesDecrypt: encKey, nameDecrypt: encKey, decryptPassthrough: true}
+	if n := mirror(ctx, encBkt, dbkt, nil, opts, errs); n != 4 {
+		t.Errorf("expected all 4 objects copied, got %d", n)
+	}
+	if testReadString(t, dbkt, "secret") != string(text) {
+		t.Error("expected the encrypted object decrypted")
+	}
+	for key, content := range plain {
+		if got := testReadString(t, dbkt, key); got != content {
+			t.Errorf("%s: expected it copied as it is, got %q", key, got)
+		}
+	}
+	if report := verify(ctx, encBkt, dbkt, opts, errs); !report.ok() || report.checked != 4 {
+		t.Errorf("expected all 4 verified, got %+v", report)
+	}
+
+	// or when rotating keys, without being encrypted with the new one.
+	_, dbkt = testMemBuckets(t)
+	newKey := testAuthentication(t)
+	reOpts := opts
+	reOpts.bytesEncrypt, reOpts.nameEncrypt = newKey, newKey
+	if n := mirror(ctx, encBkt, dbkt, nil, reOpts, errs); n != 4 {
+		t.Errorf("expected all 4 objects copied, got %d", n)
+	}
+	for key, content := range plain {
+		if got := testReadString(t, dbkt, key); got != content {
+			t.Errorf("%s: expected it copied as it is, got %q", key, got)
+		}
+	}
+	if report := verify(ctx, encBkt, dbkt, reOpts, errs); !report.ok() || report.checked != 4 {
+		t.Errorf("expected all 4 verified, got %+v", report)
+	}
+
+	// what was copied as it is checks out as it is before it's deleted.
+	_, dbkt = testMemBuckets(t)
+	opts.move = true
+	if n := mirror(ctx, encBkt, dbkt, nil, opts, errs); n != 4 {
+		t.Errorf("expected all 4 objects moved, got %d", n)
+	}
+	close(errs)
+	for _, key := range []string{"README.txt", "abcd", strings.Repeat("QUJD", 12)} {
+		if ok, err := encBkt.Exists(ctx, key); err != nil || ok {
+			t.Errorf("%s: expected it deleted from the source, exists %v err %v", key, ok, err)
+		}
+	}
+}
+
+func TestReportEveryN(t *testing.T) {
+	ctx := context.Background()
+	sbkt, dbkt := testMemBuckets(t)
+	for i := 0; i < 7; i++ {
+		if err := sbkt.WriteAll(ctx, "file"+strconv.Itoa(i), testRandomData(t), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var out strings.Builder
+	logger.SetOutput(&out)
+	reportEvery = 3
+	defer func() {
+		logger.SetOutput(os.Stdout)
+		reportEvery = 0
+	}()
+
+	errs := make(chan error)
+	go func() {
+		for err := range errs {
+			t.Error(err)
+		}
+	}()
+	n := mirror(ctx, sbkt, dbkt, nil, mirrorOpts{}, errs)
+	close(errs)
+	if n != 7 {
+		t.Errorf("expected all 7 copied, got %d", n)
+	}
+	if got := strings.Count(out.String(), "copied to destination"); got != 2 {
+		t.Errorf("expected 2 copied lines, got %d:\n%s", got, out.String())
+	}
+	if got := strings.Count(out.String(), "copying to destination"); got != 2 {
+		t.Errorf("expected 2 copying lines, got %d:\n%s", got, out.String())
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	ctx := context.Background()
+	sbkt, dbkt := testMemBuckets(t)
+	for _, key := range []string{"a", "b"} {
+		if err := sbkt.WriteAll(ctx, key, testRandomData(t), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := dbkt.WriteAll(ctx, "a", []byte("already there"), nil); err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error)
+	go func() {
+		for err := range errs {
+			t.Error(err)
+		}
+	}()
+	n := mirror(ctx, sbkt, dbkt, nil, mirrorOpts{dryRun: true}, errs)
+	close(errs)
+	if n != 1 {
+		t.Errorf("expected 1 object to copy, got %d", n)
+	}
+	if exists, err := dbkt.Exists(ctx, "b"); err != nil || exists {
+		t.Errorf("dry run copied an object, exists %v err %v", exists, err)
+	}
+}
+
+// encrypted objects carry a nonce and a gcm tag on top of the content.
+func TestEncryptedSize(t *testing.T) {
+	const overhead = 12 + 16
+	ctx := context.Background()
+	sbkt, dbkt := testMemBuckets(t)
+	tmpBkt, _ := testMemBuckets(t)
+	data := testRandomData(t)
+	if err := sbkt.WriteAll(ctx, "file", data, nil); err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error)
+	progress := make(chan progressEvent, 1)
+	go func() {
+		for err := range errs {
+			t.Error(err)
+		}
+	}()
+	encKey := testAuthentication(t)
+	opts := mirrorOpts{bytesEncrypt: encKey, nameEncrypt: encKey, progress: progress}
+	if n := mirror(ctx, sbkt, dbkt, tmpBkt, opts, errs); n != 1 {
+		t.Fatalf("expected 1 copied, got %d", n)
+	}
+	close(errs)
+	ev := <-progress
+
+	dstKey, err := destKey(ctx, sbkt, "file", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attrs, err := dbkt.Attributes(ctx, dstKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs.Size != int64(len(data))+overhead {
+		t.Errorf("expected %d bytes in the destination, got %d", len(data)+overhead, attrs.Size)
+	}
+	if ev.bytes != attrs.Size || ev.srcBytes != int64(len(data)) {
+		t.Errorf("expected %d bytes written and %d read, got %d and %d", attrs.Size, len(data), ev.bytes, ev.srcBytes)
+	}
+	if got := sizeString(ev.bytes, ev.srcBytes); got != "size 1052, 1024 in the source" {
+		t.Errorf("unexpected size description %q", got)
+	}
+}
+
+// damaged ciphertext never decrypts to part of the plaintext.
+func TestDecryptTampered(t *testing.T) {
+	encKey := testAuthentication(t)
+	cypherText, err := encrypt(testRandomData(t), encKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the body starts after the 12 byte nonce.
+	const block = 16
+	const body = 12
+	swapped := append([]byte{}, cypherText...)
+	copy(swapped[body:body+block], cypherText[body+block:body+2*block])
+	copy(swapped[body+block:body+2*block], cypherText[body:body+block])
+	dropped := append(append([]byte{}, cypherText[:body+block]...), cypherText[body+2*block:]...)
+	for name, text := range map[string][]byte{
+		"truncated":       cypherText[:len(cypherText)-1],
+		"tag cut off":     cypherText[:len(cypherText)-16],
+		"blocks swapped":  swapped,
+		"a block dropped": dropped,
+	} {
+		plain, err := decrypt(text, encKey)
+		if err == nil {
+			t.Errorf("%s: decrypted", name)
+		}
+		if plain != nil {
+			t.Errorf("%s: got %d bytes of plaintext back", name, len(plain))
+		}
+	}
+}
+
+func TestCacheControl(t *testing.T) {
+	ctx := context.Background()
+	sbkt, dbkt := testMemBuckets(t)
+	err := sbkt.WriteAll(ctx, "index.html", []byte("<html>"), &blob.WriterOptions{CacheControl: "no-cache"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error)
+	go func() {
+		for err := range errs {
+			t.Error(err)
+		}
+	}()
+	mirror(ctx, sbkt, dbkt, nil, mirrorOpts{cacheControl: "max-age=31536000"}, errs)
+	close(errs)
+	attrs, err := dbkt.Attributes(ctx, "index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs.CacheControl != "max-age=31536000" {
+		t.Errorf("expected the given Cache-Control, got %q", attrs.CacheControl)
+	}
+}
+
+// an immutable destination never has an object overwritten. one that differs is an error.
+func TestImmutable(t *testing.T) {
+	ctx := context.Background()
+	sbkt, dbkt := testMemBuckets(t)
+	for key, data := range map[string]string{"same": "same", "changed": "new content", "new": "new"} {
+		if err := sbkt.WriteAll(ctx, key, []byte(data), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for key, data := range map[string]string{"same": "same", "changed": "old content"} {
+		if err := dbkt.WriteAll(ctx, key, []byte(data), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	errs := make(chan error)
+	var got []error
+	done := make(chan bool)
+	go func() {
+		for err := range errs {
+			got = append(got, err)
+		}
+		close(done)
+	}()
+	n := mirror(ctx, sbkt, dbkt, nil, mirrorOpts{immutable: true, verifymd5: true}, errs)
+	close(errs)
+	<-done
+	if n != 1 {
+		t.Errorf("expected only the new object to be copied, copied %d", n)
+	}
+	if len(got) != 1 || !errors.Is(got[0], ErrImmutable) || !strings.Contains(got[0].Error(), "changed") {
+		t.Errorf("expected one ErrImmutable for changed, got %v", got)
+	}
+	if data := testReadString(t, dbkt, "changed"); data != "old content" {
+		t.Errorf("the immutable object was overwritten with %q", data)
+	}
+}
+
+// -limit copies the first N keys, in listing order, and stops.
+func TestLimit(t *testing.T) {
+	ctx := context.Background()
+	sbkt, dbkt := testMemBuckets(t)
+	for i := 0; i < 10; i++ {
+		if err := sbkt.WriteAll(ctx, "file"+strconv.Itoa(i), []byte("data"), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	errs := make(chan error)
+	go func() {
+		for err := range errs {
+			t.Error(err)
+		}
+	}()
+	if n := mirror(ctx, sbkt, dbkt, nil, mirrorOpts{limit: 3, skipN: 1}, errs); n != 3 {
+		t.Fatalf("expected 3 objects copied, got %d", n)
+	}
+	for i := 0; i < 10; i++ {
+		exists, err := dbkt.Exists(ctx, "file"+strconv.Itoa(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := i >= 1 && i <= 3; exists != want {
+			t.Errorf("file%d: expected copied %v", i, want)
+		}
+	}
+}
+
+// content is encrypted or decrypted once, on the way into the temporary bucket,
+// and copied from there as it is. Without a temporary bucket, mirror makes one.
+func TestTransformTmpBucket(t *testing.T) {
+	ctx := context.Background()
+	text := testRandomData(t)
+	encKey := testAuthentication(t)
+	ciphertext, err := encrypt(text, encKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		withTmp bool
+		opts    mirrorOpts
+		in, out []byte
+	}{
+		{"encrypt tmp", true, mirrorOpts{bytesEncrypt: encKey}, text, ciphertext},
+		{"encrypt no tmp", false, mirrorOpts{bytesEncrypt: encKey}, text, ciphertext},
+		{"decrypt tmp", true, mirrorOpts{bytesDecrypt: encKey}, ciphertext, text},
+		{"decrypt no tmp", false, mirrorOpts{bytesDecrypt: encKey}, ciphertext, text},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sbkt, dbkt := testMemBuckets(t)
+			if err := sbkt.WriteAll(ctx, "file", tt.in, nil); err != nil {
+				t.Fatal(err)
+			}
+			var tmpBkt Bucket
+			if tt.withTmp {
+				tmpBkt, _ = testMemBuckets(t)
+			}
+			errs := make(chan error)
+			go func() {
+				for err := range errs {
+					t.Error(err)
+				}
+			}()
+			if n := mirror(ctx, sbkt, dbkt, tmpBkt, tt.opts, errs); n != 1 {
+				t.Fatalf("expected 1 object copied, got %d", n)
+			}
+			if got := testReadString(t, dbkt, "file"); got != string(tt.out) {
+				t.Fatal("destination isn't the source transformed exactly once")
+			}
+			// the transformed md5 matches the destination's, so a second run copies nothing.
+			if n := mirror(ctx, sbkt, dbkt, tmpBkt, tt.opts, errs); n != 0 {
+				t.Fatalf("expected nothing copied the second time, got %d", n)
+			}
+			if tt.withTmp {
+				if empty, err := bucketEmpty(ctx, tmpBkt); err != nil || !empty {
+					t.Fatalf("temporary bucket not cleaned up: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestChangeExitCode(t *testing.T) {
+	for _, c := range []struct {
+		copied, deleted, code int
+	}{
+		{0, 0, 0},
+		{1, 0, exitChanged},
+		{0, 3, exitChanged},
+		{5, 2, exitChanged},
+	} {
+		if got := changeExitCode(c.copied, c.deleted); got != c.code {
+			t.Errorf("%d copied, %d deleted: expected %d, got %d", c.copied, c.deleted, c.code, got)
+		}
+	}
+}
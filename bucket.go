@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"io"
+
+	"gocloud.dev/blob"
+)
+
+// the parts of a bucket that sources and destinations are used through.
+// *blob.Bucket is one, and a test or an adapter can wrap one to change
+// what it does, e.g. to fail reads or writes of some keys.
+type Bucket interface {
+	List(opts *blob.ListOptions) *blob.ListIterator
+	ListPage(ctx context.Context, pageToken []byte, pageSize int, opts *blob.ListOptions) ([]*blob.ListObject, []byte, error)
+	Attributes(ctx context.Context, key string) (*blob.Attributes, error)
+	Exists(ctx context.Context, key string) (bool, error)
+	NewReader(ctx context.Context, key string, opts *blob.ReaderOptions) (*blob.Reader, error)
+	NewRangeReader(ctx context.Context, key string, offset, length int64, opts *blob.ReaderOptions) (*blob.Reader, error)
+	NewWriter(ctx context.Context, key string, opts *blob.WriterOptions) (*blob.Writer, error)
+	Delete(ctx context.Context, key string) error
+	// for atomic moves, dedup and signing.
+	Copy(ctx context.Context, dstKey, srcKey string, opts *blob.CopyOptions) error
+	SignedURL(ctx context.Context, key string, opts *blob.SignedURLOptions) (string, error)
+	// for the provider specific options, like S3 ACLs.
+	As(i interface{}) bool
+	ErrorAs(err error, i interface{}) bool
+}
+
+var _ Bucket = (*blob.Bucket)(nil)
+
+// like (*blob.Bucket).ReadAll, for any Bucket.
+func readAll(ctx context.Context, bkt Bucket, key string) ([]byte, error) {
+	r, err := bkt.NewReader(ctx, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// like (*blob.Bucket).WriteAll, for any Bucket.
+func writeAll(ctx context.Context, bkt Bucket, key string, p []byte, opts *blob.WriterOptions) error {
+	realOpts := new(blob.WriterOptions)
+	if opts != nil {
+		*realOpts = *opts
+	}
+	if len(realOpts.ContentMD5) == 0 {
+		sum := md5.Sum(p)
+		realOpts.ContentMD5 = sum[:]
+	}
+	w, err := bkt.NewWriter(ctx, key, realOpts)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(p); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"gocloud.dev/blob"
+)
+
+// a *blob.Bucket with one unreadable key, through the Bucket interface.
+type unreadableBucket struct {
+	*blob.Bucket
+	key string
+}
+
+var errUnreadable = errors.New("unreadable")
+
+func (b unreadableBucket) NewReader(ctx context.Context, key string, opts *blob.ReaderOptions) (*blob.Reader, error) {
+	if key == b.key {
+		return nil, errUnreadable
+	}
+	return b.Bucket.NewReader(ctx, key, opts)
+}
+
+// a *blob.Bucket with one unwritable key, through the Bucket interface.
+type unwritableBucket struct {
+	*blob.Bucket
+	key string
+}
+
+var errUnwritable = errors.New("unwritable")
+
+func (b unwritableBucket) NewWriter(ctx context.Context, key string, opts *blob.WriterOptions) (*blob.Writer, error) {
+	if key == b.key {
+		return nil, errUnwritable
+	}
+	return b.Bucket.NewWriter(ctx, key, opts)
+}
+
+func TestBucketInterface(t *testing.T) {
+	ctx := context.Background()
+	mem, dbkt := testMemBuckets(t)
+	for _, key := range []string{"a", "b", "c"} {
+		if err := mem.WriteAll(ctx, key, []byte(key), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sbkt := unreadableBucket{Bucket: mem, key: "b"}
+	errs := make(chan error)
+	var got []error
+	done := make(chan bool)
+	go func() {
+		for err := range errs {
+			got = append(got, err)
+		}
+		close(done)
+	}()
+	n := mirror(ctx, sbkt, dbkt, nil, mirrorOpts{}, errs)
+	close(errs)
+	<-done
+	if n != 2 {
+		t.Errorf("expected the readable objects to be copied, copied %d", n)
+	}
+	if len(got) != 1 || !errors.Is(got[0], errUnreadable) {
+		t.Errorf("expected the unreadable key to fail, got %v", got)
+	}
+	if exists, _ := dbkt.Exists(ctx, "b"); exists {
+		t.Error("the unreadable object was copied")
+	}
+}
+
+// destinations go through the interface too.
+func TestBucketInterfaceDest(t *testing.T) {
+	ctx := context.Background()
+	sbkt, mem := testMemBuckets(t)
+	for _, key := range []string{"a", "b", "c"} {
+		if err := sbkt.WriteAll(ctx, key, []byte(key), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dbkt := unwritableBucket{Bucket: mem, key: "b"}
+	errs := make(chan error)
+	var got []error
+	done := make(chan bool)
+	go func() {
+		for err := range errs {
+			got = append(got, err)
+		}
+		close(done)
+	}()
+	n := mirror(ctx, sbkt, dbkt, nil, mirrorOpts{}, errs)
+	close(errs)
+	<-done
+	if n != 2 {
+		t.Errorf("expected the writable objects to be copied, copied %d", n)
+	}
+	if len(got) != 1 || !errors.Is(got[0], errUnwritable) {
+		t.Errorf("expected the unwritable key to fail, got %v", got)
+	}
+	if exists, _ := mem.Exists(ctx, "b"); exists {
+		t.Error("the unwritable object was copied")
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// saves the state file every so often while copying, so a run that crashes
+// only loses what was done since the last save. it's only checked between
+// objects, like the deadline.
+type checkpoint struct {
+	path string
+	key  []byte
+	st   *state
+	// save after this many objects are recorded, or after this long. 0 is never.
+	every    int
+	interval time.Duration
+
+	// what the state was at the last save.
+	savedAt      time.Time
+	savedRecords int
+	savedLastKey string
+}
+
+// a -checkpoint-interval is a number of objects, like 1000, or a duration, like 30s.
+func parseCheckpointInterval(s string) (every int, interval time.Duration, err error) {
+	if s == "" {
+		return 0, 0, nil
+	}
+	if n, err := strconv.Atoi(s); err == nil {
+		if n <= 0 {
+			return 0, 0, fmt.Errorf("bad checkpoint interval %q: the number of objects has to be positive", s)
+		}
+		return n, 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, 0, fmt.Errorf("bad checkpoint interval %q: use a number of objects or a duration", s)
+	}
+	if d <= 0 {
+		return 0, 0, fmt.Errorf("bad checkpoint interval %q: the duration has to be positive", s)
+	}
+	return 0, d, nil
+}
+
+func newCheckpoint(path string, key []byte, st *state, every int, interval time.Duration) *checkpoint {
+	return &checkpoint{path: path, key: key, st: st, every: every, interval: interval, savedAt: time.Now(), savedRecords: st.records, savedLastKey: st.LastKey}
+}
+
+// saves the state if it's due and anything changed. A nil checkpoint never saves.
+func (c *checkpoint) tick() error {
+	if c == nil {
+		return nil
+	}
+	changed := c.st.records != c.savedRecords || c.st.LastKey != c.savedLastKey
+	due := (c.every > 0 && c.st.records-c.savedRecords >= c.every) ||
+		(c.interval > 0 && time.Since(c.savedAt) >= c.interval)
+	if !changed || !due {
+		return nil
+	}
+	if err := saveState(c.path, c.st, c.key); err != nil {
+		return err
+	}
+	c.savedAt, c.savedRecords, c.savedLastKey = time.Now(), c.st.records, c.st.LastKey
+	logf(logVerbose, "saved state, %d objects\n", len(c.st.Objects))
+	return nil
+}
+
+// the first SIGINT or SIGTERM stops the copy between objects, like the deadline,
+// so the state is saved as it would be at the end of a run. a second one is
+// left to kill the process as usual.
+func stopOnSignal() (*deadline, func()) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	return &deadline{ctx: ctx}, stop
+}
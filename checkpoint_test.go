@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseCheckpointInterval(t *testing.T) {
+	for _, c := range []struct {
+		in       string
+		every    int
+		interval time.Duration
+		ok       bool
+	}{
+		{"", 0, 0, true},
+		{"1000", 1000, 0, true},
+		{"30s", 0, 30 * time.Second, true},
+		{"0", 0, 0, false},
+		{"-5", 0, 0, false},
+		{"0s", 0, 0, false},
+		{"often", 0, 0, false},
+	} {
+		every, interval, err := parseCheckpointInterval(c.in)
+		if (err == nil) != c.ok || every != c.every || interval != c.interval {
+			t.Errorf("%q: got %d %v %v", c.in, every, interval, err)
+		}
+	}
+}
+
+// a run that dies between checkpoints loses at most the objects since the last one.
+func TestCheckpointCrash(t *testing.T) {
+	ctx := context.Background()
+	const objects, every = 10, 3
+	path := filepath.Join(t.TempDir(), "state")
+	st := newState()
+	fb := &faultBucket{}
+	sbkt := testFaultBucket(t, fb)
+	_, dbkt := testMemBuckets(t)
+	for i := 0; i < objects; i++ {
+		if err := sbkt.WriteAll(ctx, fmt.Sprintf("file%02d", i), testRandomData(t), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// what a crash at each read would leave behind.
+	fb.read = func(key string) error {
+		saved, err := loadState(path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lost := len(st.Objects) - len(saved.Objects); lost < 0 || lost >= every {
+			t.Errorf("reading %s: %d objects done, %d saved", key, len(st.Objects), len(saved.Objects))
+		}
+		return nil
+	}
+	errs := make(chan error)
+	go func() {
+		for err := range errs {
+			t.Error(err)
+		}
+	}()
+	opts := mirrorOpts{state: st, checkpoint: newCheckpoint(path, nil, st, every, 0)}
+	mirror(ctx, sbkt, dbkt, nil, opts, errs)
+	close(errs)
+
+	// and crashing before the final save.
+	saved, err := loadState(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved.Objects) != objects/every*every {
+		t.Errorf("expected %d objects saved, got %d", objects/every*every, len(saved.Objects))
+	}
+	if saved.LastKey == "" {
+		t.Error("the saved state should have a LastKey to resume from")
+	}
+}
+
+func TestCheckpointTick(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state")
+	key := testAuthentication(t)
+	st := newState()
+	c := newCheckpoint(path, key, st, 0, time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	if err := c.tick(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("nothing changed, nothing should have been saved: %v", err)
+	}
+	st.record("a", stateEntry{DstKey: "a", Size: 1})
+	time.Sleep(time.Millisecond)
+	if err := c.tick(); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := loadState(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := saved.Objects["a"]; !ok {
+		t.Error("the state wasn't saved once its interval passed")
+	}
+	var none *checkpoint
+	if err := none.tick(); err != nil {
+		t.Error(err)
+	}
+}
+
+// an interrupted copy finishes the object it's on and stops.
+func TestCheckpointInterrupt(t *testing.T) {
+	ctx := context.Background()
+	fb := &faultBucket{}
+	sbkt := testFaultBucket(t, fb)
+	_, dbkt := testMemBuckets(t)
+	for _, key := range []string{"a", "b", "c"} {
+		if err := sbkt.WriteAll(ctx, key, []byte(key), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sigCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	fb.read = func(key string) error {
+		if key == "b" {
+			cancel()
+		}
+		return nil
+	}
+	errs := make(chan error)
+	go func() {
+		for err := range errs {
+			t.Error(err)
+		}
+	}()
+	st := newState()
+	opts := mirrorOpts{state: st, interrupt: &deadline{ctx: sigCtx}}
+	n := mirror(ctx, sbkt, dbkt, nil, opts, errs)
+	close(errs)
+	if n != 2 || st.LastKey != "b" || !opts.interrupt.reached {
+		t.Errorf("expected to stop after b, copied %d, LastKey %q", n, st.LastKey)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"strconv"
+	"strings"
+
+	"gocloud.dev/blob"
+)
+
+// metadata that holds the hex md5 of an object's content as blobcopy wrote it,
+// so it can be checked later even on a backend that doesn't report md5s.
+const md5Meta = "x-blobcopy-md5"
+
+// the md5 recorded in an object's metadata. nil if there isn't one, or it's garbled.
+func recordedMD5(attrs *blob.Attributes) []byte {
+	sum, err := hex.DecodeString(attrs.Metadata[md5Meta])
+	if err != nil || len(sum) != 16 {
+		return nil
+	}
+	return sum
+}
+
+// replaces the md5 the backend reports with the recorded one, when there is one.
+func preferRecordedMD5(attrs *blob.Attributes) {
+	if sum := recordedMD5(attrs); sum != nil {
+		attrs.MD5 = sum
+	}
+}
+
+// reports whether etag is the etag of an S3 multipart upload, like
+// "9b2cf535f27731c974343645a3985328-5": the md5 of the md5s of the parts, and
+// how many there were. it's no md5 of the content, so go-cloud reports none.
+func multipartETag(etag string) bool {
+	sum, parts, ok := strings.Cut(strings.Trim(etag, `"`), "-")
+	if !ok || len(sum) != 32 {
+		return false
+	}
+	if _, err := hex.DecodeString(sum); err != nil {
+		return false
+	}
+	n, err := strconv.Atoi(parts)
+	return err == nil && n > 0
+}
+
+// with -rehash-multipart, attrs with the md5 of key worked out by reading it,
+// when it has none because it was uploaded in parts. Otherwise attrs as they are.
+func (opts mirrorOpts) multipartMD5(ctx context.Context, bkt Bucket, key string, attrs *blob.Attributes, ropts *blob.ReaderOptions) (*blob.Attributes, error) {
+	if !opts.rehashMultipart || len(attrs.MD5) != 0 || !multipartETag(attrs.ETag) {
+		return attrs, nil
+	}
+	local, err := localAttrs(ctx, bkt, key, ropts)
+	if err != nil {
+		return nil, err
+	}
+	a := *attrs
+	a.MD5 = local.MD5
+	return &a, nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"testing"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/driver"
+)
+
+func TestStoreMD5(t *testing.T) {
+	ctx := context.Background()
+	sbkt, _ := testMemBuckets(t)
+	reads := 0
+	fb := &faultBucket{
+		// a backend that doesn't report md5s.
+		attrs: func(key string, a *driver.Attributes) { a.MD5 = nil },
+		read: func(key string) error {
+			reads++
+			return nil
+		},
+	}
+	dbkt := testFaultBucket(t, fb)
+	data := testRandomData(t)
+	if err := sbkt.WriteAll(ctx, "file", data, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	errs := make(chan error)
+	go func() {
+		for err := range errs {
+			t.Error(err)
+		}
+	}()
+	opts := mirrorOpts{storeMD5: true}
+	if n := mirror(ctx, sbkt, dbkt, nil, opts, errs); n != 1 {
+		t.Errorf("expected 1 copied, got %d", n)
+	}
+	attrs, err := dbkt.Attributes(ctx, "file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := md5.Sum(data)
+	if got := attrs.Metadata[md5Meta]; got != hex.EncodeToString(sum[:]) {
+		t.Errorf("wrong recorded md5 %q", got)
+	}
+
+	// verify goes by the recorded md5, without reading the destination.
+	reads = 0
+	report := verify(ctx, sbkt, dbkt, opts, errs)
+	if !report.ok() {
+		t.Errorf("verify failed: %+v", report)
+	}
+	if reads != 0 {
+		t.Errorf("destination read %d times, the recorded md5 should do", reads)
+	}
+
+	// a recorded md5 that doesn't match is a difference, even if the content does.
+	wrong := md5.Sum([]byte("something else"))
+	wopts := &blob.WriterOptions{Metadata: map[string]string{md5Meta: hex.EncodeToString(wrong[:])}}
+	if err := fb.bkt.WriteAll(ctx, "file", data, wopts); err != nil {
+		t.Fatal(err)
+	}
+	report = verify(ctx, sbkt, dbkt, opts, errs)
+	if len(report.differ) != 1 {
+		t.Errorf("expected the recorded md5 to differ: %+v", report)
+	}
+	close(errs)
+}
+
+func TestMultipartETag(t *testing.T) {
+	for etag, expected := range map[string]bool{
+		`"9b2cf535f27731c974343645a3985328-5"`: true,
+		"9b2cf535f27731c974343645a3985328-12":  true,
+		`"9b2cf535f27731c974343645a3985328"`:   false,
+		`"9b2cf535f27731c974343645a3985328-"`:  false,
+		`"9b2cf535f27731c974343645a3985328-0"`: false,
+		`"not-hex-at-all-xxxxxxxxxxxxxxxxx-2"`: false,
+		"":                                     false,
+	} {
+		if got := multipartETag(etag); got != expected {
+			t.Errorf("%s: expected %v, got %v", etag, expected, got)
+		}
+	}
+}
+
+// a source object uploaded in parts has no md5, just a multipart etag. Going by size,
+// a destination object of the same size looks like a copy. -rehash-multipart reads it to find out.
+func TestRehashMultipart(t *testing.T) {
+	ctx := context.Background()
+	fb := &faultBucket{attrs: func(key string, a *driver.Attributes) {
+		a.MD5 = nil
+		a.ETag = `"9b2cf535f27731c974343645a3985328-3"`
+	}}
+	sbkt := testFaultBucket(t, fb)
+	_, dbkt := testMemBuckets(t)
+	if err := sbkt.WriteAll(ctx, "big", []byte("new content"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := dbkt.WriteAll(ctx, "big", []byte("old content"), nil); err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error)
+	go func() {
+		for err := range errs {
+			t.Error(err)
+		}
+	}()
+	if n := mirror(ctx, sbkt, dbkt, nil, mirrorOpts{verifymd5: true}, errs); n != 0 {
+		t.Fatalf("expected the same size to be taken for a copy, copied %d", n)
+	}
+	if report := verify(ctx, sbkt, dbkt, mirrorOpts{rehashMultipart: true}, errs); len(report.differ) != 1 {
+		t.Errorf("expected verify to find the difference, got %+v", report)
+	}
+	opts := mirrorOpts{verifymd5: true, rehashMultipart: true}
+	if n := mirror(ctx, sbkt, dbkt, nil, opts, errs); n != 1 {
+		t.Fatalf("expected the object read and copied, copied %d", n)
+	}
+	if got := testReadString(t, dbkt, "big"); got != "new content" {
+		t.Errorf("expected the new content, got %q", got)
+	}
+	if n := mirror(ctx, sbkt, dbkt, nil, opts, errs); n != 0 {
+		t.Errorf("expected nothing copied once the md5s match, copied %d", n)
+	}
+	if report := verify(ctx, sbkt, dbkt, mirrorOpts{rehashMultipart: true}, errs); !report.ok() {
+		t.Errorf("expected the copy to verify, got %+v", report)
+	}
+	close(errs)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"fmt"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// the AEADs -cipher picks between. ChaCha20-Poly1305 is faster where AES has no
+// hardware support, and just as safe.
+const (
+	cipherAESGCM = "aes-gcm"
+	cipherChaCha = "chacha20poly1305"
+)
+
+// what encrypt and encryptRandom seal with. decrypt doesn't need to be told,
+// it goes by the framing.
+var sealCipher = cipherAESGCM
+
+// ChaCha20-Poly1305 output starts with this, then the nonce and sealed text.
+// AES-GCM output has no header, so whatever was encrypted before -cipher still
+// decrypts.
+var chachaHeader = []byte("\xbcCC1")
+
+func validCipher(name string) error {
+	switch name {
+	case cipherAESGCM, cipherChaCha:
+		return nil
+	}
+	return fmt.Errorf("unknown cipher %q. use %s or %s", name, cipherAESGCM, cipherChaCha)
+}
+
+func newAEAD(name string, key []byte) (cipher.AEAD, error) {
+	if name == cipherChaCha {
+		return chacha20poly1305.New(key)
+	}
+	c, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(c)
+}
+
+// a new slice with the header of name's framing, for the nonce and text to go after.
+func cipherHeader(name string) []byte {
+	if name == cipherChaCha {
+		return append([]byte(nil), chachaHeader...)
+	}
+	return nil
+}
+
+// opens nonce and sealed text with name.
+func openWith(name string, key, cyphertext []byte) ([]byte, error) {
+	aead, err := newAEAD(name, key)
+	if err != nil {
+		return nil, err
+	}
+	nonceSize := aead.NonceSize()
+	if len(cyphertext) < nonceSize+aead.Overhead() {
+		return nil, ErrShortCyphertext
+	}
+	nonce, cyphertext := cyphertext[:nonceSize], cyphertext[nonceSize:]
+	return aead.Open(nil, nonce, cyphertext, nil)
+}
+
+// opens what encrypt or encryptRandom sealed, with whichever cipher the framing
+// says. An AES-GCM nonce can start like the header by chance, so when ChaCha20
+// doesn't open it, it's tried as AES-GCM.
+func openFramed(key, cyphertext []byte) ([]byte, error) {
+	if body, ok := bytes.CutPrefix(cyphertext, chachaHeader); ok {
+		if text, err := openWith(cipherChaCha, key, body); err == nil {
+			return text, nil
+		}
+	}
+	return openWith(cipherAESGCM, key, cyphertext)
+}
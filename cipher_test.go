@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func testCipher(t *testing.T, name string) {
+	t.Helper()
+	old := sealCipher
+	sealCipher = name
+	t.Cleanup(func() { sealCipher = old })
+}
+
+func TestCipherRoundTrip(t *testing.T) {
+	key := testAuthentication(t)
+	text := testRandomData(t)
+	for _, name := range []string{cipherAESGCM, cipherChaCha} {
+		testCipher(t, name)
+		a, err := encrypt(text, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := encrypt(text, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the skip logic needs the same text to encrypt the same way.
+		if !bytes.Equal(a, b) {
+			t.Errorf("%s: encrypting twice gave different ciphertexts", name)
+		}
+		if got, err := decrypt(a, key); err != nil || !bytes.Equal(got, text) {
+			t.Errorf("%s: round trip got %d bytes, err %v", name, len(got), err)
+		}
+		r, err := encryptRandom(text, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := decrypt(r, key); err != nil || !bytes.Equal(got, text) {
+			t.Errorf("%s: random nonce round trip got %d bytes, err %v", name, len(got), err)
+		}
+		if bytes.HasPrefix(a, chachaHeader) != (name == cipherChaCha) {
+			t.Errorf("%s: unexpected framing %x", name, a[:len(chachaHeader)])
+		}
+
+		const path = "dir/file.txt"
+		enc, err := makeKey(path, key, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dec, err := makeKey(enc, nil, key)
+		if err != nil || dec != path {
+			t.Errorf("%s: expected %q back from %q, got %q err %v", name, path, enc, dec, err)
+		}
+	}
+}
+
+// whatever was encrypted with one cipher only ever opens with that one.
+func TestCipherMismatch(t *testing.T) {
+	key := testAuthentication(t)
+	text := testRandomData(t)
+	testCipher(t, cipherAESGCM)
+	aesText, err := encrypt(text, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCipher(t, cipherChaCha)
+	chachaText, err := encrypt(text, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, c := range map[string][]byte{
+		"aes-gcm with a chacha20 header":  append(append([]byte(nil), chachaHeader...), aesText...),
+		"chacha20 without its header":     chachaText[len(chachaHeader):],
+		"chacha20 header on aes-gcm body": append(append([]byte(nil), chachaHeader...), aesText[len(chachaHeader):]...),
+	} {
+		if plain, err := decrypt(c, key); err == nil || plain != nil {
+			t.Errorf("%s: decrypted %d bytes, err %v", name, len(plain), err)
+		}
+	}
+	if _, err := openWith(cipherAESGCM, key, chachaText[len(chachaHeader):]); err == nil {
+		t.Error("expected chacha20 ciphertext not to open with aes-gcm")
+	}
+	if _, err := openWith(cipherChaCha, key, aesText); err == nil {
+		t.Error("expected aes-gcm ciphertext not to open with chacha20")
+	}
+}
+
+func TestValidCipher(t *testing.T) {
+	for name, ok := range map[string]bool{cipherAESGCM: true, cipherChaCha: true, "": false, "rot13": false} {
+		if err := validCipher(name); (err == nil) != ok {
+			t.Errorf("%q: got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/gcsblob"
+	"gocloud.dev/gcp"
+)
+
+// provider settings given as flags rather than in each bucket URL.
+type cloudFlags struct {
+	awsProfile string
+	awsRegion  string
+	gcpProject string
+}
+
+// checks that the provider flags apply to at least one of the bucket URLs.
+// an -aws-region with only gs:// buckets is a mistake, not something to ignore.
+func (c cloudFlags) check(urls []string) error {
+	has := func(scheme string) bool {
+		for _, u := range urls {
+			if strings.HasPrefix(u, scheme+"://") {
+				return true
+			}
+		}
+		return false
+	}
+	if (c.awsProfile != "" || c.awsRegion != "") && !has("s3") {
+		return fmt.Errorf("-aws-profile and -aws-region need an s3:// bucket")
+	}
+	if c.gcpProject != "" && !has(gcsblob.Scheme) {
+		return fmt.Errorf("-gcp-project needs a gs:// bucket")
+	}
+	return nil
+}
+
+// adds the aws flags to the query of an s3:// URL, where go-cloud reads them.
+// other URLs are returned as they are. A URL that already sets a different
+// region or profile is an error, rather than silently picking one.
+func (c cloudFlags) bucketURL(bucketURL string) (string, error) {
+	if !strings.HasPrefix(bucketURL, "s3://") {
+		return bucketURL, nil
+	}
+	u, err := url.Parse(bucketURL)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	for param, value := range map[string]string{"profile": c.awsProfile, "region": c.awsRegion} {
+		if value == "" {
+			continue
+		}
+		if have := q.Get(param); have != "" && have != value {
+			return "", fmt.Errorf("%s: the URL's %s %q doesn't match -aws-%s %q", bucketURL, param, have, param, value)
+		}
+		q.Set(param, value)
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// opens a bucket URL with the flags applied. With -gcp-project, requests to
+// gs:// buckets are billed to that project, which requester pays buckets need.
+func (c cloudFlags) open(ctx context.Context, bucketURL string) (*blob.Bucket, error) {
+	bucketURL, err := c.bucketURL(bucketURL)
+	if err != nil {
+		return nil, err
+	}
+	if c.gcpProject == "" || !strings.HasPrefix(bucketURL, gcsblob.Scheme+"://") {
+		return blob.OpenBucket(ctx, bucketURL)
+	}
+	creds, err := gcp.DefaultCredentials(ctx)
+	if err != nil {
+		return nil, err
+	}
+	client, err := gcp.NewHTTPClient(&userProjectTransport{base: gcp.DefaultTransport(), project: c.gcpProject}, gcp.CredentialsTokenSource(creds))
+	if err != nil {
+		return nil, err
+	}
+	mux := new(blob.URLMux)
+	mux.RegisterBucket(gcsblob.Scheme, &gcsblob.URLOpener{Client: client})
+	return mux.OpenBucket(ctx, bucketURL)
+}
+
+// sets the project that google bills a request to.
+type userProjectTransport struct {
+	base    http.RoundTripper
+	project string
+}
+
+func (t *userProjectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// RoundTrippers must not change the request they're given.
+	req = req.Clone(req.Context())
+	req.Header.Set("X-Goog-User-Project", t.project)
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestCloudFlagsCheck(t *testing.T) {
+	for _, c := range []struct {
+		flags cloudFlags
+		urls  []string
+		ok    bool
+	}{
+		{cloudFlags{}, []string{"mem://", "file:///tmp"}, true},
+		{cloudFlags{awsRegion: "us-east-2"}, []string{"mem://", "s3://bucket"}, true},
+		{cloudFlags{awsProfile: "backup"}, []string{"gs://bucket", "file:///tmp"}, false},
+		{cloudFlags{gcpProject: "proj"}, []string{"gs://bucket", "s3://bucket"}, true},
+		{cloudFlags{gcpProject: "proj"}, []string{"s3://bucket"}, false},
+	} {
+		err := c.flags.check(c.urls)
+		if (err == nil) != c.ok {
+			t.Errorf("%+v %v: unexpected error %v", c.flags, c.urls, err)
+		}
+	}
+}
+
+func TestCloudFlagsBucketURL(t *testing.T) {
+	c := cloudFlags{awsProfile: "backup", awsRegion: "eu-west-1"}
+	got, err := c.bucketURL("s3://bucket?prefix=dir/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if u.Host != "bucket" || q.Get("profile") != "backup" || q.Get("region") != "eu-west-1" || q.Get("prefix") != "dir/" {
+		t.Errorf("unexpected URL %s", got)
+	}
+
+	// the same region in the URL is fine, a different one isn't.
+	if _, err := c.bucketURL("s3://bucket?region=eu-west-1"); err != nil {
+		t.Error(err)
+	}
+	if _, err := c.bucketURL("s3://bucket?region=us-east-1"); err == nil {
+		t.Error("expected an error for a conflicting region")
+	}
+	// other providers are left alone.
+	for _, in := range []string{"gs://bucket?prefix=x", "mem://", "file:///tmp/dir"} {
+		if got, err := c.bucketURL(in); err != nil || got != in {
+			t.Errorf("%s: changed to %s, err %v", in, got, err)
+		}
+	}
+}
+
+func TestUserProjectTransport(t *testing.T) {
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("X-Goog-User-Project")
+	}))
+	defer srv.Close()
+	client := &http.Client{Transport: &userProjectTransport{base: http.DefaultTransport, project: "proj"}}
+	req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got != "proj" {
+		t.Errorf("expected the project header, got %q", got)
+	}
+	if req.Header.Get("X-Goog-User-Project") != "" {
+		t.Error("the transport changed the caller's request")
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// how many keys each repeated error lists as examples.
+const errorExamples = 3
+
+// an error about an object, with the keys its message may contain. Error is the
+// message as it is, the keys are only for grouping.
+type keyedError struct {
+	err  error
+	keys []string
+}
+
+func (e *keyedError) Error() string { return e.err.Error() }
+func (e *keyedError) Unwrap() error { return e.err }
+
+// err with the source and destination keys of the object it's about. empty keys are left out.
+func withKeys(err error, keys ...string) error {
+	var ks []string
+	for _, k := range keys {
+		if k != "" {
+			ks = append(ks, k)
+		}
+	}
+	if len(ks) == 0 {
+		return err
+	}
+	return &keyedError{err: err, keys: ks}
+}
+
+// the message of err with its keys taken out, so the same failure on two objects reads the same.
+func normalizeError(err error) (msg, example string) {
+	msg = err.Error()
+	var ke *keyedError
+	if !errors.As(err, &ke) {
+		return msg, ""
+	}
+	keys := append([]string(nil), ke.keys...)
+	// a key that's part of a longer one mustn't break it up.
+	sort.Slice(keys, func(i, j int) bool { return len(keys[i]) > len(keys[j]) })
+	for _, k := range keys {
+		msg = replaceKey(msg, k)
+	}
+	return msg, ke.keys[0]
+}
+
+// replaces key in msg where it's clearly a key: quoted or bracketed, at the start,
+// or after a space and before a colon, a comma, a bracket or the end, like "copying a: ...".
+// a key like "a" doesn't eat into words, or stand for them: "not a file" stays.
+func replaceKey(msg, key string) string {
+	var b strings.Builder
+	for {
+		i := strings.Index(msg, key)
+		if i < 0 {
+			b.WriteString(msg)
+			return b.String()
+		}
+		end := i + len(key)
+		if standsAlone(msg, i, end) {
+			b.WriteString(msg[:i])
+			b.WriteString("<key>")
+		} else {
+			b.WriteString(msg[:end])
+		}
+		msg = msg[end:]
+	}
+}
+
+// the closing character for each opening one a key can be enclosed in.
+var keyEnclosures = map[byte]byte{'"': '"', '\'': '\'', '[': ']', '(': ')'}
+
+// whether msg[i:end] is a key on its own rather than part of the words around it.
+func standsAlone(msg string, i, end int) bool {
+	if i > 0 && end < len(msg) {
+		if closing, ok := keyEnclosures[msg[i-1]]; ok && msg[end] == closing {
+			return true
+		}
+	}
+	rest := msg[end:]
+	// a message may start with the key, like "a is a symlink".
+	if i == 0 && strings.HasPrefix(rest, " ") {
+		return true
+	}
+	if i > 0 && msg[i-1] != ' ' {
+		return false
+	}
+	return rest == "" || strings.HasPrefix(rest, ":") || strings.HasPrefix(rest, ",") ||
+		strings.HasPrefix(rest, " [") || strings.HasPrefix(rest, " (")
+}
+
+// one kind of error, and how often it happened.
+type errorTally struct {
+	msg      string
+	count    int
+	examples []string
+}
+
+// counts errors by their normalized message, so one that repeats for
+// thousands of objects is printed once rather than drowning out the rest.
+type errorCoalescer struct {
+	tallies map[string]*errorTally
+	// in the order they first happened.
+	order []*errorTally
+}
+
+func newErrorCoalescer() *errorCoalescer {
+	return &errorCoalescer{tallies: make(map[string]*errorTally)}
+}
+
+// counts err, and reports whether it's the first of its kind.
+func (c *errorCoalescer) add(err error) bool {
+	msg, example := normalizeError(err)
+	t, ok := c.tallies[msg]
+	if !ok {
+		t = &errorTally{msg: msg}
+		c.tallies[msg] = t
+		c.order = append(c.order, t)
+	}
+	t.count++
+	if example != "" && len(t.examples) < errorExamples {
+		t.examples = append(t.examples, example)
+	}
+	return !ok
+}
+
+// the errors that happened more than once, most frequent first.
+func (c *errorCoalescer) repeated() []*errorTally {
+	var ts []*errorTally
+	for _, t := range c.order {
+		if t.count > 1 {
+			ts = append(ts, t)
+		}
+	}
+	sort.SliceStable(ts, func(i, j int) bool { return ts[i].count > ts[j].count })
+	return ts
+}
+
+// logs a line with the tally of each error that was only printed the first time.
+func (c *errorCoalescer) report(l *log.Logger) {
+	for _, t := range c.repeated() {
+		line := fmt.Sprintf("%d times: %s", t.count, t.msg)
+		if len(t.examples) > 0 {
+			line += fmt.Sprintf(" (e.g. %s)", strings.Join(t.examples, ", "))
+		}
+		l.Println(line)
+	}
+}
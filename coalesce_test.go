@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestErrorCoalescer(t *testing.T) {
+	c := newErrorCoalescer()
+	keys := []string{"a", "data/b.txt", "c", "d", "e"}
+	for i, key := range keys {
+		err := withKeys(fmt.Errorf("error reading %s: blob (key %q) (code=PermissionDenied): %w", key, key, errInjected), key, "enc-"+key)
+		if first := c.add(err); first != (i == 0) {
+			t.Errorf("%s: expected first %v, got %v", key, i == 0, first)
+		}
+		if !errors.Is(err, errInjected) {
+			t.Error("expected the keyed error to unwrap")
+		}
+	}
+	if !c.add(errors.New("bucket is gone")) || c.add(errors.New("bucket is gone")) {
+		t.Error("expected identical errors without keys to be coalesced too")
+	}
+	if !c.add(withKeys(errors.New("unable to get attributes for c"), "c")) {
+		t.Error("expected a different error to be printed")
+	}
+
+	repeated := c.repeated()
+	if len(repeated) != 2 {
+		t.Fatalf("expected 2 repeated errors, got %+v", repeated)
+	}
+	want := `error reading <key>: blob (key "<key>") (code=PermissionDenied): injected fault`
+	if r := repeated[0]; r.msg != want || r.count != 5 || strings.Join(r.examples, ",") != "a,data/b.txt,c" {
+		t.Errorf("unexpected tally %+v", r)
+	}
+	if r := repeated[1]; r.msg != "bucket is gone" || r.count != 2 || len(r.examples) != 0 {
+		t.Errorf("unexpected tally %+v", r)
+	}
+
+	var buf bytes.Buffer
+	c.report(log.New(&buf, "", 0))
+	expected := "5 times: " + want + " (e.g. a, data/b.txt, c)\n2 times: bucket is gone\n"
+	if buf.String() != expected {
+		t.Errorf("expected %q, got %q", expected, buf.String())
+	}
+}
+
+func TestReplaceKey(t *testing.T) {
+	for _, c := range []struct {
+		msg, key, expected string
+	}{
+		{"error copying a: not a file", "a", "error copying <key>: not a file"},
+		{"a is a symlink", "a", "<key> is a symlink"},
+		{`blob (key "a") (code=NotFound)`, "a", `blob (key "<key>") (code=NotFound)`},
+		{"error copying data [data.gz]", "data", "error copying <key> [data.gz]"},
+		{"database down", "data", "database down"},
+	} {
+		if got := replaceKey(c.msg, c.key); got != c.expected {
+			t.Errorf("%q %q: expected %q, got %q", c.msg, c.key, c.expected, got)
+		}
+	}
+}
+
+// a destination that fails every object the same way makes one line for verify too.
+func TestVerifyCoalesced(t *testing.T) {
+	ctx := context.Background()
+	sbkt, _ := testMemBuckets(t)
+	keys := []string{"a", "b", "c", "d"}
+	for _, key := range keys {
+		if err := sbkt.WriteAll(ctx, key, testRandomData(t), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dbkt := testFaultBucket(t, &faultBucket{attributes: func(string) error { return errInjected }})
+	c := newErrorCoalescer()
+	errs := make(chan error)
+	done := make(chan bool)
+	go func() {
+		for err := range errs {
+			c.add(err)
+		}
+		close(done)
+	}()
+	report := verify(ctx, sbkt, dbkt, mirrorOpts{verifyWorkers: 1}, errs)
+	close(errs)
+	<-done
+	if report.problems != len(keys) {
+		t.Errorf("expected %d problems, got %+v", len(keys), report)
+	}
+	repeated := c.repeated()
+	if len(repeated) != 1 || repeated[0].count != len(keys) {
+		t.Fatalf("expected one error for all %d objects, got %+v", len(keys), repeated)
+	}
+	if !strings.HasPrefix(repeated[0].msg, "error verifying <key>: ") {
+		t.Errorf("unexpected message %q", repeated[0].msg)
+	}
+}
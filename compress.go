@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/storage"
+	"github.com/klauspost/compress/zstd"
+	"gocloud.dev/blob"
+)
+
+// added to destination keys with -compress, so it's clear what the objects hold.
+const (
+	gzipSuffix = ".gz"
+	zstdSuffix = ".zst"
+)
+
+// how -compress compresses, and how that's told apart afterwards: the key
+// suffix, and the ContentEncoding written with the object.
+type compressCodec struct {
+	name       string
+	suffix     string
+	encoding   string
+	compress   transform
+	decompress transform
+}
+
+var (
+	gzipCodec = &compressCodec{name: "gzip", suffix: gzipSuffix, encoding: "gzip", compress: gzipBytes, decompress: gunzipBytes}
+	zstdCodec = &compressCodec{name: "zstd", suffix: zstdSuffix, encoding: "zstd", compress: zstdBytes, decompress: unzstdBytes}
+	codecs    = []*compressCodec{gzipCodec, zstdCodec}
+)
+
+// the codec for -compress-codec. none is nil.
+func parseCodec(name string) (*compressCodec, error) {
+	if name == "none" {
+		return nil, nil
+	}
+	for _, c := range codecs {
+		if name == c.name {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown compression codec %q. use gzip, zstd or none", name)
+}
+
+// the codec an object was compressed with, going by its ContentEncoding and
+// then the suffix of its key. nil when neither says.
+func detectCodec(key, contentEncoding string) *compressCodec {
+	contentEncoding = strings.ToLower(strings.TrimSpace(contentEncoding))
+	for _, c := range codecs {
+		if contentEncoding == c.encoding || c == gzipCodec && contentEncoding == "x-gzip" {
+			return c
+		}
+	}
+	for _, c := range codecs {
+		if strings.HasSuffix(key, c.suffix) {
+			return c
+		}
+	}
+	return nil
+}
+
+// the codec of -compress, gzip unless another one was picked.
+func (opts mirrorOpts) compressCodec() *compressCodec {
+	if opts.codec == nil {
+		return gzipCodec
+	}
+	return opts.codec
+}
+
+// how to read back what -compress wrote. GCS decompresses gzip objects on the
+// way out unless it's asked not to, and the bytes it stored are what's compared.
+func (opts mirrorOpts) destReadOptions() *blob.ReaderOptions {
+	if !opts.compress {
+		return nil
+	}
+	return &blob.ReaderOptions{BeforeRead: func(as func(interface{}) bool) error {
+		var h **storage.ObjectHandle
+		if as(&h) {
+			*h = (*h).ReadCompressed(true)
+		}
+		return nil
+	}}
+}
+
+// the codec dstKey in dbkt was compressed with, for undoing it. The codec of
+// -compress when the object doesn't say.
+func (opts mirrorOpts) destCodec(ctx context.Context, dbkt Bucket, dstKey string) *compressCodec {
+	if attrs, err := dbkt.Attributes(ctx, dstKey); err == nil {
+		if c := detectCodec(dstKey, attrs.ContentEncoding); c != nil {
+			return c
+		}
+	}
+	return opts.compressCodec()
+}
+
+// metadata that holds the hex md5 of the source content of a compressed object.
+// The stored bytes are compressed, so their md5 can't be compared with the source's.
+const srcMD5Meta = "x-blobcopy-src-md5"
+
+// compressing the same content always gives the same bytes: the header has no
+// name or modification time, and the level is fixed. so a compressed copy can
+// still be compared by md5 to the source compressed again.
+func gzipBytes(text []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, gzip.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(text); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func gunzipBytes(text []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(text))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// one encoder and decoder do for every object: EncodeAll and DecodeAll can be
+// called at the same time. a single thread keeps the output the same each time.
+var (
+	zstdEncoder = sync.OnceValues(func() (*zstd.Encoder, error) {
+		return zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault), zstd.WithEncoderConcurrency(1))
+	})
+	zstdDecoder = sync.OnceValues(func() (*zstd.Decoder, error) {
+		return zstd.NewReader(nil)
+	})
+)
+
+// like gzipBytes, the same content always compresses to the same bytes.
+func zstdBytes(text []byte) ([]byte, error) {
+	enc, err := zstdEncoder()
+	if err != nil {
+		return nil, err
+	}
+	return enc.EncodeAll(text, nil), nil
+}
+
+func unzstdBytes(text []byte) ([]byte, error) {
+	dec, err := zstdDecoder()
+	if err != nil {
+		return nil, err
+	}
+	return dec.DecodeAll(text, nil)
+}
+
+// the transforms from the source to the destination: decrypting, then compressing,
+// then encrypting. encrypted bytes don't compress, so compressing comes first.
+func (opts mirrorOpts) transforms() []transform {
+	ts := contentTransforms(nil, opts.bytesDecrypt)
+	if opts.compress {
+		ts = append(ts, opts.compressCodec().compress)
+	}
+	return append(ts, contentTransforms(opts.bytesEncrypt, nil)...)
+}
+
+// the transforms that turn a destination object compressed with codec, nil
+// for none, back into its source.
+func (opts mirrorOpts) reverseTransforms(codec *compressCodec) []transform {
+	ts := contentTransforms(nil, opts.bytesEncrypt)
+	if codec != nil {
+		ts = append(ts, codec.decompress)
+	}
+	return append(ts, contentTransforms(opts.bytesDecrypt, nil)...)
+}
+
+// whether compressed copies record the md5 of their source. not when encrypting,
+// where it would give away something about the plaintext.
+func (opts mirrorOpts) recordSrcMD5() bool {
+	return opts.compress && len(opts.bytesEncrypt) == 0
+}
+
+// reports whether every destination has a compressed copy of the source content
+// with this md5, going by the md5 recorded when it was written. srcMD5 is only
+// called once all of them have one, since it may have to read the source.
+func compressedInSync(ctx context.Context, targets []Bucket, exists []bool, dstKey string, srcMD5 func() ([]byte, error)) bool {
+	var recorded []string
+	for i, dbkt := range targets {
+		if !exists[i] {
+			return false
+		}
+		attrs, err := dbkt.Attributes(ctx, dstKey)
+		if err != nil || attrs.Metadata[srcMD5Meta] == "" {
+			return false
+		}
+		recorded = append(recorded, attrs.Metadata[srcMD5Meta])
+	}
+	sum, err := srcMD5()
+	if err != nil || len(sum) == 0 {
+		return false
+	}
+	for _, r := range recorded {
+		if r != hex.EncodeToString(sum) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"math/rand"
+	"testing"
+
+	"gocloud.dev/blob/driver"
+)
+
+func TestGzipBytes(t *testing.T) {
+	text := bytes.Repeat(testRandomData(t), 4)
+	a, err := gzipBytes(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := gzipBytes(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(a, b) {
+		t.Error("expected compressing the same content twice to give the same bytes")
+	}
+	plain, err := gunzipBytes(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(plain, text) {
+		t.Error("decompressed content not equal to original")
+	}
+}
+
+// with -verify-md5, a second run over unchanged objects goes by the md5 the compressed copies recorded,
+// without compressing or copying anything, whether or not the source reports md5s.
+func TestCompressRerun(t *testing.T) {
+	ctx := context.Background()
+	for _, sourceMD5 := range []bool{true, false} {
+		reads := 0
+		fb := &faultBucket{
+			attrs: func(key string, a *driver.Attributes) {
+				if !sourceMD5 {
+					a.MD5 = nil
+				}
+			},
+			read: func(key string) error {
+				reads++
+				return nil
+			},
+		}
+		sbkt := testFaultBucket(t, fb)
+		_, dbkt := testMemBuckets(t)
+		text := bytes.Repeat([]byte("compressible "), 100)
+		if err := sbkt.WriteAll(ctx, "file", text, nil); err != nil {
+			t.Fatal(err)
+		}
+		errs := make(chan error)
+		go func() {
+			for err := range errs {
+				t.Error(err)
+			}
+		}()
+		opts := mirrorOpts{compress: true, verifymd5: true}
+		if n := mirror(ctx, sbkt, dbkt, nil, opts, errs); n != 1 {
+			t.Fatalf("source md5 %v: expected 1 object copied, got %d", sourceMD5, n)
+		}
+		data, err := dbkt.ReadAll(ctx, "file"+gzipSuffix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data) >= len(text) {
+			t.Errorf("expected the copy to be compressed, it's %d bytes", len(data))
+		}
+		if plain, err := gunzipBytes(data); err != nil || !bytes.Equal(plain, text) {
+			t.Fatalf("source md5 %v: the copy doesn't decompress to the source: %v", sourceMD5, err)
+		}
+		attrs, err := dbkt.Attributes(ctx, "file"+gzipSuffix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum := md5.Sum(text)
+		if attrs.Metadata[srcMD5Meta] != hex.EncodeToString(sum[:]) {
+			t.Errorf("source md5 %v: expected the source md5 recorded, got %q", sourceMD5, attrs.Metadata[srcMD5Meta])
+		}
+		if report := verify(ctx, sbkt, dbkt, opts, errs); !report.ok() {
+			t.Errorf("source md5 %v: expected the compressed copy to verify, got %+v", sourceMD5, report)
+		}
+
+		reads = 0
+		if n := mirror(ctx, sbkt, dbkt, nil, opts, errs); n != 0 {
+			t.Fatalf("source md5 %v: expected nothing copied the second time, got %d", sourceMD5, n)
+		}
+		// without an md5 from the source, it's read once to work it out, and not compressed.
+		if want := map[bool]int{true: 0, false: 1}[sourceMD5]; reads != want {
+			t.Errorf("source md5 %v: expected %d reads of the source, got %d", sourceMD5, want, reads)
+		}
+
+		if err := sbkt.WriteAll(ctx, "file", []byte("changed"), nil); err != nil {
+			t.Fatal(err)
+		}
+		if n := mirror(ctx, sbkt, dbkt, nil, opts, errs); n != 1 {
+			t.Errorf("source md5 %v: expected the changed object copied, got %d", sourceMD5, n)
+		}
+	}
+}
+
+// about 8MiB, half random and half repeated, so it spans many compression blocks.
+func testCompressible(t *testing.T) []byte {
+	t.Helper()
+	r := rand.New(rand.NewSource(1))
+	var text []byte
+	chunk := make([]byte, 1024)
+	for i := 0; i < 8192; i++ {
+		if i%2 == 0 {
+			r.Read(chunk)
+		}
+		text = append(text, chunk...)
+	}
+	return text
+}
+
+func TestCodecs(t *testing.T) {
+	for _, text := range [][]byte{nil, []byte("small"), testCompressible(t)} {
+		for _, c := range codecs {
+			a, err := c.compress(text)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := c.compress(text)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(a, b) {
+				t.Errorf("%s: expected compressing the same content twice to give the same bytes", c.name)
+			}
+			if len(text) > 1<<20 && len(a) >= len(text)*3/4 {
+				t.Errorf("%s: expected %d bytes to compress, got %d", c.name, len(text), len(a))
+			}
+			plain, err := c.decompress(a)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(plain, text) {
+				t.Errorf("%s: %d bytes don't round trip", c.name, len(text))
+			}
+		}
+	}
+}
+
+func TestParseCodec(t *testing.T) {
+	for name, expected := range map[string]*compressCodec{"gzip": gzipCodec, "zstd": zstdCodec, "none": nil} {
+		if c, err := parseCodec(name); err != nil || c != expected {
+			t.Errorf("%s: got %v %v", name, c, err)
+		}
+	}
+	if _, err := parseCodec("brotli"); err == nil {
+		t.Error("expected an error for an unknown codec")
+	}
+}
+
+func TestDetectCodec(t *testing.T) {
+	for _, c := range []struct {
+		key, encoding string
+		expected      *compressCodec
+	}{
+		{"file.gz", "", gzipCodec},
+		{"file.zst", "", zstdCodec},
+		{"file", "gzip", gzipCodec},
+		{"file", "x-gzip", gzipCodec},
+		{"file", "ZSTD", zstdCodec},
+		// the encoding says more than the key.
+		{"file.gz", "zstd", zstdCodec},
+		{"file", "", nil},
+		{"file", "br", nil},
+	} {
+		if got := detectCodec(c.key, c.encoding); got != c.expected {
+			t.Errorf("%q %q: expected %v, got %v", c.key, c.encoding, c.expected, got)
+		}
+	}
+}
+
+// a big object with each codec: the key and content encoding say what it is, a
+// rerun copies nothing, and -move reads it back with the codec it was written with.
+func TestCompressCodec(t *testing.T) {
+	ctx := context.Background()
+	text := testCompressible(t)
+	errs := make(chan error)
+	go func() {
+		for err := range errs {
+			t.Error(err)
+		}
+	}()
+	for _, c := range codecs {
+		sbkt, dbkt := testMemBuckets(t)
+		if err := sbkt.WriteAll(ctx, "file", text, nil); err != nil {
+			t.Fatal(err)
+		}
+		opts := mirrorOpts{compress: true, codec: c, verifymd5: true}
+		if n := mirror(ctx, sbkt, dbkt, nil, opts, errs); n != 1 {
+			t.Fatalf("%s: expected 1 object copied, got %d", c.name, n)
+		}
+		attrs, err := dbkt.Attributes(ctx, "file"+c.suffix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if attrs.ContentEncoding != c.encoding {
+			t.Errorf("%s: expected content encoding %q, got %q", c.name, c.encoding, attrs.ContentEncoding)
+		}
+		data, err := dbkt.ReadAll(ctx, "file"+c.suffix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if plain, err := c.decompress(data); err != nil || !bytes.Equal(plain, text) {
+			t.Errorf("%s: the copy doesn't decompress to the source: %v", c.name, err)
+		}
+		if n := mirror(ctx, sbkt, dbkt, nil, opts, errs); n != 0 {
+			t.Errorf("%s: expected nothing copied the second time, got %d", c.name, n)
+		}
+
+		// the other codec is told apart by the object, not the options.
+		other := gzipCodec
+		if c == gzipCodec {
+			other = zstdCodec
+		}
+		if err := verifyMoved(ctx, sbkt, "file", dbkt, "file"+c.suffix, mirrorOpts{compress: true, codec: other}); err != nil {
+			t.Errorf("%s: %v", c.name, err)
+		}
+	}
+	close(errs)
+}
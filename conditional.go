@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	s3v2 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"gocloud.dev/blob"
+)
+
+// a ReaderOptions.BeforeRead that asks for the object only if it changed after t.
+// S3 and http(s) sources honor it, and answer 304 Not Modified otherwise. GCS
+// conditions go by generation rather than time, so there it does nothing, and
+// neither does it on other backends: the object is read as usual.
+func ifModifiedSince(t time.Time) func(func(interface{}) bool) error {
+	return func(as func(interface{}) bool) error {
+		var v1 *s3.GetObjectInput
+		var v2 *s3v2.GetObjectInput
+		var h http.Header
+		switch {
+		case as(&v1):
+			v1.IfModifiedSince = aws.Time(t)
+		case as(&v2):
+			v2.IfModifiedSince = aws.Time(t)
+		case as(&h):
+			h.Set("If-Modified-Since", t.UTC().Format(http.TimeFormat))
+		}
+		return nil
+	}
+}
+
+// reports whether a read failed because of ifModifiedSince. the status is all the
+// backends have in common: aws v1 errors have StatusCode, aws v2 ones HTTPStatusCode.
+func notModified(err error) bool {
+	var v1 interface{ StatusCode() int }
+	var v2 interface{ HTTPStatusCode() int }
+	switch {
+	case errors.As(err, &v1):
+		return v1.StatusCode() == http.StatusNotModified
+	case errors.As(err, &v2):
+		return v2.HTTPStatusCode() == http.StatusNotModified
+	}
+	return false
+}
+
+// when the oldest of the destination copies was written, if every target has one.
+func oldestCopy(ctx context.Context, targets []Bucket, exists []bool, listed []*blob.ListObject, key string) (time.Time, bool) {
+	var oldest time.Time
+	for i, dbkt := range targets {
+		if !exists[i] {
+			return time.Time{}, false
+		}
+		var modTime time.Time
+		if listed[i] != nil {
+			modTime = listed[i].ModTime
+		} else {
+			attrs, err := dbkt.Attributes(ctx, key)
+			if err != nil {
+				return time.Time{}, false
+			}
+			modTime = attrs.ModTime
+		}
+		if modTime.IsZero() {
+			return time.Time{}, false
+		}
+		if oldest.IsZero() || modTime.Before(oldest) {
+			oldest = modTime
+		}
+	}
+	return oldest, !oldest.IsZero()
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	s3v2 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func TestIfModifiedSince(t *testing.T) {
+	since := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	v1 := &s3.GetObjectInput{}
+	if err := ifModifiedSince(since)(testAs(v1)); err != nil {
+		t.Fatal(err)
+	}
+	if v1.IfModifiedSince == nil || !v1.IfModifiedSince.Equal(since) {
+		t.Errorf("unexpected v1 condition %v", v1.IfModifiedSince)
+	}
+	v2 := &s3v2.GetObjectInput{}
+	if err := ifModifiedSince(since)(testAs(v2)); err != nil {
+		t.Fatal(err)
+	}
+	if v2.IfModifiedSince == nil || !v2.IfModifiedSince.Equal(since) {
+		t.Errorf("unexpected v2 condition %v", v2.IfModifiedSince)
+	}
+	h := http.Header{}
+	if err := ifModifiedSince(since)(testAs(h)); err != nil {
+		t.Fatal(err)
+	}
+	if got := h.Get("If-Modified-Since"); got != "Mon, 02 Jan 2023 03:04:05 GMT" {
+		t.Errorf("unexpected header %q", got)
+	}
+	// other backends just read the object.
+	if err := ifModifiedSince(since)(func(interface{}) bool { return false }); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNotModified(t *testing.T) {
+	if !notModified(fmt.Errorf("reading: %w", &httpStatusError{url: "u", status: http.StatusNotModified})) {
+		t.Error("expected a 304 to be not modified")
+	}
+	if notModified(&httpStatusError{url: "u", status: http.StatusForbidden}) || notModified(errInjected) || notModified(nil) {
+		t.Error("expected other errors not to be")
+	}
+}
+
+// the second run asks for each object with If-Modified-Since, and the server doesn't send them again.
+func TestConditionalRerun(t *testing.T) {
+	ctx := context.Background()
+	var sent atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && r.Header.Get("Range") == "" && r.Header.Get("If-Modified-Since") == "" {
+			sent.Add(1)
+		}
+		name := strings.TrimPrefix(r.URL.Path, "/")
+		http.ServeContent(w, r, name, time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC), strings.NewReader("content of "+name))
+	}))
+	defer srv.Close()
+	manifest := filepath.Join(t.TempDir(), "manifest")
+	if err := os.WriteFile(manifest, []byte("a\nb\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sbkt, err := openHTTPBucket(srv.URL, manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sbkt.Close()
+	_, dbkt := testMemBuckets(t)
+	opts := mirrorOpts{conditional: true, verifymd5: true, bytesEncrypt: testAuthentication(t)}
+
+	run := func() int {
+		errs := make(chan error)
+		go func() {
+			for err := range errs {
+				t.Error(err)
+			}
+		}()
+		defer close(errs)
+		return mirror(ctx, sbkt, dbkt, nil, opts, errs)
+	}
+	if n := run(); n != 2 {
+		t.Fatalf("expected 2 objects copied, got %d", n)
+	}
+	first := sent.Load()
+	if first == 0 {
+		t.Fatal("expected the objects to be read")
+	}
+	if n := run(); n != 0 {
+		t.Errorf("expected nothing copied again, got %d", n)
+	}
+	if got := sent.Load(); got != first {
+		t.Errorf("expected no unconditional reads on the second run, got %d more", got-first)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// options read from a -config file, so a complicated copy can be kept in version control.
+// the file is a json object whose keys are flag names, plus src and dst:
+//
+//	{"src": "file:///data", "dst": ["s3://backup"], "verify-md5": true, "exclude": ["*.tmp", "cache/*"]}
+//
+// a list gives a repeatable flag several times. Anything left out keeps the flag's default.
+type config struct {
+	src  string
+	dsts []string
+	// the values for each flag, as they'd be given on the command line.
+	flags map[string][]string
+}
+
+func loadConfig(path string) (*config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	c := &config{flags: make(map[string][]string)}
+	for name, msg := range raw {
+		switch name {
+		case "src":
+			err = json.Unmarshal(msg, &c.src)
+		case "dst":
+			// one destination or a list of them.
+			if err = json.Unmarshal(msg, &c.dsts); err != nil {
+				var dst string
+				if err = json.Unmarshal(msg, &dst); err == nil {
+					c.dsts = []string{dst}
+				}
+			}
+		default:
+			c.flags[name], err = configValues(msg)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", path, name, err)
+		}
+	}
+	return c, nil
+}
+
+// turns a json value into flag values. lists give one value per element.
+func configValues(msg json.RawMessage) ([]string, error) {
+	var list []json.RawMessage
+	if err := json.Unmarshal(msg, &list); err != nil {
+		list = []json.RawMessage{msg}
+	}
+	var values []string
+	for _, m := range list {
+		var v interface{}
+		if err := json.Unmarshal(m, &v); err != nil {
+			return nil, err
+		}
+		switch v := v.(type) {
+		case string:
+			values = append(values, v)
+		case bool:
+			values = append(values, strconv.FormatBool(v))
+		case float64:
+			values = append(values, strconv.FormatFloat(v, 'f', -1, 64))
+		default:
+			return nil, fmt.Errorf("can't use %s as a flag value", m)
+		}
+	}
+	return values, nil
+}
+
+// sets the flags from the config, except the ones in explicit, since the command
+// line wins. the flags it sets are added to explicit, they were asked for too.
+func (c *config) apply(fs *flag.FlagSet, explicit map[string]bool) error {
+	names := make([]string, 0, len(c.flags))
+	for name := range c.flags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if name == "config" || fs.Lookup(name) == nil {
+			return fmt.Errorf("config: unknown option %q", name)
+		}
+		if explicit[name] {
+			continue
+		}
+		for _, value := range c.flags[name] {
+			if err := fs.Set(name, value); err != nil {
+				return fmt.Errorf("config: %s: %w", name, err)
+			}
+		}
+		explicit[name] = true
+	}
+	return nil
+}
+
+// the src and dst arguments. The ones on the command line win over the config's.
+func (c *config) args(cmdline []string) []string {
+	if c == nil || len(cmdline) > 0 || c.src == "" {
+		return cmdline
+	}
+	return append([]string{c.src}, c.dsts...)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func testConfig(t *testing.T, content string) *config {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestConfig(t *testing.T) {
+	c := testConfig(t, `{
+		"src": "file:///data",
+		"dst": ["s3://one", "s3://two"],
+		"verify-md5": true,
+		"multipart-parts": 4,
+		"list-rps": 2.5,
+		"exclude": ["*.tmp", "cache/*"],
+		"tmp-bkt": "mem://",
+		"quiet": true
+	}`)
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	var verifymd5, quiet bool
+	var parts int
+	var rps float64
+	var exclude patternList
+	var tmp string
+	fs.BoolVar(&verifymd5, "verify-md5", false, "")
+	fs.BoolVar(&quiet, "quiet", false, "")
+	fs.IntVar(&parts, "multipart-parts", 1, "")
+	fs.Float64Var(&rps, "list-rps", 0, "")
+	fs.Var(&exclude, "exclude", "")
+	fs.StringVar(&tmp, "tmp-bkt", "", "")
+	// the command line wins.
+	if err := fs.Parse([]string{"-tmp-bkt", "file:///tmp", "-quiet=false"}); err != nil {
+		t.Fatal(err)
+	}
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	if err := c.apply(fs, explicit); err != nil {
+		t.Fatal(err)
+	}
+
+	if !verifymd5 || parts != 4 || rps != 2.5 {
+		t.Errorf("config not applied: verify-md5 %v multipart-parts %d list-rps %v", verifymd5, parts, rps)
+	}
+	if !reflect.DeepEqual([]string(exclude), []string{"*.tmp", "cache/*"}) {
+		t.Errorf("unexpected excludes %v", exclude)
+	}
+	if tmp != "file:///tmp" || quiet {
+		t.Errorf("command line flags should win, got tmp-bkt %q quiet %v", tmp, quiet)
+	}
+	if !explicit["verify-md5"] {
+		t.Error("flags set by the config count as asked for")
+	}
+
+	if got := c.args(nil); !reflect.DeepEqual(got, []string{"file:///data", "s3://one", "s3://two"}) {
+		t.Errorf("unexpected args %v", got)
+	}
+	if got := c.args([]string{"mem://", "mem://"}); len(got) != 2 || got[0] != "mem://" {
+		t.Errorf("command line args should win, got %v", got)
+	}
+	var none *config
+	if got := none.args([]string{"a", "b"}); len(got) != 2 {
+		t.Errorf("no config, got %v", got)
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	var parts int
+	fs.IntVar(&parts, "multipart-parts", 1, "")
+	for _, content := range []string{
+		`{"no-such-flag": true}`,
+		`{"multipart-parts": "many"}`,
+		`{"multipart-parts": {"a": 1}}`,
+	} {
+		c, err := func() (*config, error) {
+			path := filepath.Join(t.TempDir(), "config.json")
+			if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			return loadConfig(path)
+		}()
+		if err == nil {
+			err = c.apply(fs, make(map[string]bool))
+		}
+		if err == nil {
+			t.Errorf("%s: expected an error", content)
+		}
+	}
+	// a single destination doesn't need a list.
+	if c := testConfig(t, `{"src": "mem://", "dst": "file:///tmp"}`); !reflect.DeepEqual(c.args(nil), []string{"mem://", "file:///tmp"}) {
+		t.Errorf("unexpected args %v", c.args(nil))
+	}
+}
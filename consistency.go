@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+)
+
+// the first wait before looking a destination object up again, with -dest-consistency-retry.
+const consistencyRetryDelay = 250 * time.Millisecond
+
+// some S3 compatible stores take a while to show an object that was just written.
+// Where an object should be there, a NotFound is tried again this many times,
+// waiting twice as long each time. Copy errors aren't retried, and neither are
+// lookups of objects that may well not exist, like the check before copying.
+type consistencyRetry struct {
+	retries int
+	delay   time.Duration
+}
+
+// runs f until it doesn't fail with NotFound, or the retries run out.
+func (r consistencyRetry) do(ctx context.Context, f func() error) error {
+	delay := r.delay
+	for i := 0; ; i++ {
+		err := f()
+		if i >= r.retries || gcerrors.Code(err) != gcerrors.NotFound {
+			return err
+		}
+		logf(logVerbose, "not found, it may not be visible yet. trying again in %v\n", delay)
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+		delay *= 2
+	}
+}
+
+// the attributes of an object that should be in bkt.
+func (r consistencyRetry) attributes(ctx context.Context, bkt Bucket, key string) (*blob.Attributes, error) {
+	var attrs *blob.Attributes
+	err := r.do(ctx, func() error {
+		var err error
+		attrs, err = bkt.Attributes(ctx, key)
+		return err
+	})
+	return attrs, err
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+)
+
+// a NotFound error, the way a store reports an object it doesn't show yet.
+func testNotFound(t *testing.T) error {
+	t.Helper()
+	bkt, _ := testMemBuckets(t)
+	_, err := bkt.Attributes(context.Background(), "nothing")
+	if gcerrors.Code(err) != gcerrors.NotFound {
+		t.Fatalf("expected NotFound, got %v", err)
+	}
+	return err
+}
+
+func TestConsistencyRetry(t *testing.T) {
+	ctx := context.Background()
+	notFound := testNotFound(t)
+	calls := 0
+	once := func() error {
+		calls++
+		if calls == 1 {
+			return notFound
+		}
+		return nil
+	}
+	r := consistencyRetry{retries: 2, delay: time.Millisecond}
+	if err := r.do(ctx, once); err != nil || calls != 2 {
+		t.Errorf("expected success on the second try, got %v after %d calls", err, calls)
+	}
+	calls = 0
+	if err := (consistencyRetry{}).do(ctx, once); gcerrors.Code(err) != gcerrors.NotFound || calls != 1 {
+		t.Errorf("expected no retries by default, got %v after %d calls", err, calls)
+	}
+	calls = 0
+	if err := r.do(ctx, func() error { calls++; return notFound }); gcerrors.Code(err) != gcerrors.NotFound || calls != 3 {
+		t.Errorf("expected to give up after 2 retries, got %v after %d calls", err, calls)
+	}
+	calls = 0
+	if err := r.do(ctx, func() error { calls++; return errInjected }); !errors.Is(err, errInjected) || calls != 1 {
+		t.Errorf("expected other errors not to be retried, got %v after %d calls", err, calls)
+	}
+}
+
+// a destination that says an object exists, then doesn't show it once.
+func TestConsistencyRetryMirror(t *testing.T) {
+	ctx := context.Background()
+	notFound := testNotFound(t)
+	for _, retries := range []int{0, 1} {
+		calls := 0
+		fb := &faultBucket{attributes: func(key string) error {
+			calls++
+			// the first call is the Exists check, the second looks at the md5.
+			if calls == 2 {
+				return notFound
+			}
+			return nil
+		}}
+		dbkt := testFaultBucket(t, fb)
+		sbkt, _ := testMemBuckets(t)
+		for _, bkt := range []*blob.Bucket{sbkt, dbkt} {
+			if err := bkt.WriteAll(ctx, "file", []byte("data"), nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		calls = 0
+		errs := make(chan error)
+		failed := make(chan int)
+		go func() {
+			n := 0
+			for range errs {
+				n++
+			}
+			failed <- n
+		}()
+		opts := mirrorOpts{verifymd5: true, consistency: consistencyRetry{retries: retries, delay: time.Millisecond}}
+		n := mirror(ctx, sbkt, dbkt, nil, opts, errs)
+		close(errs)
+		errN := <-failed
+		if retries > 0 && (n != 0 || errN != 0) {
+			t.Errorf("expected the object to be found on a retry, got %d copied and %d errors", n, errN)
+		}
+		if retries == 0 && errN != 1 {
+			t.Errorf("expected an error without retries, got %d", errN)
+		}
+	}
+}
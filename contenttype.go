@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"mime"
+	"os"
+	"path"
+	"strings"
+)
+
+// maps lowercase file extensions, like ".js", to content types.
+// the special extension "*" is the fallback for anything not in the map.
+type contentTypeMap map[string]string
+
+// parses comma separated ext=type pairs, e.g. ".js=application/javascript,*=application/octet-stream"
+func parseContentTypeMap(s string) (contentTypeMap, error) {
+	m := make(contentTypeMap)
+	if s == "" {
+		return m, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		if err := m.add(pair); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// reads ext=type pairs from a file, one per line. lines starting with # are ignored.
+func loadContentTypeMap(file string, m contentTypeMap) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := m.add(line); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func (m contentTypeMap) add(pair string) error {
+	ext, contentType, ok := strings.Cut(strings.TrimSpace(pair), "=")
+	if !ok || ext == "" || contentType == "" {
+		return fmt.Errorf("invalid content type mapping %q. use .ext=type", pair)
+	}
+	if ext != "*" && !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	m[strings.ToLower(ext)] = contentType
+	return nil
+}
+
+// the content type for a key, or "" to keep whatever the writer would do otherwise.
+func (m contentTypeMap) lookup(key string) string {
+	if contentType, ok := m[strings.ToLower(path.Ext(key))]; ok {
+		return contentType
+	}
+	return m["*"]
+}
+
+// the lowercase canonical form of a content type, so that Text/HTML; Charset=UTF-8
+// is text/html; charset=utf-8. other parameter values keep their case, since a
+// multipart boundary needs it. one that doesn't parse is returned as it is.
+func normalizeContentType(contentType string) string {
+	if contentType == "" {
+		return ""
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return contentType
+	}
+	if cs, ok := params["charset"]; ok {
+		params["charset"] = strings.ToLower(cs)
+	}
+	if s := mime.FormatMediaType(mediaType, params); s != "" {
+		return s
+	}
+	return contentType
+}
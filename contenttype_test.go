@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gocloud.dev/blob"
+)
+
+func TestContentTypeMap(t *testing.T) {
+	m, err := parseContentTypeMap(".js=application/javascript,wasm=application/wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "types")
+	err = os.WriteFile(file, []byte("# more types\n.CSS=text/css\n\n*=application/octet-stream\n"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := loadContentTypeMap(file, m); err != nil {
+		t.Fatal(err)
+	}
+
+	for key, expected := range map[string]string{
+		"app.js":          "application/javascript",
+		"dir/module.wasm": "application/wasm",
+		"STYLE.css":       "text/css",
+		"README":          "application/octet-stream",
+		"image.png":       "application/octet-stream",
+	} {
+		if got := m.lookup(key); got != expected {
+			t.Errorf("%s: expected %q, got %q", key, expected, got)
+		}
+	}
+
+	if _, err := parseContentTypeMap(".js"); err == nil {
+		t.Error("expected an error for a mapping without a type")
+	}
+}
+
+// the mapped content type is what ends up in the destination,
+// whatever the source says.
+func TestMirrorContentTypeMap(t *testing.T) {
+	ctx := context.Background()
+	sbkt, dbkt := testMemBuckets(t)
+	err := sbkt.WriteAll(ctx, "app.js", []byte("console.log(1)"), &blob.WriterOptions{ContentType: "text/plain"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := parseContentTypeMap(".js=application/javascript")
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error)
+	go func() {
+		for err := range errs {
+			t.Error(err)
+		}
+	}()
+	mirror(ctx, sbkt, dbkt, nil, mirrorOpts{contentTypes: m}, errs)
+	attrs, err := dbkt.Attributes(ctx, "app.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs.ContentType != "application/javascript" {
+		t.Errorf("expected application/javascript, got %q", attrs.ContentType)
+	}
+
+	// by the source name, whatever the destination key is.
+	key := testAuthentication(t)
+	for name, opts := range map[string]mirrorOpts{
+		"encrypted":  {contentTypes: m, nameEncrypt: key, bytesEncrypt: key},
+		"compressed": {contentTypes: m, compress: true},
+	} {
+		tmpBkt, dbkt := testMemBuckets(t)
+		mirror(ctx, sbkt, dbkt, tmpBkt, opts, errs)
+		dstKey, err := destKey(ctx, sbkt, "app.js", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		attrs, err := dbkt.Attributes(ctx, dstKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if attrs.ContentType != "application/javascript" {
+			t.Errorf("%s: expected application/javascript, got %q", name, attrs.ContentType)
+		}
+	}
+	close(errs)
+}
+
+func TestNormalizeContentType(t *testing.T) {
+	for in, expected := range map[string]string{
+		"Text/HTML":                     "text/html",
+		"text/html; Charset=UTF-8":      "text/html; charset=utf-8",
+		"Multipart/Mixed; Boundary=AbC": "multipart/mixed; boundary=AbC",
+		"application/JSON":              "application/json",
+		"":                              "",
+		"not a content type; =":         "not a content type; =",
+	} {
+		if got := normalizeContentType(in); got != expected {
+			t.Errorf("%q: expected %q, got %q", in, expected, got)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"time"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/driver"
+	"gocloud.dev/gcerrors"
+)
+
+var ErrNoDelimiter = errors.New("custom sources can't list with a delimiter")
+
+// an object in a custom source.
+type customObject struct {
+	Key         string
+	Size        int64
+	ModTime     time.Time
+	ContentType string
+	// may be nil, if the source doesn't know it.
+	MD5 []byte
+}
+
+// the extension point for sources that aren't go-cloud buckets, like an object
+// store with its own paginated listing API. It only has to list and read,
+// openCustomSource does the rest, the same way http sources work.
+// A missing key is an error wrapping fs.ErrNotExist.
+type customSource interface {
+	// a page of the objects under prefix, in key order, starting at token. "" is the
+	// first page. next is the token of the following page, "" after the last one.
+	List(ctx context.Context, prefix, token string) (objs []customObject, next string, err error)
+	Attributes(ctx context.Context, key string) (customObject, error)
+	NewReader(ctx context.Context, key string) (io.ReadCloser, error)
+}
+
+// a read only bucket over a custom source, for mirror and everything else that takes a bucket.
+func openCustomSource(src customSource) *blob.Bucket {
+	return blob.NewBucket(&customBucket{src: src})
+}
+
+type customBucket struct {
+	src customSource
+}
+
+func (b *customBucket) ErrorCode(err error) gcerrors.ErrorCode {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return gcerrors.NotFound
+	case errors.Is(err, ErrReadOnly), errors.Is(err, ErrNoDelimiter):
+		return gcerrors.Unimplemented
+	}
+	return gcerrors.Unknown
+}
+
+func (b *customBucket) As(i interface{}) bool { return false }
+
+func (b *customBucket) ErrorAs(err error, i interface{}) bool { return false }
+
+func (b *customBucket) Attributes(ctx context.Context, key string) (*driver.Attributes, error) {
+	obj, err := b.src.Attributes(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return &driver.Attributes{ContentType: obj.ContentType, ModTime: obj.ModTime, Size: obj.Size, MD5: obj.MD5}, nil
+}
+
+// the page token is the custom source's own.
+func (b *customBucket) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driver.ListPage, error) {
+	if opts.Delimiter != "" {
+		return nil, ErrNoDelimiter
+	}
+	objs, next, err := b.src.List(ctx, opts.Prefix, string(opts.PageToken))
+	if err != nil {
+		return nil, err
+	}
+	page := &driver.ListPage{}
+	for _, obj := range objs {
+		page.Objects = append(page.Objects, &driver.ListObject{Key: obj.Key, ModTime: obj.ModTime, Size: obj.Size, MD5: obj.MD5})
+	}
+	if next != "" {
+		page.NextPageToken = []byte(next)
+	}
+	return page, nil
+}
+
+type customReader struct {
+	io.Reader
+	body  io.Closer
+	attrs driver.ReaderAttributes
+}
+
+func (r *customReader) Close() error { return r.body.Close() }
+
+func (r *customReader) Attributes() *driver.ReaderAttributes { return &r.attrs }
+
+func (r *customReader) As(i interface{}) bool { return false }
+
+// the custom source only reads whole objects, so a range skips to the offset.
+func (b *customBucket) NewRangeReader(ctx context.Context, key string, offset, length int64, opts *driver.ReaderOptions) (driver.Reader, error) {
+	obj, err := b.src.Attributes(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	attrs := driver.ReaderAttributes{ContentType: obj.ContentType, ModTime: obj.ModTime, Size: obj.Size}
+	body, err := b.src.NewReader(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.CopyN(io.Discard, body, offset); err != nil {
+		body.Close()
+		return nil, err
+	}
+	var r io.Reader = body
+	if length >= 0 {
+		r = io.LimitReader(body, length)
+	}
+	return &customReader{Reader: r, body: body, attrs: attrs}, nil
+}
+
+func (b *customBucket) NewTypedWriter(ctx context.Context, key, contentType string, opts *driver.WriterOptions) (driver.Writer, error) {
+	return nil, ErrReadOnly
+}
+
+func (b *customBucket) Copy(ctx context.Context, dstKey, srcKey string, opts *driver.CopyOptions) error {
+	return ErrReadOnly
+}
+
+func (b *customBucket) Delete(ctx context.Context, key string) error {
+	return ErrReadOnly
+}
+
+func (b *customBucket) SignedURL(ctx context.Context, key string, opts *driver.SignedURLOptions) (string, error) {
+	return "", ErrReadOnly
+}
+
+func (b *customBucket) Close() error { return nil }
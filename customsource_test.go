@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"gocloud.dev/gcerrors"
+)
+
+// a custom source with a paginated listing, like a REST API would have.
+type fakeSource struct {
+	objects  map[string]string
+	pageSize int
+	pages    int
+}
+
+func (s *fakeSource) List(ctx context.Context, prefix, token string) ([]customObject, string, error) {
+	s.pages++
+	var keys []string
+	for key := range s.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	start := 0
+	if token != "" {
+		var err error
+		if start, err = strconv.Atoi(token); err != nil {
+			return nil, "", err
+		}
+	}
+	var objs []customObject
+	for i := start; i < len(keys) && i < start+s.pageSize; i++ {
+		objs = append(objs, customObject{Key: keys[i], Size: int64(len(s.objects[keys[i]]))})
+	}
+	next := ""
+	if start+s.pageSize < len(keys) {
+		next = strconv.Itoa(start + s.pageSize)
+	}
+	return objs, next, nil
+}
+
+func (s *fakeSource) Attributes(ctx context.Context, key string) (customObject, error) {
+	data, ok := s.objects[key]
+	if !ok {
+		return customObject{}, fmt.Errorf("%s: %w", key, fs.ErrNotExist)
+	}
+	return customObject{Key: key, Size: int64(len(data)), ContentType: "text/plain"}, nil
+}
+
+func (s *fakeSource) NewReader(ctx context.Context, key string) (io.ReadCloser, error) {
+	data, ok := s.objects[key]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", key, fs.ErrNotExist)
+	}
+	return io.NopCloser(strings.NewReader(data)), nil
+}
+
+func TestCustomSource(t *testing.T) {
+	ctx := context.Background()
+	src := &fakeSource{objects: make(map[string]string), pageSize: 2}
+	for i := 0; i < 5; i++ {
+		src.objects["obj"+strconv.Itoa(i)] = "content " + strconv.Itoa(i)
+	}
+	sbkt := openCustomSource(src)
+	defer sbkt.Close()
+	_, dbkt := testMemBuckets(t)
+	errs := make(chan error)
+	go func() {
+		for err := range errs {
+			t.Error(err)
+		}
+	}()
+	if n := mirror(ctx, sbkt, dbkt, nil, mirrorOpts{}, errs); n != 5 {
+		t.Fatalf("copied %d objects", n)
+	}
+	if src.pages != 3 {
+		t.Errorf("expected 3 pages of 2, listed %d", src.pages)
+	}
+	for key, want := range src.objects {
+		if got := testReadString(t, dbkt, key); got != want {
+			t.Errorf("%s: got %q", key, got)
+		}
+	}
+
+	r, err := sbkt.NewRangeReader(ctx, "obj3", 8, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if b, _ := io.ReadAll(r); string(b) != "3" {
+		t.Errorf("range read %q", b)
+	}
+	if _, err := sbkt.Attributes(ctx, "missing"); gcerrors.Code(err) != gcerrors.NotFound {
+		t.Errorf("expected NotFound, got %v", err)
+	}
+	if err := sbkt.WriteAll(ctx, "new", nil, nil); gcerrors.Code(err) != gcerrors.Unimplemented {
+		t.Errorf("custom sources are read only, got %v", err)
+	}
+}
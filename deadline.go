@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// a time budget for a run. It's only checked between objects, so the object
+// being copied when time runs out is finished rather than abandoned.
+type deadline struct {
+	ctx     context.Context
+	reached bool
+}
+
+func newDeadline(d time.Duration) (*deadline, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	return &deadline{ctx: ctx}, cancel
+}
+
+// reports whether time is up. A nil deadline never is.
+func (d *deadline) expired() bool {
+	if d == nil {
+		return false
+	}
+	if d.ctx.Err() != nil {
+		d.reached = true
+	}
+	return d.reached
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestDeadline(t *testing.T) {
+	var d *deadline
+	if d.expired() {
+		t.Error("no deadline never expires")
+	}
+	d, cancel := newDeadline(time.Nanosecond)
+	defer cancel()
+	time.Sleep(time.Millisecond)
+	if !d.expired() || !d.reached {
+		t.Error("a tiny deadline should have expired")
+	}
+
+	ctx := context.Background()
+	sbkt, dbkt := testMemBuckets(t)
+	if err := sbkt.WriteAll(ctx, "a", []byte("a"), nil); err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error)
+	go func() {
+		for err := range errs {
+			t.Error(err)
+		}
+	}()
+	if n := mirror(ctx, sbkt, dbkt, nil, mirrorOpts{deadline: d}, errs); n != 0 {
+		t.Errorf("copied %d objects after the deadline", n)
+	}
+}
+
+// time runs out while an object is copied. That one is finished, and the next
+// run picks up after it.
+func TestDeadlineResume(t *testing.T) {
+	ctx := context.Background()
+	stop, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reads := 0
+	fb := &faultBucket{read: func(string) error {
+		reads++
+		if reads == 2 {
+			cancel()
+		}
+		return nil
+	}}
+	sbkt := testFaultBucket(t, fb)
+	_, dbkt := testMemBuckets(t)
+	for i := 0; i < 5; i++ {
+		if err := sbkt.WriteAll(ctx, "file"+strconv.Itoa(i), []byte("data"), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	errs := make(chan error)
+	go func() {
+		for err := range errs {
+			t.Error(err)
+		}
+	}()
+	st := newState()
+	d := &deadline{ctx: stop}
+	if n := mirror(ctx, sbkt, dbkt, nil, mirrorOpts{state: st, deadline: d}, errs); n != 2 {
+		t.Errorf("expected the run to stop after 2 objects, copied %d", n)
+	}
+	if !d.reached {
+		t.Error("the deadline should be reported as reached")
+	}
+	if st.LastKey != "file1" {
+		t.Errorf("expected LastKey file1, got %q", st.LastKey)
+	}
+	if n := mirror(ctx, sbkt, dbkt, nil, mirrorOpts{state: st}, errs); n != 3 {
+		t.Errorf("the next run should copy the other 3, copied %d", n)
+	}
+}
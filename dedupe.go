@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+)
+
+// the object in each destination that remembers what content it already has.
+const dedupeIndexKey = ".blobcopy-dedupe.json"
+
+type dedupeEntry struct {
+	Key  string `json:"key"`
+	Size int64  `json:"size"`
+}
+
+// content sha256, in hex, to a destination key with that content.
+// It outlives a run, so a later run can find content copied by an earlier one.
+type dedupeIndex struct {
+	Objects map[string]dedupeEntry `json:"objects"`
+	changed bool
+}
+
+// reads the index of bkt. A destination without one gets an empty index.
+func loadDedupeIndex(ctx context.Context, bkt Bucket) (*dedupeIndex, error) {
+	ix := &dedupeIndex{Objects: make(map[string]dedupeEntry)}
+	data, err := readAll(ctx, bkt, dedupeIndexKey)
+	if gcerrors.Code(err) == gcerrors.NotFound {
+		return ix, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, ix); err != nil {
+		return nil, fmt.Errorf("%s: %w", dedupeIndexKey, err)
+	}
+	if ix.Objects == nil {
+		ix.Objects = make(map[string]dedupeEntry)
+	}
+	return ix, nil
+}
+
+// writes the index back to bkt, if anything was added or dropped.
+func (ix *dedupeIndex) save(ctx context.Context, bkt Bucket) error {
+	if !ix.changed {
+		return nil
+	}
+	data, err := json.Marshal(ix)
+	if err != nil {
+		return err
+	}
+	return writeAll(ctx, bkt, dedupeIndexKey, data, &blob.WriterOptions{ContentType: "application/json"})
+}
+
+// remembers that key has the content with this sum. The first key stays.
+func (ix *dedupeIndex) add(sum, key string, size int64) {
+	if _, ok := ix.Objects[sum]; ok {
+		return
+	}
+	ix.Objects[sum] = dedupeEntry{Key: key, Size: size}
+	ix.changed = true
+}
+
+// writes key in bkt as a server side copy of an object that already has the
+// content, when the index knows one, so nothing is uploaded. attrs are the
+// attributes of the content. An entry whose object is gone, or no longer looks
+// like the content, is dropped, and false means the content has to be uploaded.
+func (ix *dedupeIndex) reference(ctx context.Context, bkt Bucket, sum string, attrs *blob.Attributes, key string) (bool, error) {
+	entry, ok := ix.Objects[sum]
+	if !ok || entry.Key == key {
+		return false, nil
+	}
+	existing, err := bkt.Attributes(ctx, entry.Key)
+	if gcerrors.Code(err) == gcerrors.NotFound {
+		delete(ix.Objects, sum)
+		ix.changed = true
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	stale := existing.Size != attrs.Size || existing.Size != entry.Size
+	if len(existing.MD5) != 0 && len(attrs.MD5) != 0 && !bytes.Equal(existing.MD5, attrs.MD5) {
+		stale = true
+	}
+	if stale {
+		delete(ix.Objects, sum)
+		ix.changed = true
+		return false, nil
+	}
+	err = bkt.Copy(ctx, key, entry.Key, nil)
+	if gcerrors.Code(err) == gcerrors.Unimplemented {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// the hex sha256 of the content of key in bkt.
+func contentSHA256(ctx context.Context, bkt Bucket, key string) (string, error) {
+	r, err := bkt.NewReader(ctx, key, nil)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
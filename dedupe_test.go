@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"gocloud.dev/blob"
+)
+
+// runs a dedupe mirror, and returns the bytes uploaded.
+func testDedupeRun(t *testing.T, sbkt, dbkt *blob.Bucket) int64 {
+	t.Helper()
+	errs := make(chan error)
+	progress := make(chan progressEvent)
+	done := make(chan int64)
+	go func() {
+		var written int64
+		for {
+			select {
+			case err, ok := <-errs:
+				if !ok {
+					done <- written
+					return
+				}
+				t.Error(err)
+			case ev := <-progress:
+				written += ev.bytes
+			}
+		}
+	}()
+	mirror(context.Background(), sbkt, dbkt, nil, mirrorOpts{dedupeContent: true, progress: progress}, errs)
+	close(errs)
+	return <-done
+}
+
+func TestDedupeContent(t *testing.T) {
+	ctx := context.Background()
+	sbkt, dbkt := testMemBuckets(t)
+	content := testRandomData(t)
+	if err := sbkt.WriteAll(ctx, "a", content, nil); err != nil {
+		t.Fatal(err)
+	}
+	if n := testDedupeRun(t, sbkt, dbkt); n != int64(len(content)) {
+		t.Fatalf("first run uploaded %d bytes", n)
+	}
+
+	// the same content under a new key, in a later run.
+	sbkt2, _ := testMemBuckets(t)
+	if err := sbkt2.WriteAll(ctx, "b", content, nil); err != nil {
+		t.Fatal(err)
+	}
+	if n := testDedupeRun(t, sbkt2, dbkt); n != 0 {
+		t.Errorf("second run uploaded %d bytes, expected none", n)
+	}
+	if got := testReadString(t, dbkt, "b"); got != string(content) {
+		t.Error("b has the wrong content")
+	}
+
+	// once the indexed object changes, the content is uploaded again.
+	if err := dbkt.WriteAll(ctx, "a", []byte("changed"), nil); err != nil {
+		t.Fatal(err)
+	}
+	sbkt3, _ := testMemBuckets(t)
+	if err := sbkt3.WriteAll(ctx, "c", content, nil); err != nil {
+		t.Fatal(err)
+	}
+	if n := testDedupeRun(t, sbkt3, dbkt); n != int64(len(content)) {
+		t.Errorf("a stale index entry should be dropped, uploaded %d bytes", n)
+	}
+	if got := testReadString(t, dbkt, "c"); got != string(content) {
+		t.Error("c has the wrong content")
+	}
+
+	ix, err := loadDedupeIndex(ctx, dbkt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ix.Objects) != 1 {
+		t.Errorf("expected one indexed content, got %v", ix.Objects)
+	}
+	for _, entry := range ix.Objects {
+		if entry.Key != "c" {
+			t.Errorf("expected the index to point at c, got %s", entry.Key)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+
+	"gocloud.dev/blob"
+)
+
+// how many destination objects the deep safety check picks one from.
+const deepSafetyPage = 1000
+
+// the safety marker only proves it was written with encKey. This picks a random
+// object from the destination and checks that it really decrypts with encKey too,
+// which catches data written with some other key next to a good marker.
+// nameKey is what names are encrypted with, if they are, and content says whether
+// the content is encrypted too. an empty destination has nothing to check, and passes.
+func deepSafetyCheck(ctx context.Context, bkt Bucket, encKey, nameKey []byte, content bool) error {
+	_, safetyKey, err := safetyName(encKey)
+	if err != nil {
+		return err
+	}
+	objs, _, err := bkt.ListPage(ctx, blob.FirstPageToken, deepSafetyPage, nil)
+	if err != nil {
+		return fmt.Errorf("error listing destination for the deep safety check: %w", err)
+	}
+	var keys []string
+	for _, obj := range objs {
+		if !obj.IsDir && obj.Key != safetyKey {
+			keys = append(keys, obj.Key)
+		}
+	}
+	if len(keys) == 0 {
+		logf(logNormal, "nothing in the destination for the deep safety check yet")
+		return nil
+	}
+	key := keys[rand.Intn(len(keys))]
+	if len(nameKey) != 0 {
+		if _, err := makeKey(key, nil, nameKey); err != nil {
+			return fmt.Errorf("%w: the name of %s doesn't decrypt with this password: %v", ErrSafetyCheckFailed, key, err)
+		}
+	}
+	if content {
+		text, err := readAll(ctx, bkt, key)
+		if err != nil {
+			return fmt.Errorf("error reading %s for the deep safety check: %w", key, err)
+		}
+		if _, err := decrypt(text, encKey); err != nil {
+			return fmt.Errorf("%w: %s doesn't decrypt with this password: %v", ErrSafetyCheckFailed, key, err)
+		}
+	}
+	logf(logNormal, "deep safety check passed on %s", key)
+	return nil
+}
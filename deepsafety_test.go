@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestDeepSafetyCheck(t *testing.T) {
+	ctx := context.Background()
+	encKey1 := testAuthentication(t)
+	encKey2 := testAuthentication(t)
+	_, bkt := testMemBuckets(t)
+	if err := enableSafetyCheck(ctx, bkt, encKey1); err != nil {
+		t.Fatal(err)
+	}
+	// only the marker, nothing to check yet.
+	if err := deepSafetyCheck(ctx, bkt, encKey1, encKey1, true); err != nil {
+		t.Errorf("empty destination: %v", err)
+	}
+
+	write := func(key string, encKey []byte) {
+		t.Helper()
+		name, err := makeKey(key, encKey, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		text, err := encrypt(testRandomData(t), encKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := bkt.WriteAll(ctx, name, text, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("file", encKey1)
+	if err := deepSafetyCheck(ctx, bkt, encKey1, encKey1, true); err != nil {
+		t.Errorf("data written with the same key: %v", err)
+	}
+
+	// the marker passes with encKey1, but the data is encrypted with encKey2.
+	_, bkt = testMemBuckets(t)
+	if err := enableSafetyCheck(ctx, bkt, encKey1); err != nil {
+		t.Fatal(err)
+	}
+	write("file", encKey2)
+	if pass, err := safetyCheck(ctx, bkt, encKey1); err != nil || !pass {
+		t.Fatalf("marker should pass, got %v %v", pass, err)
+	}
+	for _, c := range []struct{ names, content bool }{{true, false}, {false, true}, {true, true}} {
+		var nameKey []byte
+		if c.names {
+			nameKey = encKey1
+		}
+		err := deepSafetyCheck(ctx, bkt, encKey1, nameKey, c.content)
+		if !errors.Is(err, ErrSafetyCheckFailed) {
+			t.Errorf("names %v content %v: expected ErrSafetyCheckFailed, got %v", c.names, c.content, err)
+		}
+	}
+}
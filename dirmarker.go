@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// the suffix hadoop and the old s3n tools use for directory markers.
+const folderSuffix = "_$folder$"
+
+// reports whether a listed object is a directory marker: an empty object named
+// dir/ like the S3 console and GCS make, or dir_$folder$ like hadoop makes.
+// the marker is returned in the dir/ form, which is what's written to destinations.
+func markerDir(key string, size int64) (string, bool) {
+	if size != 0 {
+		return "", false
+	}
+	if strings.HasSuffix(key, "/") {
+		return key, true
+	}
+	if dir, ok := strings.CutSuffix(key, folderSuffix); ok && dir != "" {
+		return dir + "/", true
+	}
+	return "", false
+}
+
+// writes the marker dir to each destination that doesn't have it yet.
+// returns how many destinations got it.
+func copyMarker(ctx context.Context, dbkts []Bucket, dir string, dryRun bool) (int, error) {
+	n := 0
+	for _, dbkt := range dbkts {
+		exists, err := dbkt.Exists(ctx, dir)
+		if err != nil {
+			return n, err
+		}
+		if exists {
+			continue
+		}
+		if !dryRun {
+			if err := writeAll(ctx, dbkt, dir, nil, nil); err != nil {
+				return n, err
+			}
+		}
+		n++
+	}
+	return n, nil
+}
+
+// the empty directories under root, as dir/ markers relative to it.
+// a local bucket doesn't list them at all, since they hold no files.
+func emptyDirs(root string) ([]string, error) {
+	var dirs []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() || path == root {
+			return nil
+		}
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			rel, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+			dirs = append(dirs, filepath.ToSlash(rel)+"/")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error looking for empty directories: %w", err)
+	}
+	return dirs, nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestMarkerDir(t *testing.T) {
+	for _, c := range []struct {
+		key  string
+		size int64
+		dir  string
+		ok   bool
+	}{
+		{"dir/", 0, "dir/", true},
+		{"a/b/", 0, "a/b/", true},
+		{"a/b_$folder$", 0, "a/b/", true},
+		{"_$folder$", 0, "", false},
+		{"dir/", 3, "", false},
+		{"file", 0, "", false},
+	} {
+		dir, ok := markerDir(c.key, c.size)
+		if dir != c.dir || ok != c.ok {
+			t.Errorf("%s %d: got %q %v, expected %q %v", c.key, c.size, dir, ok, c.dir, c.ok)
+		}
+	}
+}
+
+func TestPreserveEmptyPrefixes(t *testing.T) {
+	ctx := context.Background()
+	sbkt, dbkt := testMemBuckets(t)
+	for key, data := range map[string]string{
+		"s3/":              "",
+		"hadoop_$folder$":  "",
+		"full/file":        "content",
+		"not-a-marker/":    "has content",
+		"nested/a/b/":      "",
+		"nested/a/b/c.txt": "content",
+	} {
+		if err := sbkt.WriteAll(ctx, key, []byte(data), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	errs := make(chan error)
+	go func() {
+		for err := range errs {
+			t.Error(err)
+		}
+	}()
+	mirror(ctx, sbkt, dbkt, nil, mirrorOpts{preserveDirs: true}, errs)
+	close(errs)
+
+	var keys []string
+	iter := dbkt.List(nil)
+	for {
+		obj, err := iter.Next(ctx)
+		if err != nil {
+			break
+		}
+		keys = append(keys, obj.Key)
+	}
+	expected := []string{"full/file", "hadoop/", "nested/a/b/", "nested/a/b/c.txt", "not-a-marker/", "s3/"}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("got %v, expected %v", keys, expected)
+	}
+}
+
+func TestEmptyDirs(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	for _, dir := range []string{"empty", "a/empty", "a/full"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(root, "a/full/file"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	dirs, err := emptyDirs(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(dirs)
+	if expected := []string{"a/empty/", "empty/"}; !reflect.DeepEqual(dirs, expected) {
+		t.Errorf("got %v, expected %v", dirs, expected)
+	}
+
+	_, dbkt := testMemBuckets(t)
+	for _, dir := range dirs {
+		if _, err := copyMarker(ctx, []Bucket{dbkt}, dir, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// already there, nothing to write.
+	n, err := copyMarker(ctx, []Bucket{dbkt}, "empty/", false)
+	if err != nil || n != 0 {
+		t.Errorf("expected the existing marker left alone, got %d %v", n, err)
+	}
+	if exists, err := dbkt.Exists(ctx, "a/empty/"); err != nil || !exists {
+		t.Errorf("marker missing, exists %v err %v", exists, err)
+	}
+}
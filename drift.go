@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+var ErrDrift = errors.New("destination changed since the last run")
+
+// a hash of everything in the bucket: keys, sizes, md5s and modification times.
+// it changes whenever an object is added, removed or rewritten.
+func manifestHash(ctx context.Context, bkt Bucket) (string, error) {
+	objs, err := listAll(ctx, bkt)
+	if err != nil {
+		return "", err
+	}
+	keys := make([]string, 0, len(objs))
+	for key := range objs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	h := sha256.New()
+	for _, key := range keys {
+		obj := objs[key]
+		fmt.Fprintf(h, "%q %d %x %d\n", key, obj.Size, obj.MD5, obj.ModTime.UnixNano())
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// compares the destination to the hash the state recorded at the end of the last run.
+// a difference means something else wrote to the destination in between, and is
+// ErrDrift unless accept is set. The first run with a state has nothing to compare to.
+func checkDrift(ctx context.Context, dbkt Bucket, st *state, accept bool) error {
+	hash, err := manifestHash(ctx, dbkt)
+	if err != nil {
+		return fmt.Errorf("unable to hash destination: %w", err)
+	}
+	if st.DestHash == "" || st.DestHash == hash || accept {
+		return nil
+	}
+	return ErrDrift
+}
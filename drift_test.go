@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestDetectDrift(t *testing.T) {
+	ctx := context.Background()
+	_, dbkt := testMemBuckets(t)
+	if err := dbkt.WriteAll(ctx, "file", []byte("content"), nil); err != nil {
+		t.Fatal(err)
+	}
+	st := newState()
+	// nothing recorded yet.
+	if err := checkDrift(ctx, dbkt, st, false); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := manifestHash(ctx, dbkt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.DestHash = hash
+	if err := checkDrift(ctx, dbkt, st, false); err != nil {
+		t.Errorf("unchanged destination reported as drift: %v", err)
+	}
+
+	if err := dbkt.WriteAll(ctx, "other", []byte("out of band"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkDrift(ctx, dbkt, st, false); !errors.Is(err, ErrDrift) {
+		t.Errorf("expected ErrDrift after an added object, got %v", err)
+	}
+	if err := checkDrift(ctx, dbkt, st, true); err != nil {
+		t.Errorf("accepted drift still reported: %v", err)
+	}
+
+	if hash, err = manifestHash(ctx, dbkt); err != nil {
+		t.Fatal(err)
+	}
+	st.DestHash = hash
+	if err := dbkt.Delete(ctx, "other"); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkDrift(ctx, dbkt, st, false); !errors.Is(err, ErrDrift) {
+		t.Errorf("expected ErrDrift after a deleted object, got %v", err)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"cloud.google.com/go/storage"
+	s3v2 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+var ErrExpiryUnsupported = errors.New("expiry times are only supported on S3 and GCS destinations")
+
+// reports whether objects written to bkt can carry an expiry time.
+func expirySupported(bkt Bucket) bool {
+	provider, _ := bucketTiers(bkt)
+	return provider != ""
+}
+
+// a WriterOptions.BeforeWrite that marks the object to expire at at. That's the
+// Expires header on S3, and the custom time on GCS, which a lifecycle rule with
+// daysSinceCustomTime deletes by. Other destinations are left alone, main warns
+// about them once.
+func setExpiry(at time.Time) func(func(interface{}) bool) error {
+	return func(as func(interface{}) bool) error {
+		var v1 *s3manager.UploadInput
+		var v2 *s3v2.PutObjectInput
+		var gw *storage.Writer
+		switch {
+		case as(&v1):
+			v1.Expires = aws.Time(at)
+		case as(&v2):
+			v2.Expires = aws.Time(at)
+		case as(&gw):
+			gw.CustomTime = at
+		}
+		return nil
+	}
+}
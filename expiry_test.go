@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	s3v2 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"gocloud.dev/blob"
+)
+
+func TestSetExpiry(t *testing.T) {
+	at := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	v1 := &s3manager.UploadInput{}
+	if err := setExpiry(at)(testAs(v1)); err != nil {
+		t.Fatal(err)
+	}
+	if !aws.TimeValue(v1.Expires).Equal(at) {
+		t.Errorf("unexpected v1 expiry %v", v1.Expires)
+	}
+	v2 := &s3v2.PutObjectInput{}
+	if err := setExpiry(at)(testAs(v2)); err != nil {
+		t.Fatal(err)
+	}
+	if !aws.TimeValue(v2.Expires).Equal(at) {
+		t.Errorf("unexpected v2 expiry %v", v2.Expires)
+	}
+	gw := &storage.Writer{}
+	if err := setExpiry(at)(testAs(gw)); err != nil {
+		t.Fatal(err)
+	}
+	if !gw.CustomTime.Equal(at) {
+		t.Errorf("unexpected GCS custom time %v", gw.CustomTime)
+	}
+	// other destinations were warned about, and the write goes ahead.
+	if err := setExpiry(at)(func(interface{}) bool { return false }); err != nil {
+		t.Errorf("expected no error where expiry is unsupported, got %v", err)
+	}
+	_, mem := testMemBuckets(t)
+	if expirySupported(mem) {
+		t.Error("memory buckets don't have expiry times")
+	}
+}
+
+func TestExpires(t *testing.T) {
+	ctx := context.Background()
+	sbkt, mem := testMemBuckets(t)
+	if err := sbkt.WriteAll(ctx, "cache", []byte("data"), nil); err != nil {
+		t.Fatal(err)
+	}
+	ub := &s3UploadBucket{faultBucket: faultBucket{bkt: mem}, uploads: make(map[string]*s3manager.UploadInput)}
+	dbkt := blob.NewBucket(ub)
+	defer dbkt.Close()
+	if !expirySupported(dbkt) {
+		t.Fatal("expected an S3 bucket to support expiry times")
+	}
+	errs := make(chan error)
+	go func() {
+		for err := range errs {
+			t.Error(err)
+		}
+	}()
+	before := time.Now()
+	if n := mirror(ctx, sbkt, dbkt, nil, mirrorOpts{expires: 7 * 24 * time.Hour}, errs); n != 1 {
+		t.Fatalf("expected 1 object copied, got %d", n)
+	}
+	close(errs)
+	in := ub.uploads["cache"]
+	if in == nil || in.Expires == nil {
+		t.Fatal("expected the upload to have an expiry")
+	}
+	if got := in.Expires.Sub(before); got < 7*24*time.Hour || got > 7*24*time.Hour+time.Minute {
+		t.Errorf("expected the object to expire in 7 days, got %v", got)
+	}
+}
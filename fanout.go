@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"gocloud.dev/blob"
+)
+
+const fanoutChunkSize = 1 << 20
+
+// copies an object to several destinations, reading the source only once.
+// Each destination gets its own writer, fed the same chunks concurrently, so a
+// slow destination only holds up the others by a chunk. A destination that fails
+// is dropped and the rest carry on. errs has an entry for each destination.
+// no transform is applied, so this is only for plain copies.
+func copyObjFanout(ctx context.Context, src Bucket, dsts []Bucket, key, newKey string, wopts *blob.WriterOptions) (int, []error) {
+	errs := make([]error, len(dsts))
+	srcr, err := src.NewReader(ctx, key, nil)
+	if err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return 0, errs
+	}
+	defer srcr.Close()
+	r := limitReader(ctx, src, srcr)
+
+	// canceled if the source can't be read, which aborts every write.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	chunks := make([]chan []byte, len(dsts))
+	var wg sync.WaitGroup
+	for i, dst := range dsts {
+		chunks[i] = make(chan []byte, 1)
+		wg.Add(1)
+		go func(i int, dst Bucket) {
+			defer wg.Done()
+			errs[i] = fanoutWrite(ctx, dst, newKey, wopts, chunks[i])
+		}(i, dst)
+	}
+
+	n := 0
+	var readErr error
+	for {
+		// the writers hold on to chunks, so each one gets a new buffer.
+		buf := make([]byte, fanoutChunkSize)
+		m, err := io.ReadFull(r, buf)
+		if m > 0 {
+			n += m
+			for _, c := range chunks {
+				c <- buf[:m]
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			readErr = err
+			cancel()
+			break
+		}
+	}
+	for _, c := range chunks {
+		close(c)
+	}
+	wg.Wait()
+	if readErr != nil {
+		for i := range errs {
+			errs[i] = readErr
+		}
+		return 0, errs
+	}
+	return n, errs
+}
+
+// writes the chunks to one destination. after a failure, the rest of the chunks
+// are drained so the reader never blocks on this destination.
+func fanoutWrite(ctx context.Context, dst Bucket, key string, wopts *blob.WriterOptions, chunks chan []byte) (err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	drain := func() {
+		for range chunks {
+		}
+	}
+	// a panic here, in its own goroutine, would take down the whole process.
+	defer func() {
+		if p := recover(); p != nil {
+			err = panicError(p)
+			cancel()
+			drain()
+		}
+	}()
+	w, err := dst.NewWriter(ctx, key, wopts)
+	if err != nil {
+		drain()
+		return err
+	}
+	lw := limitWriter(ctx, dst, w)
+	for chunk := range chunks {
+		if _, err := lw.Write(chunk); err != nil {
+			cancel()
+			w.Close()
+			drain()
+			return err
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		// the source failed. closing with a canceled context aborts the write.
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
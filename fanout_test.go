@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"testing"
+
+	"gocloud.dev/blob"
+)
+
+func TestCopyObjFanout(t *testing.T) {
+	ctx := context.Background()
+	sbkt, d1 := testMemBuckets(t)
+	d2, d3 := testMemBuckets(t)
+	// a few chunks and a bit.
+	text := make([]byte, 3*fanoutChunkSize+100)
+	if _, err := rand.Read(text); err != nil {
+		t.Fatal(err)
+	}
+	if err := sbkt.WriteAll(ctx, "big", text, nil); err != nil {
+		t.Fatal(err)
+	}
+	n, errs := copyObjFanout(ctx, sbkt, []Bucket{d1, d2, d3}, "big", "copy", nil)
+	if n != len(text) {
+		t.Errorf("expected %d bytes, got %d", len(text), n)
+	}
+	for i, d := range []*blob.Bucket{d1, d2, d3} {
+		if errs[i] != nil {
+			t.Errorf("destination %d: %v", i, errs[i])
+			continue
+		}
+		if got := testReadString(t, d, "copy"); got != string(text) {
+			t.Errorf("destination %d doesn't match the source", i)
+		}
+	}
+
+	// a source that can't be read fails every destination, and leaves nothing behind.
+	_, errs = copyObjFanout(ctx, sbkt, []Bucket{d1, d2}, "missing", "missing", nil)
+	for i, err := range errs {
+		if err == nil {
+			t.Errorf("destination %d: expected an error", i)
+		}
+	}
+}
+
+// each destination is checked on its own, and the source is read once for all of them.
+func TestMirrorMany(t *testing.T) {
+	ctx := context.Background()
+	reads := 0
+	fb := &faultBucket{
+		read: func(key string) error {
+			reads++
+			return nil
+		},
+	}
+	sbkt := testFaultBucket(t, fb)
+	same, different := testMemBuckets(t)
+	empty, _ := testMemBuckets(t)
+	text := testRandomData(t)
+	if err := sbkt.WriteAll(ctx, "file", text, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := same.WriteAll(ctx, "file", text, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := different.WriteAll(ctx, "file", []byte("old"), nil); err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error)
+	go func() {
+		for err := range errs {
+			t.Error(err)
+		}
+	}()
+	st := newState()
+	n := mirrorMany(ctx, sbkt, []Bucket{same, different, empty}, nil, mirrorOpts{verifymd5: true, state: st}, errs)
+	if n != 1 {
+		t.Errorf("expected one object copied, got %d", n)
+	}
+	if reads != 1 {
+		t.Errorf("expected the source to be read once, got %d", reads)
+	}
+	for name, d := range map[string]*blob.Bucket{"same": same, "different": different, "empty": empty} {
+		if got := testReadString(t, d, "file"); got != string(text) {
+			t.Errorf("%s: destination doesn't match the source", name)
+		}
+	}
+	if !st.done("file") {
+		t.Error("object copied to every destination not recorded in the state")
+	}
+}
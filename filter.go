@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// glob patterns given with a repeatable flag.
+type patternList []string
+
+func (p *patternList) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *patternList) Set(pattern string) error {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("bad pattern %q: %w", pattern, err)
+	}
+	*p = append(*p, pattern)
+	return nil
+}
+
+// reads patterns from a file, one per line, like rsync's --include-from.
+// blank lines and lines starting with # are ignored.
+func (p *patternList) load(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := p.Set(line); err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// decides which source keys are copied. A key is copied when it matches one
+// of the includes, or there are none, and matches none of the excludes.
+// a pattern without a slash matches the last part of the key, so *.tmp excludes
+// dir/file.tmp. A pattern with a slash has to match the whole key.
+type keyFilter struct {
+	include patternList
+	exclude patternList
+}
+
+func (f *keyFilter) match(key string) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.include) > 0 && !matchAny(f.include, key) {
+		return false
+	}
+	return !matchAny(f.exclude, key)
+}
+
+func matchAny(patterns []string, key string) bool {
+	for _, pattern := range patterns {
+		name := key
+		if !strings.Contains(pattern, "/") {
+			name = path.Base(key)
+		}
+		// the patterns were checked when they were added.
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestKeyFilter(t *testing.T) {
+	dir := t.TempDir()
+	includeFile := filepath.Join(dir, "include")
+	excludeFile := filepath.Join(dir, "exclude")
+	if err := os.WriteFile(includeFile, []byte("# what to back up\ndocs/*\n\n*.go\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(excludeFile, []byte("*_test.go\n  # indented comment\n*.tmp\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var f keyFilter
+	// inline patterns merge with the ones from the files.
+	if err := f.include.Set("README"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.include.load(includeFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.exclude.load(excludeFile); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.include) != 3 || len(f.exclude) != 2 {
+		t.Fatalf("unexpected patterns, include %v exclude %v", f.include, f.exclude)
+	}
+
+	for key, expected := range map[string]bool{
+		"README":           true,
+		"docs/intro.md":    true,
+		"docs/draft.tmp":   false,
+		"docs/sub/deep.md": false,
+		"main.go":          true,
+		"cmd/tool/main.go": true,
+		"main_test.go":     false,
+		"image.png":        false,
+	} {
+		if got := f.match(key); got != expected {
+			t.Errorf("%s: expected %v, got %v", key, expected, got)
+		}
+	}
+
+	var nilFilter *keyFilter
+	if !nilFilter.match("anything") {
+		t.Error("a nil filter should match everything")
+	}
+	if err := f.exclude.Set("[bad"); err == nil {
+		t.Error("expected an error for a bad pattern")
+	}
+}
+
+func TestMirrorFilter(t *testing.T) {
+	ctx := context.Background()
+	sbkt, dbkt := testMemBuckets(t)
+	for _, key := range []string{"keep.txt", "skip.tmp", "dir/keep.txt", "dir/skip.tmp"} {
+		if err := sbkt.WriteAll(ctx, key, []byte(key), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	errs := make(chan error)
+	go func() {
+		for err := range errs {
+			t.Error(err)
+		}
+	}()
+	f := &keyFilter{exclude: patternList{"*.tmp"}}
+	if n := mirror(ctx, sbkt, dbkt, nil, mirrorOpts{filter: f}, errs); n != 2 {
+		t.Errorf("expected 2 objects copied, got %d", n)
+	}
+	objs, err := listAll(ctx, dbkt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"keep.txt", "dir/keep.txt"} {
+		if _, ok := objs[key]; !ok {
+			t.Errorf("%s was not copied", key)
+		}
+	}
+}
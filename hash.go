@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+
+	"cloud.google.com/go/storage"
+	s3v2 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"gocloud.dev/blob"
+)
+
+// the -hash-algo choices.
+const (
+	hashMD5    = "md5"
+	hashSHA1   = "sha1"
+	hashSHA256 = "sha256"
+	hashCRC32C = "crc32c"
+)
+
+func validHashAlgo(algo string) error {
+	switch algo {
+	case hashMD5, hashSHA1, hashSHA256, hashCRC32C:
+		return nil
+	}
+	return fmt.Errorf("unknown hash %q. use md5, sha1, sha256 or crc32c", algo)
+}
+
+// "" is md5, what the backends report most.
+func newHash(algo string) hash.Hash {
+	switch algo {
+	case hashSHA1:
+		return sha1.New()
+	case hashSHA256:
+		return sha256.New()
+	case hashCRC32C:
+		return crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	}
+	return md5.New()
+}
+
+// the algo sum of an object as its backend reports it, without reading it. nil when
+// it doesn't: GCS reports crc32c for every object, S3 the checksum an object was
+// uploaded with, if any. md5s are in attrs.MD5 and aren't looked up here.
+func reportedSum(attrs *blob.Attributes, algo string) []byte {
+	var gcs storage.ObjectAttrs
+	var v1 s3.HeadObjectOutput
+	var v2 s3v2.HeadObjectOutput
+	var b64 *string
+	switch {
+	case attrs.As(&gcs):
+		if algo != hashCRC32C {
+			return nil
+		}
+		return binary.BigEndian.AppendUint32(nil, gcs.CRC32C)
+	case attrs.As(&v1):
+		b64 = map[string]*string{hashSHA1: v1.ChecksumSHA1, hashSHA256: v1.ChecksumSHA256, hashCRC32C: v1.ChecksumCRC32C}[algo]
+	case attrs.As(&v2):
+		b64 = map[string]*string{hashSHA1: v2.ChecksumSHA1, hashSHA256: v2.ChecksumSHA256, hashCRC32C: v2.ChecksumCRC32C}[algo]
+	}
+	// a checksum of a multipart upload has the part count after a dash, and isn't the sum of the content.
+	sum, err := base64.StdEncoding.DecodeString(aws.StringValue(b64))
+	if err != nil || len(sum) != newHash(algo).Size() {
+		return nil
+	}
+	return sum
+}
+
+// reads an object to compute its algo sum and size.
+func localSum(ctx context.Context, bkt Bucket, key, algo string) ([]byte, int64, error) {
+	rdr, err := bkt.NewReader(ctx, key, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rdr.Close()
+	h := newHash(algo)
+	n, err := io.Copy(h, rdr)
+	if err != nil {
+		return nil, 0, err
+	}
+	return h.Sum(nil), n, nil
+}
+
+// the algo sum of an object: the one its backend reports, or, if there isn't one
+// or it isn't trusted, read from the content.
+func objectSum(ctx context.Context, bkt Bucket, key string, attrs *blob.Attributes, algo string, distrust bool) ([]byte, error) {
+	if !distrust {
+		if sum := reportedSum(attrs, algo); sum != nil {
+			return sum, nil
+		}
+	}
+	sum, _, err := localSum(ctx, bkt, key, algo)
+	return sum, err
+}
+
+// like sameAttrs, but going by the algo sums when both backends report one, which
+// spares reading either side. GCS objects composed from parts have a crc32c but no md5,
+// so with crc32c they're compared by content rather than by size.
+func sameSums(sattrs, dattrs *blob.Attributes, algo string) bool {
+	if algo != "" && algo != hashMD5 {
+		ssum, dsum := reportedSum(sattrs, algo), reportedSum(dattrs, algo)
+		if ssum != nil && dsum != nil {
+			return sattrs.Size == dattrs.Size && string(ssum) == string(dsum)
+		}
+	}
+	return sameAttrs(sattrs, dattrs)
+}
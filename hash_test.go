@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/driver"
+)
+
+func TestNewHash(t *testing.T) {
+	// the standard check values for "123456789".
+	for algo, want := range map[string]string{
+		hashMD5:    "25f9e794323b453885f5181f1b624d0b",
+		hashSHA1:   "f7c3bc1d808e04732adf679965ccc34ca7ae3441",
+		hashSHA256: "15e2b0d3c33891ebb0f1ef609ec419420c20e320ce94c65fbc8c3312448eb225",
+		hashCRC32C: "e3069283",
+	} {
+		h := newHash(algo)
+		h.Write([]byte("123456789"))
+		if got := hex.EncodeToString(h.Sum(nil)); got != want {
+			t.Errorf("%s: got %s, expected %s", algo, got, want)
+		}
+	}
+	if err := validHashAlgo("sha512"); err == nil {
+		t.Error("expected sha512 to be rejected")
+	}
+}
+
+// mirror and then verify with each algorithm, and catch a same sized corruption with each.
+func TestHashAlgoRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	for _, algo := range []string{hashMD5, hashSHA1, hashSHA256, hashCRC32C} {
+		for _, encrypted := range []bool{false, true} {
+			sbkt, dbkt := testMemBuckets(t)
+			if err := sbkt.WriteAll(ctx, "file", testRandomData(t), nil); err != nil {
+				t.Fatal(err)
+			}
+			opts := mirrorOpts{hashAlgo: algo}
+			if encrypted {
+				opts.bytesEncrypt = testAuthentication(t)
+			}
+			errs := make(chan error)
+			go func() {
+				for err := range errs {
+					t.Error(err)
+				}
+			}()
+			if n := mirror(ctx, sbkt, dbkt, nil, opts, errs); n != 1 {
+				t.Fatalf("%s: expected 1 object copied, got %d", algo, n)
+			}
+			if report := verify(ctx, sbkt, dbkt, opts, errs); !report.ok() {
+				t.Fatalf("%s encrypted %v: expected a clean report, got %+v", algo, encrypted, report)
+			}
+			data, err := dbkt.ReadAll(ctx, "file")
+			if err != nil {
+				t.Fatal(err)
+			}
+			data[0] ^= 1
+			if err := dbkt.WriteAll(ctx, "file", data, nil); err != nil {
+				t.Fatal(err)
+			}
+			report := verify(ctx, sbkt, dbkt, opts, errs)
+			if len(report.differ) != 1 {
+				t.Fatalf("%s encrypted %v: expected the corruption to be found, got %+v", algo, encrypted, report)
+			}
+			if algo != hashMD5 && report.differ[0].Reason != algo+" differs" {
+				t.Errorf("%s: unexpected reason %q", algo, report.differ[0].Reason)
+			}
+		}
+	}
+}
+
+// GCS reports a crc32c for every object, S3 the checksum an object was uploaded with.
+func TestReportedSum(t *testing.T) {
+	ctx := context.Background()
+	content := sha256.Sum256([]byte("content"))
+	heads := map[string]func(interface{}) bool{
+		"gcs":       testAs(storage.ObjectAttrs{CRC32C: 0xe3069283}),
+		"gcs2":      testAs(storage.ObjectAttrs{CRC32C: 1}),
+		"s3":        testAs(s3.HeadObjectOutput{ChecksumSHA256: aws.String(base64.StdEncoding.EncodeToString(content[:]))}),
+		"multipart": testAs(s3.HeadObjectOutput{ChecksumSHA256: aws.String(base64.StdEncoding.EncodeToString(content[:]) + "-3")}),
+	}
+	fb := &faultBucket{attrs: func(key string, a *driver.Attributes) { a.AsFunc = heads[key] }}
+	bkt := testFaultBucket(t, fb)
+	for key := range heads {
+		if err := bkt.WriteAll(ctx, key, []byte("data"), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	attrs := func(key string) *blob.Attributes {
+		a, err := bkt.Attributes(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return a
+	}
+	if got := hex.EncodeToString(reportedSum(attrs("gcs"), hashCRC32C)); got != "e3069283" {
+		t.Errorf("unexpected GCS crc32c %s", got)
+	}
+	if reportedSum(attrs("gcs"), hashSHA256) != nil {
+		t.Error("GCS doesn't report sha256s")
+	}
+	if got := reportedSum(attrs("s3"), hashSHA256); string(got) != string(content[:]) {
+		t.Errorf("unexpected S3 sha256 %x", got)
+	}
+	if reportedSum(attrs("multipart"), hashSHA256) != nil {
+		t.Error("a multipart checksum isn't the sum of the content")
+	}
+
+	// two composed GCS objects of the same size, without md5s. only their crc32cs tell them apart.
+	a, b := attrs("gcs"), attrs("gcs2")
+	a.MD5, b.MD5 = nil, nil
+	if !sameAttrs(a, b) || sameSums(a, b, hashCRC32C) {
+		t.Error("expected the crc32cs to tell them apart where the sizes can't")
+	}
+	if !sameSums(a, attrs("gcs"), hashCRC32C) {
+		t.Error("expected matching crc32cs to be the same")
+	}
+	other := &blob.Attributes{Size: a.Size}
+	if !sameSums(a, other, hashCRC32C) {
+		t.Error("expected a fall back to the sizes when one side has no crc32c")
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/driver"
+	"gocloud.dev/gcerrors"
+)
+
+var ErrReadOnly = errors.New("http and custom sources are read only")
+
+// a response we can't use, e.g. a 404 or a redirect we were not allowed to follow.
+type httpStatusError struct {
+	url    string
+	status int
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("%s: %d %s", e.url, e.status, http.StatusText(e.status))
+}
+
+// like an aws error's, so notModified can tell a 304.
+func (e *httpStatusError) StatusCode() int { return e.status }
+
+// a read only bucket over plain http(s) URLs. There's no way to list a web server,
+// so the keys come from a manifest, and each key is fetched from base + key.
+type httpBucket struct {
+	base   string
+	keys   []string
+	client *http.Client
+}
+
+// a bucket that reads the keys in manifest from the http(s) URL base.
+// the manifest has one key per line. blank lines and lines starting with # are ignored.
+// A line may also be a full URL under base.
+func openHTTPBucket(base, manifest string) (*blob.Bucket, error) {
+	if manifest == "" {
+		return nil, errors.New("an http source needs a -manifest of keys")
+	}
+	if !strings.HasSuffix(base, "/") {
+		base += "/"
+	}
+	f, err := os.Open(manifest)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	seen := make(map[string]bool)
+	var keys []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if isHTTPURL(line) {
+			key, ok := strings.CutPrefix(line, base)
+			if !ok {
+				return nil, fmt.Errorf("%s: %s is not under %s", manifest, line, base)
+			}
+			// a URL's path is escaped, a key isn't.
+			key, err := url.PathUnescape(key)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s: %w", manifest, line, err)
+			}
+			line = key
+		}
+		if !seen[line] {
+			seen[line] = true
+			keys = append(keys, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	// blob buckets list in key order.
+	sort.Strings(keys)
+	return blob.NewBucket(&httpBucket{base: base, keys: keys, client: http.DefaultClient}), nil
+}
+
+func isHTTPURL(url string) bool {
+	return strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://")
+}
+
+// each part of the key is escaped, so a key with a # or ? in it is still a path.
+func (b *httpBucket) url(key string) string {
+	parts := strings.Split(key, "/")
+	for i, p := range parts {
+		parts[i] = url.PathEscape(p)
+	}
+	return b.base + strings.Join(parts, "/")
+}
+
+func (b *httpBucket) ErrorCode(err error) gcerrors.ErrorCode {
+	var se *httpStatusError
+	switch {
+	case errors.Is(err, ErrReadOnly):
+		return gcerrors.Unimplemented
+	case errors.As(err, &se):
+		switch se.status {
+		case http.StatusNotFound, http.StatusGone:
+			return gcerrors.NotFound
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return gcerrors.PermissionDenied
+		case http.StatusTooManyRequests:
+			return gcerrors.ResourceExhausted
+		}
+	}
+	return gcerrors.Unknown
+}
+
+func (b *httpBucket) As(i interface{}) bool { return false }
+
+func (b *httpBucket) ErrorAs(err error, i interface{}) bool {
+	p, ok := i.(**httpStatusError)
+	if !ok {
+		return false
+	}
+	return errors.As(err, p)
+}
+
+// redirects are followed by the client. what's left after that is an error for this object.
+func (b *httpBucket) do(ctx context.Context, method, key string, header http.Header, ok ...int) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, b.url(key), nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	for _, status := range ok {
+		if resp.StatusCode == status {
+			return resp, nil
+		}
+	}
+	resp.Body.Close()
+	return nil, &httpStatusError{url: b.url(key), status: resp.StatusCode}
+}
+
+func responseContentType(resp *http.Response) string {
+	if ct := resp.Header.Get("Content-Type"); ct != "" {
+		return ct
+	}
+	return "application/octet-stream"
+}
+
+func responseModTime(resp *http.Response) time.Time {
+	t, err := http.ParseTime(resp.Header.Get("Last-Modified"))
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+func (b *httpBucket) Attributes(ctx context.Context, key string) (*driver.Attributes, error) {
+	resp, err := b.do(ctx, http.MethodHead, key, nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return &driver.Attributes{
+		CacheControl:       resp.Header.Get("Cache-Control"),
+		ContentDisposition: resp.Header.Get("Content-Disposition"),
+		ContentEncoding:    resp.Header.Get("Content-Encoding"),
+		ContentLanguage:    resp.Header.Get("Content-Language"),
+		ContentType:        responseContentType(resp),
+		ModTime:            responseModTime(resp),
+		Size:               resp.ContentLength,
+		ETag:               resp.Header.Get("ETag"),
+	}, nil
+}
+
+// lists the manifest. page tokens are indexes into the sorted keys.
+func (b *httpBucket) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driver.ListPage, error) {
+	start := 0
+	if len(opts.PageToken) > 0 {
+		var err error
+		start, err = strconv.Atoi(string(opts.PageToken))
+		if err != nil {
+			return nil, fmt.Errorf("bad page token: %w", err)
+		}
+	}
+	pageSize := opts.PageSize
+	if pageSize == 0 {
+		pageSize = 1000
+	}
+	page := &driver.ListPage{}
+	lastPrefix := ""
+	for i := start; i < len(b.keys); i++ {
+		key := b.keys[i]
+		if !strings.HasPrefix(key, opts.Prefix) {
+			continue
+		}
+		if len(page.Objects) == pageSize {
+			page.NextPageToken = []byte(strconv.Itoa(i))
+			break
+		}
+		if opts.Delimiter != "" {
+			rest := key[len(opts.Prefix):]
+			if n := strings.Index(rest, opts.Delimiter); n >= 0 {
+				dir := opts.Prefix + rest[:n+len(opts.Delimiter)]
+				if dir != lastPrefix {
+					page.Objects = append(page.Objects, &driver.ListObject{Key: dir, IsDir: true})
+					lastPrefix = dir
+				}
+				continue
+			}
+		}
+		page.Objects = append(page.Objects, &driver.ListObject{Key: key})
+	}
+	return page, nil
+}
+
+type httpReader struct {
+	body  io.ReadCloser
+	attrs driver.ReaderAttributes
+}
+
+func (r *httpReader) Read(p []byte) (int, error) { return r.body.Read(p) }
+
+func (r *httpReader) Close() error { return r.body.Close() }
+
+func (r *httpReader) Attributes() *driver.ReaderAttributes { return &r.attrs }
+
+func (r *httpReader) As(i interface{}) bool { return false }
+
+func (b *httpBucket) NewRangeReader(ctx context.Context, key string, offset, length int64, opts *driver.ReaderOptions) (driver.Reader, error) {
+	header := http.Header{}
+	switch {
+	case length == 0:
+		// go-cloud only wants the attributes.
+		resp, err := b.do(ctx, http.MethodHead, key, nil, http.StatusOK)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+		return &httpReader{
+			body:  io.NopCloser(strings.NewReader("")),
+			attrs: driver.ReaderAttributes{ContentType: responseContentType(resp), ModTime: responseModTime(resp), Size: resp.ContentLength},
+		}, nil
+	case length > 0:
+		header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	case offset > 0:
+		header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	// ifModifiedSince sets a header of the request.
+	if opts != nil && opts.BeforeRead != nil {
+		asHeader := func(i interface{}) bool {
+			p, ok := i.(*http.Header)
+			if ok {
+				*p = header
+			}
+			return ok
+		}
+		if err := opts.BeforeRead(asHeader); err != nil {
+			return nil, err
+		}
+	}
+	ok := []int{http.StatusOK}
+	if header.Get("Range") != "" {
+		// a server that ignores Range sends the whole thing, which would be wrong.
+		ok = []int{http.StatusPartialContent}
+	}
+	resp, err := b.do(ctx, http.MethodGet, key, header, ok...)
+	if err != nil {
+		return nil, err
+	}
+	size := resp.ContentLength
+	if cr := resp.Header.Get("Content-Range"); cr != "" {
+		// bytes 0-99/1234
+		if i := strings.LastIndex(cr, "/"); i >= 0 {
+			if n, err := strconv.ParseInt(cr[i+1:], 10, 64); err == nil {
+				size = n
+			}
+		}
+	}
+	return &httpReader{
+		body:  resp.Body,
+		attrs: driver.ReaderAttributes{ContentType: responseContentType(resp), ModTime: responseModTime(resp), Size: size},
+	}, nil
+}
+
+func (b *httpBucket) NewTypedWriter(ctx context.Context, key, contentType string, opts *driver.WriterOptions) (driver.Writer, error) {
+	return nil, ErrReadOnly
+}
+
+func (b *httpBucket) Copy(ctx context.Context, dstKey, srcKey string, opts *driver.CopyOptions) error {
+	return ErrReadOnly
+}
+
+func (b *httpBucket) Delete(ctx context.Context, key string) error {
+	return ErrReadOnly
+}
+
+func (b *httpBucket) SignedURL(ctx context.Context, key string, opts *driver.SignedURLOptions) (string, error) {
+	return "", ErrReadOnly
+}
+
+func (b *httpBucket) Close() error { return nil }
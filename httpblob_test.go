@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gocloud.dev/gcerrors"
+)
+
+func TestHTTPSource(t *testing.T) {
+	ctx := context.Background()
+	files := map[string]string{
+		"/data/a":     "aaa",
+		"/data/dir/b": "bbbbbb",
+		"/data/a b#c": "ccc",
+		"/data/q?r%":  "dddd",
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		body, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(w, r, r.URL.Path, time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC), strings.NewReader(body))
+	})
+	mux.Handle("/data/moved", http.RedirectHandler("/data/a", http.StatusFound))
+	mux.HandleFunc("/data/secret", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no", http.StatusForbidden)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	manifest := filepath.Join(t.TempDir(), "manifest")
+	lines := "# files to fetch\na\n" + srv.URL + "/data/dir/b\n\nmoved\nmissing\nsecret\na\na b#c\n" + srv.URL + "/data/q%3Fr%25\n"
+	if err := os.WriteFile(manifest, []byte(lines), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sbkt, err := openHTTPBucket(srv.URL+"/data", manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sbkt.Close()
+	_, dbkt := testMemBuckets(t)
+
+	errs := make(chan error)
+	done := make(chan map[gcerrors.ErrorCode]int)
+	go func() {
+		codes := make(map[gcerrors.ErrorCode]int)
+		for err := range errs {
+			codes[gcerrors.Code(err)]++
+		}
+		done <- codes
+	}()
+	n := mirror(ctx, sbkt, dbkt, nil, mirrorOpts{}, errs)
+	close(errs)
+	codes := <-done
+	if n != 5 {
+		t.Errorf("expected 5 objects copied, got %d", n)
+	}
+	if codes[gcerrors.NotFound] != 1 || codes[gcerrors.PermissionDenied] != 1 || len(codes) != 2 {
+		t.Errorf("expected one not found and one permission denied error, got %v", codes)
+	}
+	for key, expected := range map[string]string{"a": "aaa", "dir/b": "bbbbbb", "moved": "aaa", "a b#c": "ccc", "q?r%": "dddd"} {
+		if got := testReadString(t, dbkt, key); got != expected {
+			t.Errorf("%s: expected %q, got %q", key, expected, got)
+		}
+	}
+
+	// ranges are fetched with a Range request.
+	text, err := readRange(ctx, sbkt, "dir/b", 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(text) != "bbb" {
+		t.Errorf("unexpected range %q", text)
+	}
+
+	if err := sbkt.WriteAll(ctx, "new", []byte("x"), nil); gcerrors.Code(err) != gcerrors.Unimplemented {
+		t.Errorf("expected writes to fail as unimplemented, got %v", err)
+	}
+	if _, err := openHTTPBucket(srv.URL, ""); err == nil {
+		t.Error("expected an error without a manifest")
+	}
+}
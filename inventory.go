@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+)
+
+// an object in an -inventory, with what it held when the inventory was taken.
+// either one may be empty, and then the object isn't checked.
+type inventoryEntry struct {
+	Key string `json:"key"`
+	// as S3 inventory reports have it, with or without the quotes.
+	ETag string `json:"etag"`
+	// hex.
+	MD5 string `json:"md5"`
+}
+
+// an object whose content isn't what the inventory says it was.
+type inventoryMismatch struct {
+	Key      string
+	Expected string
+	Found    string
+}
+
+// the objects to copy, in place of listing the source. what turns out
+// to be different or gone is recorded as the copy goes.
+type inventory struct {
+	entries []inventoryEntry
+	changed []inventoryMismatch
+	missing []string
+}
+
+// reads a json array of entries. they're copied in key order, like a listing.
+func loadInventory(path string) (*inventory, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []inventoryEntry
+	if err := json.NewDecoder(f).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("error reading inventory %s: %w", path, err)
+	}
+	for i, e := range entries {
+		if e.Key == "" {
+			return nil, fmt.Errorf("error reading inventory %s: entry %d has no key", path, i)
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+	return &inventory{entries: entries}, nil
+}
+
+// what -object copies: an inventory of one, which like any inventory doesn't
+// start after the state's LastKey.
+func objectInventory(key string) *inventory {
+	return &inventory{entries: []inventoryEntry{{Key: key}}}
+}
+
+// an iterator over the inventory's objects in bkt.
+func (inv *inventory) iterator(bkt Bucket) objectIterator {
+	return &inventoryIterator{inv: inv, bkt: bkt}
+}
+
+// looks each object up in the source as it goes, so the objects are current.
+type inventoryIterator struct {
+	inv *inventory
+	bkt Bucket
+	i   int
+}
+
+func (it *inventoryIterator) Next(ctx context.Context) (*blob.ListObject, error) {
+	for it.i < len(it.inv.entries) {
+		e := it.inv.entries[it.i]
+		it.i++
+		if it.i > 1 && e.Key == it.inv.entries[it.i-2].Key {
+			continue
+		}
+		attrs, err := it.bkt.Attributes(ctx, e.Key)
+		if gcerrors.Code(err) == gcerrors.NotFound {
+			it.inv.missing = append(it.inv.missing, e.Key)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", e.Key, err)
+		}
+		if expected, found, ok := e.check(attrs); !ok {
+			it.inv.changed = append(it.inv.changed, inventoryMismatch{Key: e.Key, Expected: expected, Found: found})
+		}
+		return &blob.ListObject{Key: e.Key, ModTime: attrs.ModTime, Size: attrs.Size, MD5: attrs.MD5}, nil
+	}
+	return nil, io.EOF
+}
+
+// compares an object with its entry. an md5 is compared with the object's md5,
+// an etag with its etag, or with its md5 too, which is what S3 etags are for
+// objects that weren't uploaded in parts. An md5 can't be checked against the
+// etag of an object that was.
+func (e inventoryEntry) check(attrs *blob.Attributes) (expected, found string, ok bool) {
+	etag := strings.Trim(attrs.ETag, `"`)
+	sum := hex.EncodeToString(attrs.MD5)
+	switch {
+	case e.MD5 != "" && sum != "":
+		return e.MD5, sum, strings.EqualFold(e.MD5, sum)
+	case e.MD5 != "" && multipartETag(etag):
+		// the etag of an object uploaded in parts isn't its md5, so there's nothing to check.
+		return e.MD5, etag, true
+	case e.MD5 != "":
+		return e.MD5, etag, strings.EqualFold(e.MD5, etag)
+	case e.ETag != "":
+		want := strings.Trim(e.ETag, `"`)
+		if sum != "" && strings.EqualFold(want, sum) {
+			return want, sum, true
+		}
+		return want, etag, strings.EqualFold(want, etag)
+	}
+	return "", "", true
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gocloud.dev/blob"
+)
+
+func TestInventory(t *testing.T) {
+	ctx := context.Background()
+	sbkt, dbkt := testMemBuckets(t)
+	for _, key := range []string{"a", "b", "c", "not-listed"} {
+		if err := sbkt.WriteAll(ctx, key, []byte("content of "+key), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sum := func(s string) string {
+		h := md5.Sum([]byte(s))
+		return hex.EncodeToString(h[:])
+	}
+	// b changed since the inventory was taken, and gone was deleted.
+	inventory := `[
+		{"key": "c", "etag": "\"` + sum("content of c") + `\""},
+		{"key": "a", "md5": "` + strings.ToUpper(sum("content of a")) + `"},
+		{"key": "b", "md5": "` + sum("old content of b") + `"},
+		{"key": "gone", "md5": "` + sum("gone") + `"},
+		{"key": "a"}
+	]`
+	path := filepath.Join(t.TempDir(), "inventory.json")
+	if err := os.WriteFile(path, []byte(inventory), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	inv, err := loadInventory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errs := make(chan error)
+	go func() {
+		for err := range errs {
+			t.Error(err)
+		}
+	}()
+	if n := mirror(ctx, sbkt, dbkt, nil, mirrorOpts{inventory: inv}, errs); n != 3 {
+		t.Errorf("expected the 3 objects in the inventory copied, got %d", n)
+	}
+	close(errs)
+	if ok, _ := dbkt.Exists(ctx, "not-listed"); ok {
+		t.Error("expected an object that isn't in the inventory not to be copied")
+	}
+	if got := testReadString(t, dbkt, "b"); got != "content of b" {
+		t.Errorf("expected the changed object copied as it is now, got %q", got)
+	}
+	if len(inv.changed) != 1 || inv.changed[0].Key != "b" || inv.changed[0].Expected != sum("old content of b") || inv.changed[0].Found != sum("content of b") {
+		t.Errorf("unexpected changes %+v", inv.changed)
+	}
+	if len(inv.missing) != 1 || inv.missing[0] != "gone" {
+		t.Errorf("unexpected missing objects %v", inv.missing)
+	}
+}
+
+// a resumed run skips what the state has, and not what's before the listing's last key.
+func TestInventoryResume(t *testing.T) {
+	ctx := context.Background()
+	sbkt, dbkt := testMemBuckets(t)
+	for _, key := range []string{"a", "b", "c"} {
+		if err := sbkt.WriteAll(ctx, key, []byte(key), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	errs := make(chan error)
+	go func() {
+		for err := range errs {
+			t.Error(err)
+		}
+	}()
+	st := newState()
+	st.LastKey = "c"
+	st.record("a", stateEntry{DstKey: "a"})
+	inv := &inventory{entries: []inventoryEntry{{Key: "a"}, {Key: "b"}}}
+	if n := mirror(ctx, sbkt, dbkt, nil, mirrorOpts{state: st, inventory: inv}, errs); n != 1 {
+		t.Errorf("expected only b copied, got %d", n)
+	}
+	close(errs)
+	if ok, err := dbkt.Exists(ctx, "b"); err != nil || !ok {
+		t.Errorf("expected b copied, exists %v err %v", ok, err)
+	}
+	if st.LastKey != "c" {
+		t.Errorf("expected the listing's LastKey left alone, got %q", st.LastKey)
+	}
+}
+
+func TestLoadInventoryErrors(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{"bad": "{", "nokey": `[{"md5": "x"}]`} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadInventory(path); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+// an md5 can't be checked against the etag of an object uploaded in parts.
+func TestInventoryMultipartETag(t *testing.T) {
+	attrs := &blob.Attributes{ETag: `"9b2cf535f27731c974343645a3985328-5"`}
+	if _, _, ok := (inventoryEntry{Key: "big", MD5: "5d41402abc4b2a76b9719d911017c592"}).check(attrs); !ok {
+		t.Error("expected a multipart etag not to count as a change")
+	}
+	if _, _, ok := (inventoryEntry{Key: "big", ETag: `"0b2cf535f27731c974343645a3985328-5"`}).check(attrs); ok {
+		t.Error("expected a different multipart etag to count as a change")
+	}
+	if _, _, ok := (inventoryEntry{Key: "big", MD5: "5d41402abc4b2a76b9719d911017c592"}).check(&blob.Attributes{ETag: `"0b2cf535f27731c974343645a3985328"`}); ok {
+		t.Error("expected a different single part etag to count as a change")
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+var ErrInvalidKey = errors.New("the destination doesn't accept this key")
+
+// one thing a provider doesn't accept in a key. fix rewrites a key so it's
+// accepted, and is nil when there's no sensible way to.
+type keyRule struct {
+	what string
+	bad  func(key string) bool
+	fix  func(key string) string
+}
+
+// the rules a destination key has to follow.
+type keyRules []keyRule
+
+func isControl(r rune) bool {
+	return r < 0x20 || r == 0x7f
+}
+
+var (
+	ruleUTF8 = keyRule{
+		what: "invalid utf-8",
+		bad:  func(key string) bool { return !utf8.ValidString(key) },
+		fix:  func(key string) string { return strings.ToValidUTF8(key, "_") },
+	}
+	ruleControl = keyRule{
+		what: "control characters",
+		bad:  func(key string) bool { return strings.IndexFunc(key, isControl) >= 0 },
+		fix: func(key string) string {
+			return strings.Map(func(r rune) rune {
+				if isControl(r) {
+					return '_'
+				}
+				return r
+			}, key)
+		},
+	}
+	ruleBackslash = keyRule{
+		what: "backslashes",
+		bad:  func(key string) bool { return strings.Contains(key, `\`) },
+		fix:  func(key string) string { return strings.ReplaceAll(key, `\`, "/") },
+	}
+	// . and .. are directories on disk, and GCS refuses them as names.
+	ruleDots = keyRule{
+		what: "a . or .. path segment",
+		bad: func(key string) bool {
+			for _, seg := range strings.Split(key, "/") {
+				if seg == "." || seg == ".." {
+					return true
+				}
+			}
+			return false
+		},
+		fix: func(key string) string {
+			segs := strings.Split(key, "/")
+			for i, seg := range segs {
+				if seg == "." || seg == ".." {
+					segs[i] = strings.Repeat("_", len(seg))
+				}
+			}
+			return strings.Join(segs, "/")
+		},
+	}
+	// fileblob keeps attributes next to each file, in key + .attrs.
+	ruleAttrs = keyRule{
+		what: "the .attrs suffix",
+		bad:  func(key string) bool { return strings.HasSuffix(key, ".attrs") },
+		fix:  func(key string) string { return strings.TrimSuffix(key, ".attrs") + "_attrs" },
+	}
+	ruleACME = keyRule{
+		what: "the reserved .well-known/acme-challenge/ prefix",
+		bad:  func(key string) bool { return strings.HasPrefix(key, ".well-known/acme-challenge/") },
+	}
+	// azure strips a trailing dot from each path segment.
+	ruleLength = keyRule{
+		what: "longer than 1024 bytes",
+		bad:  func(key string) bool { return len(key) > 1024 },
+	}
+	ruleTrailingDot = keyRule{
+		what: "a trailing dot",
+		bad:  func(key string) bool { return strings.HasSuffix(key, ".") },
+		fix:  func(key string) string { return strings.TrimSuffix(key, ".") + "_" },
+	}
+)
+
+// the rules for each destination scheme. Other schemes only get the common ones.
+var providerKeyRules = map[string]keyRules{
+	"s3":     {ruleBackslash, ruleLength},
+	"gs":     {ruleLength, ruleDots, ruleACME},
+	"azblob": {ruleBackslash, ruleLength, ruleTrailingDot},
+	"file":   {ruleDots, ruleAttrs},
+}
+
+// the longest key, in bytes, each destination scheme accepts. unlike ruleLength,
+// keys are checked against it without -validate-keys, see tooLong.
+var providerKeyLimits = map[string]int{
+	"s3":     1024,
+	"gs":     1024,
+	"azblob": 1024,
+}
+
+// the shortest limit of the destination URLs, or 0 if none of them has one.
+func maxKeyLengthFor(dsts []string) int {
+	limit := 0
+	for _, dst := range dsts {
+		u, err := url.Parse(dst)
+		if err != nil {
+			continue
+		}
+		if l := providerKeyLimits[u.Scheme]; l > 0 && (limit == 0 || l < limit) {
+			limit = l
+		}
+	}
+	return limit
+}
+
+// reports whether a destination key is over the -max-key-length.
+func (opts mirrorOpts) tooLong(key string) bool {
+	return opts.maxKeyLength > 0 && len(key) > opts.maxKeyLength
+}
+
+// the rules for keys copied to all of the destination URLs.
+func keyRulesFor(dsts []string) keyRules {
+	rules := keyRules{ruleUTF8, ruleControl}
+	seen := make(map[string]bool)
+	for _, dst := range dsts {
+		u, err := url.Parse(dst)
+		if err != nil || seen[u.Scheme] {
+			continue
+		}
+		seen[u.Scheme] = true
+		for _, rule := range providerKeyRules[u.Scheme] {
+			if !rules.has(rule.what) {
+				rules = append(rules, rule)
+			}
+		}
+	}
+	return rules
+}
+
+func (rs keyRules) has(what string) bool {
+	for _, r := range rs {
+		if r.what == what {
+			return true
+		}
+	}
+	return false
+}
+
+// checks key against the rules. with sanitize, a key that breaks a rule is
+// fixed where the rule knows how, and only a key that can't be fixed is an error.
+func (rs keyRules) apply(key string, sanitize bool) (string, error) {
+	for _, r := range rs {
+		if !r.bad(key) {
+			continue
+		}
+		if !sanitize || r.fix == nil {
+			return "", fmt.Errorf("%q has %s: %w", key, r.what, ErrInvalidKey)
+		}
+		key = r.fix(key)
+	}
+	// a fix can break another rule, like a backslash turned into a slash before a dot.
+	for _, r := range rs {
+		if r.bad(key) {
+			return "", fmt.Errorf("%q still has %s after sanitizing: %w", key, r.what, ErrInvalidKey)
+		}
+	}
+	return key, nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestKeyRules(t *testing.T) {
+	for _, tc := range []struct {
+		dsts      []string
+		key       string
+		valid     bool
+		sanitized string
+	}{
+		{[]string{"s3://b"}, "a/b.txt", true, "a/b.txt"},
+		{[]string{"s3://b"}, "a\x01b", false, "a_b"},
+		{[]string{"mem://"}, "a\nb", false, "a_b"},
+		{[]string{"mem://"}, "a\\b", true, "a\\b"},
+		{[]string{"s3://b"}, `dir\file`, false, "dir/file"},
+		{[]string{"gs://b"}, "a/../b", false, "a/__/b"},
+		{[]string{"gs://b"}, ".well-known/acme-challenge/x", false, ""},
+		{[]string{"file:///tmp"}, "x.attrs", false, "x_attrs"},
+		{[]string{"azblob://c"}, "name.", false, "name_"},
+		// the fixed backslash makes a . segment that file:// rejects too.
+		{[]string{"s3://b", "file:///tmp"}, `a\.\b`, false, "a/_/b"},
+		{[]string{"s3://b"}, string(make([]byte, 1025)), false, ""},
+		{[]string{"mem://"}, "bad\xffutf8", false, "bad_utf8"},
+	} {
+		rules := keyRulesFor(tc.dsts)
+		_, err := rules.apply(tc.key, false)
+		if (err == nil) != tc.valid {
+			t.Errorf("%v %q: expected valid %v, got %v", tc.dsts, tc.key, tc.valid, err)
+		}
+		if err != nil && !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("%q: expected ErrInvalidKey, got %v", tc.key, err)
+		}
+		got, err := rules.apply(tc.key, true)
+		if tc.sanitized == "" {
+			if err == nil {
+				t.Errorf("%v %q can't be sanitized, got %q", tc.dsts, tc.key, got)
+			}
+			continue
+		}
+		if err != nil || got != tc.sanitized {
+			t.Errorf("%v %q: expected %q, got %q %v", tc.dsts, tc.key, tc.sanitized, got, err)
+		}
+	}
+}
+
+func TestValidateKeys(t *testing.T) {
+	ctx := context.Background()
+	for _, sanitize := range []bool{false, true} {
+		sbkt, dbkt := testMemBuckets(t)
+		for _, key := range []string{"good", "bad\x07key"} {
+			if err := sbkt.WriteAll(ctx, key, []byte(key), nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		errs := make(chan error)
+		errsN := 0
+		done := make(chan bool)
+		go func() {
+			for err := range errs {
+				if !errors.Is(err, ErrInvalidKey) {
+					t.Error(err)
+				}
+				errsN++
+			}
+			close(done)
+		}()
+		opts := mirrorOpts{keyRules: keyRulesFor([]string{"s3://b"}), sanitizeKeys: sanitize}
+		n := mirror(ctx, sbkt, dbkt, nil, opts, errs)
+		close(errs)
+		<-done
+		if sanitize {
+			if n != 2 || errsN != 0 {
+				t.Errorf("sanitize: copied %d with %d errors", n, errsN)
+			}
+			if got := testReadString(t, dbkt, "bad_key"); got != "bad\x07key" {
+				t.Errorf("sanitized key has %q", got)
+			}
+			continue
+		}
+		// skipped, and reported rather than dropped silently.
+		if n != 1 || errsN != 1 {
+			t.Errorf("validate: copied %d with %d errors", n, errsN)
+		}
+	}
+}
+
+func TestMaxKeyLengthFor(t *testing.T) {
+	for _, tc := range []struct {
+		dsts []string
+		want int
+	}{
+		{[]string{"s3://b"}, 1024},
+		{[]string{"file:///tmp/x", "gs://b"}, 1024},
+		{[]string{"mem://", "file:///tmp/x"}, 0},
+	} {
+		if got := maxKeyLengthFor(tc.dsts); got != tc.want {
+			t.Errorf("%v: expected %d, got %d", tc.dsts, tc.want, got)
+		}
+	}
+}
+
+// a key at the limit is copied as it is. one byte over, it's skipped up front,
+// or with a key hash, stored under the hash with the key in metadata.
+func TestMaxKeyLength(t *testing.T) {
+	ctx := context.Background()
+	atLimit := strings.Repeat("a", 1023) + "1"
+	over := strings.Repeat("a", 1024) + "2"
+	for _, scheme := range []string{"", keyHashSHA256} {
+		sbkt, dbkt := testMemBuckets(t)
+		for _, key := range []string{atLimit, over} {
+			if err := sbkt.WriteAll(ctx, key, []byte("content"), nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		errs := make(chan error)
+		errsN := 0
+		done := make(chan bool)
+		go func() {
+			for err := range errs {
+				if !errors.Is(err, ErrInvalidKey) {
+					t.Error(err)
+				}
+				errsN++
+			}
+			close(done)
+		}()
+		opts := mirrorOpts{maxKeyLength: 1024, keyHash: scheme}
+		n := mirror(ctx, sbkt, dbkt, nil, opts, errs)
+		close(errs)
+		<-done
+		if ok, err := dbkt.Exists(ctx, atLimit); err != nil || !ok {
+			t.Errorf("hash %q: expected the key at the limit copied as it is, %v", scheme, err)
+		}
+		if scheme == "" {
+			if n != 1 || errsN != 1 {
+				t.Errorf("copied %d with %d errors", n, errsN)
+			}
+			continue
+		}
+		if n != 2 || errsN != 0 {
+			t.Errorf("hash: copied %d with %d errors", n, errsN)
+		}
+		hashed, err := hashKey(over, scheme)
+		if err != nil {
+			t.Fatal(err)
+		}
+		attrs, err := dbkt.Attributes(ctx, hashed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if attrs.Metadata[origKeyMeta] != over {
+			t.Errorf("expected the original key in the metadata, got %q", attrs.Metadata[origKeyMeta])
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"gocloud.dev/runtimevar"
+	_ "gocloud.dev/runtimevar/awssecretsmanager"
+	_ "gocloud.dev/runtimevar/gcpsecretmanager"
+	"gocloud.dev/secrets"
+	_ "gocloud.dev/secrets/awskms"
+	_ "gocloud.dev/secrets/gcpkms"
+	_ "gocloud.dev/secrets/localsecrets"
+)
+
+var ErrKeyLength = errors.New("an encryption key has to be 32 bytes")
+
+// the length of the keys readAuthentication makes from a password.
+const keyLength = 32
+
+// how long fetching the key may take before giving up.
+const keySourceTimeout = 30 * time.Second
+
+// fetches the encryption key from -key-source, in place of a password. a secrets
+// manager variable (awssecretsmanager://, gcpsecretmanager://) holds the key itself.
+// a KMS key (awskms://, gcpkms://, base64key://) decrypts the key in wrappedPath,
+// which was encrypted with it. either way the key is 32 bytes, raw or in base64.
+func loadKeySource(ctx context.Context, uri, wrappedPath string) ([]byte, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("bad key source %q: %w", uri, err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, keySourceTimeout)
+	defer cancel()
+	var material []byte
+	switch {
+	case secrets.DefaultURLMux().ValidKeeperScheme(u.Scheme):
+		if wrappedPath == "" {
+			return nil, fmt.Errorf("key source %s is a KMS key, it needs -wrapped-key with the key it encrypted", u.Scheme)
+		}
+		wrapped, err := os.ReadFile(wrappedPath)
+		if err != nil {
+			return nil, err
+		}
+		keeper, err := secrets.OpenKeeper(ctx, uri)
+		if err != nil {
+			return nil, fmt.Errorf("error opening key source: %w", err)
+		}
+		defer keeper.Close()
+		material, err = keeper.Decrypt(ctx, wrapped)
+		if err != nil {
+			return nil, fmt.Errorf("error decrypting %s with the key source: %w", wrappedPath, err)
+		}
+	case runtimevar.DefaultURLMux().ValidVariableScheme(u.Scheme):
+		if wrappedPath != "" {
+			return nil, fmt.Errorf("key source %s holds the key itself, -wrapped-key is only for KMS keys", u.Scheme)
+		}
+		v, err := runtimevar.OpenVariable(ctx, uri)
+		if err != nil {
+			return nil, fmt.Errorf("error opening key source: %w", err)
+		}
+		defer v.Close()
+		// the first Watch returns right away with the value or why it couldn't be read,
+		// where Latest would keep trying until the timeout.
+		snap, err := v.Watch(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error reading the key from the key source: %w", err)
+		}
+		switch val := snap.Value.(type) {
+		case []byte:
+			material = val
+		case string:
+			material = []byte(val)
+		default:
+			return nil, fmt.Errorf("key source gave a %T, use decoder=bytes or decoder=string", snap.Value)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported key source %q. use awskms, gcpkms, base64key, awssecretsmanager or gcpsecretmanager", u.Scheme)
+	}
+	return keyMaterial(material)
+}
+
+// the key in material, which is either the 32 bytes or them in base64, since
+// secrets managers are mostly made for text.
+func keyMaterial(material []byte) ([]byte, error) {
+	if len(material) == keyLength {
+		return material, nil
+	}
+	text := strings.TrimSpace(string(material))
+	if key, err := base64.StdEncoding.DecodeString(text); err == nil && len(key) == keyLength {
+		return key, nil
+	}
+	return nil, fmt.Errorf("the key source gave %d bytes: %w", len(material), ErrKeyLength)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	_ "gocloud.dev/runtimevar/constantvar"
+	"gocloud.dev/secrets"
+	"gocloud.dev/secrets/localsecrets"
+)
+
+// a KMS key decrypts the wrapped key, the in-memory keeper standing in for a cloud one.
+func TestKeySourceKMS(t *testing.T) {
+	ctx := context.Background()
+	sk, err := localsecrets.NewRandomKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	uri := "base64key://" + base64.URLEncoding.EncodeToString(sk[:])
+	keeper, err := secrets.OpenKeeper(ctx, uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer keeper.Close()
+	key := testAuthentication(t)
+	wrapped, err := keeper.Encrypt(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "key.enc")
+	if err := os.WriteFile(path, wrapped, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := loadKeySource(ctx, uri, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(key) {
+		t.Error("expected the wrapped key back")
+	}
+
+	// another KMS key can't decrypt it.
+	other, err := localsecrets.NewRandomKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadKeySource(ctx, "base64key://"+base64.URLEncoding.EncodeToString(other[:]), path); err == nil {
+		t.Error("expected decrypting with the wrong KMS key to fail")
+	}
+	if _, err := loadKeySource(ctx, uri, ""); err == nil {
+		t.Error("expected a KMS key without a wrapped key to fail")
+	}
+}
+
+// a secret holds the key itself, raw or in base64.
+func TestKeySourceSecret(t *testing.T) {
+	ctx := context.Background()
+	key := testAuthentication(t)
+	b64 := base64.StdEncoding.EncodeToString(key)
+	got, err := loadKeySource(ctx, "constant://?decoder=string&val="+url.QueryEscape(b64+"\n"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(key) {
+		t.Error("expected the key in the secret")
+	}
+	got, err = loadKeySource(ctx, "constant://?decoder=bytes&val="+url.QueryEscape(string(key)), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(key) {
+		t.Error("expected the raw key in the secret")
+	}
+
+	if _, err := loadKeySource(ctx, "constant://?decoder=string&val=hunter2", ""); !errors.Is(err, ErrKeyLength) {
+		t.Errorf("expected a short key to be rejected, got %v", err)
+	}
+	if _, err := loadKeySource(ctx, "constant://?err=access+denied", ""); err == nil {
+		t.Error("expected an error reading the secret to fail")
+	}
+	if _, err := loadKeySource(ctx, "vault://secret/key", ""); err == nil {
+		t.Error("expected an unknown scheme to fail")
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// the variables a -key-template can use, and what each is for a source key.
+// the dates are the object's modification time, in UTC.
+var templateVars = map[string]func(key string, modTime time.Time) string{
+	"key":  func(key string, _ time.Time) string { return key },
+	"name": func(key string, _ time.Time) string { return path.Base(key) },
+	"dir": func(key string, _ time.Time) string {
+		if dir := path.Dir(key); dir != "." {
+			return dir
+		}
+		return ""
+	},
+	// without the dot. "" when there's no extension.
+	"ext":   func(key string, _ time.Time) string { return strings.TrimPrefix(path.Ext(key), ".") },
+	"year":  func(_ string, t time.Time) string { return t.UTC().Format("2006") },
+	"month": func(_ string, t time.Time) string { return t.UTC().Format("01") },
+	"day":   func(_ string, t time.Time) string { return t.UTC().Format("02") },
+	// the first two hex digits of the md5 of the key, to spread keys over prefixes.
+	"hash-prefix": func(key string, _ time.Time) string {
+		sum := md5.Sum([]byte(key))
+		return hex.EncodeToString(sum[:1])
+	},
+}
+
+// a destination key made of literal text and {variables}, like {year}/{month}/{name}.
+type keyTemplate struct {
+	// literal text and variable names, alternating, starting with text.
+	parts []string
+}
+
+func parseKeyTemplate(s string) (*keyTemplate, error) {
+	t := &keyTemplate{}
+	rest := s
+	for {
+		open := strings.IndexByte(rest, '{')
+		if open < 0 {
+			if strings.IndexByte(rest, '}') >= 0 {
+				return nil, fmt.Errorf("bad key template %q: } without {", s)
+			}
+			t.parts = append(t.parts, rest)
+			break
+		}
+		end := strings.IndexByte(rest[open:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("bad key template %q: { without }", s)
+		}
+		name := rest[open+1 : open+end]
+		if _, ok := templateVars[name]; !ok {
+			return nil, fmt.Errorf("bad key template %q: unknown variable {%s}", s, name)
+		}
+		if strings.IndexByte(rest[:open], '}') >= 0 {
+			return nil, fmt.Errorf("bad key template %q: } without {", s)
+		}
+		t.parts = append(t.parts, rest[:open], name)
+		rest = rest[open+end+1:]
+	}
+	if !t.uses("key") && !t.uses("name") {
+		return nil, fmt.Errorf("bad key template %q: it has to use {key} or {name}, or every object would get the same key", s)
+	}
+	return t, nil
+}
+
+func (t *keyTemplate) uses(name string) bool {
+	for i := 1; i < len(t.parts); i += 2 {
+		if t.parts[i] == name {
+			return true
+		}
+	}
+	return false
+}
+
+// reports whether the template needs the modification time of objects.
+func (t *keyTemplate) needsTime() bool {
+	return t.uses("year") || t.uses("month") || t.uses("day")
+}
+
+// the destination name for key. slashes that empty variables leave doubled, or at
+// the start, are dropped, so {dir}/{name} of a key without a directory is just the name.
+func (t *keyTemplate) apply(key string, modTime time.Time) string {
+	var b strings.Builder
+	for i, part := range t.parts {
+		if i%2 == 1 {
+			part = templateVars[part](key, modTime)
+		}
+		b.WriteString(part)
+	}
+	out := b.String()
+	for strings.Contains(out, "//") {
+		out = strings.ReplaceAll(out, "//", "/")
+	}
+	return strings.TrimPrefix(out, "/")
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestKeyTemplate(t *testing.T) {
+	// July 5th in UTC, where the dates come from.
+	mod := time.Date(2023, 7, 4, 23, 30, 0, 0, time.FixedZone("", -2*60*60))
+	tests := []struct {
+		template, key, want string
+	}{
+		{"{year}/{month}/{name}", "photos/cat.jpg", "2023/07/cat.jpg"},
+		{"{year}/{month}/{day}/{key}", "photos/cat.jpg", "2023/07/05/photos/cat.jpg"},
+		{"{ext}/{name}", "photos/cat.jpg", "jpg/cat.jpg"},
+		{"{ext}/{name}", "README", "README"},
+		{"{dir}/{year}/{name}", "cat.jpg", "2023/cat.jpg"},
+		{"{hash-prefix}/{key}", "photos/cat.jpg", "e3/photos/cat.jpg"},
+		{"archive-{year}/{key}", "a/b/c", "archive-2023/a/b/c"},
+	}
+	for _, tt := range tests {
+		tmpl, err := parseKeyTemplate(tt.template)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := tmpl.apply(tt.key, mod); got != tt.want {
+			t.Errorf("%s of %s: got %s, expected %s", tt.template, tt.key, got, tt.want)
+		}
+	}
+	for _, bad := range []string{"{year}/{nope}", "{year", "year}/{name}", "{year}/{month}", ""} {
+		if _, err := parseKeyTemplate(bad); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+	if tmpl, _ := parseKeyTemplate("{ext}/{name}"); tmpl.needsTime() {
+		t.Error("{ext}/{name} doesn't need modification times")
+	}
+}
+
+// objects are copied under their templated keys, and two that end up on the same
+// key are a collision.
+func TestKeyTemplateMirror(t *testing.T) {
+	ctx := context.Background()
+	sbkt, dbkt := testMemBuckets(t)
+	for _, key := range []string{"a/one.txt", "b/one.txt", "b/two.txt"} {
+		if err := sbkt.WriteAll(ctx, key, []byte(key), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	attrs, err := sbkt.Attributes(ctx, "b/two.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl, err := parseKeyTemplate("{year}/{month}/{name}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error)
+	var collisions int
+	done := make(chan bool)
+	go func() {
+		for err := range errs {
+			if !errors.Is(err, ErrKeyCollision) {
+				t.Error(err)
+			}
+			collisions++
+		}
+		close(done)
+	}()
+	n := mirror(ctx, sbkt, dbkt, nil, mirrorOpts{keyTemplate: tmpl, onCollision: conflictSkip}, errs)
+	close(errs)
+	<-done
+	if n != 2 || collisions != 1 {
+		t.Fatalf("expected 2 objects copied and 1 collision, got %d and %d", n, collisions)
+	}
+	prefix := attrs.ModTime.UTC().Format("2006/01/")
+	if got := testReadString(t, dbkt, prefix+"two.txt"); got != "b/two.txt" {
+		t.Errorf("unexpected content %q", got)
+	}
+	if got := testReadString(t, dbkt, prefix+"one.txt"); got != "a/one.txt" {
+		t.Errorf("expected the first one.txt to be kept, got %q", got)
+	}
+}
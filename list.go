@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"cloud.google.com/go/storage"
+	awsv2 "github.com/aws/aws-sdk-go-v2/aws"
+	s3v2 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"gocloud.dev/blob"
+)
+
+// list options that make the backend start listing after key.
+// S3 and GCS support this, and list in key order, so everything up to key is skipped
+// without being listed. applied is set when the backend took the hint.
+// other backends list everything.
+func startAfter(key string, applied *bool) *blob.ListOptions {
+	return &blob.ListOptions{
+		BeforeList: func(as func(interface{}) bool) error {
+			var v1 *s3.ListObjectsV2Input
+			var v2 *s3v2.ListObjectsV2Input
+			var q *storage.Query
+			switch {
+			case as(&v1):
+				v1.StartAfter = aws.String(key)
+			case as(&v2):
+				v2.StartAfter = awsv2.String(key)
+			case as(&q):
+				// StartOffset is inclusive, the smallest key after key is key + "\x00"
+				q.StartOffset = key + "\x00"
+			default:
+				return nil
+			}
+			*applied = true
+			return nil
+		},
+	}
+}
+
+var ErrEmptySource = errors.New("the source has no objects")
+
+// reports whether bkt has no objects at all, by listing a single one.
+func bucketEmpty(ctx context.Context, bkt Bucket) (bool, error) {
+	objs, _, err := bkt.ListPage(ctx, blob.FirstPageToken, 1, nil)
+	if err != nil {
+		return false, err
+	}
+	return len(objs) == 0, nil
+}
+
+// what mirror needs of a listing. *blob.ListIterator is one.
+type objectIterator interface {
+	Next(ctx context.Context) (*blob.ListObject, error)
+}
+
+// lists bkt, fetching at most rps pages a second with -list-rps.
+// 0 lists as fast as the backend will.
+func listObjects(bkt Bucket, opts *blob.ListOptions, rps float64) objectIterator {
+	if rps <= 0 {
+		return bkt.List(opts)
+	}
+	return &pacedIterator{bkt: bkt, opts: opts, pageSize: listPageSize, pace: newPacer(rps), token: blob.FirstPageToken}
+}
+
+const listPageSize = 1000
+
+// a list iterator that fetches pages itself, so it can wait before each one.
+// listing has its own limit, since a page of keys costs the backend more than a copy request.
+type pacedIterator struct {
+	bkt      Bucket
+	opts     *blob.ListOptions
+	pageSize int
+	pace     *pacer
+	token    []byte
+	page     []*blob.ListObject
+}
+
+func (it *pacedIterator) Next(ctx context.Context) (*blob.ListObject, error) {
+	for len(it.page) == 0 {
+		if len(it.token) == 0 {
+			return nil, io.EOF
+		}
+		if err := it.pace.wait(ctx); err != nil {
+			return nil, err
+		}
+		page, next, err := it.bkt.ListPage(ctx, it.token, it.pageSize, it.opts)
+		if err != nil {
+			return nil, err
+		}
+		it.page, it.token = page, next
+	}
+	obj := it.page[0]
+	it.page = it.page[1:]
+	return obj, nil
+}
+
+// spaces out calls to wait so there are at most rps of them a second.
+type pacer struct {
+	interval time.Duration
+	last     time.Time
+}
+
+func newPacer(rps float64) *pacer {
+	return &pacer{interval: time.Duration(float64(time.Second) / rps)}
+}
+
+func (p *pacer) wait(ctx context.Context) error {
+	if !p.last.IsZero() {
+		if d := p.interval - time.Since(p.last); d > 0 {
+			t := time.NewTimer(d)
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return ctx.Err()
+			case <-t.C:
+			}
+		}
+	}
+	p.last = time.Now()
+	return nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"gocloud.dev/blob"
+)
+
+func TestBucketEmpty(t *testing.T) {
+	ctx := context.Background()
+	sbkt, _ := testMemBuckets(t)
+	empty, err := bucketEmpty(ctx, sbkt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !empty {
+		t.Error("a new memory bucket should be empty")
+	}
+	if err := sbkt.WriteAll(ctx, "dir/file", []byte("x"), nil); err != nil {
+		t.Fatal(err)
+	}
+	empty, err = bucketEmpty(ctx, sbkt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if empty {
+		t.Error("a bucket with an object is not empty")
+	}
+}
+
+func TestPacedList(t *testing.T) {
+	ctx := context.Background()
+	sbkt, _ := testMemBuckets(t)
+	var expected []string
+	for i := 0; i < 5; i++ {
+		key := "file" + strconv.Itoa(i)
+		if err := sbkt.WriteAll(ctx, key, []byte("x"), nil); err != nil {
+			t.Fatal(err)
+		}
+		expected = append(expected, key)
+	}
+	// a page per object, at 50 pages a second.
+	iter := listObjects(sbkt, nil, 50).(*pacedIterator)
+	iter.pageSize = 1
+	start := time.Now()
+	var keys []string
+	for {
+		obj, err := iter.Next(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, obj.Key)
+	}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("got %v, expected %v", keys, expected)
+	}
+	// five pages are four waits of 20ms, at least.
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+		t.Errorf("listing wasn't paced, took %v", elapsed)
+	}
+
+	if _, ok := listObjects(sbkt, nil, 0).(*blob.ListIterator); !ok {
+		t.Error("without a limit, the bucket's own iterator should be used")
+	}
+}
+
+func TestPacerCanceled(t *testing.T) {
+	p := newPacer(0.001)
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := p.wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if err := p.wait(ctx); err != context.Canceled {
+		t.Errorf("expected the wait to be canceled, got %v", err)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// the plain name of an encrypted object. Hashed names can't be decrypted,
+// so for those the encrypted name in the metadata is used, as plainKey does.
+func decryptedName(ctx context.Context, bkt Bucket, key string, nameKey []byte) (string, error) {
+	name, err := makeKey(key, nil, nameKey)
+	if err == nil {
+		return name, nil
+	}
+	attrs, aerr := bkt.Attributes(ctx, key)
+	if aerr != nil {
+		return "", aerr
+	}
+	encName, ok := attrs.Metadata[origKeyMeta]
+	if !ok {
+		return "", err
+	}
+	return makeKey(encName, nil, nameKey)
+}
+
+// prints the decrypted name and size of every object in bkt, one per line.
+// Objects whose names don't decrypt with nameKey, like objects blobcopy didn't
+// write, are skipped with a warning. The safety file is skipped silently.
+func listDecrypted(ctx context.Context, bkt Bucket, nameKey []byte, w io.Writer, warn func(error)) (int, error) {
+	_, safety, err := safetyName(nameKey)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	iter := bkt.List(nil)
+	for {
+		obj, err := iter.Next(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return n, fmt.Errorf("error iterating: %w", err)
+		}
+		if obj.Key == safety {
+			continue
+		}
+		name, err := decryptedName(ctx, bkt, obj.Key, nameKey)
+		if err != nil {
+			warn(fmt.Errorf("%s doesn't decrypt with this key, skipping: %w", obj.Key, err))
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "%s\t%d\n", name, obj.Size); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestListDecrypted(t *testing.T) {
+	ctx := context.Background()
+	key := testAuthentication(t)
+	sbkt, dbkt := testMemBuckets(t)
+	for _, k := range []string{"a/one", "b"} {
+		if err := sbkt.WriteAll(ctx, k, []byte("data for "+k), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	errs := make(chan error)
+	go func() {
+		for err := range errs {
+			t.Error(err)
+		}
+	}()
+	opts := mirrorOpts{nameEncrypt: key, bytesEncrypt: key}
+	if n := mirror(ctx, sbkt, dbkt, nil, opts, errs); n != 2 {
+		t.Fatalf("copied %d objects", n)
+	}
+	// a hashed name is found through its metadata.
+	opts.keyHash = keyHashSHA256
+	if err := sbkt.WriteAll(ctx, "c", []byte("c"), nil); err != nil {
+		t.Fatal(err)
+	}
+	opts.filter = &keyFilter{include: patternList{"c"}}
+	if n := mirror(ctx, sbkt, dbkt, nil, opts, errs); n != 1 {
+		t.Fatalf("copied %d objects", n)
+	}
+	close(errs)
+	if err := enableSafetyCheck(ctx, dbkt, key); err != nil {
+		t.Fatal(err)
+	}
+	if err := dbkt.WriteAll(ctx, "foreign", []byte("x"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	var warnings []error
+	n, err := listDecrypted(ctx, dbkt, key, &out, func(err error) { warnings = append(warnings, err) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Errorf("listed %d objects, expected 3", n)
+	}
+	for _, want := range []string{"a/one\t", "b\t", "c\t"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("%q not listed in\n%s", want, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "_blobcopy_safety_") {
+		t.Error("the safety file should not be listed")
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0].Error(), "foreign") {
+		t.Errorf("expected one warning about the foreign object, got %v", warnings)
+	}
+
+	// with the wrong key nothing decrypts.
+	out.Reset()
+	warnings = nil
+	n, err = listDecrypted(ctx, dbkt, testAuthentication(t), &out, func(err error) { warnings = append(warnings, err) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 || len(warnings) != 5 {
+		t.Errorf("wrong key listed %d objects, with %d warnings", n, len(warnings))
+	}
+}
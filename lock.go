@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	s3v2 "github.com/aws/aws-sdk-go-v2/service/s3"
+	s3v2types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"gocloud.dev/blob"
+)
+
+// object lock retention modes, as S3 names them.
+const (
+	retentionGovernance = "governance"
+	retentionCompliance = "compliance"
+)
+
+var ErrLockUnsupported = errors.New("object lock is only supported on S3 destinations")
+
+func validRetentionMode(mode string) error {
+	switch mode {
+	case retentionGovernance, retentionCompliance:
+		return nil
+	}
+	return fmt.Errorf("unknown retention mode %q. use governance or compliance", mode)
+}
+
+// parses a retention period, or any other long duration. On top of what
+// time.ParseDuration takes, a whole number of days like 30d is accepted.
+func parseRetention(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("bad duration %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("bad duration %q", s)
+	}
+	return d, nil
+}
+
+// S3 object lock settings for a copied object.
+type objectLock struct {
+	// one of retentionGovernance or retentionCompliance. only used with retainUntil.
+	mode        string
+	retainUntil time.Time
+	legalHold   bool
+}
+
+func (l objectLock) empty() bool {
+	return l.retainUntil.IsZero() && !l.legalHold
+}
+
+// a WriterOptions.BeforeWrite that sets the lock on the upload.
+// S3 wants a Content-MD5 with object lock headers, see -send-content-md5.
+func (l objectLock) beforeWrite(as func(interface{}) bool) error {
+	var v1 *s3manager.UploadInput
+	var v2 *s3v2.PutObjectInput
+	switch {
+	case as(&v1):
+		if !l.retainUntil.IsZero() {
+			v1.ObjectLockMode = aws.String(strings.ToUpper(l.mode))
+			v1.ObjectLockRetainUntilDate = aws.Time(l.retainUntil)
+		}
+		if l.legalHold {
+			v1.ObjectLockLegalHoldStatus = aws.String(s3.ObjectLockLegalHoldStatusOn)
+		}
+	case as(&v2):
+		if !l.retainUntil.IsZero() {
+			v2.ObjectLockMode = s3v2types.ObjectLockMode(strings.ToUpper(l.mode))
+			v2.ObjectLockRetainUntilDate = aws.Time(l.retainUntil)
+		}
+		if l.legalHold {
+			v2.ObjectLockLegalHoldStatus = s3v2types.ObjectLockLegalHoldStatusOn
+		}
+	default:
+		return ErrLockUnsupported
+	}
+	return nil
+}
+
+// the object lock of an S3 source object. Empty for other backends.
+func sourceLock(attrs *blob.Attributes) objectLock {
+	var l objectLock
+	var v1 s3.HeadObjectOutput
+	var v2 s3v2.HeadObjectOutput
+	switch {
+	case attrs.As(&v1):
+		if v1.ObjectLockRetainUntilDate != nil && v1.ObjectLockMode != nil {
+			l.mode = strings.ToLower(*v1.ObjectLockMode)
+			l.retainUntil = *v1.ObjectLockRetainUntilDate
+		}
+		l.legalHold = aws.StringValue(v1.ObjectLockLegalHoldStatus) == s3.ObjectLockLegalHoldStatusOn
+	case attrs.As(&v2):
+		if v2.ObjectLockRetainUntilDate != nil && v2.ObjectLockMode != "" {
+			l.mode = strings.ToLower(string(v2.ObjectLockMode))
+			l.retainUntil = *v2.ObjectLockRetainUntilDate
+		}
+		l.legalHold = v2.ObjectLockLegalHoldStatus == s3v2types.ObjectLockLegalHoldStatusOn
+	}
+	return l
+}
+
+// reports whether objects written to bkt can be locked.
+func lockSupported(bkt Bucket) bool {
+	var v1 *s3.S3
+	var v2 *s3v2.Client
+	return bkt.As(&v1) || bkt.As(&v2)
+}
+
+// the lock for an object copied now: the source's lock when preserving it,
+// with the retention and legal hold from the options on top.
+func (opts mirrorOpts) lockFor(sattrs *blob.Attributes, now time.Time) objectLock {
+	var l objectLock
+	if opts.preserveLock {
+		l = sourceLock(sattrs)
+	}
+	if opts.retention > 0 {
+		l.mode = opts.retentionMode
+		l.retainUntil = now.Add(opts.retention)
+	}
+	if opts.legalHold {
+		l.legalHold = true
+	}
+	return l
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	s3v2 "github.com/aws/aws-sdk-go-v2/service/s3"
+	s3v2types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+func TestParseRetention(t *testing.T) {
+	for s, expected := range map[string]time.Duration{
+		"":    0,
+		"30d": 30 * 24 * time.Hour,
+		"12h": 12 * time.Hour,
+		"90m": 90 * time.Minute,
+	} {
+		got, err := parseRetention(s)
+		if err != nil {
+			t.Errorf("%q: %v", s, err)
+		}
+		if got != expected {
+			t.Errorf("%q: expected %v, got %v", s, expected, got)
+		}
+	}
+	for _, s := range []string{"d", "-1d", "3 days", "-5h"} {
+		if _, err := parseRetention(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestObjectLockBeforeWrite(t *testing.T) {
+	until := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	l := objectLock{mode: retentionCompliance, retainUntil: until, legalHold: true}
+
+	v1 := &s3manager.UploadInput{}
+	err := l.beforeWrite(func(i interface{}) bool {
+		p, ok := i.(**s3manager.UploadInput)
+		if ok {
+			*p = v1
+		}
+		return ok
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aws.StringValue(v1.ObjectLockMode) != "COMPLIANCE" || !aws.TimeValue(v1.ObjectLockRetainUntilDate).Equal(until) || aws.StringValue(v1.ObjectLockLegalHoldStatus) != "ON" {
+		t.Errorf("unexpected v1 lock: %v %v %v", v1.ObjectLockMode, v1.ObjectLockRetainUntilDate, v1.ObjectLockLegalHoldStatus)
+	}
+
+	v2 := &s3v2.PutObjectInput{}
+	err = objectLock{legalHold: true}.beforeWrite(func(i interface{}) bool {
+		p, ok := i.(**s3v2.PutObjectInput)
+		if ok {
+			*p = v2
+		}
+		return ok
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v2.ObjectLockMode != "" || v2.ObjectLockRetainUntilDate != nil || v2.ObjectLockLegalHoldStatus != s3v2types.ObjectLockLegalHoldStatusOn {
+		t.Errorf("unexpected v2 lock: %v %v %v", v2.ObjectLockMode, v2.ObjectLockRetainUntilDate, v2.ObjectLockLegalHoldStatus)
+	}
+
+	err = l.beforeWrite(func(i interface{}) bool { return false })
+	if !errors.Is(err, ErrLockUnsupported) {
+		t.Errorf("expected ErrLockUnsupported, got %v", err)
+	}
+}
+
+func TestLockFor(t *testing.T) {
+	ctx := context.Background()
+	sbkt, dbkt := testMemBuckets(t)
+	if lockSupported(dbkt) {
+		t.Error("memory buckets don't support object lock")
+	}
+	if err := sbkt.WriteAll(ctx, "file", []byte("x"), nil); err != nil {
+		t.Fatal(err)
+	}
+	sattrs, err := sbkt.Attributes(ctx, "file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	if l := (mirrorOpts{preserveLock: true}).lockFor(sattrs, now); !l.empty() {
+		t.Errorf("expected no lock from a memory source, got %+v", l)
+	}
+	l := mirrorOpts{retention: time.Hour, retentionMode: retentionGovernance}.lockFor(sattrs, now)
+	if l.mode != retentionGovernance || !l.retainUntil.Equal(now.Add(time.Hour)) || l.legalHold {
+		t.Errorf("unexpected lock %+v", l)
+	}
+}
@@ -0,0 +1,16 @@
+package main
+
+import "errors"
+
+// the per-object log level -quiet and -verbose ask for.
+func parseLogLevel(quiet, verbose bool) (int, error) {
+	switch {
+	case quiet && verbose:
+		return 0, errors.New("-quiet and -verbose can't be used together")
+	case quiet:
+		return logQuiet, nil
+	case verbose:
+		return logVerbose, nil
+	}
+	return logNormal, nil
+}
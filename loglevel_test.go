@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseLogLevel(t *testing.T) {
+	for _, c := range []struct {
+		quiet, verbose bool
+		expected       int
+	}{
+		{false, false, logNormal},
+		{true, false, logQuiet},
+		{false, true, logVerbose},
+	} {
+		level, err := parseLogLevel(c.quiet, c.verbose)
+		if err != nil || level != c.expected {
+			t.Errorf("quiet %v verbose %v: expected %d, got %d %v", c.quiet, c.verbose, c.expected, level, err)
+		}
+	}
+	if _, err := parseLogLevel(true, true); err == nil {
+		t.Error("expected -quiet with -verbose to fail")
+	}
+}
+
+// quiet leaves out the per-object lines, verbose adds the temporary bucket ones.
+func TestLogLevels(t *testing.T) {
+	var out strings.Builder
+	logger.SetOutput(&out)
+	defer func() {
+		logger.SetOutput(os.Stdout)
+		logLevel = logNormal
+	}()
+	for _, c := range []struct {
+		level             int
+		copied, temporary bool
+	}{
+		{logQuiet, false, false},
+		{logNormal, true, false},
+		{logVerbose, true, true},
+	} {
+		out.Reset()
+		logLevel = c.level
+		logf(logNormal, "copied\n")
+		logf(logVerbose, "temporary\n")
+		got := out.String()
+		if strings.Contains(got, "copied") != c.copied || strings.Contains(got, "temporary") != c.temporary {
+			t.Errorf("level %d: unexpected output %q", c.level, got)
+		}
+	}
+}
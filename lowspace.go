@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrLowSpace = errors.New("not enough free space")
+
+// what -on-low-space does when a local destination doesn't have room for an object.
+const (
+	// fail the object and go on with the next one, which might fit.
+	lowSpaceSkip = "skip"
+	// fail the object and stop.
+	lowSpaceAbort = "abort"
+	// copy it anyway, and let the write fail if it has to.
+	lowSpaceIgnore = "ignore"
+)
+
+// room left over besides the object itself, for encryption overhead and the
+// filesystem's own blocks.
+const lowSpaceSlack = 64 << 10
+
+// how many bytes a directory's filesystem has free for us. a var so tests
+// can run out of space. errors.ErrUnsupported where it can't be told.
+var freeSpace = dirFreeSpace
+
+func validLowSpace(s string) error {
+	switch s {
+	case lowSpaceSkip, lowSpaceAbort, lowSpaceIgnore:
+		return nil
+	}
+	return fmt.Errorf("unknown -on-low-space %q. use skip, abort or ignore", s)
+}
+
+// checks that local destinations have room for an object before it's copied,
+// since fileblob would leave it half written when the disk fills up.
+type spaceGuard struct {
+	// the directories of the destinations that are local.
+	dirs  map[Bucket]string
+	onLow string
+}
+
+// a guard over the local ones of dsts, or nil if there are none.
+func newSpaceGuard(dsts []string, dbkts []Bucket, onLow string) *spaceGuard {
+	if onLow == lowSpaceIgnore {
+		return nil
+	}
+	g := &spaceGuard{dirs: make(map[Bucket]string), onLow: onLow}
+	for i, dst := range dsts {
+		if dir, ok := localDir(dst); ok {
+			g.dirs[dbkts[i]] = dir
+		}
+	}
+	if len(g.dirs) == 0 {
+		return nil
+	}
+	return g
+}
+
+// checks the destinations in bkts have room for size bytes. A nil guard always passes.
+func (g *spaceGuard) check(bkts []Bucket, size int64) error {
+	if g == nil {
+		return nil
+	}
+	for _, bkt := range bkts {
+		dir, ok := g.dirs[bkt]
+		if !ok {
+			continue
+		}
+		free, err := freeSpace(dir)
+		if errors.Is(err, errors.ErrUnsupported) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("error checking free space in %s: %w", dir, err)
+		}
+		if size < 0 {
+			size = 0
+		}
+		if need := uint64(size) + lowSpaceSlack; free < need {
+			return fmt.Errorf("%s has %d bytes free, %d are needed: %w", dir, free, need, ErrLowSpace)
+		}
+	}
+	return nil
+}
+
+// reports whether the copy should stop after err.
+func (g *spaceGuard) abort(err error) bool {
+	return g != nil && g.onLow == lowSpaceAbort && errors.Is(err, ErrLowSpace)
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+import "errors"
+
+func dirFreeSpace(dir string) (uint64, error) {
+	return 0, errors.ErrUnsupported
+}
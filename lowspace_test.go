@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"gocloud.dev/blob"
+)
+
+func TestValidLowSpace(t *testing.T) {
+	for _, s := range []string{lowSpaceSkip, lowSpaceAbort, lowSpaceIgnore} {
+		if err := validLowSpace(s); err != nil {
+			t.Errorf("%s: %v", s, err)
+		}
+	}
+	if err := validLowSpace("wait"); err == nil {
+		t.Error("expected an error for an unknown action")
+	}
+}
+
+func TestSpaceGuard(t *testing.T) {
+	_, mem := testMemBuckets(t)
+	dir := t.TempDir()
+	local, err := blob.OpenBucket(context.Background(), "file://"+dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer local.Close()
+	if g := newSpaceGuard([]string{"mem://"}, []Bucket{mem}, lowSpaceSkip); g != nil {
+		t.Error("no local destinations should need no guard")
+	}
+	if g := newSpaceGuard([]string{"file://" + dir}, []Bucket{local}, lowSpaceIgnore); g != nil {
+		t.Error("-on-low-space ignore should need no guard")
+	}
+	// the real free space, which has room for a small object.
+	g := newSpaceGuard([]string{"mem://", "file://" + dir}, []Bucket{mem, local}, lowSpaceSkip)
+	if err := g.check([]Bucket{mem, local}, 1); err != nil {
+		t.Errorf("expected room for 1 byte in %s: %v", dir, err)
+	}
+
+	testFreeSpace(t, 1<<20)
+	if err := g.check([]Bucket{local}, 1<<20); !errors.Is(err, ErrLowSpace) {
+		t.Errorf("expected ErrLowSpace, got %v", err)
+	}
+	if err := g.check([]Bucket{mem}, 1<<20); err != nil {
+		t.Errorf("only local destinations are checked, got %v", err)
+	}
+	if g.abort(fmt.Errorf("x: %w", ErrLowSpace)) {
+		t.Error("skip shouldn't abort")
+	}
+	g.onLow = lowSpaceAbort
+	if !g.abort(fmt.Errorf("x: %w", ErrLowSpace)) || g.abort(errInjected) {
+		t.Error("abort should only stop for ErrLowSpace")
+	}
+
+	freeSpace = func(string) (uint64, error) { return 0, errors.ErrUnsupported }
+	if err := g.check([]Bucket{local}, 1<<30); err != nil {
+		t.Errorf("where free space can't be told, the copy should go ahead, got %v", err)
+	}
+}
+
+// pretends local destinations have free bytes left.
+func testFreeSpace(t *testing.T, free uint64) {
+	orig := freeSpace
+	t.Cleanup(func() { freeSpace = orig })
+	freeSpace = func(string) (uint64, error) { return free, nil }
+}
+
+func TestLowSpaceCopy(t *testing.T) {
+	ctx := context.Background()
+	for _, onLow := range []string{lowSpaceSkip, lowSpaceAbort} {
+		t.Run(onLow, func(t *testing.T) {
+			sbkt, _ := testMemBuckets(t)
+			dir := t.TempDir()
+			dbkt, err := blob.OpenBucket(ctx, "file://"+dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer dbkt.Close()
+			// a, c and d fit, b doesn't.
+			sizes := map[string]int{"a": 10, "b": 1 << 20, "c": 10, "d": 10}
+			for key, size := range sizes {
+				if err := sbkt.WriteAll(ctx, key, make([]byte, size), nil); err != nil {
+					t.Fatal(err)
+				}
+			}
+			testFreeSpace(t, 1<<19)
+			errs := make(chan error)
+			var failures []error
+			done := make(chan struct{})
+			go func() {
+				for err := range errs {
+					failures = append(failures, err)
+				}
+				close(done)
+			}()
+			st := newState()
+			opts := mirrorOpts{state: st, spaceGuard: newSpaceGuard([]string{"file://" + dir}, []Bucket{dbkt}, onLow)}
+			n := mirror(ctx, sbkt, dbkt, nil, opts, errs)
+			close(errs)
+			<-done
+			if len(failures) != 1 || !errors.Is(failures[0], ErrLowSpace) {
+				t.Fatalf("expected one ErrLowSpace, got %v", failures)
+			}
+			if ok, _ := dbkt.Exists(ctx, "b"); ok {
+				t.Error("b shouldn't have been written")
+			}
+			if _, ok := st.Objects["b"]; ok {
+				t.Error("b shouldn't be recorded as done")
+			}
+			want := 3
+			if onLow == lowSpaceAbort {
+				want = 1
+			}
+			if n != want {
+				t.Errorf("expected %d objects copied, got %d", want, n)
+			}
+		})
+	}
+}
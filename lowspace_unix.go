@@ -0,0 +1,14 @@
+//go:build unix
+
+package main
+
+import "syscall"
+
+func dirFreeSpace(dir string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+	// what's free for users that aren't root.
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
@@ -1,19 +1,27 @@
 package main
 
 import (
+	"bytes"
 	"context"
-	"crypto/aes"
-	"crypto/cipher"
 	"crypto/md5"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"net/url"
 	"os"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"gocloud.dev/gcerrors"
 
 	"golang.org/x/term"
@@ -26,305 +34,2553 @@ import (
 	_ "gocloud.dev/blob/s3blob"
 )
 
+// log levels for per-object messages.
+// errors and the final summary are always logged.
+const (
+	logQuiet = iota
+	logNormal
+	logVerbose
+)
+
 var (
-	ErrPasswordMismatch = errors.New("passwords do not match")
-	errLogger           = log.New(os.Stderr, "", log.Flags())
-	logger              = log.New(os.Stdout, "", log.Flags())
+	ErrPasswordMismatch  = errors.New("passwords do not match")
+	ErrSafetyCheckFailed = errors.New("safety check failed")
+	ErrShortCyphertext   = errors.New("cyphertext too short, not encrypted with this tool")
+	ErrImmutable         = errors.New("not overwriting an object in an immutable destination")
+	ErrNotEncryptedName  = errors.New("not an encrypted name, or encrypted with another password")
+	errLogger            = log.New(os.Stderr, "", log.Flags())
+	logger               = log.New(os.Stdout, "", log.Flags())
+	logLevel             = logNormal
+	// with -report-every-n, only every reportEvery-th copied object is logged.
+	reportEvery = 0
 )
 
 func main() {
 	var useTmp string
 	var passEncrypt bool
 	var passDecrypt bool
+	var passEncryptKeys bool
+	var passEncryptContent bool
+	var passDecryptKeys bool
+	var passDecryptContent bool
+	var decryptPassthrough bool
+	var reencrypt bool
 	var useSafety bool
 	var genSafety bool
+	var deepSafety bool
+	var requireSafety bool
 	var skipN int
 	var verifymd5 bool
+	var symlinks string
+	var sendContentMD5 bool
+	var updateOnly bool
+	var statePath string
+	var checkpointInterval string
+	var onLowSpace string
+	var encryptState bool
+	var multipartParts int
+	var resumeRetries int
+	var quiet bool
+	var bidirectional bool
+	var onConflict string
+	var contentTypeMapping string
+	var contentTypeMapFile string
+	var verifyOnly bool
+	var repairMode bool
+	var storeOrigKey bool
+	var storeMD5 bool
+	var keyHash string
+	var dedupeList bool
+	var normalizeKeys bool
+	metadata := make(metadataFilter)
+	var filter keyFilter
+	var includeFrom string
+	var excludeFrom string
+	var setRetention string
+	var retentionMode string
+	var legalHold bool
+	var preserveLock bool
+	var distrustMD5 bool
+	var rehashMultipart bool
+	var manifest string
+	var detectDrift bool
+	var strict bool
+	var acceptDrift bool
+	var allowSelf bool
+	var listFormat string
+	var mkdir bool
+	var atomicDest bool
+	var failIfEmpty bool
+	var notifyOnChange bool
+	var dryRun bool
+	var planOutPath string
+	var objectKey string
+	var rangeSpec string
+	var applyPlanPath string
+	var purgeOlderThan string
+	var purgeAllowAll bool
+	var cloud cloudFlags
+	var prelist bool
+	var preserveDirs bool
+	var signURLs string
+	var signExpiry time.Duration
+	var listRPS float64
+	var shardFlag string
+	var aclPublic bool
+	var grants grantList
+	var skipArchived bool
+	var cacheControl string
+	var configPath string
+	var move bool
+	var dedupeContent bool
+	var validateKeys bool
+	var preserveMode bool
+	var runDeadline time.Duration
+	var immutable bool
+	var completionMarker string
+	var compareMetadata bool
+	var normalizeContentTypeCase bool
+	var limit int
+	var verifyAfter bool
+	var downloadRate string
+	var tier string
+	var expires string
+	var sseAlgo string
+	var sseKeyID string
+	var preserveSSE bool
+	var hashAlgo string
+	var compress bool
+	var compressCodecName string
+	var consistencyRetries int
+	var destTemplate string
+	var conditional bool
+	var webhookURL string
+	var maxKeyLength int
+	var keySource string
+	var splitKeys bool
+	var oldSplitKeys bool
+	var inventoryPath string
+	var allVersions bool
+	var otelEndpoint string
+	var wrappedKey string
+	var uploadRate string
+	var verifyWorkers int
+	var sanitizeKeys bool
+	var verbose bool
 	flag.StringVar(&useTmp, "tmp-bkt", "", "use a temporary bucket -- can be useful for calculating md5s")
+	flag.StringVar(&manifest, "manifest", "", "for an http(s) source, a file listing the keys to fetch, one per line")
+	flag.StringVar(&objectKey, "object", "", "copy only the object with this key")
+	flag.StringVar(&rangeSpec, "range", "", "with -object, copy only these bytes of it, start-end with both included, or start- for the rest")
 	flag.IntVar(&skipN, "skip", 0, "skip the first N files")
+	flag.IntVar(&limit, "limit", 0, "stop after copying N objects, to try options out on a sample")
 	flag.BoolVar(&passEncrypt, "encrypt", false, "encrypt the data with the given key")
 	flag.BoolVar(&passDecrypt, "decrypt", false, "decrypt the data with the given key")
+	flag.BoolVar(&passEncryptKeys, "encrypt-keys", false, "encrypt only the key names")
+	flag.BoolVar(&passEncryptContent, "encrypt-content", false, "encrypt only the content")
+	flag.BoolVar(&passDecryptKeys, "decrypt-keys", false, "decrypt only the key names")
+	flag.BoolVar(&passDecryptContent, "decrypt-content", false, "decrypt only the content")
+	flag.BoolVar(&decryptPassthrough, "decrypt-passthrough", false, "when decrypting, copy objects whose names aren't encrypted as they are, rather than skipping them")
+	flag.BoolVar(&reencrypt, "reencrypt", false, "decrypt with the old key and encrypt with a new one, to rotate keys")
+	flag.StringVar(&sealCipher, "cipher", cipherAESGCM, "what to encrypt with, aes-gcm or chacha20poly1305. decrypting works out which was used")
 	flag.BoolVar(&useSafety, "safety", false, "enable safety check")
-	flag.BoolVar(&genSafety, "gen-safety", false, "enable safety check")
+	flag.BoolVar(&genSafety, "gen-safety", false, "enable safety check, and generate the safety file if it fails")
+	flag.BoolVar(&deepSafety, "safety-deep", false, "enable safety check, and also check that a random destination object decrypts")
+	flag.BoolVar(&requireSafety, "require-safety", false, "enable safety check, and never generate the safety file")
 	flag.BoolVar(&verifymd5, "verify-md5", false, "verify md5s of files. This may be much slower.")
+	flag.StringVar(&otelEndpoint, "otel-endpoint", "", "send OpenTelemetry traces and metrics to this OTLP/http collector, like http://localhost:4318")
+	flag.BoolVar(&allVersions, "all-versions", false, "copy every version of each object of a versioned s3 or gcs source, each to key@version")
+	flag.StringVar(&inventoryPath, "inventory", "", "copy only the objects in this json array of {key, etag or md5}, and report the ones that changed since")
+	flag.StringVar(&keySource, "key-source", "", "fetch the encryption key rather than asking for a password: a secret like awssecretsmanager://name, or a KMS key like awskms://id that decrypts -wrapped-key")
+	flag.BoolVar(&splitKeys, "split-keys", false, "encrypt names and content with separate keys derived from the password. with -reencrypt, only the new one")
+	flag.BoolVar(&oldSplitKeys, "old-split-keys", false, "with -reencrypt, the old password's data was written with -split-keys")
+	flag.StringVar(&wrappedKey, "wrapped-key", "", "a file with the encryption key, encrypted with the -key-source KMS key")
+	flag.IntVar(&maxKeyLength, "max-key-length", 0, "skip destination keys longer than this many bytes up front, or hash them with -key-hash. defaults to the destination provider's limit")
+	flag.StringVar(&webhookURL, "webhook", "", "POST a json event for each object copied, skipped or failed to this url, in batches")
+	flag.BoolVar(&conditional, "conditional", false, "read objects that go through the temporary bucket only if they changed since their copies were written, on S3 and http(s) sources")
+	flag.StringVar(&destTemplate, "key-template", "", "where to put objects in the destination, e.g. {year}/{month}/{name}. variables: key, name, dir, ext, year, month, day, hash-prefix")
+	flag.IntVar(&consistencyRetries, "dest-consistency-retry", 0, "look a destination object that should be there up again this many times when it's not found, with backoff, for eventually consistent stores")
+	flag.BoolVar(&compress, "compress", false, "gzip objects on the way to the destination, and add .gz to their keys")
+	flag.StringVar(&compressCodecName, "compress-codec", "gzip", "how -compress compresses: gzip, zstd or none. zstd adds .zst to keys")
+	flag.StringVar(&hashAlgo, "hash-algo", hashMD5, "the hash -verify, -repair and the skip comparison go by: md5, sha1, sha256 or crc32c. crc32c compares GCS objects without reading them, even composed ones without an md5")
+	flag.BoolVar(&rehashMultipart, "rehash-multipart", false, "read objects uploaded to S3 in parts, whose etags aren't md5s, to compare them by md5 instead of by size")
+	flag.BoolVar(&distrustMD5, "distrust-provider-md5", false, "read both sides to compute md5s rather than trusting the ones the provider reports. implies -verify-md5")
+	flag.StringVar(&symlinks, "symlinks", symlinksFollow, "how to handle symlinks in local sources: follow, skip, or error")
+	flag.BoolVar(&sendContentMD5, "send-content-md5", false, "send the source md5 with each upload so the destination can verify it")
+	flag.BoolVar(&updateOnly, "update-only", false, "only update objects that already exist in the destination, never create new ones")
+	flag.StringVar(&statePath, "state", "", "remember copied objects in this file so an interrupted run can be resumed")
+	flag.StringVar(&onLowSpace, "on-low-space", lowSpaceSkip, "when a local destination doesn't have room for an object: skip it, abort the copy, or ignore the check")
+	flag.StringVar(&checkpointInterval, "checkpoint-interval", "", "also save -state every this many objects, like 1000, or this often, like 30s. by default it's only saved at the end")
+	flag.BoolVar(&detectDrift, "detect-drift", false, "with -state, warn if the destination changed since the last run")
+	flag.BoolVar(&strict, "strict", false, "with -detect-drift, abort instead of warning")
+	flag.BoolVar(&acceptDrift, "accept-drift", false, "with -detect-drift, accept the destination as it is now")
+	flag.BoolVar(&dryRun, "dry-run", false, "log what would be copied or purged, without changing the destination")
+	flag.StringVar(&planOutPath, "plan-out", "", "do a dry run, and write what it would do to this json file for review")
+	flag.StringVar(&applyPlanPath, "apply-plan", "", "do what a -plan-out file says, after checking none of its objects changed since")
+	flag.StringVar(&purgeOlderThan, "purge-older-than", "", "after copying, delete destination objects last modified longer ago than this, e.g. 720h or 30d, whether or not they're in the source")
+	flag.BoolVar(&purgeAllowAll, "purge-allow-all", false, "let -purge-older-than delete every object in the destination")
+	flag.StringVar(&cloud.awsProfile, "aws-profile", "", "the AWS shared config profile for s3:// buckets, like ?profile= in the URL")
+	flag.StringVar(&cloud.awsRegion, "aws-region", "", "the AWS region of s3:// buckets, like ?region= in the URL")
+	flag.StringVar(&cloud.gcpProject, "gcp-project", "", "the Google Cloud project to bill requests to gs:// buckets to, e.g. for requester pays buckets")
+	flag.BoolVar(&prelist, "prelist-dest", false, "list the destination once up front instead of checking each object, faster for re-syncs but holds the whole listing in memory")
+	flag.BoolVar(&preserveDirs, "preserve-empty-prefixes", false, "copy directory markers like dir/ and dir_$folder$ as dir/ markers, and make markers for empty directories in a file:// source")
+	flag.StringVar(&signURLs, "sign-urls", "", "write a presigned download URL for each copied object to this file")
+	flag.DurationVar(&signExpiry, "sign-urls-expiry", 24*time.Hour, "how long the URLs from -sign-urls are valid")
+	flag.StringVar(&downloadRate, "download-rate", "", "read objects from the source at most this many bytes a second, e.g. 512K or 10M")
+	flag.StringVar(&uploadRate, "upload-rate", "", "write objects to the destinations at most this many bytes a second, all of them together, e.g. 512K or 10M")
+	flag.Float64Var(&listRPS, "list-rps", 0, "list at most this many pages of source objects a second, to avoid list throttling. 0 is no limit")
+	flag.StringVar(&shardFlag, "shard", "", "only copy the keys in shard i of n, given as i/n, to split a copy across machines")
+	flag.StringVar(&sseAlgo, "sse", "", "have S3 encrypt copied objects with AES256 or aws:kms, rather than the bucket's default")
+	flag.StringVar(&sseKeyID, "sse-kms-key-id", "", "the KMS key objects are encrypted with: for S3 a key id or arn with -sse aws:kms, for GCS the key name")
+	flag.BoolVar(&preserveSSE, "preserve-sse", false, "encrypt copied objects with the source object's server-side encryption, when both are S3 or both GCS")
+	flag.StringVar(&expires, "expires", "", "mark copied objects to expire this long after they're written, e.g. 7d: the Expires header on S3, the custom time on GCS")
+	flag.StringVar(&tier, "tier", "", "write copied objects in this storage tier rather than the bucket's default. S3: standard, intelligent, infrequent, onezone, glacier-ir, glacier or deep-archive. GCS: standard, nearline, coldline or archive")
+	flag.BoolVar(&aclPublic, "copy-acl-public", false, "make every copied object public-read, on S3 and GCS destinations")
+	flag.Var(&grants, "grant-read", "give the S3 account with this canonical user id read access to every copied object. may be repeated")
+	flag.BoolVar(&skipArchived, "skip-archived", false, "skip S3 source objects in GLACIER or DEEP_ARCHIVE that haven't been restored, instead of failing on each")
+	flag.StringVar(&cacheControl, "cache-control", "", "the Cache-Control header of every copied object, e.g. max-age=31536000")
+	flag.BoolVar(&move, "move", false, "delete each source object once it's in every destination and reads back the same. implies -verify-md5")
+	flag.BoolVar(&dedupeContent, "dedupe-content", false, "keep an index of content hashes in each destination, and copy content it already has within the destination instead of uploading it again")
+	flag.BoolVar(&validateKeys, "validate-keys", false, "skip and report keys the destination providers would reject, like ones with control characters")
+	flag.BoolVar(&sanitizeKeys, "sanitize-keys", false, "rewrite keys the destination providers would reject so they're accepted. implies -validate-keys")
+	flag.BoolVar(&preserveMode, "preserve-mode", false, "keep the unix permission bits of files copied between file:// buckets")
+	flag.DurationVar(&runDeadline, "deadline", 0, "stop starting new objects after this long, e.g. 30m. with -state, the next run continues from there")
+	flag.BoolVar(&immutable, "immutable", false, "never overwrite destination objects. one that differs from the source is an error. implies -verify-md5")
+	flag.StringVar(&completionMarker, "completion-marker", "", "after a run with no errors, write a marker object with this key to each destination. an old one is removed first")
+	flag.BoolVar(&compareMetadata, "compare-metadata", false, "copy the source's content type and metadata, and update destination objects whose content matches but metadata doesn't. implies -verify-md5")
+	flag.BoolVar(&normalizeContentTypeCase, "normalize-content-type-case", false, "write content types in lowercase, e.g. text/html for Text/HTML, and compare them without case with -compare-metadata")
+	flag.StringVar(&configPath, "config", "", "read options from this json file, with flag names as keys. flags on the command line win")
+	flag.BoolVar(&notifyOnChange, "notify-on-change", false, fmt.Sprintf("exit %d when the run copied, repaired or deleted anything, and 0 when everything was already in sync", exitChanged))
+	flag.BoolVar(&failIfEmpty, "fail-if-empty", false, "exit with an error when the source has no objects, which usually means a wrong bucket or prefix")
+	flag.BoolVar(&atomicDest, "atomic-dest", false, "write each object under a temporary key and move it into place when it's complete, so readers never see half an object")
+	flag.BoolVar(&mkdir, "mkdir-dest", true, "create the directory of a file:// destination if it doesn't exist")
+	flag.BoolVar(&allowSelf, "allow-self", false, "allow the destination to be the source, when the keys are changed e.g. by encrypting them")
+	flag.BoolVar(&encryptState, "encrypt-state", false, "encrypt the state file with the encryption password")
+	flag.IntVar(&multipartParts, "multipart-parts", 0, "copy big objects as N ranges in parallel")
+	flag.IntVar(&resumeRetries, "resume-retries", 0, "try a failed upload of a big object again this many times. uploads to S3 carry on from the parts already uploaded")
+	flag.BoolVar(&quiet, "quiet", false, "only log errors and the final summary")
+	flag.BoolVar(&verbose, "verbose", false, "also log temporary bucket activity")
+	flag.IntVar(&reportEvery, "report-every-n", 0, "only log every Nth copied object. errors and the summary are always logged")
+	flag.BoolVar(&bidirectional, "bidirectional", false, "sync both ways: copy keys missing on either side to the other")
+	flag.StringVar(&onConflict, "on-conflict", conflictNewer, "with -bidirectional, what to do when a key changed on both sides. with -normalize-keys, what to do when two keys normalize to one. newer, source, dest or skip")
+	flag.StringVar(&contentTypeMapping, "content-type-map", "", "set content types by extension, e.g. .js=application/javascript,.wasm=application/wasm. * is the fallback")
+	flag.StringVar(&contentTypeMapFile, "content-type-map-file", "", "read -content-type-map pairs from a file, one per line")
+	flag.BoolVar(&verifyOnly, "verify", false, "don't copy anything, report objects that are missing or differ in the destination")
+	flag.BoolVar(&repairMode, "repair", false, "like -verify, but copy the objects that are missing or differ again, and report what was fixed")
+	flag.BoolVar(&verifyAfter, "verify-after", false, "once everything is copied, check every object against each destination, like -verify")
+	flag.IntVar(&verifyWorkers, "verify-workers", defaultVerifyWorkers, "how many objects -verify and -verify-after check at once")
+	flag.StringVar(&listFormat, "list-format", listPlain, "how -verify and -repair list the objects that are only in the source, only in the destination, or differ: plain, json or csv")
+	flag.BoolVar(&storeMD5, "store-md5", false, "store the md5 of each copied object in its metadata, for later verification on backends that don't keep md5s")
+	flag.BoolVar(&storeOrigKey, "store-origkey", false, "when encrypting, also store the encrypted key name in each object's metadata")
+	flag.StringVar(&keyHash, "key-hash", "", "when encrypting, name objects by a sha256 or md5 hash of the encrypted key, to keep long keys under the destination's limit. implies -store-origkey. without encryption, only keys over -max-key-length are hashed")
+	flag.BoolVar(&dedupeList, "dedupe-list", false, "remember listed keys, and skip a key if the source lists it twice")
+	flag.BoolVar(&normalizeKeys, "normalize-keys", false, "clean up source key paths: drop leading slashes and ./ segments")
+	flag.Var(&filter.include, "include", "only copy keys matching this glob. may be repeated")
+	flag.Var(&filter.exclude, "exclude", "don't copy keys matching this glob. may be repeated")
+	flag.StringVar(&includeFrom, "include-from", "", "read -include patterns from a file, one per line")
+	flag.StringVar(&excludeFrom, "exclude-from", "", "read -exclude patterns from a file, one per line")
+	flag.StringVar(&setRetention, "set-retention", "", "lock copied objects for this long, e.g. 30d or 12h. S3 destinations with object lock only")
+	flag.StringVar(&retentionMode, "retention-mode", retentionGovernance, "the object lock mode for -set-retention: governance or compliance")
+	flag.BoolVar(&legalHold, "set-legal-hold", false, "put a legal hold on copied objects. S3 destinations with object lock only")
+	flag.BoolVar(&preserveLock, "preserve-lock", false, "copy the object lock retention and legal hold of S3 source objects")
+	flag.Var(metadata, "metadata-filter", "only copy objects with this key=value in their metadata. may be repeated, objects must match all of them")
 	flag.Parse()
-	if len(flag.Args()) != 2 {
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	var cfg *config
+	if configPath != "" {
+		var err error
+		cfg, err = loadConfig(configPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := cfg.apply(flag.CommandLine, explicit); err != nil {
+			log.Fatal(err)
+		}
+	}
+	args := cfg.args(flag.Args())
+	if len(args) < 2 {
 		log.Fatal("src and dst arguments are required")
 	}
+	if len(args) > 2 && (bidirectional || verifyOnly || repairMode || detectDrift) {
+		log.Fatal("-bidirectional, -verify, -repair and -detect-drift only work with one destination")
+	}
+	if len(args) > 2 && (planOutPath != "" || applyPlanPath != "") {
+		log.Fatal("-plan-out and -apply-plan only work with one destination")
+	}
+	// plans are made by dry runs.
+	if planOutPath != "" {
+		dryRun = true
+	}
+	if repairMode && (verifyOnly || bidirectional || dryRun) {
+		log.Fatal("-repair can't be used with -verify, -bidirectional or -dry-run")
+	}
+	// -encrypt is both -encrypt-keys and -encrypt-content. Same for -decrypt.
+	passEncryptKeys = passEncryptKeys || passEncrypt
+	passEncryptContent = passEncryptContent || passEncrypt
+	passDecryptKeys = passDecryptKeys || passDecrypt
+	passDecryptContent = passDecryptContent || passDecrypt
+	passEncrypt = passEncryptKeys || passEncryptContent
+	passDecrypt = passDecryptKeys || passDecryptContent
+	if reencrypt && (passEncrypt || passDecrypt) {
+		log.Fatal("-reencrypt can't be used with -encrypt or -decrypt")
+	}
+	if decryptPassthrough && !(passDecryptKeys || reencrypt) {
+		log.Fatal("-decrypt-passthrough is for -decrypt, -decrypt-keys or -reencrypt")
+	}
+	// repairs are written straight from the source, always decrypted.
+	if decryptPassthrough && repairMode {
+		log.Fatal("-decrypt-passthrough can't be used with -repair")
+	}
+	if err := validCipher(sealCipher); err != nil {
+		log.Fatal(err)
+	}
+	// never delete a source object on the word of an existing destination copy.
+	verifymd5 = verifymd5 || distrustMD5 || move || immutable || compareMetadata
+	if immutable && (updateOnly || repairMode || bidirectional) {
+		log.Fatal("-immutable can't be used with -update-only, -repair or -bidirectional")
+	}
+	if move && (bidirectional || verifyOnly || repairMode) {
+		log.Fatal("-move can't be used with -bidirectional, -verify or -repair")
+	}
+	level, err := parseLogLevel(quiet, verbose)
+	if err != nil {
+		log.Fatal(err)
+	}
+	keyShard, err := parseShard(shardFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if keyShard.count > 1 && bidirectional {
+		log.Fatal("-shard can't be used with -bidirectional")
+	}
+	// the metadata of an encrypted object isn't the plain object's.
+	if compareMetadata && (passEncrypt || passDecrypt || reencrypt || bidirectional) {
+		log.Fatal("-compare-metadata can't be used with encryption or -bidirectional")
+	}
+	if completionMarker != "" && (bidirectional || verifyOnly || repairMode) {
+		log.Fatal("-completion-marker can't be used with -bidirectional, -verify or -repair")
+	}
+	if limit < 0 {
+		log.Fatal("-limit can't be negative")
+	}
+	if limit > 0 && (bidirectional || verifyOnly || repairMode) {
+		log.Fatal("-limit can't be used with -bidirectional, -verify or -repair")
+	}
+	if runDeadline < 0 {
+		log.Fatal("-deadline can't be negative")
+	}
+	if runDeadline > 0 && (bidirectional || verifyOnly || repairMode) {
+		log.Fatal("-deadline can't be used with -bidirectional, -verify or -repair")
+	}
+	if listRPS < 0 {
+		log.Fatal("-list-rps can't be negative")
+	}
+	if reportEvery < 0 {
+		log.Fatal("-report-every-n can't be negative")
+	}
+	if err := validListFormat(listFormat); err != nil {
+		log.Fatal(err)
+	}
+	// machine readable lists go to stdout on their own.
+	if !verbose && (verifyOnly || repairMode) && listFormat != listPlain {
+		level = logQuiet
+	}
+	logLevel = level
+	if err := validLowSpace(onLowSpace); err != nil {
+		log.Fatal(err)
+	}
+	checkpointEvery, checkpointDuration, err := parseCheckpointInterval(checkpointInterval)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if checkpointInterval != "" && statePath == "" {
+		log.Fatal("-checkpoint-interval needs a -state file to save")
+	}
+	if checkpointInterval != "" && bidirectional {
+		log.Fatal("-checkpoint-interval can't be used with -bidirectional")
+	}
+	if detectDrift && statePath == "" {
+		log.Fatal("-detect-drift needs a -state file to remember the destination in")
+	}
+	if genSafety && requireSafety {
+		log.Fatal("-gen-safety and -require-safety can't be used together")
+	}
+	if bidirectional && (passEncrypt || passDecrypt || reencrypt) {
+		log.Fatal("-bidirectional can't be used with -encrypt, -decrypt or -reencrypt")
+	}
+	if bidirectional && (normalizeKeys || validateKeys || sanitizeKeys) {
+		log.Fatal("-bidirectional can't be used with -normalize-keys, -validate-keys or -sanitize-keys")
+	}
+	if err := validConflict(onConflict); err != nil {
+		log.Fatal(err)
+	}
+	if err := validKeyHash(keyHash); err != nil {
+		log.Fatal(err)
+	}
+	if resumeRetries < 0 {
+		log.Fatal("-resume-retries can't be negative")
+	}
+	if maxKeyLength < 0 {
+		log.Fatal("-max-key-length can't be negative")
+	}
+	if keySource != "" && reencrypt {
+		log.Fatal("-key-source can't be used with -reencrypt, which needs both passwords")
+	}
+	if keySource != "" && !(passEncrypt || passDecrypt || encryptState) {
+		log.Fatal("-key-source is for the key of -encrypt, -decrypt or -encrypt-state")
+	}
+	if splitKeys && !(passEncrypt || passDecrypt || reencrypt) {
+		log.Fatal("-split-keys is for -encrypt, -decrypt or -reencrypt")
+	}
+	if oldSplitKeys && !reencrypt {
+		log.Fatal("-old-split-keys only applies to -reencrypt, use -split-keys otherwise")
+	}
+	if wrappedKey != "" && keySource == "" {
+		log.Fatal("-wrapped-key needs the -key-source to decrypt it with")
+	}
+	if otelEndpoint != "" {
+		if u, err := url.Parse(otelEndpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			log.Fatalf("-otel-endpoint %q isn't an http(s) url", otelEndpoint)
+		}
+	}
+	if inventoryPath != "" && (bidirectional || verifyOnly || repairMode || verifyAfter) {
+		log.Fatal("-inventory can't be used with -bidirectional, -verify, -repair or -verify-after")
+	}
+	if allVersions && (move || bidirectional || repairMode || inventoryPath != "") {
+		log.Fatal("-all-versions can't be used with -move, -bidirectional, -repair or -inventory")
+	}
+	if err := validSymlinks(symlinks); err != nil {
+		log.Fatal(err)
+	}
+	if err := validRetentionMode(retentionMode); err != nil {
+		log.Fatal(err)
+	}
+	retention, err := parseRetention(setRetention)
+	if err != nil {
+		log.Fatal(err)
+	}
+	purgeAge, err := parseRetention(purgeOlderThan)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := validHashAlgo(hashAlgo); err != nil {
+		log.Fatal(err)
+	}
+	var template *keyTemplate
+	if destTemplate != "" {
+		template, err = parseKeyTemplate(destTemplate)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	// the template is for object keys, and doesn't know what to do with directories.
+	if template != nil && (preserveDirs || bidirectional) {
+		log.Fatal("-key-template can't be used with -preserve-empty-prefixes or -bidirectional")
+	}
+	if consistencyRetries < 0 {
+		log.Fatal("-dest-consistency-retry can't be negative")
+	}
+	codec, err := parseCodec(compressCodecName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if codec == nil {
+		compress = false
+	}
+	if compress && bidirectional {
+		log.Fatal("-compress can't be used with -bidirectional")
+	}
+	if conditional && bidirectional {
+		log.Fatal("-conditional can't be used with -bidirectional")
+	}
+	if webhookURL != "" {
+		if u, err := url.Parse(webhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			log.Fatalf("-webhook %q isn't an http(s) url", webhookURL)
+		}
+		if bidirectional || verifyOnly || repairMode {
+			log.Fatal("-webhook can't be used with -bidirectional, -verify or -repair")
+		}
+	}
+	readRate, err := parseRate(downloadRate)
+	if err != nil {
+		log.Fatal("-download-rate: ", err)
+	}
+	writeRate, err := parseRate(uploadRate)
+	if err != nil {
+		log.Fatal("-upload-rate: ", err)
+	}
+	if purgeAge > 0 && (verifyOnly || repairMode || bidirectional) {
+		log.Fatal("-purge-older-than can't be used with -verify, -repair or -bidirectional")
+	}
+	// an encrypted marker name is just another object.
+	if preserveDirs && (passEncrypt || passDecrypt || reencrypt || bidirectional) {
+		log.Fatal("-preserve-empty-prefixes can't be used with encryption or -bidirectional")
+	}
+	if signURLs != "" && (bidirectional || verifyOnly || repairMode) {
+		log.Fatal("-sign-urls can't be used with -bidirectional, -verify or -repair")
+	}
+	if signExpiry <= 0 {
+		log.Fatal("-sign-urls-expiry must be positive")
+	}
+	if dryRun && (genSafety || bidirectional || verifyOnly) {
+		log.Fatal("-dry-run can't be used with -gen-safety, -bidirectional or -verify")
+	}
+	// a plan only has copies and metadata updates, not deletes.
+	if (planOutPath != "" || applyPlanPath != "") && (move || purgeAge > 0) {
+		log.Fatal("-plan-out and -apply-plan can't be used with -move or -purge-older-than")
+	}
+	var copyBytes byteRange
+	if rangeSpec != "" {
+		if objectKey == "" {
+			log.Fatal("-range needs -object, the object to copy part of")
+		}
+		copyBytes, err = parseByteRange(rangeSpec)
+		if err != nil {
+			log.Fatal("-range: ", err)
+		}
+		// the bytes are copied as they are, to the same key.
+		if passEncrypt || passDecrypt || reencrypt || compress || destTemplate != "" || keyHash != "" || normalizeKeys || move || bidirectional || verifyOnly || repairMode || verifyAfter || planOutPath != "" || applyPlanPath != "" {
+			log.Fatal("-range can't be used with encryption, -compress, -key-template, -key-hash, -normalize-keys, -move, -bidirectional, -verify, -repair, -verify-after, -plan-out or -apply-plan")
+		}
+	}
+	if objectKey != "" && (inventoryPath != "" || applyPlanPath != "" || allVersions) {
+		log.Fatal("-object can't be used with -inventory, -apply-plan or -all-versions")
+	}
+	if applyPlanPath != "" && (dryRun || repairMode || inventoryPath != "" || allVersions || skipN > 0) {
+		log.Fatal("-apply-plan can't be used with -dry-run, -plan-out, -repair, -inventory, -all-versions or -skip")
+	}
+	if verifyWorkers < 1 {
+		log.Fatal("-verify-workers must be positive")
+	}
+	// these leave source objects out of the copy on purpose, which verifying would report as missing.
+	if verifyAfter && (bidirectional || verifyOnly || repairMode || dryRun || move || updateOnly || skipN > 0 || limit > 0 || skipArchived || len(metadata) > 0) {
+		log.Fatal("-verify-after can't be used with -bidirectional, -verify, -repair, -dry-run, -move, -update-only, -skip, -limit, -skip-archived or -metadata-filter")
+	}
+	// the lock would stay on the temporary object, which then can't be deleted.
+	if atomicDest && (retention > 0 || legalHold || preserveLock) {
+		log.Fatal("-atomic-dest can't be used with object locks")
+	}
+	// a server side copy keeps the metadata of the object it copies, and drops locks and ACLs.
+	if dedupeContent && (storeOrigKey || keyHash != "" || retention > 0 || legalHold || preserveLock || aclPublic || len(grants) != 0 || bidirectional) {
+		log.Fatal("-dedupe-content can't be used with -store-origkey, -key-hash, object locks, -copy-acl-public, -grant-read or -bidirectional")
+	}
+	// a copy inside the destination makes a new file, with the default mode.
+	if preserveMode && (atomicDest || dedupeContent) {
+		log.Fatal("-preserve-mode can't be used with -atomic-dest or -dedupe-content")
+	}
+	// the server side copy into place would drop the ACL.
+	if atomicDest && (aclPublic || len(grants) != 0) {
+		log.Fatal("-atomic-dest can't be used with -copy-acl-public or -grant-read")
+	}
+	// S3 takes a canned ACL or grants, not both.
+	if aclPublic && len(grants) != 0 {
+		log.Fatal("-copy-acl-public can't be used with -grant-read")
+	}
+	// so would the copies these make, for the storage class.
+	expiresIn, err := parseRetention(expires)
+	if err != nil {
+		log.Fatal("-expires: ", err)
+	}
+	// a server side copy keeps the expiry of the object it copies, and updating the
+	// metadata in place drops it.
+	if expiresIn > 0 && (dedupeContent || compareMetadata) {
+		log.Fatal("-expires can't be used with -dedupe-content or -compare-metadata")
+	}
+	if tier != "" && (atomicDest || dedupeContent || compareMetadata) {
+		log.Fatal("-tier can't be used with -atomic-dest, -dedupe-content or -compare-metadata")
+	}
+	sseOpt := sse{algo: sseAlgo, keyID: sseKeyID}
+	if (!sseOpt.empty() || preserveSSE) && (atomicDest || dedupeContent || compareMetadata) {
+		log.Fatal("-sse, -sse-kms-key-id and -preserve-sse can't be used with -atomic-dest, -dedupe-content or -compare-metadata")
+	}
+	// S3 etags aren't md5s for objects encrypted with KMS keys, so they're recorded instead.
+	skipListedMD5 := sseOpt.hidesMD5() || preserveSSE
+	if skipListedMD5 && !storeMD5 {
+		logf(logVerbose, "storing md5s in metadata, S3 doesn't keep them for objects encrypted with KMS keys\n")
+		storeMD5 = true
+	}
+	contentTypes, err := parseContentTypeMap(contentTypeMapping)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if includeFrom != "" {
+		if err := filter.include.load(includeFrom); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if excludeFrom != "" {
+		if err := filter.exclude.load(excludeFrom); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if contentTypeMapFile != "" {
+		if err := loadContentTypeMap(contentTypeMapFile, contentTypes); err != nil {
+			log.Fatal(err)
+		}
+	}
 	var bytesAuth []byte
 	var bytesEncrypt []byte
 	var bytesDecrypt []byte
-	if passEncrypt || passDecrypt {
-		if useTmp == "" {
-			useTmp = "mem://"
+	var nameEncrypt []byte
+	var nameDecrypt []byte
+	if reencrypt {
+		var err error
+		bytesAuth, err = readAuthentication(passwordEnv, "old encryption password")
+		if err != nil {
+			os.Exit(1)
 		}
+	} else if keySource != "" {
+		var err error
+		bytesAuth, err = loadKeySource(context.Background(), keySource, wrappedKey)
+		if err != nil {
+			log.Fatal(err)
+		}
+	} else if passEncrypt || passDecrypt || encryptState {
 		var err error
 		bytesAuth, err = getAuthentication()
 		if err != nil {
 			os.Exit(1)
 		}
 	}
+	nameAuth, contentAuth := splitKey(bytesAuth, splitKeys && !reencrypt)
+	if passEncryptContent {
+		bytesEncrypt = contentAuth
+	}
+	if passDecryptContent {
+		bytesDecrypt = contentAuth
+	}
+	if passEncryptKeys {
+		nameEncrypt = nameAuth
+	}
+	if passDecryptKeys {
+		nameDecrypt = nameAuth
+	}
+	// the safety file is named and encrypted with whatever we encrypt content with.
+	var safetyKey []byte
 	if passEncrypt {
-		bytesEncrypt = bytesAuth
+		safetyKey = contentAuth
+	}
+	if reencrypt {
+		newAuth, err := readAuthentication(newPasswordEnv, "new encryption password")
+		if err != nil {
+			os.Exit(1)
+		}
+		nameDecrypt, bytesDecrypt = splitKey(bytesAuth, oldSplitKeys)
+		nameEncrypt, bytesEncrypt = splitKey(newAuth, splitKeys)
+		safetyKey = bytesEncrypt
 	}
-	if passDecrypt {
-		bytesDecrypt = bytesAuth
+	if deepSafety && safetyKey == nil {
+		log.Fatal("-safety-deep needs something to decrypt, use it with -encrypt or -reencrypt")
 	}
 
-	src := flag.Arg(0)
-	dst := flag.Arg(1)
+	if args[0] == "list" {
+		if len(args) != 2 {
+			log.Fatal("list takes one bucket")
+		}
+		if len(nameDecrypt) == 0 {
+			log.Fatal("list needs -decrypt or -decrypt-keys to decrypt the names with")
+		}
+		ctx := context.Background()
+		bkt, err := cloud.open(ctx, args[1])
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer bkt.Close()
+		warn := func(err error) { errLogger.Println("warning:", err) }
+		if _, err := listDecrypted(ctx, bkt, nameDecrypt, os.Stdout, warn); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	src := args[0]
+	dsts := args[1:]
+	if move && isHTTPURL(src) {
+		log.Fatalf("-move: %v", ErrReadOnly)
+	}
+	if err := cloud.check(args); err != nil {
+		log.Fatal(err)
+	}
+	keysChange := len(nameEncrypt) != 0 || len(nameDecrypt) != 0 || normalizeKeys
+	for _, dst := range dsts {
+		if err := checkSelfCopy(src, dst, keysChange, allowSelf); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var st *state
+	if statePath != "" {
+		var err error
+		st, err = loadState(statePath, bytesAuth)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	var stateKey []byte
+	if encryptState {
+		stateKey = bytesAuth
+	}
 
 	start := time.Now()
 	ctx := context.Background()
-	sbkt, err := blob.OpenBucket(ctx, src)
+	var sbkt *blob.Bucket
+	if isHTTPURL(src) {
+		sbkt, err = openHTTPBucket(src, manifest)
+	} else {
+		sbkt, err = cloud.open(ctx, src)
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer sbkt.Close()
-
-	dbkt, err := blob.OpenBucket(ctx, dst)
-	if err != nil {
-		log.Fatal(err)
+	if allVersions {
+		sbkt, err = openVersionSource(sbkt, src)
+		if err != nil {
+			log.Fatalf("%s: %v", src, err)
+		}
+		defer sbkt.Close()
+	}
+	if preserveMode && !modeSupported(sbkt) {
+		errLogger.Println("not preserving file modes:", ErrModeUnsupported)
+		preserveMode = false
 	}
-	defer dbkt.Close()
-	if useSafety {
-		pass, err := safetyCheck(ctx, dbkt, bytesEncrypt)
+	if failIfEmpty {
+		empty, err := bucketEmpty(ctx, sbkt)
 		if err != nil {
 			log.Fatal(err)
 		}
-		if !pass {
-			log.Printf("safety check failed.")
-			if !genSafety {
-				log.Printf("use --gen-safety to generate a safety check with this password.")
-				os.Exit(1)
+		if empty {
+			log.Fatalf("%s: %v", src, ErrEmptySource)
+		}
+	}
+
+	var dbkts []Bucket
+	for _, dst := range dsts {
+		// on by default for local destinations. only an error if it was asked for.
+		if _, local := localDir(dst); mkdir && (local || explicit["mkdir-dest"]) {
+			if err := mkdirDest(dst); err != nil {
+				log.Fatalf("%s: %v", dst, err)
+			}
+		}
+		dbkt, err := cloud.open(ctx, dst)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer dbkt.Close()
+		// asking for a lock on a destination that can't have one is a mistake.
+		// finding locks on the source to preserve is not, so that's only a warning.
+		if (retention > 0 || legalHold) && !lockSupported(dbkt) {
+			log.Fatalf("%s: %v", dst, ErrLockUnsupported)
+		}
+		if aclPublic && !aclSupported(dbkt) {
+			log.Fatalf("%s: %v", dst, ErrACLUnsupported)
+		}
+		if len(grants) != 0 && !grantSupported(dbkt) {
+			log.Fatalf("%s: %v", dst, ErrGrantUnsupported)
+		}
+		if !sseOpt.empty() {
+			if err := validSSE(dbkt, sseOpt); err != nil {
+				log.Fatalf("%s: %v", dst, err)
 			}
-			log.Printf("generating safety check.")
-			err = enableSafetyCheck(ctx, dbkt, bytesEncrypt)
+		}
+		if expiresIn > 0 && !expirySupported(dbkt) {
+			errLogger.Printf("warning: %s: %v, objects copied there won't expire\n", dst, ErrExpiryUnsupported)
+		}
+		if tier != "" {
+			if err := validTier(dbkt, tier); err != nil {
+				log.Fatalf("%s: %v", dst, err)
+			}
+		}
+		if preserveMode && !modeSupported(dbkt) {
+			errLogger.Println("not preserving file modes:", ErrModeUnsupported)
+			preserveMode = false
+		}
+		if preserveLock && !lockSupported(dbkt) {
+			errLogger.Println("not preserving object locks:", ErrLockUnsupported)
+			preserveLock = false
+		}
+		if useSafety || genSafety || requireSafety || deepSafety {
+			err := runSafetyCheck(ctx, dbkt, safetyKey, genSafety, requireSafety)
 			if err != nil {
 				log.Fatal(err)
 			}
 		}
+		if deepSafety {
+			// hashed names can't be decrypted, only their content can.
+			var nameKey []byte
+			if keyHash == "" {
+				nameKey = nameEncrypt
+			}
+			err := deepSafetyCheck(ctx, dbkt, safetyKey, nameKey, len(bytesEncrypt) != 0)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		dbkts = append(dbkts, dbkt)
+	}
+	// the single destination, for the modes that only take one.
+	dbkt := dbkts[0]
+	// before the drift check, which hashed the destination as it was without the marker.
+	if completionMarker != "" && !dryRun {
+		if err := clearMarker(ctx, dbkts, completionMarker); err != nil {
+			log.Fatalf("error removing the old completion marker: %v", err)
+		}
+	}
+	if detectDrift {
+		err := checkDrift(ctx, dbkt, st, acceptDrift)
+		switch {
+		case errors.Is(err, ErrDrift) && !strict:
+			errLogger.Println("warning:", err, "use -accept-drift if that's expected")
+		case err != nil:
+			log.Fatal(err)
+		}
 	}
 
-	var tmpBkt *blob.Bucket
+	// an interface holding a nil Bucket isn't nil, so it's only set once opened.
+	var tmpBkt Bucket
 	if useTmp != "" {
-		tmpBkt, err = blob.OpenBucket(ctx, useTmp)
+		bkt, err := cloud.open(ctx, useTmp)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer bkt.Close()
+		tmpBkt = bkt
 	}
-	if err != nil {
-		log.Fatal(err)
+	// the temporary bucket is left out, it's local to this side of the link.
+	if readRate > 0 {
+		readLimits[sbkt] = newRateLimiter(readRate)
+	}
+	if writeRate > 0 {
+		// one limit shared by every destination, they go over the same uplink.
+		l := newRateLimiter(writeRate)
+		for _, dbkt := range dbkts {
+			writeLimits[dbkt] = l
+		}
 	}
 
-	errs := make(chan error)
-	errsN := 0
-	stopErrs := make(chan bool)
-	errsStopped := make(chan bool)
-	go func() {
-		for {
-			select {
-			case err := <-errs:
-				errLogger.Println(err)
-				errsN++
-			case <-stopErrs:
-				close(errsStopped)
-				return
+	if rangeSpec != "" {
+		for i, dbkt := range dbkts {
+			if dryRun {
+				logger.Printf("dry run: would copy bytes %s of %s to %s\n", copyBytes, objectKey, dsts[i])
+				continue
+			}
+			n, err := copyRange(ctx, sbkt, dbkt, objectKey, copyBytes)
+			if err != nil {
+				log.Fatalf("error copying bytes %s of %s to %s: %v", copyBytes, objectKey, dsts[i], err)
+			}
+			logger.Printf("copied bytes %s of %s to %s, %d bytes. duration: %v\n", copyBytes, objectKey, dsts[i], n, time.Since(start))
+		}
+		if notifyOnChange && !dryRun {
+			os.Exit(changeExitCode(len(dbkts), 0))
+		}
+		return
+	}
+
+	// one goroutine keeps track of the run: errors and copied objects.
+	// mirror sends synchronously, so once it returns everything has been received.
+	errs := make(chan error)
+	progress := make(chan progressEvent)
+	errsN := 0
+	errCodes := make(map[gcerrors.ErrorCode]int)
+	// repeats of an error are counted rather than printed, unless -verbose.
+	coalesced := newErrorCoalescer()
+	copiedBytes := int64(0)
+	srcBytes := int64(0)
+	stopErrs := make(chan bool)
+	errsStopped := make(chan bool)
+	go func() {
+		for {
+			select {
+			case err := <-errs:
+				if coalesced.add(err) || logLevel >= logVerbose {
+					errLogger.Println(err)
+				}
+				errsN++
+				errCodes[gcerrors.Code(err)]++
+			case ev := <-progress:
+				copiedBytes += ev.bytes
+				srcBytes += ev.srcBytes
+			case <-stopErrs:
+				close(errsStopped)
+				return
+			}
+		}
+	}()
+
+	opts := mirrorOpts{
+		bytesEncrypt:         bytesEncrypt,
+		bytesDecrypt:         bytesDecrypt,
+		nameEncrypt:          nameEncrypt,
+		nameDecrypt:          nameDecrypt,
+		skipN:                skipN,
+		verifymd5:            verifymd5,
+		symlinks:             symlinks,
+		sendContentMD5:       sendContentMD5,
+		updateOnly:           updateOnly,
+		state:                st,
+		multipartParts:       multipartParts,
+		resumeRetries:        resumeRetries,
+		contentTypes:         contentTypes,
+		storeOrigKey:         storeOrigKey,
+		storeMD5:             storeMD5,
+		keyHash:              keyHash,
+		normalizeKeys:        normalizeKeys,
+		onCollision:          onConflict,
+		metadata:             metadata,
+		filter:               &filter,
+		progress:             progress,
+		retention:            retention,
+		retentionMode:        retentionMode,
+		legalHold:            legalHold,
+		preserveLock:         preserveLock,
+		distrustMD5:          distrustMD5,
+		rehashMultipart:      rehashMultipart,
+		atomicDest:           atomicDest,
+		dryRun:               dryRun,
+		decryptPassthrough:   decryptPassthrough,
+		prelistDest:          prelist,
+		preserveDirs:         preserveDirs,
+		listRPS:              listRPS,
+		shard:                keyShard,
+		publicRead:           aclPublic,
+		grants:               grants,
+		tier:                 tier,
+		expires:              expiresIn,
+		sse:                  sseOpt,
+		preserveSSE:          preserveSSE,
+		skipListedMD5:        skipListedMD5,
+		hashAlgo:             hashAlgo,
+		compress:             compress,
+		codec:                codec,
+		consistency:          consistencyRetry{retries: consistencyRetries, delay: consistencyRetryDelay},
+		keyTemplate:          template,
+		conditional:          conditional,
+		skipArchived:         skipArchived,
+		cacheControl:         cacheControl,
+		move:                 move,
+		dedupeContent:        dedupeContent,
+		sanitizeKeys:         sanitizeKeys,
+		preserveMode:         preserveMode,
+		immutable:            immutable,
+		compareMetadata:      compareMetadata,
+		normalizeContentType: normalizeContentTypeCase,
+		limit:                limit,
+		verifyWorkers:        verifyWorkers,
+	}
+	if runDeadline > 0 {
+		var cancel context.CancelFunc
+		opts.deadline, cancel = newDeadline(runDeadline)
+		defer cancel()
+	}
+	opts.spaceGuard = newSpaceGuard(dsts, dbkts, onLowSpace)
+	if resumeRetries > 0 {
+		opts.partUploaders = make(map[Bucket]partUploader)
+		for i, dst := range dsts {
+			up, err := newPartUploader(dbkts[i], dst)
+			switch {
+			case errors.Is(err, ErrResumeUnsupported):
+				logf(logVerbose, "%s: failed uploads start over, %v\n", dst, err)
+			case err != nil:
+				log.Fatalf("%s: %v", dst, err)
+			default:
+				opts.partUploaders[dbkts[i]] = up
+			}
+		}
+	}
+	// a dry run never saves the state.
+	if st != nil && !dryRun && (checkpointEvery > 0 || checkpointDuration > 0) {
+		opts.checkpoint = newCheckpoint(statePath, stateKey, st, checkpointEvery, checkpointDuration)
+	}
+	if validateKeys || sanitizeKeys {
+		opts.keyRules = keyRulesFor(dsts)
+	}
+	opts.maxKeyLength = maxKeyLength
+	if opts.maxKeyLength == 0 {
+		opts.maxKeyLength = maxKeyLengthFor(dsts)
+	}
+	if dedupeList {
+		opts.seen = newKeySet()
+	}
+	if signURLs != "" {
+		f, err := os.Create(signURLs)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer func() {
+			if err := f.Close(); err != nil {
+				errLogger.Println("error writing signed URLs:", err)
+			}
+		}()
+		opts.signer = newURLSigner(f, signExpiry)
+	}
+	if webhookURL != "" {
+		opts.webhook = newWebhook(webhookURL)
+	}
+	if inventoryPath != "" {
+		opts.inventory, err = loadInventory(inventoryPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if objectKey != "" {
+		opts.inventory = objectInventory(objectKey)
+	}
+	if planOutPath != "" {
+		opts.planOut = &plan{Source: src, Destination: dsts[0], Created: start}
+	}
+	// nothing is copied unless everything is as it was when the plan was made.
+	if applyPlanPath != "" {
+		p, err := loadPlan(applyPlanPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if p.Source != src || p.Destination != dsts[0] {
+			log.Fatalf("%s is a plan for copying %s to %s", applyPlanPath, p.Source, p.Destination)
+		}
+		drift, err := p.check(ctx, sbkt, dbkt)
+		if err != nil {
+			log.Fatalf("error checking the plan: %v", err)
+		}
+		for _, d := range drift {
+			errLogger.Printf("%s %s\n", d.Key, d.Reason)
+		}
+		if len(drift) > 0 {
+			log.Fatalf("%d objects %v, not applying %s", len(drift), ErrPlanDrift, applyPlanPath)
+		}
+		opts.applyPlan = p
+	}
+	// sends what's left of the traces and metrics. it's called before exiting too, where defers don't run.
+	flushTelemetry := func() {}
+	if otelEndpoint != "" {
+		shutdown, err := setupTelemetry(ctx, otelEndpoint)
+		if err != nil {
+			log.Fatal(err)
+		}
+		var once sync.Once
+		flushTelemetry = func() {
+			once.Do(func() {
+				ctx, cancel := context.WithTimeout(context.Background(), telemetryFlushTimeout)
+				defer cancel()
+				if err := shutdown(ctx); err != nil {
+					errLogger.Println("error sending telemetry:", err)
+				}
+			})
+		}
+		defer flushTelemetry()
+	}
+
+	if repairMode {
+		report := repair(ctx, sbkt, dbkt, opts, errs)
+		close(stopErrs)
+		<-errsStopped
+		summary := logger
+		if listFormat != listPlain {
+			if err := writeReport(os.Stdout, report, listFormat); err != nil {
+				errLogger.Println("error writing report:", err)
+			}
+			summary = errLogger
+		}
+		summary.Printf("checked %d objects. repaired %d missing, %d that differed. %d errors. duration: %v\n", report.checked, len(report.missing), len(report.differ), errsN, time.Since(start))
+		coalesced.report(errLogger)
+		if report.problems > 0 {
+			flushTelemetry()
+			os.Exit(1)
+		}
+		if code := changeExitCode(len(report.missing)+len(report.differ), 0); notifyOnChange && code != 0 {
+			flushTelemetry()
+			os.Exit(code)
+		}
+		return
+	}
+	if verifyOnly {
+		report := verify(ctx, sbkt, dbkt, opts, errs)
+		close(stopErrs)
+		<-errsStopped
+		summary := logger
+		if listFormat != listPlain {
+			if err := writeReport(os.Stdout, report, listFormat); err != nil {
+				errLogger.Println("error writing report:", err)
+			}
+			summary = errLogger
+		}
+		summary.Printf("verified %d objects. %d missing, %d only in destination, %d differ. %d errors. duration: %v\n", report.checked, len(report.missing), len(report.extra), len(report.differ), errsN, time.Since(start))
+		coalesced.report(errLogger)
+		if !report.ok() {
+			flushTelemetry()
+			os.Exit(1)
+		}
+		return
+	}
+
+	var n int
+	if bidirectional {
+		res := syncBuckets(ctx, sbkt, dbkt, st, onConflict, errs, progress)
+		n = res.toDst + res.toSrc
+		logger.Printf("synced %d objects to destination, %d objects to source. %d conflicts.\n", res.toDst, res.toSrc, res.conflicts)
+	} else {
+		// with a state file, a signal stops the copy so it's saved. after the
+		// copy, signals kill the process as usual.
+		stop := func() {}
+		if st != nil {
+			opts.interrupt, stop = stopOnSignal()
+		}
+		n = mirrorMany(ctx, sbkt, dbkts, tmpBkt, opts, errs)
+		stop()
+	}
+	if opts.webhook != nil {
+		if err := opts.webhook.close(); err != nil {
+			errLogger.Println("warning: webhook:", err)
+		}
+	}
+	if root, ok := localDir(src); ok && preserveDirs {
+		dirs, err := emptyDirs(root)
+		if err != nil {
+			errs <- err
+		}
+		for _, dir := range dirs {
+			if !filter.match(dir) {
+				continue
+			}
+			m, err := copyMarker(ctx, dbkts, opts.plainName(dir), dryRun)
+			if err != nil {
+				errs <- withKeys(fmt.Errorf("error copying directory marker %s: %w", dir, err), dir)
+			}
+			if m > 0 {
+				n++
+				logCopied(n, "copied empty directory %s\n", dir)
+			}
+		}
+	}
+	// an independent pass over everything, now that the copy is done. a run that stopped
+	// early is missing objects anyway, so it isn't verified.
+	var verified []verifyReport
+	verifyFailed := false
+	if verifyAfter && !(opts.deadline != nil && opts.deadline.reached) && !(opts.interrupt != nil && opts.interrupt.reached) {
+		for i, dbkt := range dbkts {
+			logf(logNormal, "verifying %s\n", dsts[i])
+			report := verify(ctx, sbkt, dbkt, opts, errs)
+			verified = append(verified, report)
+			verifyFailed = verifyFailed || !report.ok()
+		}
+	}
+	purged := 0
+	if purgeAge > 0 {
+		// anything copied in this run is newer than the start, and stays.
+		cutoff := start.Add(-purgeAge)
+		keep := make(map[string]bool)
+		if safetyKey != nil {
+			_, encKeyName, err := safetyName(safetyKey)
+			if err != nil {
+				log.Fatal(err)
+			}
+			keep[encKeyName] = true
+		}
+		keep[dedupeIndexKey] = true
+		if completionMarker != "" {
+			keep[completionMarker] = true
+		}
+		for i, dbkt := range dbkts {
+			p, err := purgeOlder(ctx, dbkt, cutoff, keep, purgeAllowAll, dryRun, errs)
+			if err != nil {
+				errs <- fmt.Errorf("%s: %w", dsts[i], err)
+			}
+			purged += p
+		}
+	}
+	close(stopErrs)
+	<-errsStopped
+	// a run that ran out of time says so, so it isn't mistaken for a complete copy.
+	stopped := ""
+	if opts.deadline != nil && opts.deadline.reached {
+		stopped = fmt.Sprintf("deadline of %v reached, stopped early. ", runDeadline)
+	}
+	if opts.interrupt != nil && opts.interrupt.reached {
+		stopped = "interrupted, stopped early. "
+	}
+	if dryRun {
+		if opts.planOut != nil {
+			if err := opts.planOut.save(planOutPath); err != nil {
+				log.Fatalf("error writing the plan: %v", err)
+			}
+			logger.Printf("wrote a plan of %d actions to %s\n", len(opts.planOut.Actions), planOutPath)
+		}
+		logger.Printf("%sdry run: would copy %d objects, purge %d. %d errors. duration: %v\n", stopped, n, purged, errsN, time.Since(start))
+		return
+	}
+	if detectDrift {
+		hash, err := manifestHash(ctx, dbkt)
+		if err != nil {
+			errLogger.Println("error hashing destination:", err)
+		}
+		st.DestHash = hash
+	}
+	if st != nil {
+		if err := saveState(statePath, st, stateKey); err != nil {
+			errLogger.Println("error saving state:", err)
+		}
+	}
+	if completionMarker != "" {
+		info := completionInfo{Source: src, Objects: n, Bytes: copiedBytes, Started: start, Finished: time.Now()}
+		written, err := markComplete(ctx, dbkts, completionMarker, info, errsN > 0 || stopped != "" || verifyFailed)
+		switch {
+		case err != nil:
+			errLogger.Println("error writing the completion marker:", err)
+			errsN++
+		case !written:
+			errLogger.Println("not writing the completion marker, the run didn't finish cleanly")
+		}
+	}
+	transferred := fmt.Sprintf("%d bytes", copiedBytes)
+	if srcBytes != copiedBytes {
+		transferred = fmt.Sprintf("%d bytes written, %d read", copiedBytes, srcBytes)
+	}
+	logger.Printf("%scopied %d objects, %s. %d errors. duration: %v\n", stopped, n, transferred, errsN, time.Since(start))
+	if purgeAge > 0 {
+		logger.Printf("purged %d objects older than %v\n", purged, purgeAge)
+	}
+	if errsN > 0 {
+		logger.Printf("errors by type: %s\n", errorBreakdown(errCodes))
+		coalesced.report(errLogger)
+	}
+	if p := opts.applyPlan; p != nil {
+		skipped := p.unapplied()
+		for _, e := range skipped {
+			errLogger.Printf("planned to %s %s [%s], not done\n", e.Action, e.Key, e.DstKey)
+		}
+		logger.Printf("plan of %d actions: %d done, %d not done\n", len(p.Actions), len(p.Actions)-len(skipped), len(skipped))
+	}
+	if objectKey != "" && len(opts.inventory.missing) > 0 {
+		errLogger.Printf("%s isn't in the source\n", objectKey)
+		flushTelemetry()
+		os.Exit(1)
+	}
+	if inv := opts.inventory; inv != nil && objectKey == "" {
+		for _, m := range inv.changed {
+			errLogger.Printf("%s changed since the inventory: expected %s, found %s\n", m.Key, m.Expected, m.Found)
+		}
+		for _, key := range inv.missing {
+			errLogger.Printf("%s is in the inventory but not in the source\n", key)
+		}
+		logger.Printf("inventory of %d objects: %d changed since, %d missing\n", len(inv.entries), len(inv.changed), len(inv.missing))
+	}
+	for i, report := range verified {
+		if report.ok() {
+			logger.Printf("verification of %s passed: %d objects\n", dsts[i], report.checked)
+			continue
+		}
+		for _, d := range append(report.missing, report.differ...) {
+			errLogger.Printf("failed verification: %s [%s] %s\n", d.Key, d.DstKey, d.Reason)
+		}
+		errLogger.Printf("verification of %s failed: checked %d objects. %d missing, %d differ, %d couldn't be checked\n", dsts[i], report.checked, len(report.missing), len(report.differ), report.problems)
+	}
+	if verifyFailed {
+		flushTelemetry()
+		os.Exit(1)
+	}
+	if code := changeExitCode(n, purged); notifyOnChange && code != 0 {
+		flushTelemetry()
+		os.Exit(code)
+	}
+}
+
+// with -notify-on-change, the exit status of a run that didn't fail says whether it changed anything.
+const exitChanged = 10
+
+// the exit status with -notify-on-change of a run that copied and deleted this many objects.
+func changeExitCode(copied, deleted int) int {
+	if copied > 0 || deleted > 0 {
+		return exitChanged
+	}
+	return 0
+}
+
+// logs a per-object message if the log level allows it.
+func logf(level int, format string, v ...interface{}) {
+	if logLevel < level {
+		return
+	}
+	logger.Printf(format, v...)
+}
+
+// logs a line about the nth copied object. With -report-every-n, that's
+// only one in every reportEvery of them; otherwise it's the same as logf at logNormal.
+func logCopied(n int, format string, v ...interface{}) {
+	if reportEvery > 1 && n%reportEvery != 0 {
+		return
+	}
+	logf(logNormal, format, v...)
+}
+
+// formats error counts per gcerrors code, most frequent first.
+// e.g. "NotFound: 3, PermissionDenied: 1"
+func errorBreakdown(codes map[gcerrors.ErrorCode]int) string {
+	keys := make([]gcerrors.ErrorCode, 0, len(codes))
+	for code := range codes {
+		keys = append(keys, code)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if codes[keys[i]] != codes[keys[j]] {
+			return codes[keys[i]] > codes[keys[j]]
+		}
+		return keys[i].String() < keys[j].String()
+	})
+	parts := make([]string, len(keys))
+	for i, code := range keys {
+		parts[i] = fmt.Sprintf("%s: %d", code, codes[code])
+	}
+	return strings.Join(parts, ", ")
+}
+
+// options that change how mirror copies objects.
+type mirrorOpts struct {
+	// keys to encrypt and decrypt the content with.
+	bytesEncrypt []byte
+	bytesDecrypt []byte
+	// keys to encrypt and decrypt key names with.
+	nameEncrypt []byte
+	nameDecrypt []byte
+	skipN       int
+	verifymd5   bool
+	// one of symlinksFollow, symlinksSkip or symlinksError.
+	// only has an effect on local (fileblob) sources.
+	symlinks string
+	// send the source md5 along with the upload so the destination
+	// rejects a corrupted write. S3 and GCS check it server side.
+	sendContentMD5 bool
+	// only overwrite objects that already exist in the destination.
+	updateOnly bool
+	// objects recorded in the state are skipped, and copied objects are recorded.
+	// may be nil.
+	state *state
+	// copy big objects as this many concurrent ranges.
+	multipartParts int
+	// tries to upload a big object again after a failure, resuming with the
+	// part uploaders of the destinations that have them.
+	resumeRetries int
+	partUploaders map[Bucket]partUploader
+	// overrides the content type of copied objects by extension. may be nil.
+	contentTypes contentTypeMap
+	// when encrypting, keep the encrypted original key in the object's metadata.
+	storeOrigKey bool
+	// store the md5 of each copied object in its metadata, see md5Meta.
+	storeMD5 bool
+	// when encrypting, name objects by this hash of the encrypted key. implies storeOrigKey.
+	// otherwise only keys over maxKeyLength are hashed.
+	keyHash string
+	// keys listed so far. a key listed twice is only copied once. may be nil.
+	seen *keySet
+	// clean up source key paths before they're used for the destination.
+	normalizeKeys bool
+	// a conflict policy for source keys that normalize to the same key.
+	onCollision string
+	// only objects with all of this metadata are copied. may be nil.
+	metadata metadataFilter
+	// only keys that match the filter are copied. may be nil.
+	filter *keyFilter
+	// gets an event for every object copied to the destination. may be nil.
+	progress chan progressEvent
+	// S3 object lock for copied objects: retain for this long in retentionMode,
+	// and/or put a legal hold on them. preserveLock copies the source's lock.
+	retention     time.Duration
+	retentionMode string
+	legalHold     bool
+	preserveLock  bool
+	// compute md5s by reading both sides, rather than trusting what the provider reports.
+	distrustMD5 bool
+	// read objects with multipart etags to get their md5s, see multipartMD5.
+	rehashMultipart bool
+	// write each object under a temporary key, and move it into place once it's complete.
+	atomicDest bool
+	// only log what would be copied.
+	dryRun bool
+	// copy objects whose names don't decrypt as they are, see objectName.
+	decryptPassthrough bool
+	// with dryRun, gets what would be done. may be nil.
+	planOut *plan
+	// the objects to copy, and where, take from this plan when set. main has checked nothing changed since.
+	applyPlan *plan
+	// list each destination once up front, rather than asking about every object.
+	prelistDest bool
+	// copy directory markers in the dir/ form, see markerDir.
+	preserveDirs bool
+	// signs a download URL for each copied object when set.
+	signer *urlSigner
+	// gets an event for every object copied, skipped or failed. may be nil.
+	webhook *webhook
+	// the objects to copy, rather than everything in the source. may be nil.
+	inventory *inventory
+	// names this run's objects in the temporary bucket. one is made up if it's "".
+	tmpNonce string
+	// at most this many source list pages a second. 0 is no limit.
+	listRPS float64
+	// only copy the source keys in this shard.
+	shard shard
+	// make copied objects readable by anyone.
+	publicRead bool
+	// S3 accounts that get read access to copied objects.
+	grants grantList
+	// the -tier objects are written in. "" is the bucket's default.
+	tier string
+	// copied objects expire this long after they're written. 0 is never.
+	expires time.Duration
+	// the server-side encryption objects are written with. empty is the bucket's default.
+	sse         sse
+	preserveSSE bool
+	// destination listings have md5s that may not be, so the destination is asked.
+	skipListedMD5 bool
+	// what verify compares, and the skip comparison when both sides report it. "" is md5.
+	hashAlgo string
+	// compress content on the way to the destination, see opts.transforms.
+	compress bool
+	// what to compress it with. nil is gzip, see opts.compressCodec.
+	codec *compressCodec
+	// retries for destination objects that should be there but aren't visible yet.
+	consistency consistencyRetry
+	// reorganizes destination keys. may be nil.
+	keyTemplate *keyTemplate
+	// load objects into the temporary bucket only if they changed since they were copied.
+	conditional bool
+	// skip source objects that are archived, rather than failing on them.
+	skipArchived bool
+	// the Cache-Control header of every copied object, when set.
+	cacheControl string
+	// when set, destination keys are checked against the rules of the destination providers.
+	// a key that breaks them is skipped, or fixed with sanitizeKeys.
+	keyRules keyRules
+	// destination keys longer than this are skipped up front, or hashed with keyHash. 0 is no limit.
+	maxKeyLength int
+	sanitizeKeys bool
+	// copy the source's content type and metadata, and bring the destination's
+	// up to date where only those differ.
+	compareMetadata bool
+	// write content types in their canonical lowercase form, and compare them that way.
+	normalizeContentType bool
+	// report existing destination objects that differ as errors, rather than overwriting them.
+	// only set together with verifymd5.
+	immutable bool
+	// stop after copying this many objects. 0 is no limit.
+	limit int
+	// how many objects verify checks at once. 0 is defaultVerifyWorkers.
+	verifyWorkers int
+	// stop starting new objects once this runs out. may be nil.
+	deadline *deadline
+	// like the deadline, but for a signal. may be nil.
+	interrupt *deadline
+	// saves the state between objects. may be nil.
+	checkpoint *checkpoint
+	// checks local destinations have room for each object. may be nil.
+	spaceGuard *spaceGuard
+	// give local destination files the permission bits of local source files.
+	preserveMode bool
+	// don't upload content a destination already has under another key, see dedupeIndex.
+	dedupeContent bool
+	// delete each source object once every destination has it, see moveSource.
+	// only set together with verifymd5.
+	move bool
+}
+
+// an object copied to the destination. bytes is what was written, which
+// encryption makes bigger than the srcBytes that were read.
+type progressEvent struct {
+	key      string
+	bytes    int64
+	srcBytes int64
+}
+
+// describes the size of a copy. Both sizes are given when a transform changed it.
+func sizeString(written, src int64) string {
+	if written == src {
+		return fmt.Sprintf("size %d", written)
+	}
+	return fmt.Sprintf("size %d, %d in the source", written, src)
+}
+
+// the name a source key is stored under, before any key name encryption.
+func (opts mirrorOpts) plainName(key string) string {
+	if opts.normalizeKeys {
+		return normalizeKey(key)
+	}
+	return key
+}
+
+// a set of keys that is safe for concurrent use.
+type keySet struct {
+	mu   sync.Mutex
+	keys map[string]struct{}
+}
+
+func newKeySet() *keySet {
+	return &keySet{keys: make(map[string]struct{})}
+}
+
+// adds key to the set, and reports whether it was new.
+func (s *keySet) add(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.keys[key]; ok {
+		return false
+	}
+	s.keys[key] = struct{}{}
+	return true
+}
+
+// copies all objects from src to dst.
+func mirror(ctx context.Context, sbkt, dbkt, tmpBkt Bucket, opts mirrorOpts, errs chan error) int {
+	return mirrorMany(ctx, sbkt, []Bucket{dbkt}, tmpBkt, opts, errs)
+}
+
+// like mirror, but to any number of destinations in one pass over the source.
+// an object that more than one destination needs is read once and written to all of them.
+// the state records an object once every destination has it.
+func mirrorMany(ctx context.Context, sbkt Bucket, dbkts []Bucket, tmpBkt Bucket, opts mirrorOpts, errs chan error) int {
+	// content is only ever transformed on its way into the temporary bucket. every
+	// copy after that is a plain copy of the transformed object, so without one the
+	// destination would get the source bytes as they are.
+	if tmpBkt == nil && len(opts.transforms()) != 0 {
+		mem, err := blob.OpenBucket(ctx, "mem://")
+		if err != nil {
+			errs <- fmt.Errorf("error opening temporary bucket: %w", err)
+			return 0
+		}
+		defer mem.Close()
+		tmpBkt = mem
+	}
+	tmpNonce := opts.tmpNonce
+	if tmpBkt != nil && tmpNonce == "" {
+		tmpNonce = newTmpNonce()
+	}
+	// with prelistDest, the objects in each destination, in the order of dbkts.
+	var listings []map[string]*blob.ListObject
+	if opts.prelistDest {
+		for _, dbkt := range dbkts {
+			objs, err := prelistDest(ctx, dbkt)
+			if err != nil {
+				errs <- fmt.Errorf("error listing destination: %w", err)
+				return 0
+			}
+			listings = append(listings, objs)
+		}
+	}
+	// with dedupeContent, what content each destination already has.
+	var indexes map[Bucket]*dedupeIndex
+	if opts.dedupeContent && !opts.dryRun {
+		indexes = make(map[Bucket]*dedupeIndex)
+		for _, dbkt := range dbkts {
+			ix, err := loadDedupeIndex(ctx, dbkt)
+			if err != nil {
+				errs <- fmt.Errorf("error reading the dedupe index: %w", err)
+				return 0
+			}
+			indexes[dbkt] = ix
+		}
+		defer func() {
+			for dbkt, ix := range indexes {
+				if err := ix.save(ctx, dbkt); err != nil {
+					errs <- fmt.Errorf("error writing the dedupe index: %w", err)
+				}
+			}
+		}()
+	}
+	var listOpts *blob.ListOptions
+	resumed := false
+	var iter objectIterator
+	// LastKey is a place in the listing, not in an inventory or plan. a resumed
+	// run of one skips what the state has instead.
+	if opts.inventory != nil {
+		iter = opts.inventory.iterator(sbkt)
+	} else if opts.applyPlan != nil {
+		iter = opts.applyPlan.iterator(sbkt)
+	} else {
+		if opts.state != nil && opts.state.LastKey != "" {
+			listOpts = startAfter(opts.state.LastKey, &resumed)
+		}
+		iter = listObjects(sbkt, listOpts, opts.listRPS)
+	}
+	// cleanloop won't run on the last iteration, but that's fine.
+	cleanloop := func() {}
+	// once anything fails, the state's LastKey stays put, so the failed object is listed again next time.
+	failed := false
+	// the object being handled, for -webhook events and for grouping its errors.
+	curKey, curDstKey := "", ""
+	// the span of the object being handled, and what ends it.
+	tel := newTelemetry()
+	curSpan := trace.SpanFromContext(ctx)
+	endObject := func() {}
+	fail := func(err error) {
+		failed = true
+		errs <- withKeys(err, curKey, curDstKey)
+		spanError(curSpan, err)
+		tel.errors.Add(ctx, 1)
+		if opts.webhook != nil && curKey != "" {
+			opts.webhook.send(webhookEvent{Key: curKey, Action: actionFailed, Error: err.Error()})
+		}
+	}
+	skipped := func(obj *blob.ListObject, dstKey string) {
+		curSpan.SetAttributes(attribute.String("blobcopy.action", actionSkipped))
+		if opts.webhook != nil {
+			opts.webhook.send(webhookEvent{Key: obj.Key, DstKey: dstKey, Size: obj.Size, Action: actionSkipped})
+		}
+	}
+	prevKey := ""
+	// set once the listing ends. LastKey only marks a listing that didn't, so the
+	// next run lists everything again and finds objects added before the old last key.
+	listed := false
+	// objects skipped with -skip aren't done, so they also keep LastKey from moving.
+	doneUpTo := func() {
+		if opts.state == nil || failed || opts.skipN != 0 || opts.inventory != nil || opts.applyPlan != nil {
+			return
+		}
+		if listed {
+			opts.state.LastKey = ""
+		} else if prevKey != "" {
+			opts.state.LastKey = prevKey
+		}
+	}
+	var warnOnce sync.Once
+	warnNoRename := func() {
+		warnOnce.Do(func() {
+			errLogger.Println("warning: the destination can't copy objects, writing directly instead of with -atomic-dest")
+		})
+	}
+	// destination key -> the source key copied there, when keys are normalized or templated.
+	var claimed map[string]claimedKey
+	if opts.normalizeKeys || opts.keyTemplate != nil {
+		claimed = make(map[string]claimedKey)
+	}
+	// objOpts are the options the object was copied with.
+	moveOne := func(key, dstKey string, objOpts mirrorOpts) {
+		if err := moveSource(ctx, sbkt, key, dbkts, dstKey, objOpts); err != nil {
+			fail(fmt.Errorf("error moving %s: %w", key, err))
+			return
+		}
+		logf(logNormal, "deleted %s from the source\n", key)
+	}
+	loopN := 0
+	addedN := 0
+	// each object is deleted from the temporary bucket once it's copied. anything
+	// left, like after a failed delete, is deleted at the end.
+	if tmpBkt != nil {
+		defer func() {
+			n, err := cleanTmp(ctx, tmpBkt, tmpNonce)
+			if err != nil {
+				errs <- fmt.Errorf("error cleaning up the temporary bucket: %w", err)
+			}
+			if n > 0 {
+				logf(logVerbose, "deleted %d objects left in the temporary bucket\n", n)
+			}
+		}()
+	}
+	// copies objects until the listing ends or the run has to stop.
+	copyLoop := func() {
+		for {
+			cleanloop()
+			cleanloop = func() {}
+			endObject()
+			endObject, curSpan = func() {}, trace.SpanFromContext(ctx)
+			doneUpTo()
+			// LastKey is the last object handled, so a resumed run picks up right after it.
+			if opts.deadline.expired() {
+				logf(logNormal, "deadline reached, not starting any more objects\n")
+				break
+			}
+			if opts.interrupt.expired() {
+				logf(logNormal, "interrupted, not starting any more objects\n")
+				break
+			}
+			if err := opts.checkpoint.tick(); err != nil {
+				errLogger.Println("error saving state:", err)
+			}
+			if opts.limit > 0 && addedN >= opts.limit {
+				logf(logNormal, "copied %d objects, the -limit\n", addedN)
+				break
+			}
+			loopN++
+			curKey, curDstKey = "", ""
+			obj, err := iter.Next(ctx)
+			if err == io.EOF {
+				listed = true
+				break
+			}
+			if err != nil {
+				fail(fmt.Errorf("error iterating: %w", err))
+				continue
+			}
+			curKey = obj.Key
+			ctx, span, end := tel.startObject(ctx, obj.Key)
+			curSpan, endObject = span, end
+			if loopN == 1 && listOpts != nil {
+				if resumed {
+					logf(logNormal, "resuming listing after %s\n", opts.state.LastKey)
+				} else {
+					logf(logNormal, "this source can't start listing after %s, listing everything\n", opts.state.LastKey)
+				}
+			}
+			prevKey = obj.Key
+			if loopN <= opts.skipN {
+				continue
+			}
+			if isSymlink(obj) {
+				switch opts.symlinks {
+				case symlinksSkip:
+					logf(logNormal, "%s is a symlink, skipping", obj.Key)
+					continue
+				case symlinksError:
+					fail(fmt.Errorf("%s is a symlink: %w", obj.Key, ErrSymlink))
+					continue
+				}
+			}
+
+			if !opts.filter.match(obj.Key) {
+				logf(logVerbose, "%s is filtered out, skipping\n", obj.Key)
+				continue
+			}
+			if !opts.shard.match(obj.Key) {
+				logf(logVerbose, "%s is in another shard, skipping\n", obj.Key)
+				continue
 			}
-		}
-	}()
 
-	n := mirror(ctx, sbkt, dbkt, tmpBkt, bytesEncrypt, bytesDecrypt, skipN, verifymd5, errs)
-	close(stopErrs)
-	<-errsStopped
-	logger.Printf("copied %d objects. %d errors. duration: %v\n", n, errsN, time.Since(start))
-}
+			if opts.seen != nil && !opts.seen.add(obj.Key) {
+				logf(logNormal, "%s was already listed, skipping duplicate\n", obj.Key)
+				continue
+			}
 
-// copies all objects from src to dst.
-func mirror(ctx context.Context, sbkt, dbkt, tmpBkt *blob.Bucket, bytesEncrypt, bytesDecrypt []byte, skipN int, verifymd5 bool, errs chan error) int {
-	iter := sbkt.List(nil)
-	// cleanloop won't run on the last iteration, but that's fine.
-	cleanloop := func() {}
-	loopN := 0
-	addedN := 0
-	for {
-		cleanloop()
-		loopN++
-		obj, err := iter.Next(ctx)
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			errs <- fmt.Errorf("error iterating: %w", err)
-			continue
-		}
-		if loopN <= skipN {
-			continue
-		}
+			if opts.preserveDirs {
+				if dir, ok := markerDir(obj.Key, obj.Size); ok {
+					dir = opts.plainName(dir)
+					n, err := copyMarker(ctx, dbkts, dir, opts.dryRun)
+					if err != nil {
+						fail(fmt.Errorf("error copying directory marker %s: %w", obj.Key, err))
+					}
+					if n > 0 {
+						addedN++
+						logCopied(addedN, "[%d] copied directory marker %s [%s]\n", loopN, obj.Key, dir)
+					}
+					continue
+				}
+			}
 
-		// before we do anything else, let's see if this file already exists in the destination
-		dobjKey, err := makeKey(obj.Key, bytesEncrypt, bytesDecrypt)
-		exists, err := dbkt.Exists(ctx, dobjKey)
-		if err != nil {
-			errs <- fmt.Errorf("error checking if %s exists in destination: %w", obj.Key, err)
-			continue
-		}
-		if exists && !verifymd5 {
-			logger.Printf("%s [%s] already exists in destination, skipping with no MD5 check", obj.Key, dobjKey)
-			cleanloop = func() {}
-			continue
-		}
+			if opts.state != nil && !opts.verifymd5 && opts.state.done(obj.Key) {
+				logf(logNormal, "%s already copied according to state, skipping", obj.Key)
+				skipped(obj, "")
+				continue
+			}
 
-		sattrs, err := sbkt.Attributes(ctx, obj.Key)
-		if err != nil {
-			errs <- fmt.Errorf("unable to get attributes for %s: %w", obj.Key, err)
-			continue
-		}
-		// if we're using a memory bucket, first copy the object to the memory bucket
-		// and this will calculate the MD5 for us.
-		// csbkt and sattrs will be updated to point to the temporary bucket in that case.
-		csbkt := sbkt
-		objKey := obj.Key
-		if tmpBkt != nil {
-			logger.Printf("[%d] loading to temporary bucket %s\n", loopN, obj.Key)
-			_, newKey, err := copyObj(ctx, sbkt, tmpBkt, obj.Key, bytesEncrypt, bytesDecrypt)
+			// before we do anything else, let's see if this file already exists in the destination
+			name, objOpts, err := opts.objectName(ctx, sbkt, obj.Key)
+			// someone else's object in an encrypted bucket. it won't decrypt next time either.
+			if errors.Is(err, ErrNotEncryptedName) {
+				errLogger.Printf("warning: skipping %s: %v. -decrypt-passthrough copies objects like it as they are\n", obj.Key, err)
+				continue
+			}
+			if err != nil {
+				fail(fmt.Errorf("unable to make destination key for %s: %w", obj.Key, err))
+				continue
+			}
+			dobjKey, hashedLong, err := hashedDestName(name, objOpts)
+			curDstKey = dobjKey
+			// the key would only be rejected again next time, so it doesn't count as failed.
+			if errors.Is(err, ErrInvalidKey) {
+				errs <- withKeys(fmt.Errorf("skipping %s: %w", obj.Key, err), obj.Key)
+				continue
+			}
 			if err != nil {
-				errs <- fmt.Errorf("error copying object to tmp bucket %s: %w", obj.Key, err)
+				fail(fmt.Errorf("unable to make destination key for %s: %w", obj.Key, err))
 				continue
 			}
-			csbkt = tmpBkt
-			sattrs, _ = csbkt.Attributes(ctx, newKey)
-			objKey = newKey
-			cleanloop = func() {
-				logger.Printf("[%d] deleting from temporary bucket %s\n", loopN, obj.Key)
-				if err := tmpBkt.Delete(ctx, newKey); err != nil {
-					errs <- fmt.Errorf("error deleting %s from temporary bucket: %w", obj.Key, err)
+			// different flags than the dry run's can put the object somewhere else.
+			if opts.applyPlan != nil {
+				if planned := opts.applyPlan.dstKey(obj.Key); planned != dobjKey {
+					fail(fmt.Errorf("%s: %w: it was going to %s, now %s", obj.Key, ErrPlanDrift, planned, dobjKey))
+					continue
 				}
 			}
-		}
+			// two source keys can normalize to the same destination key.
+			// the policy decides whether the later one replaces the earlier.
+			replace := false
+			if claimed != nil {
+				if first, ok := claimed[dobjKey]; ok {
+					errs <- withKeys(collisionError(obj.Key, dobjKey, first, opts.onCollision), obj.Key, dobjKey, first.key)
+					if !collisionWins(opts.onCollision, first, obj.ModTime) {
+						continue
+					}
+					replace = true
+				}
+				claimed[dobjKey] = claimedKey{key: obj.Key, modTime: obj.ModTime}
+			}
+			// each destination is checked on its own, and only the ones that need it get a copy.
+			var targets []Bucket
+			var targetExists []bool
+			var targetListed []*blob.ListObject
+			inSync := 0
+			checkFailed := false
+			for i, dbkt := range dbkts {
+				var exists bool
+				var listed *blob.ListObject
+				if listings != nil {
+					listed, exists = listings[i][dobjKey]
+				} else {
+					exists, err = dbkt.Exists(ctx, dobjKey)
+					if err != nil {
+						fail(fmt.Errorf("error checking destination for %s: %w", obj.Key, err))
+						checkFailed = true
+						continue
+					}
+				}
+				if !exists && opts.updateOnly {
+					logf(logNormal, "%s [%s] does not exist in destination, skipping in update-only mode", obj.Key, dobjKey)
+					continue
+				}
+				// update-only always compares md5s. refreshing changed objects is the whole point.
+				if exists && !replace && !opts.verifymd5 && !opts.updateOnly {
+					logf(logNormal, "%s [%s] already exists in destination, skipping with no MD5 check", obj.Key, dobjKey)
+					inSync++
+					continue
+				}
+				targets = append(targets, dbkt)
+				targetExists = append(targetExists, exists)
+				targetListed = append(targetListed, listed)
+			}
+			if inSync == len(dbkts) {
+				if opts.state != nil {
+					opts.state.record(obj.Key, stateEntry{DstKey: dobjKey, MD5: obj.MD5, Size: obj.Size})
+				}
+				skipped(obj, dobjKey)
+				continue
+			}
+			if len(targets) == 0 {
+				continue
+			}
 
-		// if it exists, check if the md5 matches
-		if exists {
-			dattrs, err := dbkt.Attributes(ctx, objKey)
+			sattrs, err := sbkt.Attributes(ctx, obj.Key)
 			if err != nil {
-				errs <- fmt.Errorf("error getting attributes for %s in destination: %w", obj.Key, err)
+				fail(fmt.Errorf("unable to get attributes for %s: %w", obj.Key, err))
+				continue
+			}
+			if !opts.metadata.match(sattrs.Metadata) {
+				logf(logNormal, "%s doesn't match the metadata filter, skipping\n", obj.Key)
+				continue
+			}
+			// reading an archived object fails, better to say why up front.
+			if class := archivedClass(sattrs); class != "" {
+				if opts.skipArchived {
+					logf(logNormal, "%s is archived in %s, skipping\n", obj.Key, class)
+				} else {
+					fail(archivedError(obj.Key, class))
+				}
+				continue
+			}
+			lock := opts.lockFor(sattrs, time.Now())
+			var mode os.FileMode
+			hasMode := false
+			if opts.preserveMode {
+				mode, hasMode = sourceMode(sattrs)
+			}
+			// sattrs is replaced by the transformed object's when there's a temporary bucket.
+			srcSize := sattrs.Size
+			srcMD5 := sattrs.MD5
+			srcModTime := sattrs.ModTime
+			// the metadata copies should have, from the source object itself.
+			var want wantMetadata
+			if opts.compareMetadata {
+				want = opts.wantMetadata(sattrs, obj.Key)
+			}
+			// the state remembers what the last copy wrote. when the source hasn't changed since,
+			// and every destination still has that, the object doesn't need to be read or
+			// transformed to compare it.
+			if opts.state != nil && !opts.distrustMD5 && !opts.move {
+				if entry, ok := opts.state.unchanged(obj.Key, dobjKey, srcMD5); ok && statedInSync(ctx, targets, targetExists, targetListed, dobjKey, entry) {
+					logf(logNormal, "%s [%s] is unchanged since the last run, skipping", obj.Key, dobjKey)
+					skipped(obj, dobjKey)
+					continue
+				}
+			}
+			// a compressed copy can't be compared to the source without compressing it again,
+			// but it records the md5 of what it was compressed from.
+			if opts.recordSrcMD5() && !opts.distrustMD5 && !opts.move && !opts.compareMetadata {
+				sum := func() ([]byte, error) {
+					if len(srcMD5) != 0 {
+						return srcMD5, nil
+					}
+					a, err := localAttrs(ctx, sbkt, obj.Key, nil)
+					if err != nil {
+						return nil, err
+					}
+					srcMD5 = a.MD5
+					return srcMD5, nil
+				}
+				if compressedInSync(ctx, targets, targetExists, dobjKey, sum) {
+					logf(logNormal, "%s [%s] has a compressed copy of the same content, skipping\n", obj.Key, dobjKey)
+					skipped(obj, dobjKey)
+					continue
+				}
+			}
+			// if we're using a memory bucket, first copy the object to the memory bucket
+			// and this will calculate the MD5 for us.
+			// csbkt, objKey and sattrs will be updated to point to the temporary bucket in that case.
+			// This is the one place content is encrypted or decrypted. the md5 compared below is
+			// then the md5 of the ciphertext, which is what the destination has too: the nonce
+			// comes from the plaintext, so the same object always encrypts to the same bytes.
+			csbkt := sbkt
+			objKey := obj.Key
+			if tmpBkt != nil {
+				logf(logVerbose, "[%d] loading to temporary bucket %s\n", loopN, obj.Key)
+				newKey := tmpKey(tmpNonce, obj.Key)
+				ts := objOpts.transforms()
+				if opts.recordSrcMD5() && len(srcMD5) == 0 {
+					// the source didn't report one, so it's worked out on the way through.
+					ts = append([]transform{func(text []byte) ([]byte, error) {
+						sum := md5.Sum(text)
+						srcMD5 = sum[:]
+						return text, nil
+					}}, ts...)
+				}
+				// with -conditional, the source only sends the object if it changed since it was copied.
+				var ropts *blob.ReaderOptions
+				if opts.conditional && !opts.distrustMD5 && !opts.move && !opts.compareMetadata {
+					if since, ok := oldestCopy(ctx, targets, targetExists, targetListed, dobjKey); ok {
+						ropts = &blob.ReaderOptions{BeforeRead: ifModifiedSince(since)}
+					}
+				}
+				_, _, err := copyTransformed(ctx, sbkt, tmpBkt, obj.Key, newKey, ts, ropts, nil)
+				if ropts != nil && notModified(err) {
+					logf(logNormal, "%s [%s] is not modified since it was copied, skipping\n", obj.Key, dobjKey)
+					skipped(obj, dobjKey)
+					continue
+				}
+				if err != nil {
+					fail(fmt.Errorf("error copying object to tmp bucket %s: %w", obj.Key, err))
+					continue
+				}
+				csbkt = tmpBkt
+				objKey = newKey
+				cleanloop = func() {
+					logf(logVerbose, "[%d] deleting from temporary bucket %s\n", loopN, obj.Key)
+					if err := tmpBkt.Delete(ctx, newKey); err != nil {
+						errs <- withKeys(fmt.Errorf("error deleting from temporary bucket %s: %w", obj.Key, err), obj.Key)
+					}
+				}
+				sattrs, err = csbkt.Attributes(ctx, newKey)
+				if err != nil {
+					fail(fmt.Errorf("unable to get tmp bucket attributes for %s: %w", obj.Key, err))
+					continue
+				}
+			}
+
+			// where it exists, check if the md5 matches
+			anyExists := false
+			for _, exists := range targetExists {
+				anyExists = anyExists || exists
+			}
+			if opts.distrustMD5 && anyExists {
+				sattrs, err = localAttrs(ctx, csbkt, objKey, nil)
+				if err != nil {
+					fail(fmt.Errorf("unable to compute md5 of %s: %w", obj.Key, err))
+					continue
+				}
+			}
+			var need []Bucket
+			// what the destination has, for the plan. plans are only made for one destination.
+			var planDst *planObject
+			for i, dbkt := range targets {
+				if !targetExists[i] {
+					need = append(need, dbkt)
+					continue
+				}
+				// listings don't carry metadata, so a recorded md5 is only used when the listing has none.
+				dattrs := listedAttrs(targetListed[i])
+				if dattrs == nil || opts.compareMetadata || opts.skipListedMD5 {
+					dattrs, err = opts.consistency.attributes(ctx, dbkt, dobjKey)
+					if err != nil {
+						fail(fmt.Errorf("error getting destination attributes for %s: %w", obj.Key, err))
+						checkFailed = true
+						continue
+					}
+					preferRecordedMD5(dattrs)
+				}
+				o := newPlanObject(dattrs.Size, dattrs.MD5, dattrs.ModTime)
+				planDst = &o
+				if opts.distrustMD5 {
+					dattrs, err = localAttrs(ctx, dbkt, dobjKey, opts.destReadOptions())
+					if err != nil {
+						fail(fmt.Errorf("unable to compute destination md5 of %s: %w", obj.Key, err))
+						checkFailed = true
+						continue
+					}
+				}
+				// the same size is all there is to go by for an object uploaded in parts,
+				// unless it's read.
+				if sattrs.Size == dattrs.Size {
+					if sattrs, err = opts.multipartMD5(ctx, csbkt, objKey, sattrs, nil); err != nil {
+						fail(fmt.Errorf("unable to compute md5 of %s: %w", obj.Key, err))
+						checkFailed = true
+						break
+					}
+					if dattrs, err = opts.multipartMD5(ctx, dbkt, dobjKey, dattrs, opts.destReadOptions()); err != nil {
+						fail(fmt.Errorf("unable to compute destination md5 of %s: %w", obj.Key, err))
+						checkFailed = true
+						continue
+					}
+				}
+				if !sameSums(sattrs, dattrs, opts.hashAlgo) {
+					// in a backup that's only ever added to, a changed object is damage, not an update.
+					if opts.immutable {
+						fail(fmt.Errorf("%s [%s] differs from the source: %w", obj.Key, dobjKey, ErrImmutable))
+						checkFailed = true
+						continue
+					}
+					need = append(need, dbkt)
+					continue
+				}
+				if opts.compareMetadata && want.differs(dattrs) {
+					if opts.dryRun {
+						logf(logNormal, "[%d] would update metadata of %s [%s]\n", loopN, obj.Key, dobjKey)
+						if opts.planOut != nil {
+							opts.planOut.add(planEntry{Key: obj.Key, DstKey: dobjKey, Action: planUpdateMetadata, Reason: reasonMetadata, Src: newPlanObject(srcSize, srcMD5, srcModTime), Dst: planDst})
+						}
+						continue
+					}
+					err := updateMetadata(ctx, dbkt, dobjKey, want, dattrs)
+					switch {
+					case errors.Is(err, ErrMetadataInPlace):
+						// copied again, with the metadata.
+						need = append(need, dbkt)
+					case err != nil:
+						fail(fmt.Errorf("error updating destination metadata of %s: %w", obj.Key, err))
+						checkFailed = true
+					default:
+						logf(logNormal, "[%d] updated metadata of %s [%s]\n", loopN, obj.Key, dobjKey)
+						opts.applyPlan.applied(obj.Key)
+					}
+				}
+			}
+			// only an object that's in every destination can leave the source.
+			everywhere := !checkFailed && len(targets) == len(dbkts)
+			if len(need) == 0 {
+				if opts.state != nil && !checkFailed {
+					opts.state.record(obj.Key, stateEntry{DstKey: dobjKey, MD5: sattrs.MD5, Size: sattrs.Size, SrcMD5: srcMD5})
+				}
+				if !checkFailed {
+					skipped(obj, dobjKey)
+				}
+				if opts.move && everywhere && !opts.dryRun {
+					moveOne(obj.Key, dobjKey, objOpts)
+				}
+				continue
+			}
+			// either it doesn't exist, or the MD5 doesn't match. copy it.
+			if opts.dryRun {
+				addedN++
+				logCopied(addedN, "[%d] would copy to destination %s [%s] %s\n", loopN, obj.Key, dobjKey, sizeString(sattrs.Size, srcSize))
+				if opts.planOut != nil {
+					reason := reasonMissing
+					if planDst != nil {
+						reason = reasonDiffers
+					}
+					opts.planOut.add(planEntry{Key: obj.Key, DstKey: dobjKey, Action: planCopy, Reason: reason, Src: newPlanObject(srcSize, srcMD5, srcModTime), Dst: planDst})
+				}
+				continue
+			}
+			if err := opts.spaceGuard.check(need, sattrs.Size); err != nil {
+				fail(fmt.Errorf("not copying %s: %w", obj.Key, err))
+				if opts.spaceGuard.abort(err) {
+					logf(logNormal, "out of space, not starting any more objects\n")
+					break
+				}
 				continue
 			}
-			if string(sattrs.MD5) == string(dattrs.MD5) {
+			logCopied(addedN+1, "[%d] copying to destination %s [%s] %s\n", loopN, obj.Key, dobjKey, sizeString(sattrs.Size, srcSize))
+			wopts := &blob.WriterOptions{}
+			// the bytes are copied without a transform here, so the source md5
+			// is also the md5 of what we write. nil when the source didn't report one.
+			if opts.sendContentMD5 {
+				wopts.ContentMD5 = sattrs.MD5
+			}
+			// by the source name, not one with a -compress suffix or encrypted.
+			wopts.ContentType = opts.contentTypes.lookup(obj.Key)
+			wopts.CacheControl = opts.cacheControl
+			if opts.compareMetadata {
+				wopts.ContentType = want.contentType
+				wopts.Metadata = want.metadata
+			}
+			if opts.normalizeContentType {
+				wopts.ContentType = normalizeContentType(wopts.ContentType)
+			}
+			if opts.compress {
+				wopts.ContentEncoding = opts.compressCodec().encoding
+			}
+			var lockHook func(func(interface{}) bool) error
+			if !lock.empty() {
+				lockHook = lock.beforeWrite
+			}
+			var aclHook func(func(interface{}) bool) error
+			if opts.publicRead {
+				aclHook = publicRead
+			}
+			if len(opts.grants) != 0 {
+				aclHook = grantRead(opts.grants)
+			}
+			var modeHook func(func(interface{}) bool) error
+			if hasMode {
+				modeHook = setMode(mode)
+			}
+			var tierHook func(func(interface{}) bool) error
+			if opts.tier != "" {
+				tierHook = setTier(opts.tier)
+			}
+			var expiryHook func(func(interface{}) bool) error
+			if opts.expires > 0 {
+				expiryHook = setExpiry(time.Now().Add(opts.expires))
+			}
+			var sseHook func(func(interface{}) bool) error
+			if s := opts.sseFor(sattrs); !s.empty() {
+				sseHook = setSSE(s)
+			}
+			wopts.BeforeWrite = chainBeforeWrite(lockHook, aclHook, modeHook, tierHook, expiryHook, sseHook)
+			if (opts.storeOrigKey || opts.keyHash != "") && len(objOpts.nameEncrypt) != 0 || hashedLong {
+				encName, err := makeKey(name, objOpts.nameEncrypt, nil)
+				if err != nil {
+					fail(fmt.Errorf("unable to encrypt key name %s: %w", obj.Key, err))
+					continue
+				}
+				if wopts.Metadata == nil {
+					wopts.Metadata = make(map[string]string)
+				}
+				wopts.Metadata[origKeyMeta] = encName
+			}
+			if opts.recordSrcMD5() && len(srcMD5) != 0 {
+				if wopts.Metadata == nil {
+					wopts.Metadata = make(map[string]string)
+				}
+				wopts.Metadata[srcMD5Meta] = hex.EncodeToString(srcMD5)
+			}
+			if opts.storeMD5 {
+				sum := sattrs.MD5
+				if len(sum) == 0 {
+					a, err := localAttrs(ctx, csbkt, objKey, nil)
+					if err != nil {
+						fail(fmt.Errorf("unable to compute md5 of %s: %w", obj.Key, err))
+						continue
+					}
+					sum = a.MD5
+				}
+				if wopts.Metadata == nil {
+					wopts.Metadata = make(map[string]string)
+				}
+				wopts.Metadata[md5Meta] = hex.EncodeToString(sum)
+			}
+			writeKey := dobjKey
+			if opts.atomicDest {
+				writeKey = dobjKey + atomicSuffix
+			}
+			// destinations that already have this content under another key get a
+			// server side copy of that object. only the rest are uploaded to.
+			sum := ""
+			if indexes != nil {
+				sum, err = contentSHA256(ctx, csbkt, objKey)
+				if err != nil {
+					fail(fmt.Errorf("unable to hash %s: %w", obj.Key, err))
+					continue
+				}
+			}
+			copyErrs := make([]error, len(need))
+			var upload []Bucket
+			var uploadAt []int
+			for i, dbkt := range need {
+				if ix := indexes[dbkt]; ix != nil {
+					ok, err := ix.reference(ctx, dbkt, sum, sattrs, writeKey)
+					if ok {
+						logf(logVerbose, "%s [%s] has content already in the destination, copying it there\n", obj.Key, dobjKey)
+					}
+					if ok || err != nil {
+						copyErrs[i] = err
+						continue
+					}
+				}
+				upload = append(upload, dbkt)
+				uploadAt = append(uploadAt, i)
+			}
+			var n int
+			// the md5 of what was written, when the copy computed it.
+			var written []byte
+			var uploadErrs []error
+			switch {
+			case len(upload) == 0:
+			case len(upload) > 1:
+				n, uploadErrs = copyObjFanout(ctx, csbkt, upload, objKey, writeKey, wopts)
+			case opts.resumeRetries > 0 && sattrs.Size > rangeChunkSize:
+				n, err = copyResumable(ctx, csbkt, upload[0], opts.partUploaders[upload[0]], objKey, writeKey, sattrs.Size, opts.multipartParts, rangeChunkSize, opts.resumeRetries, wopts)
+				uploadErrs = []error{err}
+			case opts.multipartParts > 1 && sattrs.Size > rangeChunkSize:
+				n, err = copyObjRanges(ctx, csbkt, upload[0], objKey, writeKey, sattrs.Size, opts.multipartParts, rangeChunkSize, wopts)
+				uploadErrs = []error{err}
+			default:
+				n, written, err = copyObjTo(ctx, csbkt, upload[0], objKey, writeKey, nil, nil, wopts)
+				uploadErrs = []error{err}
+			}
+			for j, err := range uploadErrs {
+				copyErrs[uploadAt[j]] = err
+			}
+			if opts.atomicDest {
+				for i, dbkt := range need {
+					if copyErrs[i] != nil {
+						continue
+					}
+					direct := func() error {
+						_, _, err := copyObjTo(ctx, csbkt, dbkt, objKey, dobjKey, nil, nil, wopts)
+						return err
+					}
+					copyErrs[i] = finishAtomic(ctx, dbkt, writeKey, dobjKey, opts.consistency, direct, warnNoRename)
+				}
+			}
+			copied := false
+			copiedAll := !checkFailed
+			for i, err := range copyErrs {
+				if err != nil {
+					fail(fmt.Errorf("error copying object to destination %s: %w", obj.Key, err))
+					copiedAll = false
+					continue
+				}
+				copied = true
+				if ix := indexes[need[i]]; ix != nil {
+					ix.add(sum, dobjKey, sattrs.Size)
+				}
+			}
+			if !copied {
 				continue
 			}
+			addedN++
+			opts.applyPlan.applied(obj.Key)
+			if opts.progress != nil {
+				opts.progress <- progressEvent{key: obj.Key, bytes: int64(n), srcBytes: srcSize}
+			}
+			if opts.state != nil && copiedAll {
+				sum := written
+				if sum == nil {
+					sum = sattrs.MD5
+				}
+				opts.state.record(obj.Key, stateEntry{DstKey: dobjKey, MD5: sum, Size: int64(n), SrcMD5: srcMD5})
+			}
+			if opts.signer != nil {
+				for i, dbkt := range need {
+					if copyErrs[i] != nil {
+						continue
+					}
+					if err := opts.signer.sign(ctx, dbkt, dobjKey); err != nil {
+						errs <- withKeys(fmt.Errorf("error signing a URL for %s: %w", obj.Key, err), obj.Key, dobjKey)
+					}
+				}
+			}
+			logCopied(addedN, "[%d] copied to destination %s [%s] %s\n", loopN, obj.Key, dobjKey, sizeString(int64(n), srcSize))
+			if opts.webhook != nil {
+				opts.webhook.send(webhookEvent{Key: obj.Key, DstKey: dobjKey, Size: int64(n), Action: actionCopied})
+			}
+			tel.copied.Add(ctx, 1)
+			tel.bytes.Add(ctx, int64(n))
+			span.SetAttributes(attribute.String("blobcopy.action", actionCopied), attribute.Int64("blobcopy.size", int64(n)))
+			if opts.move && copiedAll && everywhere {
+				moveOne(obj.Key, dobjKey, objOpts)
+			}
 		}
-		// either it doesn't exist, or the MD5 doesn't match. copy it.
-		logger.Printf("[%d] copying to destination %s [%s] size %d\n", loopN, obj.Key, objKey, sattrs.Size)
-		n, _, err := copyObj(ctx, csbkt, dbkt, objKey, []byte{}, []byte{})
-		if err != nil {
-			errs <- fmt.Errorf("error copying object to destination %s: %w", obj.Key, err)
-			continue
+	}
+	// a panic while handling an object, like one from a backend returning something
+	// unexpected, fails that object rather than the whole run. everything the loop
+	// keeps is out here, so running it again carries on with the next object.
+	listingPanicked := false
+	onPanic := func(err error) {
+		if curKey == "" {
+			// the listing itself panicked, and would again.
+			fail(fmt.Errorf("error iterating: %w", err))
+			listingPanicked = true
+			return
 		}
-		addedN++
-		logger.Printf("[%d] copied to destination %s [%s] size %d\n", loopN, obj.Key, objKey, n)
+		fail(fmt.Errorf("error copying %s: %w", curKey, err))
+	}
+	for !runRecovered(copyLoop, onPanic) && !listingPanicked {
 	}
+	endObject()
+	doneUpTo()
 	return addedN
 }
 
+// reports whether every target has the md5 the state recorded for it.
+func statedInSync(ctx context.Context, targets []Bucket, exists []bool, listed []*blob.ListObject, key string, entry stateEntry) bool {
+	for i, dbkt := range targets {
+		if !exists[i] {
+			return false
+		}
+		dattrs := listedAttrs(listed[i])
+		if dattrs == nil {
+			var err error
+			dattrs, err = dbkt.Attributes(ctx, key)
+			if err != nil {
+				return false
+			}
+			preferRecordedMD5(dattrs)
+		}
+		if !bytes.Equal(dattrs.MD5, entry.MD5) {
+			return false
+		}
+	}
+	return true
+}
+
 // copy object refereced by key from src to dst buckets.
-func copyObj(ctx context.Context, src, dst *blob.Bucket, key string, bytesEncrypt, bytesDecrypt []byte) (int, string, error) {
+// both the key name and the content are encrypted/decrypted with the given keys.
+// wopts are passed to the destination writer and may be nil.
+// returns the size, the new key and the md5 of what was written.
+func copyObj(ctx context.Context, src, dst Bucket, key string, bytesEncrypt, bytesDecrypt []byte, wopts *blob.WriterOptions) (int, string, []byte, error) {
 	newKey, err := makeKey(key, bytesEncrypt, bytesDecrypt)
 	if err != nil {
-		return 0, "", err
+		return 0, "", nil, err
 	}
+	n, sum, err := copyObjTo(ctx, src, dst, key, newKey, bytesEncrypt, bytesDecrypt, wopts)
+	return n, newKey, sum, err
+}
 
-	srcr, err := src.NewReader(ctx, key, nil)
-	if err != nil {
-		return 0, "", err
-	}
-	defer srcr.Close()
+// like copyObj, but writes to newKey rather than the key makeKey would make.
+// only the content is encrypted/decrypted.
+// the md5 of the written bytes is computed on the way, so it's known even
+// when neither side reports one, without reading the object again.
+func copyObjTo(ctx context.Context, src, dst Bucket, key, newKey string, bytesEncrypt, bytesDecrypt []byte, wopts *blob.WriterOptions) (int, []byte, error) {
+	return copyTransformed(ctx, src, dst, key, newKey, contentTransforms(bytesEncrypt, bytesDecrypt), nil, wopts)
+}
 
-	beforeText, err := io.ReadAll(srcr)
-	if err != nil {
-		return 0, "", err
-	}
+// like copyObjTo, through any transforms. ropts are passed to the source reader and may be nil.
+func copyTransformed(ctx context.Context, src, dst Bucket, key, newKey string, ts []transform, ropts *blob.ReaderOptions, wopts *blob.WriterOptions) (n int, sum []byte, err error) {
+	ctx, span := otel.Tracer(telemetryName).Start(ctx, "blobcopy.copy", trace.WithAttributes(attribute.String("blobcopy.key", key), attribute.String("blobcopy.dst_key", newKey)))
+	defer func() {
+		if err != nil {
+			spanError(span, err)
+		}
+		span.SetAttributes(attribute.Int("blobcopy.size", n))
+		span.End()
+	}()
+	// canceling the context aborts the write, so a failed copy never leaves a partial object behind.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-	newText, err := encrypt(beforeText, bytesEncrypt)
+	srcr, err := src.NewReader(ctx, key, ropts)
 	if err != nil {
-		return 0, "", err
+		return 0, nil, err
 	}
+	defer srcr.Close()
 
-	newText, err = decrypt(newText, bytesDecrypt)
+	dstw, err := dst.NewWriter(ctx, newKey, wopts)
 	if err != nil {
-		return 0, "", err
+		return 0, nil, err
 	}
 
-	dstw, err := dst.NewWriter(ctx, newKey, nil)
+	h := md5.New()
+	w := io.MultiWriter(limitWriter(ctx, dst, dstw), h)
+	written, err := transformCopy(w, limitReader(ctx, src, srcr), ts)
 	if err != nil {
-		return 0, "", err
+		cancel()
+		dstw.Close()
+		return 0, nil, err
 	}
-
-	n, err := dstw.Write(newText)
-	if err != nil {
-		return 0, "", err
+	if err := dstw.Close(); err != nil {
+		return 0, nil, err
 	}
-	return n, newKey, dstw.Close()
+	return int(written), h.Sum(nil), nil
 }
 
 func encrypt(text []byte, key []byte) ([]byte, error) {
 	if len(key) == 0 {
 		return text, nil
 	}
-	c, err := aes.NewCipher(key)
-	if err != nil {
-		return nil, err
-	}
-	gcm, err := cipher.NewGCM(c)
+	aead, err := newAEAD(sealCipher, key)
 	if err != nil {
 		return nil, err
 	}
 	// this is not secure.
-	// doing this so we have a consistent hash and filename for the same input
+	// doing this so we have a consistent hash and filename for the same input.
+	// the same text always gets the same nonce, and then the same ciphertext,
+	// so only different texts with the same md5 reuse one. see randomNonce for
+	// what doesn't need to be consistent.
 	md5sum := md5.Sum(text)
-	nonce := md5sum[:gcm.NonceSize()]
-	return gcm.Seal(nonce, nonce, text, nil), nil
+	nonce := md5sum[:aead.NonceSize()]
+	return aead.Seal(append(cipherHeader(sealCipher), nonce...), nonce, text, nil), nil
 }
 
+// objects are sealed whole, so the AEAD authenticates all of the ciphertext at once.
+// a truncated or reordered object fails to open, and no plaintext comes back.
+// a chunked format would have to bind each chunk's index, and which one is last,
+// into its additional data to keep that property.
 func decrypt(cyphertext []byte, key []byte) ([]byte, error) {
 	if len(key) == 0 {
 		return cyphertext, nil
 	}
-	c, err := aes.NewCipher(key)
+	return openFramed(key, cyphertext)
+}
+
+// metadata that holds the encrypted original key of an encrypted object.
+const origKeyMeta = "x-blobcopy-origkey"
+
+// the destination key for a source key.
+func destKey(ctx context.Context, sbkt Bucket, key string, opts mirrorOpts) (string, error) {
+	name, err := plainKey(ctx, sbkt, key, opts)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-	gcm, err := cipher.NewGCM(c)
-	if err != nil {
-		return nil, err
+	return destName(name, opts)
+}
+
+// the plain name of a source object, and the options to copy it with. With
+// -decrypt-passthrough, an object whose name isn't encrypted is copied as it is,
+// name and content, rather than failing.
+func (opts mirrorOpts) objectName(ctx context.Context, sbkt Bucket, key string) (string, mirrorOpts, error) {
+	name, err := plainKey(ctx, sbkt, key, opts)
+	if errors.Is(err, ErrNotEncryptedName) && opts.decryptPassthrough {
+		// not encrypted again under -reencrypt either.
+		opts.nameDecrypt, opts.bytesDecrypt = nil, nil
+		opts.nameEncrypt, opts.bytesEncrypt = nil, nil
+		name, err = plainKey(ctx, sbkt, key, opts)
 	}
-	nonceSize := gcm.NonceSize()
-	nonce, cyphertext := cyphertext[:nonceSize], cyphertext[nonceSize:]
-	return gcm.Open(nil, nonce, cyphertext, nil)
+	return name, opts, err
 }
 
-func makeKey(oldKey string, bytesEncrypt, bytesDecrypt []byte) (string, error) {
-	newKey := oldKey
-	if len(bytesEncrypt) != 0 {
-		encryptedKey, err := encrypt([]byte(newKey), bytesEncrypt)
+// the plain name of a source object. When decrypting an object that carries its
+// encrypted original key in metadata, that is decrypted instead of the object's key.
+// the -key-template is applied last, to the plain name.
+func plainKey(ctx context.Context, sbkt Bucket, key string, opts mirrorOpts) (string, error) {
+	var attrs *blob.Attributes
+	name := key
+	if len(opts.nameDecrypt) != 0 {
+		var err error
+		attrs, err = sbkt.Attributes(ctx, key)
 		if err != nil {
 			return "", err
 		}
-		newKey = base64.URLEncoding.EncodeToString(encryptedKey)
+		if encName, ok := attrs.Metadata[origKeyMeta]; ok {
+			key = encName
+		}
+		// encrypted names can only be normalized once they're decrypted.
+		name, err = makeKey(key, nil, opts.nameDecrypt)
+		if err != nil {
+			return "", err
+		}
+	}
+	name = opts.plainName(name)
+	if opts.keyTemplate == nil {
+		return name, nil
+	}
+	var modTime time.Time
+	if opts.keyTemplate.needsTime() {
+		if attrs == nil {
+			var err error
+			attrs, err = sbkt.Attributes(ctx, key)
+			if err != nil {
+				return "", err
+			}
+		}
+		modTime = attrs.ModTime
+	}
+	return opts.keyTemplate.apply(name, modTime), nil
+}
+
+// the destination key for a plain name.
+// When encrypting with a key hash, the encrypted key is hashed to keep it short.
+func destName(name string, opts mirrorOpts) (string, error) {
+	key, _, err := hashedDestName(name, opts)
+	return key, err
+}
+
+// like destName, and reports whether the key is a hash of a plain key over the
+// -max-key-length, whose original has to be kept in metadata. an over-long key
+// is an ErrInvalidKey without a key hash.
+func hashedDestName(name string, opts mirrorOpts) (string, bool, error) {
+	if opts.compress {
+		name += opts.compressCodec().suffix
+	}
+	newKey, err := makeKey(name, opts.nameEncrypt, nil)
+	if err != nil {
+		return "", false, err
 	}
+	if len(opts.nameEncrypt) != 0 && opts.keyHash != "" {
+		key, err := hashKey(newKey, opts.keyHash)
+		return key, false, err
+	}
+	// before the rules, which would reject a long key that can be hashed.
+	if opts.tooLong(newKey) {
+		if opts.keyHash == "" {
+			return "", false, fmt.Errorf("%q is %d bytes, over the limit of %d: %w", newKey, len(newKey), opts.maxKeyLength, ErrInvalidKey)
+		}
+		key, err := hashKey(newKey, opts.keyHash)
+		return key, true, err
+	}
+	if opts.keyRules != nil {
+		newKey, err = opts.keyRules.apply(newKey, opts.sanitizeKeys)
+		return newKey, false, err
+	}
+	return newKey, false, nil
+}
+
+const (
+	keyHashSHA256 = "sha256"
+	keyHashMD5    = "md5"
+)
+
+func validKeyHash(scheme string) error {
+	switch scheme {
+	case "", keyHashSHA256, keyHashMD5:
+		return nil
+	}
+	return fmt.Errorf("unknown key hash %q. use sha256 or md5", scheme)
+}
+
+// a fixed length stand-in for a key that may be too long for the destination.
+// it can't be reversed, the key itself is kept in the object's metadata.
+func hashKey(key, scheme string) (string, error) {
+	switch scheme {
+	case keyHashSHA256:
+		sum := sha256.Sum256([]byte(key))
+		return hex.EncodeToString(sum[:]), nil
+	case keyHashMD5:
+		sum := md5.Sum([]byte(key))
+		return hex.EncodeToString(sum[:]), nil
+	}
+	return "", validKeyHash(scheme)
+}
+
+// decrypts, then encrypts a key name. Both may be given to re-encrypt it.
+func makeKey(oldKey string, bytesEncrypt, bytesDecrypt []byte) (string, error) {
+	newKey := oldKey
 	if len(bytesDecrypt) != 0 {
+		// like a file someone put in the bucket by hand.
 		decodedKey, err := base64.URLEncoding.DecodeString(newKey)
 		if err != nil {
-			return "", err
+			return "", fmt.Errorf("%w: %w", ErrNotEncryptedName, err)
 		}
 		decryptedKey, err := decrypt(decodedKey, bytesDecrypt)
 		if err != nil {
-			return "", err
+			return "", fmt.Errorf("%w: %w", ErrNotEncryptedName, err)
 		}
 		newKey = string(decryptedKey)
 	}
+	if len(bytesEncrypt) != 0 {
+		encryptedKey, err := encrypt([]byte(newKey), bytesEncrypt)
+		if err != nil {
+			return "", err
+		}
+		newKey = base64.URLEncoding.EncodeToString(encryptedKey)
+	}
 	return newKey, nil
 }
 
+// environment variables that hold the passwords, so there's no prompt.
+const (
+	passwordEnv    = "BLOBCOPY_ENCRYPTION_PASSWORD"
+	newPasswordEnv = "BLOBCOPY_NEW_ENCRYPTION_PASSWORD"
+)
+
 func getAuthentication() ([]byte, error) {
-	pass, ok := os.LookupEnv("BLOBCOPY_ENCRYPTION_PASSWORD")
+	return readAuthentication(passwordEnv, "encryption password")
+}
+
+// reads a password from env, or prompts for it twice, and turns it into an encryption key.
+// what is the name of the password in the prompts.
+func readAuthentication(env, what string) ([]byte, error) {
+	pass, ok := os.LookupEnv(env)
 	if !ok {
 		oldState, err := term.MakeRaw(int(os.Stdin.Fd()))
 		if err != nil {
@@ -338,14 +2594,14 @@ func getAuthentication() ([]byte, error) {
 		}()
 
 		terminal := term.NewTerminal(os.Stdin, "")
-		_, _ = terminal.Write([]byte("Enter encryption password: "))
+		_, _ = terminal.Write([]byte("Enter " + what + ": "))
 		bytepass1, err := term.ReadPassword(int(os.Stdin.Fd()))
 		_, _ = terminal.Write([]byte("\n"))
 		if err != nil {
 			return nil, err
 		}
 		pass1 := string(bytepass1)
-		_, _ = terminal.Write([]byte("Enter encryption password (verify): "))
+		_, _ = terminal.Write([]byte("Enter " + what + " (verify): "))
 		bytepass2, err := term.ReadPassword(int(os.Stdin.Fd()))
 		_, _ = terminal.Write([]byte("\n"))
 		if err != nil {
@@ -374,7 +2630,52 @@ func safetyName(encKey []byte) (string, string, error) {
 	return keyName, encKeyName, nil
 }
 
-func enableSafetyCheck(ctx context.Context, bkt *blob.Bucket, encKey []byte) error {
+// checks the safety file in the destination.
+// -safety fails when the check fails, with a hint to use -gen-safety.
+// -gen-safety generates the safety file when the check fails.
+// -require-safety fails when the check fails and never generates the safety file,
+// so pointing it at the wrong bucket or using the wrong password always fails.
+func runSafetyCheck(ctx context.Context, bkt Bucket, encKey []byte, gen, require bool) error {
+	pass, err := safetyCheck(ctx, bkt, encKey)
+	if err != nil {
+		return err
+	}
+	if pass {
+		return nil
+	}
+	if require {
+		return fmt.Errorf("%w. -require-safety is set, is this the right destination and password?", ErrSafetyCheckFailed)
+	}
+	if !gen {
+		return fmt.Errorf("%w. use --gen-safety to generate a safety check with this password.", ErrSafetyCheckFailed)
+	}
+	logger.Printf("safety check failed. generating safety check.")
+	err = enableSafetyCheck(ctx, bkt, encKey)
+	if err != nil {
+		return err
+	}
+	// another process may have been generating the marker at the same time.
+	// the content is deterministic, so whoever wrote last, it should check out.
+	pass, err = safetyCheck(ctx, bkt, encKey)
+	if err != nil {
+		return err
+	}
+	if !pass {
+		return fmt.Errorf("%w after generating it", ErrSafetyCheckFailed)
+	}
+	return nil
+}
+
+// writes the safety marker for encKey, unless a valid one is already there.
+// concurrent callers all write the same content, so racing is harmless.
+func enableSafetyCheck(ctx context.Context, bkt Bucket, encKey []byte) error {
+	pass, err := safetyCheck(ctx, bkt, encKey)
+	if err != nil {
+		return err
+	}
+	if pass {
+		return nil
+	}
 	// a predictable key name that will be different for every encryption key
 	_, encKeyName, err := safetyName(encKey)
 	if err != nil {
@@ -395,7 +2696,7 @@ func enableSafetyCheck(ctx context.Context, bkt *blob.Bucket, encKey []byte) err
 	return wtr.Close()
 }
 
-func safetyCheck(ctx context.Context, bkt *blob.Bucket, encKey []byte) (bool, error) {
+func safetyCheck(ctx context.Context, bkt Bucket, encKey []byte) (bool, error) {
 	_, encKeyName, err := safetyName(encKey)
 	if err != nil {
 		return false, err
@@ -413,6 +2714,7 @@ func safetyCheck(ctx context.Context, bkt *blob.Bucket, encKey []byte) (bool, er
 	default:
 		return false, err
 	}
+	defer rdr.Close()
 	actualContent, err := io.ReadAll(rdr)
 	if err != nil {
 		return false, err
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+)
+
+// what the completion marker says about the run that wrote it.
+type completionInfo struct {
+	Source   string    `json:"source"`
+	Objects  int       `json:"objects"`
+	Bytes    int64     `json:"bytes"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+}
+
+// removes a marker left by an earlier run, so it can't be taken for this one's.
+func clearMarker(ctx context.Context, dbkts []Bucket, key string) error {
+	for _, dbkt := range dbkts {
+		if err := dbkt.Delete(ctx, key); err != nil && gcerrors.Code(err) != gcerrors.NotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+// writes the marker to every destination, only after a run that went through
+// everything without an error. reports whether it was written.
+func markComplete(ctx context.Context, dbkts []Bucket, key string, info completionInfo, failed bool) (bool, error) {
+	if failed {
+		return false, nil
+	}
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return false, err
+	}
+	for _, dbkt := range dbkts {
+		if err := writeAll(ctx, dbkt, key, data, &blob.WriterOptions{ContentType: "application/json"}); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"gocloud.dev/blob"
+)
+
+// runs a mirror and the completion marker steps main does around it.
+func testMarkedRun(t *testing.T, sbkt, dbkt *blob.Bucket) bool {
+	t.Helper()
+	ctx := context.Background()
+	dbkts := []Bucket{dbkt}
+	if err := clearMarker(ctx, dbkts, ".blobcopy-complete"); err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error)
+	errsN := 0
+	done := make(chan bool)
+	go func() {
+		for range errs {
+			errsN++
+		}
+		close(done)
+	}()
+	start := time.Now()
+	n := mirror(ctx, sbkt, dbkt, nil, mirrorOpts{}, errs)
+	close(errs)
+	<-done
+	info := completionInfo{Source: "mem://", Objects: n, Started: start, Finished: time.Now()}
+	written, err := markComplete(ctx, dbkts, ".blobcopy-complete", info, errsN > 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return written
+}
+
+func TestCompletionMarker(t *testing.T) {
+	ctx := context.Background()
+	failing := false
+	sbkt := testFaultBucket(t, &faultBucket{read: func(key string) error {
+		if failing && key == "b" {
+			return errors.New("unreadable")
+		}
+		return nil
+	}})
+	_, dbkt := testMemBuckets(t)
+	for _, key := range []string{"a", "b"} {
+		if err := sbkt.WriteAll(ctx, key, []byte(key), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !testMarkedRun(t, sbkt, dbkt) {
+		t.Fatal("a clean run should write the marker")
+	}
+	data, err := dbkt.ReadAll(ctx, ".blobcopy-complete")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var info completionInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		t.Fatal(err)
+	}
+	if info.Objects != 2 || info.Source != "mem://" {
+		t.Errorf("unexpected marker %+v", info)
+	}
+
+	// a failed run removes the old marker and doesn't write a new one.
+	failing = true
+	if err := sbkt.WriteAll(ctx, "b", []byte("changed"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := dbkt.Delete(ctx, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if testMarkedRun(t, sbkt, dbkt) {
+		t.Error("a run with errors wrote the marker")
+	}
+	if exists, _ := dbkt.Exists(ctx, ".blobcopy-complete"); exists {
+		t.Error("the stale marker is still there after a failed run")
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	s3v2 "github.com/aws/aws-sdk-go-v2/service/s3"
+	s3v2types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"gocloud.dev/blob"
+)
+
+var ErrMetadataInPlace = errors.New("the destination can't update metadata without rewriting the object")
+
+// metadata blobcopy keeps for itself. It isn't the source's, so it's never compared.
+func ownMetadata(key string) bool {
+	key = strings.ToLower(key)
+	return key == origKeyMeta || key == md5Meta
+}
+
+// the content type and metadata a copy of an object should have.
+type wantMetadata struct {
+	contentType string
+	metadata    map[string]string
+	// compare content types by their normalizeContentType form.
+	foldContentType bool
+}
+
+// what a copy of the source object with attrs, copied to key, should have.
+// the content type map wins over the source's content type.
+func (opts mirrorOpts) wantMetadata(attrs *blob.Attributes, key string) wantMetadata {
+	want := wantMetadata{contentType: opts.contentTypes.lookup(key), metadata: make(map[string]string)}
+	if want.contentType == "" {
+		want.contentType = attrs.ContentType
+	}
+	if opts.normalizeContentType {
+		want.contentType = normalizeContentType(want.contentType)
+		want.foldContentType = true
+	}
+	for k, v := range attrs.Metadata {
+		if !ownMetadata(k) {
+			want.metadata[strings.ToLower(k)] = v
+		}
+	}
+	return want
+}
+
+// reports whether an object with dattrs has different metadata than wanted.
+// keys are compared without case, since S3 lowercases them.
+func (want wantMetadata) differs(dattrs *blob.Attributes) bool {
+	got := dattrs.ContentType
+	if want.foldContentType {
+		got = normalizeContentType(got)
+	}
+	if want.contentType != "" && want.contentType != got {
+		return true
+	}
+	n := 0
+	for k, v := range dattrs.Metadata {
+		if ownMetadata(k) {
+			continue
+		}
+		n++
+		if wanted, ok := want.metadata[strings.ToLower(k)]; !ok || wanted != v {
+			return true
+		}
+	}
+	return n != len(want.metadata)
+}
+
+// sets the metadata of key in dbkt by copying the object onto itself, which
+// S3 and GCS do without the bytes leaving the provider. blobcopy's own metadata
+// on the object is kept. Other backends get ErrMetadataInPlace.
+func updateMetadata(ctx context.Context, dbkt Bucket, key string, want wantMetadata, dattrs *blob.Attributes) error {
+	meta := make(map[string]string)
+	for k, v := range want.metadata {
+		meta[k] = v
+	}
+	for k, v := range dattrs.Metadata {
+		if ownMetadata(k) {
+			meta[strings.ToLower(k)] = v
+		}
+	}
+	contentType := want.contentType
+	if contentType == "" {
+		contentType = dattrs.ContentType
+	}
+	return dbkt.Copy(ctx, key, key, &blob.CopyOptions{BeforeCopy: replaceMetadata(contentType, meta)})
+}
+
+// a CopyOptions.BeforeCopy that gives the copy this content type and metadata,
+// rather than the ones of the object it's copied from.
+func replaceMetadata(contentType string, meta map[string]string) func(func(interface{}) bool) error {
+	return func(as func(interface{}) bool) error {
+		var v1 *s3.CopyObjectInput
+		var v2 *s3v2.CopyObjectInput
+		var copier *storage.Copier
+		switch {
+		case as(&v1):
+			v1.MetadataDirective = aws.String(s3.MetadataDirectiveReplace)
+			v1.Metadata = aws.StringMap(meta)
+			v1.ContentType = aws.String(contentType)
+		case as(&v2):
+			v2.MetadataDirective = s3v2types.MetadataDirectiveReplace
+			v2.Metadata = meta
+			v2.ContentType = aws.String(contentType)
+		case as(&copier):
+			copier.ObjectAttrs.Metadata = meta
+			copier.ObjectAttrs.ContentType = contentType
+		default:
+			return ErrMetadataInPlace
+		}
+		return nil
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	s3v2 "github.com/aws/aws-sdk-go-v2/service/s3"
+	s3v2types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"gocloud.dev/blob"
+)
+
+func TestWantMetadata(t *testing.T) {
+	opts := mirrorOpts{contentTypes: contentTypeMap{".js": "application/javascript"}}
+	src := &blob.Attributes{ContentType: "text/plain", Metadata: map[string]string{"Owner": "me", md5Meta: "x"}}
+	want := opts.wantMetadata(src, "app.js")
+	if want.contentType != "application/javascript" {
+		t.Errorf("the content type map should win, got %q", want.contentType)
+	}
+	if len(want.metadata) != 1 || want.metadata["owner"] != "me" {
+		t.Errorf("unexpected metadata %v", want.metadata)
+	}
+	for _, tc := range []struct {
+		dattrs  *blob.Attributes
+		differs bool
+	}{
+		{&blob.Attributes{ContentType: "application/javascript", Metadata: map[string]string{"owner": "me"}}, false},
+		// blobcopy's own metadata doesn't count.
+		{&blob.Attributes{ContentType: "application/javascript", Metadata: map[string]string{"owner": "me", md5Meta: "y"}}, false},
+		{&blob.Attributes{ContentType: "text/plain", Metadata: map[string]string{"owner": "me"}}, true},
+		{&blob.Attributes{ContentType: "application/javascript", Metadata: map[string]string{"owner": "you"}}, true},
+		{&blob.Attributes{ContentType: "application/javascript"}, true},
+		{&blob.Attributes{ContentType: "application/javascript", Metadata: map[string]string{"owner": "me", "extra": "1"}}, true},
+	} {
+		if got := want.differs(tc.dattrs); got != tc.differs {
+			t.Errorf("%+v: expected differs %v, got %v", tc.dattrs, tc.differs, got)
+		}
+	}
+}
+
+// same content, different content type. the destination is brought up to date.
+func TestCompareMetadata(t *testing.T) {
+	ctx := context.Background()
+	sbkt, dbkt := testMemBuckets(t)
+	if err := sbkt.WriteAll(ctx, "page", []byte("<html></html>"), &blob.WriterOptions{ContentType: "text/html", Metadata: map[string]string{"lang": "en"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := dbkt.WriteAll(ctx, "page", []byte("<html></html>"), &blob.WriterOptions{ContentType: "text/plain"}); err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error)
+	go func() {
+		for err := range errs {
+			t.Error(err)
+		}
+	}()
+	if n := mirror(ctx, sbkt, dbkt, nil, mirrorOpts{verifymd5: true}, errs); n != 0 {
+		t.Fatalf("without -compare-metadata the matching content is left alone, copied %d", n)
+	}
+	// memory buckets can't update metadata in place, so it's copied again.
+	if err := updateMetadata(ctx, dbkt, "page", wantMetadata{}, &blob.Attributes{}); !errors.Is(err, ErrMetadataInPlace) {
+		t.Errorf("expected ErrMetadataInPlace, got %v", err)
+	}
+	if n := mirror(ctx, sbkt, dbkt, nil, mirrorOpts{verifymd5: true, compareMetadata: true}, errs); n != 1 {
+		t.Fatalf("expected the object to be copied with its metadata, copied %d", n)
+	}
+	attrs, err := dbkt.Attributes(ctx, "page")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs.ContentType != "text/html" || attrs.Metadata["lang"] != "en" {
+		t.Errorf("metadata not updated: %q %v", attrs.ContentType, attrs.Metadata)
+	}
+	if n := mirror(ctx, sbkt, dbkt, nil, mirrorOpts{verifymd5: true, compareMetadata: true}, errs); n != 0 {
+		t.Errorf("the metadata matches now, copied %d", n)
+	}
+}
+
+// a hashed key's original is kept next to the source's metadata, not instead of it.
+func TestCompareMetadataHashedKey(t *testing.T) {
+	ctx := context.Background()
+	sbkt, dbkt := testMemBuckets(t)
+	key := strings.Repeat("k", 50)
+	if err := sbkt.WriteAll(ctx, key, []byte("content"), &blob.WriterOptions{Metadata: map[string]string{"owner": "me"}}); err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error)
+	go func() {
+		for err := range errs {
+			t.Error(err)
+		}
+	}()
+	opts := mirrorOpts{verifymd5: true, compareMetadata: true, keyHash: keyHashSHA256, maxKeyLength: 20}
+	if n := mirror(ctx, sbkt, dbkt, nil, opts, errs); n != 1 {
+		t.Fatalf("expected 1 object copied, got %d", n)
+	}
+	hashed, err := hashKey(key, keyHashSHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attrs, err := dbkt.Attributes(ctx, hashed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs.Metadata["owner"] != "me" || attrs.Metadata[origKeyMeta] != key {
+		t.Errorf("expected both the source's metadata and the original key, got %v", attrs.Metadata)
+	}
+	if n := mirror(ctx, sbkt, dbkt, nil, opts, errs); n != 0 {
+		t.Errorf("the metadata matches, copied %d", n)
+	}
+	close(errs)
+}
+
+func TestReplaceMetadata(t *testing.T) {
+	meta := map[string]string{"lang": "en"}
+	v1 := &s3.CopyObjectInput{}
+	if err := replaceMetadata("text/html", meta)(testAs(v1)); err != nil {
+		t.Fatal(err)
+	}
+	if aws.StringValue(v1.MetadataDirective) != s3.MetadataDirectiveReplace || aws.StringValue(v1.ContentType) != "text/html" || aws.StringValue(v1.Metadata["lang"]) != "en" {
+		t.Errorf("unexpected v1 input %v", v1)
+	}
+	v2 := &s3v2.CopyObjectInput{}
+	if err := replaceMetadata("text/html", meta)(testAs(v2)); err != nil {
+		t.Fatal(err)
+	}
+	if v2.MetadataDirective != s3v2types.MetadataDirectiveReplace || v2.Metadata["lang"] != "en" {
+		t.Errorf("unexpected v2 input %v", v2)
+	}
+	copier := &storage.Copier{}
+	if err := replaceMetadata("text/html", meta)(testAs(copier)); err != nil {
+		t.Fatal(err)
+	}
+	if copier.ObjectAttrs.ContentType != "text/html" || copier.ObjectAttrs.Metadata["lang"] != "en" {
+		t.Errorf("unexpected copier attrs %v", copier.ObjectAttrs)
+	}
+}
+
+// content types that only differ in case are treated as equal with -normalize-content-type-case.
+func TestCompareMetadataContentTypeCase(t *testing.T) {
+	ctx := context.Background()
+	sbkt, dbkt := testMemBuckets(t)
+	if err := sbkt.WriteAll(ctx, "page", []byte("<html></html>"), &blob.WriterOptions{ContentType: "Text/HTML; Charset=UTF-8"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := dbkt.WriteAll(ctx, "page", []byte("<html></html>"), &blob.WriterOptions{ContentType: "text/html; charset=utf-8"}); err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error)
+	go func() {
+		for err := range errs {
+			t.Error(err)
+		}
+	}()
+	if n := mirror(ctx, sbkt, dbkt, nil, mirrorOpts{verifymd5: true, compareMetadata: true, normalizeContentType: true}, errs); n != 0 {
+		t.Errorf("the content types only differ in case, copied %d", n)
+	}
+	if n := mirror(ctx, sbkt, dbkt, nil, mirrorOpts{verifymd5: true, compareMetadata: true}, errs); n != 1 {
+		t.Errorf("without normalizing, the content type is updated, copied %d", n)
+	}
+
+	// written in lowercase.
+	sbkt, dbkt = testMemBuckets(t)
+	if err := sbkt.WriteAll(ctx, "page", []byte("<html></html>"), &blob.WriterOptions{ContentType: "Text/HTML"}); err != nil {
+		t.Fatal(err)
+	}
+	if n := mirror(ctx, sbkt, dbkt, nil, mirrorOpts{verifymd5: true, compareMetadata: true, normalizeContentType: true}, errs); n != 1 {
+		t.Fatalf("expected 1 object copied, got %d", n)
+	}
+	close(errs)
+	attrs, err := dbkt.Attributes(ctx, "page")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs.ContentType != "text/html" {
+		t.Errorf("expected text/html, got %q", attrs.ContentType)
+	}
+}
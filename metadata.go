@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// metadata key=value pairs an object must all have to be copied.
+// it's a flag.Value, so -metadata-filter can be repeated.
+type metadataFilter map[string]string
+
+func (f metadataFilter) String() string {
+	pairs := make([]string, 0, len(f))
+	for k, v := range f {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// metadata keys are case insensitive, and go-cloud hands them to us lowercased.
+func (f metadataFilter) Set(pair string) error {
+	k, v, ok := strings.Cut(pair, "=")
+	if !ok || k == "" {
+		return fmt.Errorf("bad metadata filter %q, expected key=value", pair)
+	}
+	f[strings.ToLower(k)] = v
+	return nil
+}
+
+// reports whether md has every key=value pair of the filter. An empty filter matches everything.
+func (f metadataFilter) match(md map[string]string) bool {
+	for k, v := range f {
+		if got, ok := md[k]; !ok || got != v {
+			return false
+		}
+	}
+	return true
+}
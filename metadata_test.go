@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"gocloud.dev/blob"
+)
+
+func TestMetadataFilter(t *testing.T) {
+	ctx := context.Background()
+	sbkt, dbkt := testMemBuckets(t)
+
+	f := make(metadataFilter)
+	for _, pair := range []string{"Env=prod", "team=storage"} {
+		if err := f.Set(pair); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.Set("novalue"); err == nil {
+		t.Error("expected an error for a filter without a value")
+	}
+
+	for key, md := range map[string]map[string]string{
+		"match":     {"env": "prod", "team": "storage"},
+		"extra":     {"env": "prod", "team": "storage", "owner": "me"},
+		"wrong-env": {"env": "dev", "team": "storage"},
+		"no-team":   {"env": "prod"},
+		"none":      nil,
+	} {
+		if err := sbkt.WriteAll(ctx, key, []byte(key), &blob.WriterOptions{Metadata: md}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	errs := make(chan error)
+	go func() {
+		for err := range errs {
+			t.Error(err)
+		}
+	}()
+	n := mirror(ctx, sbkt, dbkt, nil, mirrorOpts{metadata: f}, errs)
+	if n != 2 {
+		t.Errorf("expected 2 objects copied, got %d", n)
+	}
+	for key, expected := range map[string]bool{
+		"match":     true,
+		"extra":     true,
+		"wrong-env": false,
+		"no-team":   false,
+		"none":      false,
+	} {
+		exists, err := dbkt.Exists(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exists != expected {
+			t.Errorf("%s: expected copied %v, got %v", key, expected, exists)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"os"
+)
+
+var ErrNotLocal = errors.New("-mkdir-dest only works with file:// destinations")
+
+// the directory of a file:// bucket URL. ok is false for other schemes.
+func localDir(bucketURL string) (dir string, ok bool) {
+	u, err := url.Parse(bucketURL)
+	if err != nil || u.Scheme != "file" {
+		return "", false
+	}
+	return u.Path, true
+}
+
+// creates the directory of a local destination, so writing to a new one doesn't fail.
+func mkdirDest(bucketURL string) error {
+	dir, ok := localDir(bucketURL)
+	if !ok {
+		return ErrNotLocal
+	}
+	return os.MkdirAll(dir, 0o755)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"gocloud.dev/blob"
+)
+
+func TestMkdirDest(t *testing.T) {
+	ctx := context.Background()
+	dir := filepath.Join(t.TempDir(), "new", "nested")
+	dst := "file://" + filepath.ToSlash(dir)
+	if got, ok := localDir(dst); !ok || got != filepath.ToSlash(dir) {
+		t.Errorf("unexpected local dir %q %v", got, ok)
+	}
+	if err := mkdirDest(dst); err != nil {
+		t.Fatal(err)
+	}
+	bkt, err := blob.OpenBucket(ctx, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bkt.Close()
+	if err := bkt.WriteAll(ctx, "file", []byte("x"), nil); err != nil {
+		t.Fatal(err)
+	}
+	// already there is fine.
+	if err := mkdirDest(dst); err != nil {
+		t.Error(err)
+	}
+
+	for _, dst := range []string{"s3://bucket", "mem://", "gs://bucket/dir"} {
+		if _, ok := localDir(dst); ok {
+			t.Errorf("%s: not a local destination", dst)
+		}
+		if err := mkdirDest(dst); !errors.Is(err, ErrNotLocal) {
+			t.Errorf("%s: expected ErrNotLocal, got %v", dst, err)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+	"os"
+
+	"gocloud.dev/blob"
+)
+
+var ErrModeUnsupported = errors.New("file modes are only kept between file:// buckets")
+
+// the permission bits of a file in a local source. false for other backends.
+func sourceMode(attrs *blob.Attributes) (os.FileMode, bool) {
+	var fi os.FileInfo
+	if !attrs.As(&fi) {
+		return 0, false
+	}
+	return fi.Mode().Perm(), true
+}
+
+// a WriterOptions.BeforeWrite that gives a local destination file the mode.
+// fileblob writes to a temporary file that's renamed into place, which keeps it.
+func setMode(mode os.FileMode) func(func(interface{}) bool) error {
+	return func(as func(interface{}) bool) error {
+		var f *os.File
+		if !as(&f) {
+			return ErrModeUnsupported
+		}
+		return f.Chmod(mode)
+	}
+}
+
+// reports whether bkt is a local directory, with files that have modes.
+func modeSupported(bkt Bucket) bool {
+	var fi os.FileInfo
+	return bkt.As(&fi)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gocloud.dev/blob"
+)
+
+func TestPreserveMode(t *testing.T) {
+	ctx := context.Background()
+	srcDir, dstDir := t.TempDir(), t.TempDir()
+	modes := map[string]os.FileMode{"secret": 0o600, "script": 0o755, "public": 0o644}
+	for name, mode := range modes {
+		path := filepath.Join(srcDir, name)
+		if err := os.WriteFile(path, []byte(name), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(path, mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sbkt, err := blob.OpenBucket(ctx, "file://"+srcDir+"?metadata=skip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sbkt.Close()
+	dbkt, err := blob.OpenBucket(ctx, "file://"+dstDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbkt.Close()
+	if !modeSupported(sbkt) || !modeSupported(dbkt) {
+		t.Fatal("file buckets should support modes")
+	}
+	errs := make(chan error)
+	go func() {
+		for err := range errs {
+			t.Error(err)
+		}
+	}()
+	if n := mirror(ctx, sbkt, dbkt, nil, mirrorOpts{preserveMode: true}, errs); n != len(modes) {
+		t.Fatalf("copied %d files", n)
+	}
+	for name, mode := range modes {
+		fi, err := os.Stat(filepath.Join(dstDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode().Perm() != mode {
+			t.Errorf("%s: expected mode %v, got %v", name, mode, fi.Mode().Perm())
+		}
+	}
+}
+
+func TestModeUnsupported(t *testing.T) {
+	bkt, _ := testMemBuckets(t)
+	if modeSupported(bkt) {
+		t.Error("memory buckets don't have modes")
+	}
+	if err := setMode(0o600)(testAs(struct{}{})); err != ErrModeUnsupported {
+		t.Errorf("expected ErrModeUnsupported, got %v", err)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+
+	"gocloud.dev/blob"
+)
+
+var ErrMoveMismatch = errors.New("the destination doesn't round trip to the source")
+
+// md5 of the content of key in bkt, after the transforms.
+func transformedMD5(ctx context.Context, bkt Bucket, key string, ts []transform, ropts *blob.ReaderOptions) ([]byte, error) {
+	r, err := bkt.NewReader(ctx, key, ropts)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	h := md5.New()
+	if _, err := transformCopy(h, r, ts); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// checks that dstKey in dbkt turns back into the source object.
+// the destination is read and the transforms are undone: what was encrypted is
+// decrypted, what was compressed is decompressed, and what was decrypted is encrypted again. encryption is
+// deterministic, so that gives back the source bytes exactly. A wrong key or a
+// damaged object fails here, before the source is gone.
+func verifyMoved(ctx context.Context, sbkt Bucket, key string, dbkt Bucket, dstKey string, opts mirrorOpts) error {
+	want, err := transformedMD5(ctx, sbkt, key, nil, nil)
+	if err != nil {
+		return fmt.Errorf("unable to read source %s: %w", key, err)
+	}
+	var got []byte
+	err = opts.consistency.do(ctx, func() error {
+		var codec *compressCodec
+		if opts.compress {
+			codec = opts.destCodec(ctx, dbkt, dstKey)
+		}
+		got, err = transformedMD5(ctx, dbkt, dstKey, opts.reverseTransforms(codec), opts.destReadOptions())
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("unable to read back %s [%s]: %w", key, dstKey, err)
+	}
+	if !bytes.Equal(want, got) {
+		return fmt.Errorf("%s [%s]: %w", key, dstKey, ErrMoveMismatch)
+	}
+	return nil
+}
+
+// deletes key from the source, once every destination has been verified.
+func moveSource(ctx context.Context, sbkt Bucket, key string, dbkts []Bucket, dstKey string, opts mirrorOpts) error {
+	for _, dbkt := range dbkts {
+		if err := verifyMoved(ctx, sbkt, key, dbkt, dstKey, opts); err != nil {
+			return fmt.Errorf("not deleting from the source: %w", err)
+		}
+	}
+	return sbkt.Delete(ctx, key)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"gocloud.dev/blob"
+)
+
+// an encrypted source, moved with -decrypt.
+func testMoveSource(t *testing.T, key []byte) *blob.Bucket {
+	t.Helper()
+	ctx := context.Background()
+	sbkt, _ := testMemBuckets(t)
+	text, err := encrypt([]byte("hello"), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sbkt.WriteAll(ctx, "a", text, nil); err != nil {
+		t.Fatal(err)
+	}
+	return sbkt
+}
+
+func testMove(t *testing.T, sbkt, dbkt *blob.Bucket, opts mirrorOpts) int {
+	t.Helper()
+	ctx := context.Background()
+	tmpBkt, _ := testMemBuckets(t)
+	errs := make(chan error)
+	errsN := 0
+	done := make(chan bool)
+	go func() {
+		for err := range errs {
+			t.Log(err)
+			errsN++
+		}
+		close(done)
+	}()
+	opts.move = true
+	opts.verifymd5 = true
+	mirror(ctx, sbkt, dbkt, tmpBkt, opts, errs)
+	close(errs)
+	<-done
+	return errsN
+}
+
+func TestMove(t *testing.T) {
+	ctx := context.Background()
+	key := testAuthentication(t)
+	sbkt := testMoveSource(t, key)
+	_, dbkt := testMemBuckets(t)
+	if n := testMove(t, sbkt, dbkt, mirrorOpts{bytesDecrypt: key}); n != 0 {
+		t.Fatalf("%d errors", n)
+	}
+	if got := testReadString(t, dbkt, "a"); got != "hello" {
+		t.Errorf("destination has %q", got)
+	}
+	if exists, err := sbkt.Exists(ctx, "a"); err != nil || exists {
+		t.Errorf("the source should be gone, exists %v err %v", exists, err)
+	}
+}
+
+func TestMoveWrongKey(t *testing.T) {
+	ctx := context.Background()
+	sbkt := testMoveSource(t, testAuthentication(t))
+	_, dbkt := testMemBuckets(t)
+	if n := testMove(t, sbkt, dbkt, mirrorOpts{bytesDecrypt: testAuthentication(t)}); n == 0 {
+		t.Error("decrypting with the wrong key should fail")
+	}
+	if exists, _ := sbkt.Exists(ctx, "a"); !exists {
+		t.Error("the source was deleted after a failed decrypt")
+	}
+}
+
+func TestMoveReadBackFails(t *testing.T) {
+	ctx := context.Background()
+	key := testAuthentication(t)
+	sbkt := testMoveSource(t, key)
+	dbkt := testFaultBucket(t, &faultBucket{read: func(string) error { return errors.New("corrupt") }})
+	if n := testMove(t, sbkt, dbkt, mirrorOpts{bytesDecrypt: key}); n == 0 {
+		t.Error("a destination that can't be read back should fail the move")
+	}
+	if exists, _ := sbkt.Exists(ctx, "a"); !exists {
+		t.Error("the source was deleted without verifying the destination")
+	}
+}
+
+func TestVerifyMoved(t *testing.T) {
+	ctx := context.Background()
+	key := testAuthentication(t)
+	sbkt := testMoveSource(t, key)
+	_, dbkt := testMemBuckets(t)
+	opts := mirrorOpts{bytesDecrypt: key}
+	if err := dbkt.WriteAll(ctx, "a", []byte("hello"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyMoved(ctx, sbkt, "a", dbkt, "a", opts); err != nil {
+		t.Error(err)
+	}
+	if err := dbkt.WriteAll(ctx, "a", []byte("hellO"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyMoved(ctx, sbkt, "a", dbkt, "a", opts); !errors.Is(err, ErrMoveMismatch) {
+		t.Errorf("expected ErrMoveMismatch, got %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var ErrNonceSize = errors.New("nonce is too short to be picked at random")
+
+// random gcm nonces have to be at least this many bytes. with 96 bits, a key
+// can seal about 2^32 messages before the chance of two sharing a nonce gets
+// past 2^-32, which is the limit NIST gives for gcm.
+const minRandomNonce = 12
+
+// where random nonces come from. only tests change it.
+var nonceSource io.Reader = rand.Reader
+
+// a fresh nonce for gcm, from the system's CSPRNG.
+func randomNonce(gcm cipher.AEAD) ([]byte, error) {
+	if gcm.NonceSize() < minRandomNonce {
+		return nil, fmt.Errorf("%w: %d bytes, it needs %d", ErrNonceSize, gcm.NonceSize(), minRandomNonce)
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(nonceSource, nonce); err != nil {
+		return nil, err
+	}
+	return nonce, nil
+}
+
+// like encrypt, but with a random nonce, for things that don't need to come out
+// the same every time, like the state file. decrypt opens either one.
+func encryptRandom(text []byte, key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return text, nil
+	}
+	aead, err := newAEAD(sealCipher, key)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := randomNonce(aead)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(append(cipherHeader(sealCipher), nonce...), nonce, text, nil), nil
+}
+
+// what the keys -split-keys derives are for. they're part of the derivation,
+// so changing one changes the key.
+const (
+	keyPurposeNames   = "blobcopy names"
+	keyPurposeContent = "blobcopy content"
+)
+
+// a key for one purpose, made from key with HMAC-SHA256. keys for different
+// purposes have nothing to do with each other, so names and content sealed
+// with nonces from the same md5 never share a key.
+func deriveKey(key []byte, purpose string) []byte {
+	if len(key) == 0 {
+		return nil
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+// the name and content keys for key, which are key itself unless split.
+func splitKey(key []byte, split bool) (names, content []byte) {
+	if !split {
+		return key, key
+	}
+	return deriveKey(key, keyPurposeNames), deriveKey(key, keyPurposeContent)
+}
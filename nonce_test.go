@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRandomNonce(t *testing.T) {
+	c, err := aes.NewCipher(testAuthentication(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCM(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]bool{}
+	for i := 0; i < 10000; i++ {
+		nonce, err := randomNonce(gcm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(nonce) < minRandomNonce {
+			t.Fatalf("nonce of %d bytes", len(nonce))
+		}
+		if seen[string(nonce)] {
+			t.Fatalf("nonce %x came up twice", nonce)
+		}
+		seen[string(nonce)] = true
+	}
+
+	short, err := cipher.NewGCMWithNonceSize(c, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := randomNonce(short); !errors.Is(err, ErrNonceSize) {
+		t.Errorf("expected ErrNonceSize for an 8 byte nonce, got %v", err)
+	}
+
+	orig := nonceSource
+	t.Cleanup(func() { nonceSource = orig })
+	nonceSource = strings.NewReader("short")
+	if _, err := randomNonce(gcm); err == nil {
+		t.Error("expected an error when the random source runs out")
+	}
+}
+
+func TestEncryptRandom(t *testing.T) {
+	key := testAuthentication(t)
+	text := testRandomData(t)
+	a, err := encryptRandom(text, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := encryptRandom(text, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(a, b) {
+		t.Error("the same text sealed twice came out the same")
+	}
+	for _, cypherText := range [][]byte{a, b} {
+		plain, err := decrypt(cypherText, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(plain, text) {
+			t.Error("decrypted text doesn't match")
+		}
+	}
+}
+
+func TestSplitKey(t *testing.T) {
+	key := testAuthentication(t)
+	names, content := splitKey(key, false)
+	if !bytes.Equal(names, key) || !bytes.Equal(content, key) {
+		t.Error("without splitting, both keys should be the key")
+	}
+	names, content = splitKey(key, true)
+	if len(names) != keyLength || len(content) != keyLength {
+		t.Fatalf("derived keys of %d and %d bytes", len(names), len(content))
+	}
+	if bytes.Equal(names, content) || bytes.Equal(names, key) || bytes.Equal(content, key) {
+		t.Error("split keys should all differ")
+	}
+	names2, content2 := splitKey(key, true)
+	if !bytes.Equal(names, names2) || !bytes.Equal(content, content2) {
+		t.Error("split keys should be the same for the same key")
+	}
+	if n, c := splitKey(nil, true); n != nil || c != nil {
+		t.Error("no key should split into no keys")
+	}
+
+	// the same text, as a name and as content, doesn't share a key and nonce.
+	encName, err := makeKey("file", names, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encContent, err := encrypt([]byte("file"), content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plainName, err := makeKey(encName, nil, names); err != nil || plainName != "file" {
+		t.Errorf("name doesn't decrypt with the names key: %q %v", plainName, err)
+	}
+	if _, err := makeKey(encName, nil, content); err == nil {
+		t.Error("name shouldn't decrypt with the content key")
+	}
+	if _, err := decrypt(encContent, names); err == nil {
+		t.Error("content shouldn't decrypt with the names key")
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+var ErrKeyCollision = errors.New("normalizes to the same key as")
+
+// cleans up a key so the same logical path gets the same key on every provider.
+// leading slashes and ./ and ../ segments are removed and repeated slashes are collapsed.
+// a trailing slash is kept, since it marks a directory placeholder on most providers.
+func normalizeKey(key string) string {
+	if key == "" {
+		return key
+	}
+	clean := strings.TrimLeft(path.Clean("/"+key), "/")
+	if clean != "" && strings.HasSuffix(key, "/") {
+		clean += "/"
+	}
+	return clean
+}
+
+// the source keys that claimed each destination key in this run,
+// so two keys that normalize to one can be told apart.
+type claimedKey struct {
+	key     string
+	modTime time.Time
+}
+
+// decides whether an object that normalizes to the same destination key as an
+// object already copied in this run should replace it.
+//   - newer: the object with the newer ModTime wins.
+//   - source: the object listed last wins.
+//   - dest or skip: the object listed first, which is already in the destination, stays.
+func collisionWins(policy string, first claimedKey, modTime time.Time) bool {
+	switch policy {
+	case conflictNewer:
+		return modTime.After(first.modTime)
+	case conflictSource:
+		return true
+	}
+	return false
+}
+
+func collisionError(key, dstKey string, first claimedKey, policy string) error {
+	return fmt.Errorf("%s %w %s (%s), resolving with policy %s", key, ErrKeyCollision, first.key, dstKey, policy)
+}
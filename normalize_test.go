@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNormalizeKey(t *testing.T) {
+	for key, expected := range map[string]string{
+		"":              "",
+		"file":          "file",
+		"/file":         "file",
+		"//dir//file":   "dir/file",
+		"./dir/./file":  "dir/file",
+		"dir/../file":   "file",
+		"../file":       "file",
+		"dir/":          "dir/",
+		"/dir/sub/./":   "dir/sub/",
+		"/":             "",
+		"dir/file.tar.": "dir/file.tar.",
+	} {
+		if got := normalizeKey(key); got != expected {
+			t.Errorf("%q: expected %q, got %q", key, expected, got)
+		}
+	}
+}
+
+// keys that normalize to the same key are copied once, and the policy picks which one.
+func TestNormalizeKeysCollision(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		policy   string
+		expected string
+	}{
+		{conflictNewer, "new"},
+		{conflictSource, "new"},
+		{conflictDest, "old"},
+		{conflictSkip, "old"},
+	} {
+		sbkt, dbkt := testMemBuckets(t)
+		// "dir/./file" is listed before "dir/file", and is older.
+		if err := sbkt.WriteAll(ctx, "dir/./file", []byte("old"), nil); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+		if err := sbkt.WriteAll(ctx, "dir/file", []byte("new"), nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := sbkt.WriteAll(ctx, "/other", []byte("other"), nil); err != nil {
+			t.Fatal(err)
+		}
+
+		errs := make(chan error)
+		done := make(chan int)
+		go func() {
+			n := 0
+			for err := range errs {
+				if !errors.Is(err, ErrKeyCollision) {
+					t.Error(err)
+				}
+				n++
+			}
+			done <- n
+		}()
+		mirror(ctx, sbkt, dbkt, nil, mirrorOpts{normalizeKeys: true, onCollision: tc.policy}, errs)
+		close(errs)
+		if n := <-done; n != 1 {
+			t.Errorf("%s: expected one collision, got %d", tc.policy, n)
+		}
+		if got := testReadString(t, dbkt, "dir/file"); got != tc.expected {
+			t.Errorf("%s: expected %q, got %q", tc.policy, tc.expected, got)
+		}
+		if got := testReadString(t, dbkt, "other"); got != "other" {
+			t.Errorf("%s: leading slash not removed, got %q", tc.policy, got)
+		}
+		objs, err := listAll(ctx, dbkt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(objs) != 2 {
+			t.Errorf("%s: expected 2 objects in destination, got %d", tc.policy, len(objs))
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+)
+
+var ErrPanic = errors.New("recovered from a panic")
+
+// runs f, and reports whether it returned rather than panicked. a panic is
+// passed to onPanic as an ErrPanic.
+func runRecovered(f func(), onPanic func(error)) (returned bool) {
+	defer func() {
+		if p := recover(); p != nil {
+			onPanic(panicError(p))
+		}
+	}()
+	f()
+	return true
+}
+
+// the error for a recovered panic. the stack is logged, it's what a bug report needs.
+func panicError(p interface{}) error {
+	errLogger.Printf("%v: %v\n%s", ErrPanic, p, debug.Stack())
+	return fmt.Errorf("%w: %v", ErrPanic, p)
+}
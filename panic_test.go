@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// reading "bad" panics, like a backend returning something unexpected.
+func testPanickyBucket(t *testing.T) *faultBucket {
+	t.Helper()
+	sbkt, _ := testMemBuckets(t)
+	for _, key := range []string{"a", "bad", "c"} {
+		if err := sbkt.WriteAll(context.Background(), key, []byte("content of "+key), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &faultBucket{bkt: sbkt, read: func(key string) error {
+		if key == "bad" {
+			var b []byte
+			_ = b[5]
+		}
+		return nil
+	}}
+}
+
+// the object that panicked fails, and the run carries on with the rest.
+func TestPanicRecovery(t *testing.T) {
+	ctx := context.Background()
+	for _, fanout := range []bool{false, true} {
+		src := testFaultBucket(t, testPanickyBucket(t))
+		_, dbkt := testMemBuckets(t)
+		_, other := testMemBuckets(t)
+		dbkts := []Bucket{dbkt}
+		if fanout {
+			dbkts = append(dbkts, other)
+		}
+		errs := make(chan error)
+		done := make(chan []error)
+		go func() {
+			var got []error
+			for err := range errs {
+				got = append(got, err)
+			}
+			done <- got
+		}()
+		n := mirrorMany(ctx, src, dbkts, nil, mirrorOpts{}, errs)
+		close(errs)
+		got := <-done
+		if n != 2 {
+			t.Errorf("fanout %v: expected 2 objects copied, got %d", fanout, n)
+		}
+		if len(got) != 1 || !errors.Is(got[0], ErrPanic) {
+			t.Errorf("fanout %v: expected one panic error, got %v", fanout, got)
+		}
+		if ok, _ := dbkt.Exists(ctx, "c"); !ok {
+			t.Errorf("fanout %v: expected the object after the panic copied", fanout)
+		}
+	}
+}
+
+// a panic in a goroutine of a ranged copy is an error of that copy.
+func TestPanicRecoveryRanges(t *testing.T) {
+	ctx := context.Background()
+	src := testFaultBucket(t, testPanickyBucket(t))
+	_, dbkt := testMemBuckets(t)
+	_, err := copyObjRanges(ctx, src, dbkt, "bad", "bad", int64(len("content of bad")), 2, 4, nil)
+	if !errors.Is(err, ErrPanic) {
+		t.Errorf("expected a panic error, got %v", err)
+	}
+}
+
+func TestPanicRecoveryVerify(t *testing.T) {
+	ctx := context.Background()
+	src := testFaultBucket(t, testPanickyBucket(t))
+	_, dbkt := testMemBuckets(t)
+	for _, key := range []string{"a", "bad", "c"} {
+		if err := dbkt.WriteAll(ctx, key, []byte("content of "+key), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	errs := make(chan error)
+	go func() {
+		for range errs {
+		}
+	}()
+	report := verify(ctx, src, dbkt, mirrorOpts{verifymd5: true, distrustMD5: true}, errs)
+	close(errs)
+	if report.checked != 3 || report.problems != 1 {
+		t.Errorf("expected 3 objects checked with one problem, got %d and %d", report.checked, report.problems)
+	}
+}
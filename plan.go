@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+)
+
+var ErrPlanDrift = errors.New("changed since the plan was made")
+
+// what a plan does with an object.
+const (
+	planCopy           = "copy"
+	planUpdateMetadata = "update-metadata"
+)
+
+// why it does it, besides reasonMissing.
+const (
+	reasonDiffers  = "differs"
+	reasonMetadata = "metadata differs"
+)
+
+// an object as the plan found it. objects without an md5 are told apart by
+// their modification time.
+type planObject struct {
+	Size     int64     `json:"size"`
+	MD5      string    `json:"md5,omitempty"`
+	Modified time.Time `json:"modified"`
+}
+
+func newPlanObject(size int64, md5 []byte, modified time.Time) planObject {
+	return planObject{Size: size, MD5: hex.EncodeToString(md5), Modified: modified}
+}
+
+// reports whether attrs are still the object o was.
+func (o *planObject) same(attrs *blob.Attributes) bool {
+	if o.Size != attrs.Size {
+		return false
+	}
+	if o.MD5 != "" {
+		return o.MD5 == hex.EncodeToString(attrs.MD5)
+	}
+	return o.Modified.Equal(attrs.ModTime)
+}
+
+// one thing a -dry-run would have done.
+type planEntry struct {
+	Key    string     `json:"key"`
+	DstKey string     `json:"dst_key"`
+	Action string     `json:"action"`
+	Reason string     `json:"reason"`
+	Src    planObject `json:"source"`
+	// nil when the destination doesn't have the object.
+	Dst *planObject `json:"destination,omitempty"`
+}
+
+// what a -dry-run with -plan-out found to do, for -apply-plan to do exactly that later.
+type plan struct {
+	Source      string      `json:"source"`
+	Destination string      `json:"destination"`
+	Created     time.Time   `json:"created"`
+	Actions     []planEntry `json:"actions"`
+	// the entries of Actions by source key, once loaded.
+	byKey map[string]*planEntry
+	// the keys whose actions were done by -apply-plan.
+	done map[string]bool
+}
+
+func (p *plan) add(e planEntry) {
+	p.Actions = append(p.Actions, e)
+}
+
+func (p *plan) save(path string) error {
+	raw, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(raw, '\n'), 0o600)
+}
+
+func loadPlan(path string) (*plan, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	var p plan
+	if err := dec.Decode(&p); err != nil {
+		return nil, fmt.Errorf("error reading plan %s: %w", path, err)
+	}
+	seen := make(map[string]bool, len(p.Actions))
+	for i := range p.Actions {
+		e := &p.Actions[i]
+		switch {
+		case e.Key == "" || e.DstKey == "":
+			return nil, fmt.Errorf("error reading plan %s: action %d has no key", path, i)
+		case e.Action != planCopy && e.Action != planUpdateMetadata:
+			return nil, fmt.Errorf("error reading plan %s: %s: unknown action %q", path, e.Key, e.Action)
+		case e.Action == planUpdateMetadata && e.Dst == nil:
+			return nil, fmt.Errorf("error reading plan %s: %s: no destination object to update", path, e.Key)
+		case seen[e.Key]:
+			return nil, fmt.Errorf("error reading plan %s: %s is in it twice", path, e.Key)
+		}
+		seen[e.Key] = true
+	}
+	// in key order, like a listing.
+	sort.Slice(p.Actions, func(i, j int) bool { return p.Actions[i].Key < p.Actions[j].Key })
+	p.byKey = make(map[string]*planEntry, len(p.Actions))
+	for i := range p.Actions {
+		p.byKey[p.Actions[i].Key] = &p.Actions[i]
+	}
+	return &p, nil
+}
+
+// an object that isn't what the plan found.
+type planDrift struct {
+	Key    string
+	Reason string
+}
+
+// checks every object of the plan is still as it was in both buckets, so that
+// applying it does what was reviewed.
+func (p *plan) check(ctx context.Context, sbkt Bucket, dbkt Bucket) ([]planDrift, error) {
+	var drift []planDrift
+	for _, e := range p.Actions {
+		reason, err := e.checkSource(ctx, sbkt)
+		if err != nil {
+			return nil, err
+		}
+		if reason == "" {
+			reason, err = e.checkDest(ctx, dbkt)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if reason != "" {
+			drift = append(drift, planDrift{Key: e.Key, Reason: reason})
+		}
+	}
+	return drift, nil
+}
+
+// why the source object isn't what the plan found, or "" when it is.
+func (e *planEntry) checkSource(ctx context.Context, bkt Bucket) (string, error) {
+	attrs, err := bkt.Attributes(ctx, e.Key)
+	if gcerrors.Code(err) == gcerrors.NotFound {
+		return "gone from the source", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", e.Key, err)
+	}
+	if !e.Src.same(attrs) {
+		return "changed in the source", nil
+	}
+	return "", nil
+}
+
+// why the destination object isn't what the plan found, or "" when it is.
+func (e *planEntry) checkDest(ctx context.Context, bkt Bucket) (string, error) {
+	attrs, err := bkt.Attributes(ctx, e.DstKey)
+	if gcerrors.Code(err) == gcerrors.NotFound {
+		if e.Dst != nil {
+			return "gone from the destination", nil
+		}
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", e.DstKey, err)
+	}
+	preferRecordedMD5(attrs)
+	switch {
+	case e.Dst == nil:
+		return "now in the destination", nil
+	case !e.Dst.same(attrs):
+		return "changed in the destination", nil
+	}
+	return "", nil
+}
+
+// an iterator over the plan's objects in bkt.
+func (p *plan) iterator(bkt Bucket) objectIterator {
+	return &planIterator{p: p, bkt: bkt}
+}
+
+// looks each object up in the source as it goes, so one that changed since
+// the plan was checked isn't copied.
+type planIterator struct {
+	p   *plan
+	bkt Bucket
+	i   int
+}
+
+func (it *planIterator) Next(ctx context.Context) (*blob.ListObject, error) {
+	if it.i == len(it.p.Actions) {
+		return nil, io.EOF
+	}
+	e := it.p.Actions[it.i]
+	it.i++
+	attrs, err := it.bkt.Attributes(ctx, e.Key)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", e.Key, err)
+	}
+	if !e.Src.same(attrs) {
+		return nil, fmt.Errorf("%s: %w", e.Key, ErrPlanDrift)
+	}
+	return &blob.ListObject{Key: e.Key, ModTime: attrs.ModTime, Size: attrs.Size, MD5: attrs.MD5}, nil
+}
+
+// records that the action for key was done. p may be nil.
+func (p *plan) applied(key string) {
+	if p == nil {
+		return
+	}
+	if p.done == nil {
+		p.done = make(map[string]bool)
+	}
+	p.done[key] = true
+}
+
+// the actions that weren't done, like ones that failed or that the state said were done already.
+func (p *plan) unapplied() []planEntry {
+	var es []planEntry
+	for _, e := range p.Actions {
+		if !p.done[e.Key] {
+			es = append(es, e)
+		}
+	}
+	return es
+}
+
+// the destination key the plan has for key.
+func (p *plan) dstKey(key string) string {
+	if e := p.byKey[key]; e != nil {
+		return e.DstKey
+	}
+	return ""
+}
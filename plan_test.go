@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestPlan(t *testing.T) {
+	ctx := context.Background()
+	sbkt, dbkt := testMemBuckets(t)
+	data := map[string][]byte{"a": testRandomData(t), "b": testRandomData(t), "c": testRandomData(t)}
+	for key, b := range data {
+		if err := sbkt.WriteAll(ctx, key, b, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := dbkt.WriteAll(ctx, "a", data["a"], nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := dbkt.WriteAll(ctx, "b", []byte("old"), nil); err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error)
+	go func() {
+		for err := range errs {
+			t.Error(err)
+		}
+	}()
+	p := &plan{Source: "mem://src", Destination: "mem://dst"}
+	if n := mirror(ctx, sbkt, dbkt, nil, mirrorOpts{verifymd5: true, dryRun: true, planOut: p}, errs); n != 2 {
+		t.Fatalf("expected 2 objects to copy, got %d", n)
+	}
+	if len(p.Actions) != 2 {
+		t.Fatalf("expected 2 actions, got %+v", p.Actions)
+	}
+	b, c := p.Actions[0], p.Actions[1]
+	sum, err := sbkt.Attributes(ctx, "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Key != "b" || b.DstKey != "b" || b.Action != planCopy || b.Reason != reasonDiffers || b.Src.MD5 != hex.EncodeToString(sum.MD5) || b.Src.Size != 1024 || b.Dst == nil || b.Dst.Size != 3 {
+		t.Errorf("unexpected action for b: %+v", b)
+	}
+	if c.Key != "c" || c.Action != planCopy || c.Reason != reasonMissing || c.Dst != nil {
+		t.Errorf("unexpected action for c: %+v", c)
+	}
+
+	path := filepath.Join(t.TempDir(), "plan.json")
+	if err := p.save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := loadPlan(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if drift, err := loaded.check(ctx, sbkt, dbkt); err != nil || len(drift) != 0 {
+		t.Fatalf("expected no drift, got %+v %v", drift, err)
+	}
+	// an object that isn't in the plan isn't copied, even though it's missing.
+	if err := sbkt.WriteAll(ctx, "d", testRandomData(t), nil); err != nil {
+		t.Fatal(err)
+	}
+	if n := mirror(ctx, sbkt, dbkt, nil, mirrorOpts{verifymd5: true, applyPlan: loaded}, errs); n != 2 {
+		t.Errorf("expected the 2 planned objects copied, got %d", n)
+	}
+	close(errs)
+	for _, key := range []string{"a", "b", "c"} {
+		if got := testReadString(t, dbkt, key); got != string(data[key]) {
+			t.Errorf("%s: expected the source content, got %d bytes", key, len(got))
+		}
+	}
+	if ok, err := dbkt.Exists(ctx, "d"); err != nil || ok {
+		t.Errorf("expected d not to be copied, exists %v err %v", ok, err)
+	}
+}
+
+func TestPlanDrift(t *testing.T) {
+	ctx := context.Background()
+	sbkt, dbkt := testMemBuckets(t)
+	for _, key := range []string{"changed", "gone", "taken", "same"} {
+		if err := sbkt.WriteAll(ctx, key, testRandomData(t), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	errs := make(chan error)
+	go func() {
+		for err := range errs {
+			t.Error(err)
+		}
+	}()
+	p := &plan{}
+	mirror(ctx, sbkt, dbkt, nil, mirrorOpts{dryRun: true, planOut: p}, errs)
+	path := filepath.Join(t.TempDir(), "plan.json")
+	if err := p.save(path); err != nil {
+		t.Fatal(err)
+	}
+	p, err := loadPlan(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sbkt.WriteAll(ctx, "changed", testRandomData(t), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := sbkt.Delete(ctx, "gone"); err != nil {
+		t.Fatal(err)
+	}
+	if err := dbkt.WriteAll(ctx, "taken", []byte("someone else's"), nil); err != nil {
+		t.Fatal(err)
+	}
+	drift, err := p.check(ctx, sbkt, dbkt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []planDrift{
+		{"changed", "changed in the source"},
+		{"gone", "gone from the source"},
+		{"taken", "now in the destination"},
+	}
+	if len(drift) != len(want) {
+		t.Fatalf("expected %v, got %v", want, drift)
+	}
+	for i := range want {
+		if drift[i] != want[i] {
+			t.Errorf("expected %v, got %v", want[i], drift[i])
+		}
+	}
+
+	// a change after the check isn't copied either.
+	var failed []error
+	errs2 := make(chan error)
+	done := make(chan bool)
+	go func() {
+		for err := range errs2 {
+			failed = append(failed, err)
+		}
+		close(done)
+	}()
+	if n := mirror(ctx, sbkt, dbkt, nil, mirrorOpts{applyPlan: p}, errs2); n != 1 {
+		t.Errorf("expected only the unchanged object copied, got %d", n)
+	}
+	close(errs2)
+	<-done
+	if len(failed) != 2 || !errors.Is(failed[0], ErrPlanDrift) {
+		t.Errorf("expected the changed and gone objects to fail, got %v", failed)
+	}
+	close(errs)
+}
+
+// the state's last key from an earlier listing doesn't leave planned actions out.
+func TestPlanState(t *testing.T) {
+	ctx := context.Background()
+	sbkt, dbkt := testMemBuckets(t)
+	for _, key := range []string{"a", "b", "c"} {
+		if err := sbkt.WriteAll(ctx, key, testRandomData(t), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	errs := make(chan error)
+	go func() {
+		for err := range errs {
+			t.Error(err)
+		}
+	}()
+	p := &plan{}
+	mirror(ctx, sbkt, dbkt, nil, mirrorOpts{dryRun: true, planOut: p}, errs)
+	path := filepath.Join(t.TempDir(), "plan.json")
+	if err := p.save(path); err != nil {
+		t.Fatal(err)
+	}
+	p, err := loadPlan(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := newState()
+	st.LastKey = "z"
+	st.record("c", stateEntry{})
+	if n := mirror(ctx, sbkt, dbkt, nil, mirrorOpts{applyPlan: p, state: st}, errs); n != 2 {
+		t.Errorf("expected a and b copied, got %d", n)
+	}
+	close(errs)
+	if st.LastKey != "z" {
+		t.Errorf("expected the last key left alone, got %q", st.LastKey)
+	}
+	if un := p.unapplied(); len(un) != 1 || un[0].Key != "c" {
+		t.Errorf("expected only c not done, got %+v", un)
+	}
+}
+
+// different flags than the plan was made with would copy somewhere else.
+func TestPlanDestKey(t *testing.T) {
+	ctx := context.Background()
+	sbkt, dbkt := testMemBuckets(t)
+	if err := sbkt.WriteAll(ctx, "file", testRandomData(t), nil); err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error)
+	go func() {
+		for err := range errs {
+			if !errors.Is(err, ErrPlanDrift) {
+				t.Error(err)
+			}
+		}
+	}()
+	p := &plan{}
+	mirror(ctx, sbkt, dbkt, nil, mirrorOpts{dryRun: true, planOut: p}, errs)
+	p.byKey = map[string]*planEntry{"file": &p.Actions[0]}
+	key := testAuthentication(t)
+	if n := mirror(ctx, sbkt, dbkt, nil, mirrorOpts{nameEncrypt: key, bytesEncrypt: key, applyPlan: p}, errs); n != 0 {
+		t.Errorf("expected nothing copied under another key, got %d", n)
+	}
+	close(errs)
+}
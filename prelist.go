@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+
+	"gocloud.dev/blob"
+)
+
+// past this many objects, -prelist-dest warns about the memory it's using.
+const prelistWarn = 1000000
+
+// lists a destination once, so whether each object exists and what its md5 is
+// can be looked up instead of asked for one request at a time.
+func prelistDest(ctx context.Context, bkt Bucket) (map[string]*blob.ListObject, error) {
+	objs, err := listAll(ctx, bkt)
+	if err != nil {
+		return nil, err
+	}
+	if len(objs) > prelistWarn {
+		errLogger.Printf("warning: -prelist-dest is holding %d destination objects in memory\n", len(objs))
+	}
+	return objs, nil
+}
+
+// the attributes of a listed object, when the listing has everything the md5
+// check needs. nil means the destination has to be asked.
+func listedAttrs(obj *blob.ListObject) *blob.Attributes {
+	if obj == nil || len(obj.MD5) == 0 {
+		return nil
+	}
+	return &blob.Attributes{MD5: obj.MD5, Size: obj.Size, ModTime: obj.ModTime}
+}
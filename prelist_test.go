@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"testing"
+)
+
+func TestPrelistDest(t *testing.T) {
+	ctx := context.Background()
+	sbkt, _ := testMemBuckets(t)
+	attrCalls := 0
+	fb := &faultBucket{
+		attributes: func(key string) error {
+			attrCalls++
+			return nil
+		},
+	}
+	dbkt := testFaultBucket(t, fb)
+	for i := 0; i < 10; i++ {
+		key := "file" + strconv.Itoa(i)
+		data := testRandomData(t)
+		if err := sbkt.WriteAll(ctx, key, data, nil); err != nil {
+			t.Fatal(err)
+		}
+		// most of them are already there, one is stale and one is missing.
+		switch i {
+		case 0:
+		case 1:
+			data = []byte("stale")
+			fallthrough
+		default:
+			if err := fb.bkt.WriteAll(ctx, key, data, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	run := func(opts mirrorOpts) int {
+		t.Helper()
+		errs := make(chan error)
+		done := make(chan struct{})
+		go func() {
+			for err := range errs {
+				t.Error(err)
+			}
+			close(done)
+		}()
+		n := mirror(ctx, sbkt, dbkt, nil, opts, errs)
+		close(errs)
+		<-done
+		return n
+	}
+	if n := run(mirrorOpts{verifymd5: true, prelistDest: true}); n != 2 {
+		t.Errorf("expected the missing and the stale object copied, got %d", n)
+	}
+	if attrCalls != 0 {
+		t.Errorf("expected no per-object requests to the destination, got %d", attrCalls)
+	}
+	if got := testReadString(t, fb.bkt, "file1"); got == "stale" {
+		t.Error("stale object not replaced")
+	}
+
+	// without the listing, every object is asked about.
+	attrCalls = 0
+	if n := run(mirrorOpts{verifymd5: true}); n != 0 {
+		t.Errorf("expected nothing left to copy, got %d", n)
+	}
+	if attrCalls < 10 {
+		t.Errorf("expected a request per object without -prelist-dest, got %d", attrCalls)
+	}
+}
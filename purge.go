@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+var ErrPurgeAll = errors.New("refusing to purge every object in the destination")
+
+// deletes the objects in bkt last modified before cutoff, whether or not they're
+// still in the source. keys in keep, like the safety file, are never deleted.
+// when every object would go, which usually means a typo in the age, nothing is
+// deleted unless allowAll. With dryRun, the objects are only logged.
+// returns how many objects were, or would be, deleted. failed deletes are sent to errs.
+func purgeOlder(ctx context.Context, bkt Bucket, cutoff time.Time, keep map[string]bool, allowAll, dryRun bool, errs chan error) (int, error) {
+	objs, err := listAll(ctx, bkt)
+	if err != nil {
+		return 0, fmt.Errorf("error listing destination: %w", err)
+	}
+	total := 0
+	var old []string
+	for key, obj := range objs {
+		if keep[key] {
+			continue
+		}
+		total++
+		// an object without a modification time can't be too old.
+		if !obj.ModTime.IsZero() && obj.ModTime.Before(cutoff) {
+			old = append(old, key)
+		}
+	}
+	if len(old) > 0 && len(old) == total && !allowAll {
+		return 0, fmt.Errorf("%w, all %d are older than %s. use -purge-allow-all if that's intended", ErrPurgeAll, total, cutoff.Format(time.RFC3339))
+	}
+	sort.Strings(old)
+	n := 0
+	for _, key := range old {
+		if dryRun {
+			logf(logNormal, "would purge %s, last modified %s\n", key, objs[key].ModTime.Format(time.RFC3339))
+			n++
+			continue
+		}
+		if err := bkt.Delete(ctx, key); err != nil {
+			errs <- withKeys(fmt.Errorf("error purging %s: %w", key, err), key)
+			continue
+		}
+		logf(logNormal, "purged %s, last modified %s\n", key, objs[key].ModTime.Format(time.RFC3339))
+		n++
+	}
+	return n, nil
+}
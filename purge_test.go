@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"gocloud.dev/blob/driver"
+)
+
+// a destination over a memory bucket where each key lists with the given age.
+func testAgedBucket(t *testing.T, ages map[string]time.Duration, now time.Time) *faultBucket {
+	t.Helper()
+	fb := &faultBucket{
+		list: func(page *driver.ListPage) {
+			for _, obj := range page.Objects {
+				if age, ok := ages[obj.Key]; ok {
+					obj.ModTime = now.Add(-age)
+				}
+			}
+		},
+	}
+	bkt := testFaultBucket(t, fb)
+	for key := range ages {
+		if err := bkt.WriteAll(context.Background(), key, []byte(key), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return fb
+}
+
+func testPurge(t *testing.T, fb *faultBucket, cutoff time.Time, keep map[string]bool, allowAll, dryRun bool) (int, error) {
+	t.Helper()
+	errs := make(chan error)
+	done := make(chan struct{})
+	go func() {
+		for err := range errs {
+			t.Error(err)
+		}
+		close(done)
+	}()
+	n, err := purgeOlder(context.Background(), testFaultBucket(t, fb), cutoff, keep, allowAll, dryRun, errs)
+	close(errs)
+	<-done
+	return n, err
+}
+
+func testExists(t *testing.T, fb *faultBucket, key string) bool {
+	t.Helper()
+	exists, err := fb.bkt.Exists(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return exists
+}
+
+func TestPurgeOlder(t *testing.T) {
+	now := time.Now()
+	day := 24 * time.Hour
+	fb := testAgedBucket(t, map[string]time.Duration{
+		"new":    time.Hour,
+		"old":    40 * day,
+		"older":  400 * day,
+		"safety": 400 * day,
+	}, now)
+	keep := map[string]bool{"safety": true}
+	cutoff := now.Add(-30 * day)
+
+	n, err := testPurge(t, fb, cutoff, keep, false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("dry run: expected 2 to purge, got %d", n)
+	}
+	if !testExists(t, fb, "old") || !testExists(t, fb, "older") {
+		t.Error("dry run deleted objects")
+	}
+
+	n, err = testPurge(t, fb, cutoff, keep, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("expected 2 purged, got %d", n)
+	}
+	for key, want := range map[string]bool{"new": true, "old": false, "older": false, "safety": true} {
+		if got := testExists(t, fb, key); got != want {
+			t.Errorf("%s: exists %v, expected %v", key, got, want)
+		}
+	}
+}
+
+func TestPurgeGuard(t *testing.T) {
+	now := time.Now()
+	fb := testAgedBucket(t, map[string]time.Duration{"a": 48 * time.Hour, "b": 72 * time.Hour}, now)
+	// everything is older than an hour. probably meant 1d, or a lot more.
+	cutoff := now.Add(-time.Hour)
+	n, err := testPurge(t, fb, cutoff, nil, false, false)
+	if !errors.Is(err, ErrPurgeAll) {
+		t.Errorf("expected ErrPurgeAll, got %v", err)
+	}
+	if n != 0 || !testExists(t, fb, "a") || !testExists(t, fb, "b") {
+		t.Errorf("the guard should delete nothing, purged %d", n)
+	}
+
+	n, err = testPurge(t, fb, cutoff, nil, true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("expected both purged with allowAll, got %d", n)
+	}
+}
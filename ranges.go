@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"gocloud.dev/blob"
+)
+
+// objects bigger than this are copied in ranges when -multipart-parts is set.
+const rangeChunkSize = 32 << 20
+
+type rangeChunk struct {
+	data []byte
+	err  error
+}
+
+// copies one big object by reading up to parts ranges of chunkSize at once
+// and writing them in order. The writer is told to upload with the same
+// concurrency, so on S3 the multipart upload is parallel as well.
+// at most parts chunks are held in memory.
+// no transform is applied, so this is only for plain copies.
+// the object is read from key and written to newKey.
+func copyObjRanges(ctx context.Context, src, dst Bucket, key, newKey string, size int64, parts int, chunkSize int64, wopts *blob.WriterOptions) (int, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	nchunks := int((size + chunkSize - 1) / chunkSize)
+	chunks := make([]chan rangeChunk, nchunks)
+	for i := range chunks {
+		chunks[i] = make(chan rangeChunk, 1)
+	}
+	// a slot is taken before reading a chunk, and given back once it's written.
+	slots := make(chan struct{}, parts)
+	go func() {
+		for i := range chunks {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			go func(i int) {
+				defer func() {
+					if p := recover(); p != nil {
+						chunks[i] <- rangeChunk{err: panicError(p)}
+					}
+				}()
+				offset := int64(i) * chunkSize
+				length := chunkSize
+				if offset+length > size {
+					length = size - offset
+				}
+				data, err := readRange(ctx, src, key, offset, length)
+				chunks[i] <- rangeChunk{data: data, err: err}
+			}(i)
+		}
+	}()
+
+	opts := blob.WriterOptions{}
+	if wopts != nil {
+		opts = *wopts
+	}
+	opts.MaxConcurrency = parts
+	opts.BufferSize = int(chunkSize)
+	dstw, err := dst.NewWriter(ctx, newKey, &opts)
+	if err != nil {
+		return 0, err
+	}
+	lw := limitWriter(ctx, dst, dstw)
+	n := 0
+	for i := range chunks {
+		chunk := <-chunks[i]
+		if chunk.err != nil {
+			cancel()
+			dstw.Close()
+			return n, chunk.err
+		}
+		written, err := lw.Write(chunk.data)
+		n += written
+		if err != nil {
+			cancel()
+			dstw.Close()
+			return n, err
+		}
+		<-slots
+	}
+	return n, dstw.Close()
+}
+
+func readRange(ctx context.Context, bkt Bucket, key string, offset, length int64) ([]byte, error) {
+	rdr, err := bkt.NewRangeReader(ctx, key, offset, length, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer rdr.Close()
+	data, err := io.ReadAll(limitReader(ctx, bkt, rdr))
+	if err != nil {
+		return nil, err
+	}
+	// a source that stops early mustn't leave a short object behind.
+	if int64(len(data)) != length {
+		return nil, fmt.Errorf("%s: read %d bytes at %d, expected %d", key, len(data), offset, length)
+	}
+	return data, nil
+}
+
+var ErrBadRange = errors.New("range is outside the object")
+
+// the bytes of an object -range copies, from start to end, both included like
+// in an HTTP Range header. end is -1 for the rest of the object.
+type byteRange struct {
+	start, end int64
+}
+
+// parses start-end, or start- for everything from start on.
+func parseByteRange(s string) (byteRange, error) {
+	start, end, ok := strings.Cut(s, "-")
+	if !ok {
+		return byteRange{}, fmt.Errorf("bad range %q, expected start-end", s)
+	}
+	r := byteRange{end: -1}
+	var err error
+	if r.start, err = strconv.ParseInt(start, 10, 64); err != nil || r.start < 0 {
+		return byteRange{}, fmt.Errorf("bad range %q: the start isn't a number of bytes", s)
+	}
+	if end != "" {
+		if r.end, err = strconv.ParseInt(end, 10, 64); err != nil || r.end < r.start {
+			return byteRange{}, fmt.Errorf("bad range %q: the end isn't a number of bytes after the start", s)
+		}
+	}
+	return r, nil
+}
+
+func (r byteRange) String() string {
+	if r.end < 0 {
+		return fmt.Sprintf("%d-", r.start)
+	}
+	return fmt.Sprintf("%d-%d", r.start, r.end)
+}
+
+// the offset and length of r in an object of size bytes.
+func (r byteRange) bounds(size int64) (offset, length int64, err error) {
+	end := r.end
+	if end < 0 {
+		end = size - 1
+	}
+	if r.start >= size || end >= size {
+		return 0, 0, fmt.Errorf("%w: %s of %d bytes", ErrBadRange, r, size)
+	}
+	return r.start, end - r.start + 1, nil
+}
+
+// copies the bytes r of key to dst, as an object of just those bytes under the same key.
+func copyRange(ctx context.Context, src Bucket, dst Bucket, key string, r byteRange) (int64, error) {
+	attrs, err := src.Attributes(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	offset, length, err := r.bounds(attrs.Size)
+	if err != nil {
+		return 0, err
+	}
+	rdr, err := src.NewRangeReader(ctx, key, offset, length, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer rdr.Close()
+	// closing the writer after a cancel drops what was written.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	dstw, err := dst.NewWriter(ctx, key, &blob.WriterOptions{ContentType: attrs.ContentType})
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(limitWriter(ctx, dst, dstw), limitReader(ctx, src, rdr))
+	if err != nil {
+		cancel()
+		dstw.Close()
+		return n, err
+	}
+	// a source that sends less than it was asked for mustn't leave a short object behind.
+	if n != length {
+		cancel()
+		dstw.Close()
+		return n, fmt.Errorf("%s: read %d bytes of %s, expected %d", key, n, r, length)
+	}
+	return n, dstw.Close()
+}
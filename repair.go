@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"gocloud.dev/blob"
+)
+
+// verify, but every object that's missing or differs in the destination is
+// copied again, through the same transforms mirror uses. Objects that match are
+// never touched, and nothing is copied that verify wouldn't have reported.
+// the report holds what was fixed, under what was wrong with it. Objects that
+// couldn't be checked or copied are sent to errs and count as problems.
+func repair(ctx context.Context, sbkt Bucket, dbkt Bucket, opts mirrorOpts, errs chan error) verifyReport {
+	var report verifyReport
+	iter := listObjects(sbkt, nil, opts.listRPS)
+	for {
+		obj, err := iter.Next(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			report.problems++
+			errs <- fmt.Errorf("error iterating: %w", err)
+			continue
+		}
+		if !opts.filter.match(obj.Key) || !opts.shard.match(obj.Key) {
+			continue
+		}
+		d, _, err := verifyObj(ctx, sbkt, dbkt, obj.Key, opts)
+		// mirror skips it too.
+		if errors.Is(err, ErrNotEncryptedName) {
+			errLogger.Printf("warning: skipping %s: %v\n", obj.Key, err)
+			continue
+		}
+		report.checked++
+		if err != nil {
+			report.problems++
+			errs <- withKeys(fmt.Errorf("error verifying %s: %w", obj.Key, err), obj.Key)
+			continue
+		}
+		if d == nil {
+			continue
+		}
+		wopts, err := repairWriterOptions(ctx, sbkt, obj.Key, d.DstKey, opts)
+		if err == nil {
+			_, _, err = copyTransformed(ctx, sbkt, dbkt, obj.Key, d.DstKey, opts.transforms(), nil, wopts)
+		}
+		if err != nil {
+			report.problems++
+			errs <- withKeys(fmt.Errorf("error repairing %s, %s: %w", obj.Key, d.Reason, err), obj.Key, d.DstKey)
+			continue
+		}
+		logf(logNormal, "%s [%s] repaired, %s\n", d.Key, d.DstKey, d.Reason)
+		if d.Reason == reasonMissing {
+			report.missing = append(report.missing, *d)
+		} else {
+			report.differ = append(report.differ, *d)
+		}
+	}
+	return report
+}
+
+// the writer options mirror would use for an object. the md5 isn't recorded,
+// since it's only known once the transformed content has been written.
+func repairWriterOptions(ctx context.Context, sbkt Bucket, key, dstKey string, opts mirrorOpts) (*blob.WriterOptions, error) {
+	wopts := &blob.WriterOptions{ContentType: opts.contentTypes.lookup(key), CacheControl: opts.cacheControl}
+	if opts.normalizeContentType {
+		wopts.ContentType = normalizeContentType(wopts.ContentType)
+	}
+	if opts.compress {
+		wopts.ContentEncoding = opts.compressCodec().encoding
+	}
+	var tierHook, expiryHook func(func(interface{}) bool) error
+	if opts.tier != "" {
+		tierHook = setTier(opts.tier)
+	}
+	if opts.expires > 0 {
+		expiryHook = setExpiry(time.Now().Add(opts.expires))
+	}
+	wopts.BeforeWrite = chainBeforeWrite(tierHook, expiryHook)
+	stored := (opts.storeOrigKey || opts.keyHash != "") && len(opts.nameEncrypt) != 0
+	// a key over the limit may have been hashed.
+	if stored || opts.keyHash != "" && opts.maxKeyLength > 0 {
+		name, err := plainKey(ctx, sbkt, key, opts)
+		if err != nil {
+			return nil, err
+		}
+		_, hashedLong, err := hashedDestName(name, opts)
+		if err != nil {
+			return nil, err
+		}
+		if !stored && !hashedLong {
+			return wopts, nil
+		}
+		encName, err := makeKey(name, opts.nameEncrypt, nil)
+		if err != nil {
+			return nil, err
+		}
+		wopts.Metadata = map[string]string{origKeyMeta: encName}
+	}
+	return wopts, nil
+}
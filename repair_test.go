@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRepair(t *testing.T) {
+	ctx := context.Background()
+	sbkt, _ := testMemBuckets(t)
+	fb := &faultBucket{}
+	dbkt := testFaultBucket(t, fb)
+	for _, key := range []string{"same", "changed", "missing"} {
+		if err := sbkt.WriteAll(ctx, key, testRandomData(t), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	errs := make(chan error)
+	go func() {
+		for err := range errs {
+			t.Error(err)
+		}
+	}()
+	encKey := testAuthentication(t)
+	opts := mirrorOpts{bytesEncrypt: encKey, nameEncrypt: encKey}
+	tmpBkt, _ := testMemBuckets(t)
+	mirror(ctx, sbkt, dbkt, tmpBkt, opts, errs)
+
+	changed, err := destKey(ctx, sbkt, "changed", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing, err := destKey(ctx, sbkt, "missing", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	same, err := destKey(ctx, sbkt, "same", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fb.bkt.WriteAll(ctx, changed, testRandomData(t), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := fb.bkt.Delete(ctx, missing); err != nil {
+		t.Fatal(err)
+	}
+	before, err := fb.bkt.Attributes(ctx, same)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// so a rewrite of the matching object would show in its ModTime.
+	time.Sleep(10 * time.Millisecond)
+	report := repair(ctx, sbkt, dbkt, opts, errs)
+	if report.checked != 3 || report.problems != 0 {
+		t.Errorf("unexpected report %+v", report)
+	}
+	if len(report.missing) != 1 || report.missing[0].Key != "missing" {
+		t.Errorf("unexpected repaired missing objects %+v", report.missing)
+	}
+	if len(report.differ) != 1 || report.differ[0].Key != "changed" {
+		t.Errorf("unexpected repaired objects %+v", report.differ)
+	}
+	after, err := fb.bkt.Attributes(ctx, same)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a matching object is never written again.
+	if !after.ModTime.Equal(before.ModTime) {
+		t.Error("the matching object was rewritten")
+	}
+
+	if report := verify(ctx, sbkt, dbkt, opts, errs); !report.ok() {
+		t.Errorf("expected a clean verify after repairing, got %+v", report)
+	}
+	if report := repair(ctx, sbkt, dbkt, opts, errs); len(report.missing)+len(report.differ) != 0 {
+		t.Errorf("nothing left to repair, got %+v", report)
+	}
+	close(errs)
+}
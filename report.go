@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// how -verify prints the objects that are missing, extra or differ.
+const (
+	listPlain = "plain"
+	listJSON  = "json"
+	listCSV   = "csv"
+)
+
+func validListFormat(format string) error {
+	switch format {
+	case listPlain, listJSON, listCSV:
+		return nil
+	}
+	return fmt.Errorf("unknown list format %q. use plain, json or csv", format)
+}
+
+// the categories, as they're named in json and csv.
+const (
+	categoryOnlySource = "only_in_source"
+	categoryOnlyDest   = "only_in_dest"
+	categoryDiffer     = "differ"
+)
+
+// writes the discrepancies of a report in a machine readable format. json is a
+// single object with an array per category, and csv has a category column.
+// the plain format is what verify logs as it goes.
+func writeReport(w io.Writer, r verifyReport, format string) error {
+	switch format {
+	case listJSON:
+		// empty arrays rather than null, so consumers don't need to special case them.
+		out := map[string][]discrepancy{
+			categoryOnlySource: append([]discrepancy{}, r.missing...),
+			categoryOnlyDest:   append([]discrepancy{}, r.extra...),
+			categoryDiffer:     append([]discrepancy{}, r.differ...),
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(out)
+	case listCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write([]string{"category", "key", "dst_key", "reason"}); err != nil {
+			return err
+		}
+		for _, c := range []struct {
+			category string
+			ds       []discrepancy
+		}{
+			{categoryOnlySource, r.missing},
+			{categoryOnlyDest, r.extra},
+			{categoryDiffer, r.differ},
+		} {
+			for _, d := range c.ds {
+				if err := cw.Write([]string{c.category, d.Key, d.DstKey, d.Reason}); err != nil {
+					return err
+				}
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+	return fmt.Errorf("no report in the %s format", format)
+}
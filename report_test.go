@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"testing"
+)
+
+func TestWriteReport(t *testing.T) {
+	r := verifyReport{
+		missing: []discrepancy{{Key: "gone", DstKey: "gone", Reason: reasonMissing}},
+		differ:  []discrepancy{{Key: "changed", DstKey: "changed", Reason: "md5 differs"}},
+	}
+
+	var buf bytes.Buffer
+	if err := writeReport(&buf, r, listJSON); err != nil {
+		t.Fatal(err)
+	}
+	var out map[string][]discrepancy
+	if err := json.Unmarshal(buf.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	if len(out[categoryOnlySource]) != 1 || out[categoryOnlySource][0].Key != "gone" {
+		t.Errorf("unexpected %s: %+v", categoryOnlySource, out[categoryOnlySource])
+	}
+	if extra, ok := out[categoryOnlyDest]; !ok || extra == nil || len(extra) != 0 {
+		t.Errorf("expected an empty %s array, got %+v", categoryOnlyDest, extra)
+	}
+	if len(out[categoryDiffer]) != 1 || out[categoryDiffer][0].Reason != "md5 differs" {
+		t.Errorf("unexpected %s: %+v", categoryDiffer, out[categoryDiffer])
+	}
+	if !bytes.Contains(buf.Bytes(), []byte(`"only_in_dest": []`)) {
+		t.Errorf("expected an empty array rather than null:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	if err := writeReport(&buf, r, listCSV); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("expected a header and 2 rows, got %v", records)
+	}
+	if records[0][0] != "category" || records[1][0] != categoryOnlySource || records[2][0] != categoryDiffer || records[2][1] != "changed" {
+		t.Errorf("unexpected csv %v", records)
+	}
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/url"
+	"sync"
+	"time"
+
+	awsv2 "github.com/aws/aws-sdk-go-v2/aws"
+	s3v2 "github.com/aws/aws-sdk-go-v2/service/s3"
+	s3v2types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+)
+
+var ErrResumeUnsupported = errors.New("only uploads to S3 can be resumed")
+
+// S3 takes at most this many parts.
+const maxUploadParts = 10000
+
+// how long to wait before the first retry of a failed upload. it doubles for each one after.
+var resumeDelay = time.Second
+
+// a multipart upload to one destination that's driven a part at a time, so a
+// failed upload can carry on from the parts that made it.
+type partUploader interface {
+	// starts an upload of key, with what wopts and its BeforeWrite ask for.
+	create(ctx context.Context, key string, wopts *blob.WriterOptions) (uploadID string, err error)
+	// part numbers start at 1. returns the etag of the part.
+	uploadPart(ctx context.Context, key, uploadID string, num int32, data []byte) (string, error)
+	// etags are the etags of parts 1 to len(etags).
+	complete(ctx context.Context, key, uploadID string, etags []string) error
+	abort(ctx context.Context, key, uploadID string) error
+}
+
+// the bucket name and prefix= of a bucket url.
+func urlBucket(bucketURL string) (name, prefix string, err error) {
+	u, err := url.Parse(bucketURL)
+	if err != nil {
+		return "", "", err
+	}
+	return u.Host, u.Query().Get("prefix"), nil
+}
+
+// the part uploader of the S3 bucket bkt, opened from bucketURL.
+func newPartUploader(bkt Bucket, bucketURL string) (partUploader, error) {
+	name, prefix, err := urlBucket(bucketURL)
+	if err != nil {
+		return nil, err
+	}
+	var v1 *s3.S3
+	var v2 *s3v2.Client
+	switch {
+	case bkt.As(&v1):
+		return &s3v1Parts{client: v1, bucket: name, prefix: prefix}, nil
+	case bkt.As(&v2):
+		return &s3v2Parts{client: v2, bucket: name, prefix: prefix}, nil
+	}
+	return nil, ErrResumeUnsupported
+}
+
+// reports whether an upload that failed with err is worth trying again.
+func transient(ctx context.Context, err error) bool {
+	if ctx.Err() != nil || errors.Is(err, ErrLowSpace) {
+		return false
+	}
+	switch gcerrors.Code(err) {
+	case gcerrors.NotFound, gcerrors.PermissionDenied, gcerrors.InvalidArgument, gcerrors.FailedPrecondition, gcerrors.Unimplemented:
+		return false
+	}
+	return true
+}
+
+// tries f again up to retries times while it fails with transient errors.
+func retryUpload(ctx context.Context, key string, retries int, f func(attempt int) error) error {
+	delay := resumeDelay
+	for attempt := 0; ; attempt++ {
+		err := f(attempt)
+		if err == nil || attempt == retries || !transient(ctx, err) {
+			return err
+		}
+		logf(logNormal, "upload of %s failed, trying again in %v: %v\n", key, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return err
+		}
+		delay *= 2
+	}
+}
+
+// copies a big object, trying again up to retries times when the upload fails.
+// with a part uploader, the parts that were uploaded before a failure are kept,
+// and only the rest are uploaded again. without one, the object starts over.
+// parts is how many ranges of chunkSize are read and uploaded at once.
+func copyResumable(ctx context.Context, src Bucket, dst Bucket, up partUploader, key, newKey string, size int64, parts int, chunkSize int64, retries int, wopts *blob.WriterOptions) (int, error) {
+	if parts < 1 {
+		parts = 1
+	}
+	if up == nil {
+		var n int
+		err := retryUpload(ctx, newKey, retries, func(int) error {
+			var err error
+			if parts > 1 {
+				n, err = copyObjRanges(ctx, src, dst, key, newKey, size, parts, chunkSize, wopts)
+			} else {
+				n, _, err = copyObjTo(ctx, src, dst, key, newKey, nil, nil, wopts)
+			}
+			return err
+		})
+		return n, err
+	}
+
+	if min := (size + maxUploadParts - 1) / maxUploadParts; chunkSize < min {
+		chunkSize = min
+	}
+	nparts := int((size + chunkSize - 1) / chunkSize)
+	uploadID, err := up.create(ctx, newKey, wopts)
+	if err != nil {
+		return 0, err
+	}
+	etags := make([]string, nparts)
+	err = retryUpload(ctx, newKey, retries, func(attempt int) error {
+		if attempt > 0 {
+			done := 0
+			for _, etag := range etags {
+				if etag != "" {
+					done++
+				}
+			}
+			logf(logNormal, "resuming upload of %s, %d of %d parts were uploaded\n", newKey, done, nparts)
+		}
+		return uploadParts(ctx, src, up, key, newKey, uploadID, size, chunkSize, parts, etags)
+	})
+	if err == nil {
+		err = up.complete(ctx, newKey, uploadID, etags)
+	}
+	if err != nil {
+		// the parts are kept, and billed for, until the upload is aborted.
+		if aerr := up.abort(context.Background(), newKey, uploadID); aerr != nil {
+			errLogger.Printf("error aborting the upload of %s: %v\n", newKey, aerr)
+		}
+		return 0, err
+	}
+	return int(size), nil
+}
+
+// uploads the parts that don't have an etag yet, parts at a time. the first error stops it.
+func uploadParts(ctx context.Context, src Bucket, up partUploader, key, newKey, uploadID string, size, chunkSize int64, parts int, etags []string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var mu sync.Mutex
+	var firstErr error
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, parts)
+	for i := range etags {
+		if etags[i] != "" {
+			continue
+		}
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			fail := func(err error) {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+				cancel()
+			}
+			defer func() {
+				if p := recover(); p != nil {
+					fail(panicError(p))
+				}
+			}()
+			etag, err := uploadPart(ctx, src, up, key, newKey, uploadID, i, size, chunkSize)
+			if err != nil {
+				fail(err)
+				return
+			}
+			mu.Lock()
+			etags[i] = etag
+			mu.Unlock()
+		}(i)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// reads part i, counting from 0, and uploads it.
+func uploadPart(ctx context.Context, src Bucket, up partUploader, key, newKey, uploadID string, i int, size, chunkSize int64) (string, error) {
+	offset := int64(i) * chunkSize
+	length := chunkSize
+	if offset+length > size {
+		length = size - offset
+	}
+	data, err := readRange(ctx, src, key, offset, length)
+	if err != nil {
+		return "", err
+	}
+	return up.uploadPart(ctx, newKey, uploadID, int32(i+1), data)
+}
+
+type s3v1Parts struct {
+	client *s3.S3
+	bucket string
+	prefix string
+}
+
+func (u *s3v1Parts) create(ctx context.Context, key string, wopts *blob.WriterOptions) (string, error) {
+	in := &s3manager.UploadInput{Bucket: aws.String(u.bucket), Key: aws.String(u.prefix + key)}
+	if wopts != nil {
+		if wopts.ContentType != "" {
+			in.ContentType = aws.String(wopts.ContentType)
+		}
+		if wopts.CacheControl != "" {
+			in.CacheControl = aws.String(wopts.CacheControl)
+		}
+		if len(wopts.Metadata) != 0 {
+			in.Metadata = aws.StringMap(wopts.Metadata)
+		}
+		if wopts.BeforeWrite != nil {
+			as := func(i interface{}) bool {
+				p, ok := i.(**s3manager.UploadInput)
+				if ok {
+					*p = in
+				}
+				return ok
+			}
+			if err := wopts.BeforeWrite(as); err != nil {
+				return "", err
+			}
+		}
+	}
+	out, err := u.client.CreateMultipartUploadWithContext(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:                    in.Bucket,
+		Key:                       in.Key,
+		ACL:                       in.ACL,
+		GrantRead:                 in.GrantRead,
+		Expires:                   in.Expires,
+		CacheControl:              in.CacheControl,
+		ContentType:               in.ContentType,
+		Metadata:                  in.Metadata,
+		StorageClass:              in.StorageClass,
+		ServerSideEncryption:      in.ServerSideEncryption,
+		SSEKMSKeyId:               in.SSEKMSKeyId,
+		ObjectLockMode:            in.ObjectLockMode,
+		ObjectLockRetainUntilDate: in.ObjectLockRetainUntilDate,
+		ObjectLockLegalHoldStatus: in.ObjectLockLegalHoldStatus,
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(out.UploadId), nil
+}
+
+func (u *s3v1Parts) uploadPart(ctx context.Context, key, uploadID string, num int32, data []byte) (string, error) {
+	out, err := u.client.UploadPartWithContext(ctx, &s3.UploadPartInput{
+		Bucket:     aws.String(u.bucket),
+		Key:        aws.String(u.prefix + key),
+		UploadId:   aws.String(uploadID),
+		PartNumber: aws.Int64(int64(num)),
+		Body:       bytes.NewReader(data),
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(out.ETag), nil
+}
+
+func (u *s3v1Parts) complete(ctx context.Context, key, uploadID string, etags []string) error {
+	parts := make([]*s3.CompletedPart, len(etags))
+	for i, etag := range etags {
+		parts[i] = &s3.CompletedPart{ETag: aws.String(etag), PartNumber: aws.Int64(int64(i + 1))}
+	}
+	_, err := u.client.CompleteMultipartUploadWithContext(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(u.bucket),
+		Key:             aws.String(u.prefix + key),
+		UploadId:        aws.String(uploadID),
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+	})
+	return err
+}
+
+func (u *s3v1Parts) abort(ctx context.Context, key, uploadID string) error {
+	_, err := u.client.AbortMultipartUploadWithContext(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(u.bucket),
+		Key:      aws.String(u.prefix + key),
+		UploadId: aws.String(uploadID),
+	})
+	return err
+}
+
+type s3v2Parts struct {
+	client *s3v2.Client
+	bucket string
+	prefix string
+}
+
+func (u *s3v2Parts) create(ctx context.Context, key string, wopts *blob.WriterOptions) (string, error) {
+	in := &s3v2.PutObjectInput{Bucket: awsv2.String(u.bucket), Key: awsv2.String(u.prefix + key)}
+	if wopts != nil {
+		if wopts.ContentType != "" {
+			in.ContentType = awsv2.String(wopts.ContentType)
+		}
+		if wopts.CacheControl != "" {
+			in.CacheControl = awsv2.String(wopts.CacheControl)
+		}
+		if len(wopts.Metadata) != 0 {
+			in.Metadata = wopts.Metadata
+		}
+		if wopts.BeforeWrite != nil {
+			as := func(i interface{}) bool {
+				p, ok := i.(**s3v2.PutObjectInput)
+				if ok {
+					*p = in
+				}
+				return ok
+			}
+			if err := wopts.BeforeWrite(as); err != nil {
+				return "", err
+			}
+		}
+	}
+	out, err := u.client.CreateMultipartUpload(ctx, &s3v2.CreateMultipartUploadInput{
+		Bucket:                    in.Bucket,
+		Key:                       in.Key,
+		ACL:                       in.ACL,
+		GrantRead:                 in.GrantRead,
+		Expires:                   in.Expires,
+		CacheControl:              in.CacheControl,
+		ContentType:               in.ContentType,
+		Metadata:                  in.Metadata,
+		StorageClass:              in.StorageClass,
+		ServerSideEncryption:      in.ServerSideEncryption,
+		SSEKMSKeyId:               in.SSEKMSKeyId,
+		ObjectLockMode:            in.ObjectLockMode,
+		ObjectLockRetainUntilDate: in.ObjectLockRetainUntilDate,
+		ObjectLockLegalHoldStatus: in.ObjectLockLegalHoldStatus,
+	})
+	if err != nil {
+		return "", err
+	}
+	return awsv2.ToString(out.UploadId), nil
+}
+
+func (u *s3v2Parts) uploadPart(ctx context.Context, key, uploadID string, num int32, data []byte) (string, error) {
+	out, err := u.client.UploadPart(ctx, &s3v2.UploadPartInput{
+		Bucket:     awsv2.String(u.bucket),
+		Key:        awsv2.String(u.prefix + key),
+		UploadId:   awsv2.String(uploadID),
+		PartNumber: num,
+		Body:       bytes.NewReader(data),
+	})
+	if err != nil {
+		return "", err
+	}
+	return awsv2.ToString(out.ETag), nil
+}
+
+func (u *s3v2Parts) complete(ctx context.Context, key, uploadID string, etags []string) error {
+	parts := make([]s3v2types.CompletedPart, len(etags))
+	for i, etag := range etags {
+		parts[i] = s3v2types.CompletedPart{ETag: awsv2.String(etag), PartNumber: int32(i + 1)}
+	}
+	_, err := u.client.CompleteMultipartUpload(ctx, &s3v2.CompleteMultipartUploadInput{
+		Bucket:          awsv2.String(u.bucket),
+		Key:             awsv2.String(u.prefix + key),
+		UploadId:        awsv2.String(uploadID),
+		MultipartUpload: &s3v2types.CompletedMultipartUpload{Parts: parts},
+	})
+	return err
+}
+
+func (u *s3v2Parts) abort(ctx context.Context, key, uploadID string) error {
+	_, err := u.client.AbortMultipartUpload(ctx, &s3v2.AbortMultipartUploadInput{
+		Bucket:   awsv2.String(u.bucket),
+		Key:      awsv2.String(u.prefix + key),
+		UploadId: awsv2.String(uploadID),
+	})
+	return err
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"gocloud.dev/blob"
+)
+
+func testResumeDelay(t *testing.T) {
+	t.Helper()
+	old := resumeDelay
+	resumeDelay = time.Millisecond
+	t.Cleanup(func() { resumeDelay = old })
+}
+
+// a part uploader that keeps parts in memory. fail can fail an upload of a part.
+type testParts struct {
+	mu        sync.Mutex
+	parts     map[int32][]byte
+	uploads   map[int32]int
+	fail      func(num int32) error
+	completed []byte
+	aborted   bool
+}
+
+func (u *testParts) create(ctx context.Context, key string, wopts *blob.WriterOptions) (string, error) {
+	u.parts = make(map[int32][]byte)
+	u.uploads = make(map[int32]int)
+	return "upload", nil
+}
+
+func (u *testParts) uploadPart(ctx context.Context, key, uploadID string, num int32, data []byte) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.fail != nil {
+		if err := u.fail(num); err != nil {
+			return "", err
+		}
+	}
+	u.parts[num] = data
+	u.uploads[num]++
+	return fmt.Sprint(num), nil
+}
+
+func (u *testParts) complete(ctx context.Context, key, uploadID string, etags []string) error {
+	var buf bytes.Buffer
+	for i, etag := range etags {
+		if etag != fmt.Sprint(i+1) {
+			return fmt.Errorf("part %d has etag %q", i+1, etag)
+		}
+		buf.Write(u.parts[int32(i+1)])
+	}
+	u.completed = buf.Bytes()
+	return nil
+}
+
+func (u *testParts) abort(ctx context.Context, key, uploadID string) error {
+	u.aborted = true
+	return nil
+}
+
+func TestCopyResumable(t *testing.T) {
+	testResumeDelay(t)
+	ctx := context.Background()
+	sbkt, dbkt := testMemBuckets(t)
+	var data []byte
+	for i := 0; i < 10; i++ {
+		data = append(data, testRandomData(t)...)
+	}
+	data = data[:len(data)-100]
+	if err := sbkt.WriteAll(ctx, "big", data, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// part 5 fails once. the parts that made it aren't uploaded again.
+	failed := false
+	up := &testParts{fail: func(num int32) error {
+		if num == 5 && !failed {
+			failed = true
+			return errInjected
+		}
+		return nil
+	}}
+	n, err := copyResumable(ctx, sbkt, dbkt, up, "big", "big", int64(len(data)), 3, 1024, 2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(data) || !bytes.Equal(up.completed, data) {
+		t.Errorf("expected the %d bytes of the source, got %d bytes, %d copied", len(data), len(up.completed), n)
+	}
+	for num, count := range up.uploads {
+		if count != 1 {
+			t.Errorf("part %d was uploaded %d times", num, count)
+		}
+	}
+	if len(up.uploads) != 10 || up.aborted {
+		t.Errorf("expected 10 parts and no abort, got %d parts, aborted %v", len(up.uploads), up.aborted)
+	}
+
+	// out of retries, the upload is aborted.
+	attempts := 0
+	up = &testParts{fail: func(num int32) error {
+		if num == 1 {
+			attempts++
+			return errInjected
+		}
+		return nil
+	}}
+	if _, err := copyResumable(ctx, sbkt, dbkt, up, "big", "big", int64(len(data)), 1, 1024, 2, nil); !errors.Is(err, errInjected) {
+		t.Errorf("expected the injected error, got %v", err)
+	}
+	if attempts != 3 || !up.aborted {
+		t.Errorf("expected 3 attempts and an abort, got %d attempts, aborted %v", attempts, up.aborted)
+	}
+
+	// errors that won't go away aren't tried again.
+	attempts = 0
+	up = &testParts{fail: func(num int32) error {
+		attempts++
+		return ErrLowSpace
+	}}
+	if _, err := copyResumable(ctx, sbkt, dbkt, up, "big", "big", int64(len(data)), 1, 1024, 2, nil); !errors.Is(err, ErrLowSpace) || attempts != 1 {
+		t.Errorf("expected one attempt failing with ErrLowSpace, got %d attempts, %v", attempts, err)
+	}
+}
+
+func TestCopyResumableRestart(t *testing.T) {
+	testResumeDelay(t)
+	ctx := context.Background()
+	reads := 0
+	sbkt := testFaultBucket(t, &faultBucket{read: func(key string) error {
+		reads++
+		if reads == 1 {
+			return errInjected
+		}
+		return nil
+	}})
+	_, dbkt := testMemBuckets(t)
+	data := testRandomData(t)
+	if err := sbkt.WriteAll(ctx, "big", data, nil); err != nil {
+		t.Fatal(err)
+	}
+	// without a part uploader, the copy starts over.
+	n, err := copyResumable(ctx, sbkt, dbkt, nil, "big", "big", int64(len(data)), 1, 1024, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(data) || testReadString(t, dbkt, "big") != string(data) {
+		t.Errorf("expected the source copied, got %d bytes", n)
+	}
+
+	reads = 0
+	sbkt = testFaultBucket(t, &faultBucket{read: func(key string) error {
+		reads++
+		return errUnimplemented
+	}})
+	if err := sbkt.WriteAll(ctx, "big", data, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := copyResumable(ctx, sbkt, dbkt, nil, "big", "big", int64(len(data)), 1, 1024, 3, nil); err == nil || reads != 1 {
+		t.Errorf("expected an unimplemented read not to be tried again, got %d reads, %v", reads, err)
+	}
+}
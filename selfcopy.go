@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+)
+
+var ErrSelfCopy = errors.New("source and destination are the same bucket")
+
+// reports whether two bucket URLs point at the same bucket and prefix.
+// query parameters other than prefix only change how the bucket is opened, so they're ignored.
+func sameBucket(a, b string) bool {
+	ua, err := url.Parse(a)
+	if err != nil {
+		return a == b
+	}
+	ub, err := url.Parse(b)
+	if err != nil {
+		return a == b
+	}
+	return bucketID(ua) == bucketID(ub)
+}
+
+func bucketID(u *url.URL) string {
+	p := path.Clean("/" + u.Path)
+	return strings.ToLower(u.Scheme) + "://" + strings.ToLower(u.Host) + p + "?prefix=" + u.Query().Get("prefix")
+}
+
+// copying a bucket onto itself with the same keys rewrites every object in place,
+// so that's always refused. When the keys change, e.g. because they're encrypted,
+// the copies sit next to the originals, which is allowed with allowSelf.
+func checkSelfCopy(src, dst string, keysChange, allowSelf bool) error {
+	if !sameBucket(src, dst) {
+		return nil
+	}
+	if keysChange && allowSelf {
+		return nil
+	}
+	if keysChange {
+		return fmt.Errorf("%w: %s. use -allow-self to write the changed keys next to the originals", ErrSelfCopy, dst)
+	}
+	return fmt.Errorf("%w: %s", ErrSelfCopy, dst)
+}
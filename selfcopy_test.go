@@ -0,0 +1,33 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckSelfCopy(t *testing.T) {
+	for _, tc := range []struct {
+		src, dst   string
+		keysChange bool
+		allowSelf  bool
+		refused    bool
+	}{
+		{"s3://bucket", "s3://bucket", false, false, true},
+		{"s3://bucket", "S3://Bucket/", false, false, true},
+		{"s3://bucket?region=us-east-1", "s3://bucket?region=us-west-2", false, false, true},
+		{"file:///tmp/dir", "file:///tmp/./dir/", false, false, true},
+		// the same keys are refused even with -allow-self.
+		{"s3://bucket", "s3://bucket", false, true, true},
+		{"s3://bucket", "s3://bucket", true, false, true},
+		{"s3://bucket", "s3://bucket", true, true, false},
+		{"s3://bucket", "s3://other", false, false, false},
+		{"s3://bucket", "gs://bucket", false, false, false},
+		{"s3://bucket?prefix=a/", "s3://bucket?prefix=b/", false, false, false},
+		{"file:///tmp/a", "file:///tmp/b", false, false, false},
+	} {
+		err := checkSelfCopy(tc.src, tc.dst, tc.keysChange, tc.allowSelf)
+		if refused := errors.Is(err, ErrSelfCopy); refused != tc.refused {
+			t.Errorf("%s -> %s (keys change %v, allow %v): expected refused %v, got %v", tc.src, tc.dst, tc.keysChange, tc.allowSelf, tc.refused, err)
+		}
+	}
+}
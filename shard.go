@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+)
+
+// one of count disjoint parts of the keys, so several machines can each copy
+// their own part of a bucket without talking to each other.
+// the zero shard is every key.
+type shard struct {
+	index int
+	count int
+}
+
+// parses a shard like 2/8, the third of eight. indexes start at 0.
+func parseShard(s string) (shard, error) {
+	if s == "" {
+		return shard{}, nil
+	}
+	i, n, ok := strings.Cut(s, "/")
+	index, err1 := strconv.Atoi(i)
+	count, err2 := strconv.Atoi(n)
+	if !ok || err1 != nil || err2 != nil || count < 1 || index < 0 || index >= count {
+		return shard{}, fmt.Errorf("bad shard %q. use i/n with 0 <= i < n", s)
+	}
+	return shard{index: index, count: count}, nil
+}
+
+// reports whether key is in this shard. It only depends on the key,
+// so every machine agrees on where each key goes.
+func (s shard) match(key string) bool {
+	if s.count <= 1 {
+		return true
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32()%uint32(s.count)) == s.index
+}
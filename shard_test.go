@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"testing"
+)
+
+func TestParseShard(t *testing.T) {
+	for s, expected := range map[string]shard{"": {}, "0/1": {0, 1}, "2/8": {2, 8}} {
+		got, err := parseShard(s)
+		if err != nil || got != expected {
+			t.Errorf("%q: got %+v %v", s, got, err)
+		}
+	}
+	for _, s := range []string{"8/8", "-1/4", "1", "a/b", "0/0", "1/2/3"} {
+		if _, err := parseShard(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestShards(t *testing.T) {
+	ctx := context.Background()
+	sbkt, _ := testMemBuckets(t)
+	const objects = 100
+	for i := 0; i < objects; i++ {
+		if err := sbkt.WriteAll(ctx, "dir/file"+strconv.Itoa(i), []byte("x"), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	errs := make(chan error)
+	go func() {
+		for err := range errs {
+			t.Error(err)
+		}
+	}()
+	// every shard copies to its own bucket, and together they're the whole source.
+	const count = 4
+	seen := make(map[string]int)
+	for i := 0; i < count; i++ {
+		_, dbkt := testMemBuckets(t)
+		n := mirror(ctx, sbkt, dbkt, nil, mirrorOpts{shard: shard{index: i, count: count}}, errs)
+		if n == 0 || n == objects {
+			t.Errorf("shard %d: copied %d objects, expected some but not all", i, n)
+		}
+		objs, err := listAll(ctx, dbkt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for key := range objs {
+			seen[key]++
+		}
+	}
+	close(errs)
+	if len(seen) != objects {
+		t.Errorf("expected all %d objects copied by some shard, got %d", objects, len(seen))
+	}
+	for key, n := range seen {
+		if n != 1 {
+			t.Errorf("%s copied by %d shards", key, n)
+		}
+	}
+}
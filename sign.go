@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+)
+
+// writes a presigned download URL for each copied object to w,
+// as a line with the destination key and the URL separated by a tab.
+type urlSigner struct {
+	w      io.Writer
+	expiry time.Duration
+	// destinations that turned out not to support signing. They're warned about once.
+	unsupported map[Bucket]bool
+}
+
+func newURLSigner(w io.Writer, expiry time.Duration) *urlSigner {
+	return &urlSigner{w: w, expiry: expiry, unsupported: make(map[Bucket]bool)}
+}
+
+// signs key in bkt. A destination that can't sign URLs isn't an error, it's
+// skipped with a warning.
+func (s *urlSigner) sign(ctx context.Context, bkt Bucket, key string) error {
+	if s.unsupported[bkt] {
+		return nil
+	}
+	u, err := bkt.SignedURL(ctx, key, &blob.SignedURLOptions{Expiry: s.expiry, Method: http.MethodGet})
+	if gcerrors.Code(err) == gcerrors.Unimplemented {
+		s.unsupported[bkt] = true
+		errLogger.Println("warning: the destination can't sign URLs, not signing its objects:", err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(s.w, "%s\t%s\n", key, u)
+	return err
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/fileblob"
+)
+
+func testSignMirror(t *testing.T, sbkt, dbkt *blob.Bucket) string {
+	t.Helper()
+	var out strings.Builder
+	errs := make(chan error)
+	done := make(chan struct{})
+	go func() {
+		for err := range errs {
+			t.Error(err)
+		}
+		close(done)
+	}()
+	opts := mirrorOpts{signer: newURLSigner(&out, time.Hour)}
+	if n := mirror(context.Background(), sbkt, dbkt, nil, opts, errs); n != 2 {
+		t.Errorf("expected 2 copied, got %d", n)
+	}
+	close(errs)
+	<-done
+	return out.String()
+}
+
+func TestSignURLs(t *testing.T) {
+	ctx := context.Background()
+	sbkt, _ := testMemBuckets(t)
+	for _, key := range []string{"a", "dir/b"} {
+		if err := sbkt.WriteAll(ctx, key, []byte(key), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	base, err := url.Parse("https://files.example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbkt, err := fileblob.OpenBucket(t.TempDir(), &fileblob.Options{URLSigner: fileblob.NewURLSignerHMAC(base, []byte("secret"))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbkt.Close()
+
+	lines := strings.Split(strings.TrimSpace(testSignMirror(t, sbkt, dbkt)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected a URL per object, got %q", lines)
+	}
+	for i, key := range []string{"a", "dir/b"} {
+		gotKey, u, ok := strings.Cut(lines[i], "\t")
+		if !ok || gotKey != key || !strings.HasPrefix(u, base.String()) {
+			t.Errorf("unexpected line %q", lines[i])
+		}
+	}
+}
+
+func TestSignURLsUnsupported(t *testing.T) {
+	ctx := context.Background()
+	// memory buckets can't sign URLs. copying still works, there's just nothing to write.
+	sbkt, dbkt := testMemBuckets(t)
+	for _, key := range []string{"a", "b"} {
+		if err := sbkt.WriteAll(ctx, key, []byte(key), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if out := testSignMirror(t, sbkt, dbkt); out != "" {
+		t.Errorf("expected no URLs, got %q", out)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	s3v2 "github.com/aws/aws-sdk-go-v2/service/s3"
+	s3v2types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"gocloud.dev/blob"
+)
+
+var ErrSSEUnsupported = errors.New("server-side encryption settings are only supported on S3 and GCS destinations")
+
+// how the provider encrypts an object it stores. this has nothing to do with
+// -encrypt, which encrypts before the provider ever sees the data.
+type sse struct {
+	// the S3 algorithm, AES256 or aws:kms. GCS has none, only the key.
+	algo string
+	// the KMS key: an S3 key id or arn, or a GCS key name.
+	keyID string
+	// "S3" or "GCS" when it was read from a source object, which only
+	// carries over to the same provider. "" for settings from flags.
+	provider string
+}
+
+func (s sse) empty() bool {
+	return s.algo == "" && s.keyID == ""
+}
+
+// reports whether S3 keeps md5s as the etags of objects written with s. it
+// doesn't for KMS keys, and blobcopy has to record them itself.
+func (s sse) hidesMD5() bool {
+	return strings.HasPrefix(s.algo, s3.ServerSideEncryptionAwsKms)
+}
+
+// checks that objects written to bkt can be encrypted with s.
+func validSSE(bkt Bucket, s sse) error {
+	provider, _ := bucketTiers(bkt)
+	switch provider {
+	case "S3":
+		if !knownSSEAlgo(s.algo) {
+			return fmt.Errorf("unknown S3 server-side encryption %q. use %s", s.algo, strings.Join(s3.ServerSideEncryption_Values(), ", "))
+		}
+		if s.keyID != "" && !s.hidesMD5() {
+			return fmt.Errorf("a KMS key needs -sse %s", s3.ServerSideEncryptionAwsKms)
+		}
+	case "GCS":
+		if s.algo != "" {
+			return errors.New("GCS has no -sse algorithms, it encrypts with the -sse-kms-key-id key alone")
+		}
+	default:
+		return ErrSSEUnsupported
+	}
+	return nil
+}
+
+func knownSSEAlgo(algo string) bool {
+	for _, v := range s3.ServerSideEncryption_Values() {
+		if algo == v {
+			return true
+		}
+	}
+	return false
+}
+
+// the encryption of an S3 or GCS source object. Empty for other backends, and
+// for objects in the bucket's default encryption.
+func sourceSSE(attrs *blob.Attributes) sse {
+	var v1 s3.HeadObjectOutput
+	var v2 s3v2.HeadObjectOutput
+	var gcs storage.ObjectAttrs
+	switch {
+	case attrs.As(&v1):
+		return sse{algo: aws.StringValue(v1.ServerSideEncryption), keyID: aws.StringValue(v1.SSEKMSKeyId), provider: "S3"}
+	case attrs.As(&v2):
+		return sse{algo: string(v2.ServerSideEncryption), keyID: aws.StringValue(v2.SSEKMSKeyId), provider: "S3"}
+	case attrs.As(&gcs):
+		return sse{keyID: gcs.KMSKeyName, provider: "GCS"}
+	}
+	return sse{}
+}
+
+// the encryption to write an object with: what the flags say, or with
+// -preserve-sse the source object's when they don't say anything.
+func (opts mirrorOpts) sseFor(sattrs *blob.Attributes) sse {
+	if !opts.sse.empty() || !opts.preserveSSE {
+		return opts.sse
+	}
+	return sourceSSE(sattrs)
+}
+
+// a WriterOptions.BeforeWrite that has the provider encrypt the object with s.
+// settings read from a source on another provider are left out.
+func setSSE(s sse) func(func(interface{}) bool) error {
+	return func(as func(interface{}) bool) error {
+		var v1 *s3manager.UploadInput
+		var v2 *s3v2.PutObjectInput
+		var gw *storage.Writer
+		switch {
+		case as(&v1):
+			if s.provider == "GCS" {
+				return nil
+			}
+			if s.algo != "" {
+				v1.ServerSideEncryption = aws.String(s.algo)
+			}
+			if s.keyID != "" {
+				v1.SSEKMSKeyId = aws.String(s.keyID)
+			}
+		case as(&v2):
+			if s.provider == "GCS" {
+				return nil
+			}
+			if s.algo != "" {
+				v2.ServerSideEncryption = s3v2types.ServerSideEncryption(s.algo)
+			}
+			if s.keyID != "" {
+				v2.SSEKMSKeyId = aws.String(s.keyID)
+			}
+		case as(&gw):
+			if s.provider == "S3" {
+				return nil
+			}
+			gw.KMSKeyName = s.keyID
+		default:
+			if s.provider != "" {
+				return nil
+			}
+			return ErrSSEUnsupported
+		}
+		return nil
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	s3v2 "github.com/aws/aws-sdk-go-v2/service/s3"
+	s3v2types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/driver"
+)
+
+func TestSetSSE(t *testing.T) {
+	kms := sse{algo: "aws:kms", keyID: "arn:aws:kms:us-east-1:123:key/abc"}
+	v1 := &s3manager.UploadInput{}
+	if err := setSSE(kms)(testAs(v1)); err != nil {
+		t.Fatal(err)
+	}
+	if aws.StringValue(v1.ServerSideEncryption) != "aws:kms" || aws.StringValue(v1.SSEKMSKeyId) != kms.keyID {
+		t.Errorf("unexpected v1 encryption %v %v", v1.ServerSideEncryption, v1.SSEKMSKeyId)
+	}
+	v2 := &s3v2.PutObjectInput{}
+	if err := setSSE(sse{algo: "AES256"})(testAs(v2)); err != nil {
+		t.Fatal(err)
+	}
+	if v2.ServerSideEncryption != s3v2types.ServerSideEncryptionAes256 || v2.SSEKMSKeyId != nil {
+		t.Errorf("unexpected v2 encryption %v %v", v2.ServerSideEncryption, v2.SSEKMSKeyId)
+	}
+	gw := &storage.Writer{}
+	if err := setSSE(sse{keyID: "projects/p/locations/l/keyRings/r/cryptoKeys/k"})(testAs(gw)); err != nil {
+		t.Fatal(err)
+	}
+	if gw.KMSKeyName != "projects/p/locations/l/keyRings/r/cryptoKeys/k" {
+		t.Errorf("unexpected GCS key %q", gw.KMSKeyName)
+	}
+
+	// a source's settings don't carry over to another provider.
+	gw = &storage.Writer{}
+	if err := setSSE(sse{algo: "aws:kms", keyID: "abc", provider: "S3"})(testAs(gw)); err != nil || gw.KMSKeyName != "" {
+		t.Errorf("S3 settings shouldn't apply to GCS, got %q %v", gw.KMSKeyName, err)
+	}
+	v1 = &s3manager.UploadInput{}
+	if err := setSSE(sse{keyID: "k", provider: "GCS"})(testAs(v1)); err != nil || v1.SSEKMSKeyId != nil {
+		t.Errorf("GCS settings shouldn't apply to S3, got %v %v", v1.SSEKMSKeyId, err)
+	}
+	if err := setSSE(kms)(func(interface{}) bool { return false }); !errors.Is(err, ErrSSEUnsupported) {
+		t.Errorf("expected ErrSSEUnsupported, got %v", err)
+	}
+}
+
+// a bucket that looks like S3, and records what each upload asked for.
+type s3UploadBucket struct {
+	faultBucket
+	uploads map[string]*s3manager.UploadInput
+}
+
+func (b *s3UploadBucket) As(i interface{}) bool {
+	p, ok := i.(**s3.S3)
+	if ok {
+		*p = &s3.S3{}
+	}
+	return ok
+}
+
+func (b *s3UploadBucket) NewTypedWriter(ctx context.Context, key, contentType string, opts *driver.WriterOptions) (driver.Writer, error) {
+	in := &s3manager.UploadInput{}
+	if opts.BeforeWrite != nil {
+		if err := opts.BeforeWrite(testAs(in)); err != nil {
+			return nil, err
+		}
+	}
+	b.uploads[key] = in
+	return b.faultBucket.NewTypedWriter(ctx, key, contentType, opts)
+}
+
+func TestValidSSE(t *testing.T) {
+	_, mem := testMemBuckets(t)
+	dbkt := blob.NewBucket(&s3UploadBucket{faultBucket: faultBucket{bkt: mem}})
+	defer dbkt.Close()
+	for _, c := range []struct {
+		s  sse
+		ok bool
+	}{
+		{sse{algo: "AES256"}, true},
+		{sse{algo: "aws:kms"}, true},
+		{sse{algo: "aws:kms", keyID: "abc"}, true},
+		{sse{algo: "AES256", keyID: "abc"}, false},
+		{sse{keyID: "abc"}, false},
+		{sse{algo: "rot13"}, false},
+	} {
+		if err := validSSE(dbkt, c.s); (err == nil) != c.ok {
+			t.Errorf("%+v: got %v", c.s, err)
+		}
+	}
+	if err := validSSE(mem, sse{algo: "AES256"}); !errors.Is(err, ErrSSEUnsupported) {
+		t.Errorf("expected memory buckets not to have server-side encryption, got %v", err)
+	}
+}
+
+func TestSSE(t *testing.T) {
+	ctx := context.Background()
+	// a source that looks like S3, with its objects encrypted with a KMS key.
+	fb := &faultBucket{attrs: func(key string, a *driver.Attributes) {
+		a.AsFunc = testAs(s3.HeadObjectOutput{ServerSideEncryption: aws.String("aws:kms"), SSEKMSKeyId: aws.String("source-key")})
+	}}
+	sbkt := testFaultBucket(t, fb)
+	if err := sbkt.WriteAll(ctx, "file", []byte("data"), nil); err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error)
+	go func() {
+		for err := range errs {
+			t.Error(err)
+		}
+	}()
+	for _, c := range []struct {
+		name     string
+		opts     mirrorOpts
+		algo, id string
+	}{
+		{"flags", mirrorOpts{sse: sse{algo: "aws:kms", keyID: "dest-key"}}, "aws:kms", "dest-key"},
+		{"preserved", mirrorOpts{preserveSSE: true}, "aws:kms", "source-key"},
+		{"flags over preserved", mirrorOpts{sse: sse{algo: "AES256"}, preserveSSE: true}, "AES256", ""},
+		{"default", mirrorOpts{}, "", ""},
+	} {
+		_, mem := testMemBuckets(t)
+		sb := &s3UploadBucket{faultBucket: faultBucket{bkt: mem}, uploads: make(map[string]*s3manager.UploadInput)}
+		dbkt := blob.NewBucket(sb)
+		if n := mirror(ctx, sbkt, dbkt, nil, c.opts, errs); n != 1 {
+			t.Fatalf("%s: expected 1 object copied, got %d", c.name, n)
+		}
+		dbkt.Close()
+		in := sb.uploads["file"]
+		if aws.StringValue(in.ServerSideEncryption) != c.algo || aws.StringValue(in.SSEKMSKeyId) != c.id {
+			t.Errorf("%s: expected %q %q, got %v %v", c.name, c.algo, c.id, in.ServerSideEncryption, in.SSEKMSKeyId)
+		}
+	}
+	close(errs)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// the first bytes of a state file tell us how to read the rest of it.
+// plain state files are json, encrypted ones are the encrypted json.
+var (
+	stateMagicPlain     = []byte("blobcopy-state-v1\n")
+	stateMagicEncrypted = []byte("blobcopy-state-v1-encrypted\n")
+)
+
+var (
+	ErrStateEncrypted = errors.New("state file is encrypted, an encryption password is required")
+	ErrStateFormat    = errors.New("not a blobcopy state file")
+)
+
+// state remembers which objects have been copied, so an interrupted
+// run can pick up where it left off.
+type state struct {
+	// source key -> what we know about its copy in the destination
+	Objects map[string]stateEntry `json:"objects"`
+	// every key up to and including LastKey, in list order, is done.
+	// a resumed run starts listing after it where the backend allows.
+	LastKey string `json:"last_key,omitempty"`
+	// a manifestHash of the destination at the end of the last run.
+	DestHash string `json:"dest_hash,omitempty"`
+	// how many times record was called, for checkpoints.
+	records int
+}
+
+type stateEntry struct {
+	DstKey string `json:"dst_key"`
+	// the md5 of what was written to the destination.
+	MD5  []byte `json:"md5,omitempty"`
+	Size int64  `json:"size"`
+	// the md5 of the source object when it was copied.
+	SrcMD5 []byte `json:"src_md5,omitempty"`
+}
+
+func newState() *state {
+	return &state{Objects: make(map[string]stateEntry)}
+}
+
+func (s *state) done(key string) bool {
+	_, ok := s.Objects[key]
+	return ok
+}
+
+// the entry for key, if the source hasn't changed since it was copied to dstKey.
+// then the destination should still have the entry's md5.
+func (s *state) unchanged(key, dstKey string, srcMD5 []byte) (stateEntry, bool) {
+	e, ok := s.Objects[key]
+	if !ok || e.DstKey != dstKey || len(srcMD5) == 0 || len(e.MD5) == 0 || !bytes.Equal(e.SrcMD5, srcMD5) {
+		return stateEntry{}, false
+	}
+	return e, true
+}
+
+func (s *state) record(key string, entry stateEntry) {
+	s.Objects[key] = entry
+	s.records++
+}
+
+// reads a state file. A missing file is an empty state.
+// encKey is only needed if the file was saved encrypted.
+func loadState(path string, encKey []byte) (*state, error) {
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return newState(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseState(raw, encKey)
+}
+
+func parseState(raw []byte, encKey []byte) (*state, error) {
+	var body []byte
+	switch {
+	case bytes.HasPrefix(raw, stateMagicEncrypted):
+		if len(encKey) == 0 {
+			return nil, ErrStateEncrypted
+		}
+		var err error
+		body, err = decrypt(raw[len(stateMagicEncrypted):], encKey)
+		if err != nil {
+			return nil, fmt.Errorf("unable to decrypt state file: %w", err)
+		}
+	case bytes.HasPrefix(raw, stateMagicPlain):
+		body = raw[len(stateMagicPlain):]
+	default:
+		return nil, ErrStateFormat
+	}
+	s := newState()
+	if err := json.Unmarshal(body, s); err != nil {
+		return nil, err
+	}
+	if s.Objects == nil {
+		s.Objects = make(map[string]stateEntry)
+	}
+	return s, nil
+}
+
+// writes the state file, encrypted if encKey is given.
+// the file is written next to path and renamed so a crash never leaves half a state file behind.
+func saveState(path string, s *state, encKey []byte) error {
+	raw, err := formatState(s, encKey)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func formatState(s *state, encKey []byte) ([]byte, error) {
+	body, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(encKey) == 0 {
+		return append(append([]byte{}, stateMagicPlain...), body...), nil
+	}
+	// nothing compares state files, so their nonces can be random.
+	cypherText, err := encryptRandom(body, encKey)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte{}, stateMagicEncrypted...), cypherText...), nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"gocloud.dev/blob"
+)
+
+func testState() *state {
+	s := newState()
+	s.record("secret/file", stateEntry{DstKey: "secret/file", MD5: []byte("0123456789abcdef"), Size: 42})
+	return s
+}
+
+func TestStateRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state")
+	if err := saveState(path, testState(), nil); err != nil {
+		t.Fatal(err)
+	}
+	s, err := loadState(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.done("secret/file") {
+		t.Error("expected the recorded object to be loaded")
+	}
+}
+
+// an encrypted state file can only be read back with the key,
+// and doesn't contain the object names in plain text.
+func TestEncryptedStateRoundTrip(t *testing.T) {
+	encKey := testAuthentication(t)
+	raw, err := formatState(testState(), encKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(raw, []byte("secret")) {
+		t.Fatal("encrypted state exposes object names")
+	}
+
+	if _, err := parseState(raw, nil); !errors.Is(err, ErrStateEncrypted) {
+		t.Errorf("expected ErrStateEncrypted without a key, got %v", err)
+	}
+	if _, err := parseState(raw, testAuthentication(t)); err == nil {
+		t.Error("expected an error loading with the wrong key")
+	}
+	s, err := parseState(raw, encKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry := s.Objects["secret/file"]; entry.Size != 42 {
+		t.Errorf("unexpected entry after round trip: %+v", entry)
+	}
+}
+
+func TestStateFormat(t *testing.T) {
+	if _, err := parseState([]byte(`{"objects":{}}`), nil); !errors.Is(err, ErrStateFormat) {
+		t.Errorf("expected ErrStateFormat, got %v", err)
+	}
+}
+
+// LastKey follows the listing while everything succeeds, stops moving at the first failure,
+// and is cleared once the listing is done.
+func TestStateLastKey(t *testing.T) {
+	ctx := context.Background()
+	sbkt, dbkt := testMemBuckets(t)
+	for _, key := range []string{"b", "c", "d"} {
+		if err := sbkt.WriteAll(ctx, key, []byte(key), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	errs := make(chan error)
+	go func() {
+		for err := range errs {
+			t.Error(err)
+		}
+	}()
+	st := newState()
+	mirror(ctx, sbkt, dbkt, nil, mirrorOpts{state: st, limit: 2}, errs)
+	if st.LastKey != "c" {
+		t.Errorf("expected LastKey c, got %q", st.LastKey)
+	}
+	mirror(ctx, sbkt, dbkt, nil, mirrorOpts{state: st}, errs)
+	if st.LastKey != "" {
+		t.Errorf("expected no LastKey after the whole listing, got %q", st.LastKey)
+	}
+
+	// a complete run doesn't keep the next one from finding a key before its last one.
+	if err := sbkt.WriteAll(ctx, "a", []byte("new"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if n := mirror(ctx, sbkt, dbkt, nil, mirrorOpts{state: st}, errs); n != 1 || testReadString(t, dbkt, "a") != "new" {
+		t.Errorf("expected the new key copied, got %d copied", n)
+	}
+
+	// a local source where "b" fails, because it's a symlink.
+	dir := t.TempDir()
+	for _, key := range []string{"a", "c"} {
+		if err := os.WriteFile(filepath.Join(dir, key), []byte(key), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(dir, "a"), filepath.Join(dir, "b")); err != nil {
+		t.Fatal(err)
+	}
+	lbkt, err := blob.OpenBucket(ctx, "file://"+dir+"?metadata=skip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lbkt.Close()
+	failures := make(chan error)
+	go func() {
+		for range failures {
+		}
+	}()
+	st = newState()
+	mirror(ctx, lbkt, dbkt, nil, mirrorOpts{state: st, symlinks: symlinksError}, failures)
+	if st.LastKey != "a" {
+		t.Errorf("expected LastKey to stop before the failed key, got %q", st.LastKey)
+	}
+}
+
+func TestStartAfter(t *testing.T) {
+	applied := false
+	opts := startAfter("photos/2020", &applied)
+	q := &storage.Query{}
+	err := opts.BeforeList(func(i interface{}) bool {
+		p, ok := i.(**storage.Query)
+		if !ok {
+			return false
+		}
+		*p = q
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !applied || q.StartOffset != "photos/2020\x00" {
+		t.Errorf("expected start offset after the key, got %q", q.StartOffset)
+	}
+
+	applied = false
+	if err := opts.BeforeList(func(interface{}) bool { return false }); err != nil {
+		t.Fatal(err)
+	}
+	if applied {
+		t.Error("start after should not be applied to a backend that doesn't support it")
+	}
+}
+
+// with the state of the last run, an unchanged encrypted object is skipped
+// without reading it into the temporary bucket to compare md5s.
+func TestStateUnchanged(t *testing.T) {
+	ctx := context.Background()
+	reads := 0
+	fb := &faultBucket{read: func(string) error { reads++; return nil }}
+	sbkt := testFaultBucket(t, fb)
+	tmpBkt, dbkt := testMemBuckets(t)
+	if err := sbkt.WriteAll(ctx, "a", testRandomData(t), nil); err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error)
+	go func() {
+		for err := range errs {
+			t.Error(err)
+		}
+	}()
+	st := newState()
+	opts := mirrorOpts{state: st, verifymd5: true, bytesEncrypt: testAuthentication(t)}
+	if n := mirror(ctx, sbkt, dbkt, tmpBkt, opts, errs); n != 1 {
+		t.Fatalf("copied %d objects", n)
+	}
+	if entry := st.Objects["a"]; len(entry.MD5) == 0 || len(entry.SrcMD5) == 0 {
+		t.Fatalf("expected both md5s in the state, got %+v", entry)
+	}
+
+	reads = 0
+	if n := mirror(ctx, sbkt, dbkt, tmpBkt, opts, errs); n != 0 {
+		t.Errorf("copied %d unchanged objects", n)
+	}
+	if reads != 0 {
+		t.Errorf("read the unchanged source %d times", reads)
+	}
+
+	if err := sbkt.WriteAll(ctx, "a", testRandomData(t), nil); err != nil {
+		t.Fatal(err)
+	}
+	if n := mirror(ctx, sbkt, dbkt, tmpBkt, opts, errs); n != 1 {
+		t.Errorf("a changed object should be copied again, copied %d", n)
+	}
+}
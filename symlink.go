@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"gocloud.dev/blob"
+)
+
+const (
+	symlinksFollow = "follow"
+	symlinksSkip   = "skip"
+	symlinksError  = "error"
+)
+
+var ErrSymlink = errors.New("symlinks are not allowed")
+
+func validSymlinks(mode string) error {
+	switch mode {
+	case symlinksFollow, symlinksSkip, symlinksError:
+		return nil
+	}
+	return fmt.Errorf("unknown symlinks mode %q. use follow, skip or error", mode)
+}
+
+// reports whether a listed object is a symlink.
+// fileblob walks the directory without following links, so the
+// os.FileInfo it exposes through As is the lstat of the entry.
+// other drivers don't expose os.FileInfo and are never symlinks.
+func isSymlink(obj *blob.ListObject) bool {
+	var fi os.FileInfo
+	if !obj.As(&fi) {
+		return false
+	}
+	return fi.Mode()&os.ModeSymlink != 0
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"gocloud.dev/blob"
+)
+
+// what to do when a key changed on both sides since the last sync.
+const (
+	conflictNewer  = "newer"
+	conflictSource = "source"
+	conflictDest   = "dest"
+	conflictSkip   = "skip"
+)
+
+var ErrConflict = errors.New("changed on both sides")
+
+func validConflict(policy string) error {
+	switch policy {
+	case conflictNewer, conflictSource, conflictDest, conflictSkip:
+		return nil
+	}
+	return fmt.Errorf("unknown conflict policy %q. use newer, source, dest or skip", policy)
+}
+
+type syncResult struct {
+	toDst     int
+	toSrc     int
+	conflicts int
+}
+
+// copies source-only keys to dst and dst-only keys to src.
+// keys on both sides with different content go in the direction of the side
+// that changed since the last sync, according to the state. Without a state entry
+// we can't tell which side changed, so the newer ModTime wins.
+// keys that changed on both sides are conflicts, and are resolved by the policy.
+// there is no encryption here, keys and content are the same on both sides.
+// copied objects in either direction are sent to progress, which may be nil.
+func syncBuckets(ctx context.Context, sbkt, dbkt Bucket, st *state, policy string, errs chan error, progress chan progressEvent) syncResult {
+	var res syncResult
+	sobjs, err := listAll(ctx, sbkt)
+	if err != nil {
+		errs <- fmt.Errorf("error listing source: %w", err)
+		return res
+	}
+	dobjs, err := listAll(ctx, dbkt)
+	if err != nil {
+		errs <- fmt.Errorf("error listing destination: %w", err)
+		return res
+	}
+
+	copyTo := func(from, to Bucket, obj *blob.ListObject, toDst bool) {
+		direction := "destination"
+		if !toDst {
+			direction = "source"
+		}
+		logCopied(res.toDst+res.toSrc+1, "syncing %s to %s\n", obj.Key, direction)
+		n, _, _, err := copyObj(ctx, from, to, obj.Key, nil, nil, nil)
+		if err != nil {
+			errs <- withKeys(fmt.Errorf("error syncing %s (to %s): %w", obj.Key, direction, err), obj.Key)
+			return
+		}
+		if progress != nil {
+			progress <- progressEvent{key: obj.Key, bytes: int64(n), srcBytes: int64(n)}
+		}
+		if toDst {
+			res.toDst++
+		} else {
+			res.toSrc++
+		}
+		if st != nil {
+			st.record(obj.Key, stateEntry{DstKey: obj.Key, MD5: obj.MD5, Size: obj.Size})
+		}
+	}
+
+	for key, sobj := range sobjs {
+		dobj, ok := dobjs[key]
+		if !ok {
+			copyTo(sbkt, dbkt, sobj, true)
+			continue
+		}
+		if sameContent(sobj, dobj) {
+			if st != nil {
+				st.record(key, stateEntry{DstKey: key, MD5: sobj.MD5, Size: sobj.Size})
+			}
+			continue
+		}
+		var base *stateEntry
+		if st != nil {
+			if entry, ok := st.Objects[key]; ok && len(entry.MD5) > 0 {
+				base = &entry
+			}
+		}
+		switch {
+		case base == nil:
+			if dobj.ModTime.After(sobj.ModTime) {
+				copyTo(dbkt, sbkt, dobj, false)
+			} else {
+				copyTo(sbkt, dbkt, sobj, true)
+			}
+		case string(dobj.MD5) == string(base.MD5):
+			copyTo(sbkt, dbkt, sobj, true)
+		case string(sobj.MD5) == string(base.MD5):
+			copyTo(dbkt, sbkt, dobj, false)
+		default:
+			res.conflicts++
+			errs <- withKeys(fmt.Errorf("%s: %w, resolving with policy %s", key, ErrConflict, policy), key)
+			switch policy {
+			case conflictSource:
+				copyTo(sbkt, dbkt, sobj, true)
+			case conflictDest:
+				copyTo(dbkt, sbkt, dobj, false)
+			case conflictNewer:
+				if dobj.ModTime.After(sobj.ModTime) {
+					copyTo(dbkt, sbkt, dobj, false)
+				} else {
+					copyTo(sbkt, dbkt, sobj, true)
+				}
+			}
+		}
+	}
+	for key, dobj := range dobjs {
+		if _, ok := sobjs[key]; !ok {
+			copyTo(dbkt, sbkt, dobj, false)
+		}
+	}
+	return res
+}
+
+// without an md5 on both sides only the size can tell. the modification times
+// can't: a copy is newer than the object it was copied from.
+func sameContent(a, b *blob.ListObject) bool {
+	if len(a.MD5) == 0 || len(b.MD5) == 0 {
+		return a.Size == b.Size
+	}
+	return string(a.MD5) == string(b.MD5)
+}
+
+func listAll(ctx context.Context, bkt Bucket) (map[string]*blob.ListObject, error) {
+	objs := make(map[string]*blob.ListObject)
+	iter := bkt.List(nil)
+	for {
+		obj, err := iter.Next(ctx)
+		if err == io.EOF {
+			return objs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		objs[obj.Key] = obj
+	}
+}
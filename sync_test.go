@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/driver"
+)
+
+// all four quadrants of the sync matrix: only in source, only in destination,
+// on both sides and the same, on both sides and different.
+func TestSyncQuadrants(t *testing.T) {
+	ctx := context.Background()
+	sbkt, dbkt := testMemBuckets(t)
+
+	writes := []struct {
+		bkt  *blob.Bucket
+		key  string
+		data string
+	}{
+		{sbkt, "src-only", "a"},
+		{dbkt, "dst-only", "b"},
+		{sbkt, "same", "c"},
+		{dbkt, "same", "c"},
+		// written in this order, the destination is newer.
+		{sbkt, "different", "old"},
+		{dbkt, "different", "new"},
+	}
+	for _, w := range writes {
+		if err := w.bkt.WriteAll(ctx, w.key, []byte(w.data), nil); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	errs := make(chan error)
+	go func() {
+		for err := range errs {
+			t.Error(err)
+		}
+	}()
+	res := syncBuckets(ctx, sbkt, dbkt, nil, conflictNewer, errs, nil)
+	if res.toDst != 1 || res.toSrc != 2 || res.conflicts != 0 {
+		t.Errorf("unexpected sync result %+v", res)
+	}
+	if got := testReadString(t, dbkt, "src-only"); got != "a" {
+		t.Errorf("source only key not copied to destination, got %q", got)
+	}
+	if got := testReadString(t, sbkt, "dst-only"); got != "b" {
+		t.Errorf("destination only key not copied to source, got %q", got)
+	}
+	if got := testReadString(t, sbkt, "different"); got != "new" {
+		t.Errorf("newer destination content not copied to source, got %q", got)
+	}
+
+	// without md5s, the copies are newer than their sources, but they're the same.
+	noMD5 := func(page *driver.ListPage) {
+		for _, obj := range page.Objects {
+			obj.MD5 = nil
+		}
+	}
+	sbkt = testFaultBucket(t, &faultBucket{bkt: sbkt, list: noMD5})
+	dbkt = testFaultBucket(t, &faultBucket{bkt: dbkt, list: noMD5})
+	if res := syncBuckets(ctx, sbkt, dbkt, nil, conflictNewer, errs, nil); res != (syncResult{}) {
+		t.Errorf("expected nothing to sync again, got %+v", res)
+	}
+}
+
+// with a state from the last sync, the side that changed wins,
+// and changes on both sides are conflicts.
+func TestSyncConflicts(t *testing.T) {
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		policy   string
+		src, dst string
+	}{
+		{conflictSource, "src", "src"},
+		{conflictDest, "dst", "dst"},
+		{conflictSkip, "src", "dst"},
+	} {
+		sbkt, dbkt := testMemBuckets(t)
+		for _, bkt := range []*blob.Bucket{sbkt, dbkt} {
+			if err := bkt.WriteAll(ctx, "key", []byte("base"), nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		errs := make(chan error)
+		go func() {
+			for err := range errs {
+				t.Error(err)
+			}
+		}()
+		st := newState()
+		syncBuckets(ctx, sbkt, dbkt, st, tc.policy, errs, nil)
+
+		if err := sbkt.WriteAll(ctx, "key", []byte("src"), nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := dbkt.WriteAll(ctx, "key", []byte("dst"), nil); err != nil {
+			t.Fatal(err)
+		}
+		conflicts := make(chan error)
+		go func() {
+			for err := range conflicts {
+				if !errors.Is(err, ErrConflict) {
+					t.Error(err)
+				}
+			}
+		}()
+		res := syncBuckets(ctx, sbkt, dbkt, st, tc.policy, conflicts, nil)
+		if res.conflicts != 1 {
+			t.Errorf("%s: expected a conflict, got %+v", tc.policy, res)
+		}
+		if got := testReadString(t, sbkt, "key"); got != tc.src {
+			t.Errorf("%s: expected source %q, got %q", tc.policy, tc.src, got)
+		}
+		if got := testReadString(t, dbkt, "key"); got != tc.dst {
+			t.Errorf("%s: expected destination %q, got %q", tc.policy, tc.dst, got)
+		}
+	}
+
+	// only one side changed since the last sync: no conflict.
+	sbkt, dbkt := testMemBuckets(t)
+	for _, bkt := range []*blob.Bucket{sbkt, dbkt} {
+		if err := bkt.WriteAll(ctx, "key", []byte("base"), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	errs := make(chan error)
+	go func() {
+		for err := range errs {
+			t.Error(err)
+		}
+	}()
+	st := newState()
+	syncBuckets(ctx, sbkt, dbkt, st, conflictSkip, errs, nil)
+	if err := sbkt.WriteAll(ctx, "key", []byte("changed"), nil); err != nil {
+		t.Fatal(err)
+	}
+	res := syncBuckets(ctx, sbkt, dbkt, st, conflictSkip, errs, nil)
+	if res.toDst != 1 || res.conflicts != 0 {
+		t.Errorf("unexpected sync result %+v", res)
+	}
+	if got := testReadString(t, dbkt, "key"); got != "changed" {
+		t.Errorf("expected source change in destination, got %q", got)
+	}
+}
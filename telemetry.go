@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.opencensus.io/stats/view"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/bridge/opencensus"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"gocloud.dev/blob"
+)
+
+// the name of blobcopy's tracer and meter.
+const telemetryName = "github.com/coryschwartz/blobcopy"
+
+// how long sending what's left at the end may take.
+const telemetryFlushTimeout = 10 * time.Second
+
+// with -otel-endpoint, traces and metrics go to an OTLP collector over http.
+// gocloud's own OpenCensus spans and blob metrics are bridged to it too, so the
+// backend calls show up under the objects they're for. without it, the otel
+// globals are no-ops, and so is all the instrumenting.
+// returns a func that flushes what's left and shuts the exporters down.
+func setupTelemetry(ctx context.Context, endpoint string) (func(context.Context) error, error) {
+	traceExporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, err
+	}
+	metricExporter, err := otlpmetrichttp.New(ctx, otlpmetrichttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, err
+	}
+	if err := view.Register(blob.OpenCensusViews...); err != nil {
+		return nil, err
+	}
+	res := resource.NewSchemaless(attribute.String("service.name", "blobcopy"))
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(traceExporter), sdktrace.WithResource(res))
+	reader := sdkmetric.NewPeriodicReader(metricExporter, sdkmetric.WithProducer(opencensus.NewMetricProducer()))
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader), sdkmetric.WithResource(res))
+	otel.SetTracerProvider(tp)
+	otel.SetMeterProvider(mp)
+	opencensus.InstallTraceBridge()
+	return func(ctx context.Context) error {
+		return errors.Join(tp.Shutdown(ctx), mp.Shutdown(ctx))
+	}, nil
+}
+
+// the spans and instruments of a run, from the otel globals.
+type telemetry struct {
+	tracer   trace.Tracer
+	copied   metric.Int64Counter
+	bytes    metric.Int64Counter
+	errors   metric.Int64Counter
+	duration metric.Float64Histogram
+}
+
+func newTelemetry() *telemetry {
+	meter := otel.Meter(telemetryName)
+	t := &telemetry{tracer: otel.Tracer(telemetryName)}
+	// an instrument that can't be made is a no-op, and otel reports why.
+	var err error
+	if t.copied, err = meter.Int64Counter("blobcopy.objects.copied", metric.WithDescription("objects copied")); err != nil {
+		otel.Handle(err)
+	}
+	if t.bytes, err = meter.Int64Counter("blobcopy.bytes.copied", metric.WithDescription("bytes written to destinations"), metric.WithUnit("By")); err != nil {
+		otel.Handle(err)
+	}
+	if t.errors, err = meter.Int64Counter("blobcopy.errors", metric.WithDescription("objects that failed")); err != nil {
+		otel.Handle(err)
+	}
+	if t.duration, err = meter.Float64Histogram("blobcopy.object.duration", metric.WithDescription("time spent on each object"), metric.WithUnit("s")); err != nil {
+		otel.Handle(err)
+	}
+	return t
+}
+
+// starts the span of one object. end ends it, and records how long it took.
+func (t *telemetry) startObject(ctx context.Context, key string) (context.Context, trace.Span, func()) {
+	ctx, span := t.tracer.Start(ctx, "blobcopy.object", trace.WithAttributes(attribute.String("blobcopy.key", key)))
+	start := time.Now()
+	end := func() {
+		t.duration.Record(ctx, time.Since(start).Seconds())
+		span.End()
+	}
+	return ctx, span, end
+}
+
+// marks a span as failed.
+func spanError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
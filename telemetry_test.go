@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	tracenoop "go.opentelemetry.io/otel/trace/noop"
+)
+
+// records spans in memory and metrics in a reader, until the test is done.
+func testTelemetry(t *testing.T) (*tracetest.InMemoryExporter, *sdkmetric.ManualReader) {
+	t.Helper()
+	spans := tracetest.NewInMemoryExporter()
+	reader := sdkmetric.NewManualReader()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(spans)))
+	otel.SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+	t.Cleanup(func() {
+		otel.SetTracerProvider(tracenoop.NewTracerProvider())
+		otel.SetMeterProvider(metricnoop.NewMeterProvider())
+	})
+	return spans, reader
+}
+
+func testAttr(attrs []attribute.KeyValue, key string) string {
+	for _, a := range attrs {
+		if string(a.Key) == key {
+			return a.Value.Emit()
+		}
+	}
+	return ""
+}
+
+// each object gets a span, with the copy under it, and failures are marked.
+func TestTelemetrySpans(t *testing.T) {
+	ctx := context.Background()
+	exporter, reader := testTelemetry(t)
+	sbkt, dbkt := testMemBuckets(t)
+	for _, key := range []string{"a", "b", "c"} {
+		if err := sbkt.WriteAll(ctx, key, []byte("content of "+key), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := dbkt.WriteAll(ctx, "b", []byte("content of b"), nil); err != nil {
+		t.Fatal(err)
+	}
+	src := testFaultBucket(t, &faultBucket{bkt: sbkt, read: func(key string) error {
+		if key == "c" {
+			return errInjected
+		}
+		return nil
+	}})
+	errs := make(chan error)
+	go func() {
+		for range errs {
+		}
+	}()
+	mirror(ctx, src, dbkt, nil, mirrorOpts{}, errs)
+	close(errs)
+
+	objects := make(map[string]sdktrace.ReadOnlySpan)
+	copies := 0
+	for _, s := range exporter.GetSpans().Snapshots() {
+		switch s.Name() {
+		case "blobcopy.object":
+			objects[testAttr(s.Attributes(), "blobcopy.key")] = s
+		case "blobcopy.copy":
+			copies++
+		}
+	}
+	if len(objects) != 3 {
+		t.Fatalf("expected a span for each object, got %v", objects)
+	}
+	if got := testAttr(objects["a"].Attributes(), "blobcopy.action"); got != actionCopied {
+		t.Errorf("a: expected copied, got %q", got)
+	}
+	if got := testAttr(objects["b"].Attributes(), "blobcopy.action"); got != actionSkipped {
+		t.Errorf("b: expected skipped, got %q", got)
+	}
+	if objects["c"].Status().Code != codes.Error {
+		t.Errorf("c: expected the span to be failed, got %v", objects["c"].Status())
+	}
+	// a copies, c fails to.
+	if copies != 2 {
+		t.Errorf("expected 2 copy spans, got %d", copies)
+	}
+	for _, s := range exporter.GetSpans().Snapshots() {
+		if s.Name() == "blobcopy.copy" && s.Parent().SpanID() != objects[testAttr(s.Attributes(), "blobcopy.key")].SpanContext().SpanID() {
+			t.Errorf("expected the copy of %s under its object's span", testAttr(s.Attributes(), "blobcopy.key"))
+		}
+	}
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(ctx, &rm); err != nil {
+		t.Fatal(err)
+	}
+	sums := make(map[string]int64)
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if sum, ok := m.Data.(metricdata.Sum[int64]); ok {
+				for _, dp := range sum.DataPoints {
+					sums[m.Name] += dp.Value
+				}
+			}
+		}
+	}
+	if sums["blobcopy.objects.copied"] != 1 || sums["blobcopy.bytes.copied"] != int64(len("content of a")) || sums["blobcopy.errors"] != 1 {
+		t.Errorf("unexpected metrics %v", sums)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/driver"
+	"gocloud.dev/gcerrors"
+)
+
+var errInjected = errors.New("injected fault")
+
+// an injected fault that go-cloud reports as gcerrors.Unimplemented.
+var errUnimplemented = errors.New("injected unimplemented")
+
+// a blob driver that passes everything through to another bucket,
+// with hooks that tests use to inject faults or odd behavior.
+type faultBucket struct {
+	bkt *blob.Bucket
+	// returns an error to fail an Attributes call.
+	attributes func(key string) error
+	// may change the attributes an Attributes call returns.
+	attrs func(key string, a *driver.Attributes)
+	// returns an error to fail a read.
+	read func(key string) error
+	// takes this many bytes off the end of a range read, like a source that stops early.
+	short func(key string) int64
+	// may change a page of list results.
+	list func(page *driver.ListPage)
+	// returns an error to fail a Copy.
+	copy func(dstKey, srcKey string) error
+}
+
+// a *blob.Bucket backed by a faultBucket over a new memory bucket.
+func testFaultBucket(t *testing.T, fb *faultBucket) *blob.Bucket {
+	t.Helper()
+	if fb.bkt == nil {
+		bkt, err := blob.OpenBucket(context.Background(), "mem://")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { bkt.Close() })
+		fb.bkt = bkt
+	}
+	bkt := blob.NewBucket(fb)
+	t.Cleanup(func() { bkt.Close() })
+	return bkt
+}
+
+func (fb *faultBucket) ErrorCode(err error) gcerrors.ErrorCode {
+	if errors.Is(err, errInjected) {
+		return gcerrors.Internal
+	}
+	if errors.Is(err, errUnimplemented) {
+		return gcerrors.Unimplemented
+	}
+	return gcerrors.Code(err)
+}
+
+func (fb *faultBucket) As(i interface{}) bool { return false }
+
+func (fb *faultBucket) ErrorAs(err error, i interface{}) bool { return false }
+
+func (fb *faultBucket) Attributes(ctx context.Context, key string) (*driver.Attributes, error) {
+	if fb.attributes != nil {
+		if err := fb.attributes(key); err != nil {
+			return nil, err
+		}
+	}
+	a, err := fb.bkt.Attributes(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	da := &driver.Attributes{
+		CacheControl:       a.CacheControl,
+		ContentDisposition: a.ContentDisposition,
+		ContentEncoding:    a.ContentEncoding,
+		ContentLanguage:    a.ContentLanguage,
+		ContentType:        a.ContentType,
+		Metadata:           a.Metadata,
+		CreateTime:         a.CreateTime,
+		ModTime:            a.ModTime,
+		Size:               a.Size,
+		MD5:                a.MD5,
+		ETag:               a.ETag,
+	}
+	if fb.attrs != nil {
+		fb.attrs(key, da)
+	}
+	return da, nil
+}
+
+func (fb *faultBucket) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driver.ListPage, error) {
+	token := opts.PageToken
+	if len(token) == 0 {
+		token = blob.FirstPageToken
+	}
+	pageSize := opts.PageSize
+	if pageSize == 0 {
+		pageSize = 1000
+	}
+	objs, next, err := fb.bkt.ListPage(ctx, token, pageSize, &blob.ListOptions{Prefix: opts.Prefix, Delimiter: opts.Delimiter})
+	if err != nil {
+		return nil, err
+	}
+	page := &driver.ListPage{NextPageToken: next}
+	for _, obj := range objs {
+		page.Objects = append(page.Objects, &driver.ListObject{
+			Key:     obj.Key,
+			ModTime: obj.ModTime,
+			Size:    obj.Size,
+			MD5:     obj.MD5,
+			IsDir:   obj.IsDir,
+		})
+	}
+	if fb.list != nil {
+		fb.list(page)
+	}
+	return page, nil
+}
+
+type faultReader struct {
+	*blob.Reader
+}
+
+func (r faultReader) Attributes() *driver.ReaderAttributes {
+	return &driver.ReaderAttributes{ContentType: r.ContentType(), ModTime: r.ModTime(), Size: r.Size()}
+}
+
+func (r faultReader) As(i interface{}) bool { return false }
+
+func (fb *faultBucket) NewRangeReader(ctx context.Context, key string, offset, length int64, opts *driver.ReaderOptions) (driver.Reader, error) {
+	if fb.read != nil {
+		if err := fb.read(key); err != nil {
+			return nil, err
+		}
+	}
+	if fb.short != nil && length > 0 {
+		length -= fb.short(key)
+	}
+	r, err := fb.bkt.NewRangeReader(ctx, key, offset, length, nil)
+	if err != nil {
+		return nil, err
+	}
+	return faultReader{r}, nil
+}
+
+func (fb *faultBucket) NewTypedWriter(ctx context.Context, key, contentType string, opts *driver.WriterOptions) (driver.Writer, error) {
+	return fb.bkt.NewWriter(ctx, key, &blob.WriterOptions{
+		CacheControl:       opts.CacheControl,
+		ContentDisposition: opts.ContentDisposition,
+		ContentEncoding:    opts.ContentEncoding,
+		ContentLanguage:    opts.ContentLanguage,
+		ContentType:        contentType,
+		Metadata:           opts.Metadata,
+	})
+}
+
+func (fb *faultBucket) Copy(ctx context.Context, dstKey, srcKey string, opts *driver.CopyOptions) error {
+	if fb.copy != nil {
+		if err := fb.copy(dstKey, srcKey); err != nil {
+			return err
+		}
+	}
+	return fb.bkt.Copy(ctx, dstKey, srcKey, nil)
+}
+
+func (fb *faultBucket) Delete(ctx context.Context, key string) error {
+	return fb.bkt.Delete(ctx, key)
+}
+
+func (fb *faultBucket) SignedURL(ctx context.Context, key string, opts *driver.SignedURLOptions) (string, error) {
+	return "", errors.New("signed urls are not supported")
+}
+
+func (fb *faultBucket) Close() error { return nil }
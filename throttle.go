@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// how fast object content is read from a bucket, and written to one, in bytes a second.
+// main fills these in from -download-rate for the source and -upload-rate for the
+// destinations. a bucket that isn't in them, like the temporary bucket, isn't limited.
+var (
+	readLimits  = map[Bucket]*rateLimiter{}
+	writeLimits = map[Bucket]*rateLimiter{}
+)
+
+// reads and writes wait for at most this many bytes at a time, so a big buffer
+// doesn't go out in one burst followed by a long pause.
+const rateChunk = 64 << 10
+
+// parses a rate in bytes a second. a K, M or G suffix multiplies by 1024, 1024² or 1024³,
+// so 10M is 10 MiB a second.
+func parseRate(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	mult := int64(1)
+	switch {
+	case strings.HasSuffix(s, "K"):
+		mult = 1 << 10
+	case strings.HasSuffix(s, "M"):
+		mult = 1 << 20
+	case strings.HasSuffix(s, "G"):
+		mult = 1 << 30
+	}
+	digits := s
+	if mult > 1 {
+		digits = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("bad rate %q", s)
+	}
+	return n * mult, nil
+}
+
+// spaces out bytes so they go at most rate a second, however many goroutines share it.
+type rateLimiter struct {
+	rate int64
+	mu   sync.Mutex
+	// when the next byte may go.
+	next time.Time
+}
+
+func newRateLimiter(rate int64) *rateLimiter {
+	return &rateLimiter{rate: rate}
+}
+
+// reserves n bytes, and waits until the ones before them have had their time.
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	at := l.next
+	l.next = l.next.Add(time.Duration(int64(n) * int64(time.Second) / l.rate))
+	l.mu.Unlock()
+	d := time.Until(at)
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+type limitedReader struct {
+	ctx context.Context
+	r   io.Reader
+	l   *rateLimiter
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	if len(p) > rateChunk {
+		p = p[:rateChunk]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if werr := r.l.wait(r.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+type limitedWriter struct {
+	ctx context.Context
+	w   io.Writer
+	l   *rateLimiter
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > rateChunk {
+			chunk = chunk[:rateChunk]
+		}
+		if err := w.l.wait(w.ctx, len(chunk)); err != nil {
+			return written, err
+		}
+		n, err := w.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[len(chunk):]
+	}
+	return written, nil
+}
+
+// r, limited to the read rate of bkt, if it has one.
+func limitReader(ctx context.Context, bkt Bucket, r io.Reader) io.Reader {
+	l := readLimits[bkt]
+	if l == nil {
+		return r
+	}
+	return &limitedReader{ctx: ctx, r: r, l: l}
+}
+
+// w, limited to the write rate of bkt, if it has one.
+func limitWriter(ctx context.Context, bkt Bucket, w io.Writer) io.Writer {
+	l := writeLimits[bkt]
+	if l == nil {
+		return w
+	}
+	return &limitedWriter{ctx: ctx, w: w, l: l}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParseRate(t *testing.T) {
+	for s, want := range map[string]int64{"": 0, "100": 100, "512K": 512 << 10, "10M": 10 << 20, "1G": 1 << 30} {
+		got, err := parseRate(s)
+		if err != nil || got != want {
+			t.Errorf("%q: expected %d, got %d %v", s, want, got, err)
+		}
+	}
+	for _, s := range []string{"fast", "0", "-5M", "M", "10MB"} {
+		if _, err := parseRate(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	ctx := context.Background()
+	// 4 chunks at 8 chunks a second: the first goes right away, the rest wait for the ones before.
+	l := newRateLimiter(8 * rateChunk)
+	var out bytes.Buffer
+	w := &limitedWriter{ctx: ctx, w: &out, l: l}
+	start := time.Now()
+	if _, err := w.Write(make([]byte, 4*rateChunk)); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 300*time.Millisecond {
+		t.Errorf("expected the write to take about 375ms, took %v", d)
+	}
+	if out.Len() != 4*rateChunk {
+		t.Errorf("expected all of it written, got %d bytes", out.Len())
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := l.wait(canceled, rateChunk); err == nil {
+		t.Error("expected waiting with a canceled context to fail")
+	}
+}
+
+// the download limit is only used reading the source and the upload limit only
+// writing the destination, with or without a temporary bucket in between.
+func TestRateLimitSides(t *testing.T) {
+	ctx := context.Background()
+	for _, withTmp := range []bool{false, true} {
+		sbkt, dbkt := testMemBuckets(t)
+		if err := sbkt.WriteAll(ctx, "file", testRandomData(t), nil); err != nil {
+			t.Fatal(err)
+		}
+		var opts mirrorOpts
+		var tmpBkt Bucket
+		if withTmp {
+			tmpBkt, _ = testMemBuckets(t)
+			opts.bytesEncrypt = testAuthentication(t)
+		}
+		const fast = 1 << 30
+		download, upload := newRateLimiter(fast), newRateLimiter(fast)
+		wrongRead, wrongWrite := newRateLimiter(fast), newRateLimiter(fast)
+		readLimits[sbkt], writeLimits[dbkt] = download, upload
+		readLimits[dbkt], writeLimits[sbkt] = wrongRead, wrongWrite
+		defer func() {
+			delete(readLimits, sbkt)
+			delete(readLimits, dbkt)
+			delete(writeLimits, sbkt)
+			delete(writeLimits, dbkt)
+		}()
+
+		errs := make(chan error)
+		go func() {
+			for err := range errs {
+				t.Error(err)
+			}
+		}()
+		if n := mirror(ctx, sbkt, dbkt, tmpBkt, opts, errs); n != 1 {
+			t.Fatalf("tmp %v: expected 1 object copied, got %d", withTmp, n)
+		}
+		if download.next.IsZero() || upload.next.IsZero() {
+			t.Errorf("tmp %v: expected the source read and the destination write to be limited", withTmp)
+		}
+		if !wrongRead.next.IsZero() || !wrongWrite.next.IsZero() {
+			t.Errorf("tmp %v: a limit was applied to the wrong side", withTmp)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	s3v2 "github.com/aws/aws-sdk-go-v2/service/s3"
+	s3v2types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+var ErrTierUnsupported = errors.New("storage tiers are only supported on S3 and GCS destinations")
+
+// the -tier names, and the storage class S3 and GCS call them.
+var (
+	s3Tiers = map[string]string{
+		"standard":     s3.StorageClassStandard,
+		"intelligent":  s3.StorageClassIntelligentTiering,
+		"infrequent":   s3.StorageClassStandardIa,
+		"onezone":      s3.StorageClassOnezoneIa,
+		"glacier-ir":   s3.StorageClassGlacierIr,
+		"glacier":      s3.StorageClassGlacier,
+		"deep-archive": s3.StorageClassDeepArchive,
+	}
+	// GCS has no per object intelligent tiering, autoclass is set on the bucket.
+	gcsTiers = map[string]string{
+		"standard": "STANDARD",
+		"nearline": "NEARLINE",
+		"coldline": "COLDLINE",
+		"archive":  "ARCHIVE",
+	}
+)
+
+// the tiers of the provider bkt is on, or nil if it doesn't have any.
+func bucketTiers(bkt Bucket) (string, map[string]string) {
+	var v1 *s3.S3
+	var v2 *s3v2.Client
+	var gcs *storage.Client
+	switch {
+	case bkt.As(&v1) || bkt.As(&v2):
+		return "S3", s3Tiers
+	case bkt.As(&gcs):
+		return "GCS", gcsTiers
+	}
+	return "", nil
+}
+
+// checks that objects written to bkt can be put in tier.
+func validTier(bkt Bucket, tier string) error {
+	provider, tiers := bucketTiers(bkt)
+	if tiers == nil {
+		return ErrTierUnsupported
+	}
+	return knownTier(provider, tiers, tier)
+}
+
+func knownTier(provider string, tiers map[string]string, tier string) error {
+	if _, ok := tiers[tier]; ok {
+		return nil
+	}
+	names := make([]string, 0, len(tiers))
+	for name := range tiers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return fmt.Errorf("unknown %s tier %q. use one of %s", provider, tier, strings.Join(names, ", "))
+}
+
+// a WriterOptions.BeforeWrite that writes the object in the storage class for tier,
+// rather than the bucket's default.
+func setTier(tier string) func(func(interface{}) bool) error {
+	return func(as func(interface{}) bool) error {
+		var v1 *s3manager.UploadInput
+		var v2 *s3v2.PutObjectInput
+		var gw *storage.Writer
+		switch {
+		case as(&v1):
+			if err := knownTier("S3", s3Tiers, tier); err != nil {
+				return err
+			}
+			v1.StorageClass = aws.String(s3Tiers[tier])
+		case as(&v2):
+			if err := knownTier("S3", s3Tiers, tier); err != nil {
+				return err
+			}
+			v2.StorageClass = s3v2types.StorageClass(s3Tiers[tier])
+		case as(&gw):
+			if err := knownTier("GCS", gcsTiers, tier); err != nil {
+				return err
+			}
+			gw.StorageClass = gcsTiers[tier]
+		default:
+			return ErrTierUnsupported
+		}
+		return nil
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	s3v2 "github.com/aws/aws-sdk-go-v2/service/s3"
+	s3v2types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/driver"
+)
+
+func TestSetTier(t *testing.T) {
+	v1 := &s3manager.UploadInput{}
+	if err := setTier("intelligent")(testAs(v1)); err != nil {
+		t.Fatal(err)
+	}
+	if aws.StringValue(v1.StorageClass) != "INTELLIGENT_TIERING" {
+		t.Errorf("unexpected v1 storage class %v", v1.StorageClass)
+	}
+	v2 := &s3v2.PutObjectInput{}
+	if err := setTier("deep-archive")(testAs(v2)); err != nil {
+		t.Fatal(err)
+	}
+	if v2.StorageClass != s3v2types.StorageClassDeepArchive {
+		t.Errorf("unexpected v2 storage class %v", v2.StorageClass)
+	}
+	gw := &storage.Writer{}
+	if err := setTier("coldline")(testAs(gw)); err != nil {
+		t.Fatal(err)
+	}
+	if gw.StorageClass != "COLDLINE" {
+		t.Errorf("unexpected GCS storage class %q", gw.StorageClass)
+	}
+
+	// each provider has its own tiers.
+	if err := setTier("coldline")(testAs(&s3manager.UploadInput{})); err == nil {
+		t.Error("expected coldline to be unknown on S3")
+	}
+	if err := setTier("intelligent")(testAs(&storage.Writer{})); err == nil {
+		t.Error("expected intelligent to be unknown on GCS")
+	}
+	if err := setTier("standard")(func(interface{}) bool { return false }); !errors.Is(err, ErrTierUnsupported) {
+		t.Errorf("expected ErrTierUnsupported, got %v", err)
+	}
+}
+
+// a bucket that looks like S3, and records the storage class each upload asked for.
+type tierBucket struct {
+	faultBucket
+	classes map[string]string
+}
+
+func (b *tierBucket) As(i interface{}) bool {
+	p, ok := i.(**s3.S3)
+	if ok {
+		*p = &s3.S3{}
+	}
+	return ok
+}
+
+func (b *tierBucket) NewTypedWriter(ctx context.Context, key, contentType string, opts *driver.WriterOptions) (driver.Writer, error) {
+	in := &s3manager.UploadInput{}
+	if opts.BeforeWrite != nil {
+		if err := opts.BeforeWrite(testAs(in)); err != nil {
+			return nil, err
+		}
+	}
+	b.classes[key] = aws.StringValue(in.StorageClass)
+	return b.faultBucket.NewTypedWriter(ctx, key, contentType, opts)
+}
+
+func TestTier(t *testing.T) {
+	ctx := context.Background()
+	sbkt, mem := testMemBuckets(t)
+	if err := sbkt.WriteAll(ctx, "file", []byte("data"), nil); err != nil {
+		t.Fatal(err)
+	}
+	tb := &tierBucket{faultBucket: faultBucket{bkt: mem}, classes: make(map[string]string)}
+	dbkt := blob.NewBucket(tb)
+	defer dbkt.Close()
+
+	if err := validTier(dbkt, "intelligent"); err != nil {
+		t.Fatal(err)
+	}
+	if err := validTier(dbkt, "nearline"); err == nil {
+		t.Error("expected nearline to be rejected for an S3 destination")
+	}
+	if err := validTier(mem, "standard"); !errors.Is(err, ErrTierUnsupported) {
+		t.Errorf("expected memory buckets not to have tiers, got %v", err)
+	}
+
+	errs := make(chan error)
+	go func() {
+		for err := range errs {
+			t.Error(err)
+		}
+	}()
+	if n := mirror(ctx, sbkt, dbkt, nil, mirrorOpts{tier: "intelligent"}, errs); n != 1 {
+		t.Fatalf("expected 1 object copied, got %d", n)
+	}
+	if class := tb.classes["file"]; class != "INTELLIGENT_TIERING" {
+		t.Errorf("expected the upload in INTELLIGENT_TIERING, got %q", class)
+	}
+}
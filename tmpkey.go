@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"gocloud.dev/blob"
+)
+
+// objects in the temporary bucket are kept under this prefix, in a directory per run.
+const tmpPrefix = "_blobcopy_tmp/"
+
+// tells apart runs in the same process that start at the same moment.
+var tmpRuns atomic.Int64
+
+// a name for one run's temporary objects. runs sharing a temporary bucket,
+// like the shards of a copy, each get their own.
+func newTmpNonce() string {
+	return fmt.Sprintf("%d-%d-%d", time.Now().UnixNano(), os.Getpid(), tmpRuns.Add(1))
+}
+
+// the key of a source object in the temporary bucket. it's the same for the
+// same key and nonce, different for any other source key or run, and never
+// too long, whatever the source key is.
+func tmpKey(nonce, key string) string {
+	sum := sha256.Sum256([]byte(nonce + "\x00" + key))
+	return tmpPrefix + nonce + "/" + hex.EncodeToString(sum[:16])
+}
+
+// deletes whatever a run left in the temporary bucket. returns how many objects that was.
+func cleanTmp(ctx context.Context, bkt Bucket, nonce string) (int, error) {
+	iter := bkt.List(&blob.ListOptions{Prefix: tmpPrefix + nonce + "/"})
+	n := 0
+	for {
+		obj, err := iter.Next(ctx)
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		if err := bkt.Delete(ctx, obj.Key); err != nil {
+			return n, err
+		}
+		n++
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"gocloud.dev/blob"
+)
+
+func TestTmpKey(t *testing.T) {
+	a, b := newTmpNonce(), newTmpNonce()
+	if a == b {
+		t.Fatal("expected every run to get its own nonce")
+	}
+	if tmpKey(a, "x") != tmpKey(a, "x") {
+		t.Error("expected the same key to get the same temporary key")
+	}
+	if tmpKey(a, "x") == tmpKey(a, "y") || tmpKey(a, "x") == tmpKey(b, "x") {
+		t.Error("expected other keys and runs to get other temporary keys")
+	}
+	if !strings.HasPrefix(tmpKey(a, strings.Repeat("long/", 500)), tmpPrefix+a+"/") || len(tmpKey(a, strings.Repeat("long/", 500))) > 100 {
+		t.Error("expected a short key under the run's prefix")
+	}
+}
+
+// runs that share a temporary bucket, like shards, have the same keys with
+// different content. each destination gets its own source's objects, and the
+// temporary bucket is empty once they're done.
+func TestTmpKeyParallel(t *testing.T) {
+	ctx := context.Background()
+	tmpBkt, _ := testMemBuckets(t)
+	const workers = 4
+	var srcs, dsts []*blob.Bucket
+	for w := 0; w < workers; w++ {
+		sbkt, dbkt := testMemBuckets(t)
+		for i := 0; i < 20; i++ {
+			if err := sbkt.WriteAll(ctx, fmt.Sprintf("file%d", i), []byte(fmt.Sprintf("worker %d file %d", w, i)), nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		srcs, dsts = append(srcs, sbkt), append(dsts, dbkt)
+	}
+	errs := make(chan error)
+	go func() {
+		for err := range errs {
+			t.Error(err)
+		}
+	}()
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			if n := mirror(ctx, srcs[w], dsts[w], tmpBkt, mirrorOpts{}, errs); n != 20 {
+				t.Errorf("worker %d: expected 20 objects copied, got %d", w, n)
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for w := 0; w < workers; w++ {
+		for i := 0; i < 20; i++ {
+			want := fmt.Sprintf("worker %d file %d", w, i)
+			if got := testReadString(t, dsts[w], fmt.Sprintf("file%d", i)); got != want {
+				t.Errorf("expected %q, got %q", want, got)
+			}
+		}
+	}
+	if empty, err := bucketEmpty(ctx, tmpBkt); err != nil || !empty {
+		t.Errorf("expected the temporary bucket to be empty, %v", err)
+	}
+}
+
+// what a run leaves behind is deleted at the end.
+func TestCleanTmp(t *testing.T) {
+	ctx := context.Background()
+	tmpBkt, _ := testMemBuckets(t)
+	nonce := newTmpNonce()
+	for _, key := range []string{tmpKey(nonce, "a"), tmpKey(nonce, "b"), tmpKey("another run", "a"), "unrelated"} {
+		if err := tmpBkt.WriteAll(ctx, key, []byte("x"), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	n, err := cleanTmp(ctx, tmpBkt, nonce)
+	if err != nil || n != 2 {
+		t.Fatalf("expected 2 objects deleted, got %d %v", n, err)
+	}
+	objs, err := listAll(ctx, tmpBkt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objs) != 2 {
+		t.Errorf("expected the other run's objects left alone, got %v", objs)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"io"
+)
+
+// a step applied to the content of an object on its way to the destination.
+type transform func([]byte) ([]byte, error)
+
+// the transforms to apply to content, in order. Decrypting comes before encrypting,
+// so decrypting with one key and encrypting with another re-encrypts.
+// a plain copy has no transforms at all.
+func contentTransforms(bytesEncrypt, bytesDecrypt []byte) []transform {
+	var ts []transform
+	if len(bytesDecrypt) != 0 {
+		ts = append(ts, func(text []byte) ([]byte, error) { return decrypt(text, bytesDecrypt) })
+	}
+	if len(bytesEncrypt) != 0 {
+		ts = append(ts, func(text []byte) ([]byte, error) { return encrypt(text, bytesEncrypt) })
+	}
+	return ts
+}
+
+func applyTransforms(text []byte, ts []transform) ([]byte, error) {
+	for _, t := range ts {
+		var err error
+		text, err = t(text)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return text, nil
+}
+
+// copies r to w through the transforms. Without any it's a plain io.Copy,
+// and nothing is buffered. Otherwise the whole content is read into memory:
+// the nonce is derived from the plaintext, and gcm authenticates the
+// ciphertext as a whole, so neither can be done a piece at a time.
+func transformCopy(w io.Writer, r io.Reader, ts []transform) (int64, error) {
+	if len(ts) == 0 {
+		return io.Copy(w, r)
+	}
+	text, err := io.ReadAll(r)
+	if err != nil {
+		return 0, err
+	}
+	text, err = applyTransforms(text, ts)
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(text)
+	return int64(n), err
+}
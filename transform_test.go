@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"testing"
+)
+
+func TestContentTransforms(t *testing.T) {
+	keyA := testAuthentication(t)
+	keyB := append([]byte{}, keyA...)
+	keyB[0] ^= 0xff
+
+	if ts := contentTransforms(nil, nil); len(ts) != 0 {
+		t.Errorf("expected no transforms for a plain copy, got %d", len(ts))
+	}
+	if ts := contentTransforms(keyA, nil); len(ts) != 1 {
+		t.Errorf("expected one transform to encrypt, got %d", len(ts))
+	}
+	if ts := contentTransforms(keyB, keyA); len(ts) != 2 {
+		t.Errorf("expected two transforms to re-encrypt, got %d", len(ts))
+	}
+
+	ctx := context.Background()
+	sbkt, dbkt := testMemBuckets(t)
+	text := testRandomData(t)
+	if err := sbkt.WriteAll(ctx, "plain", text, nil); err != nil {
+		t.Fatal(err)
+	}
+	// encrypt with A, re-encrypt A -> B, decrypt with B.
+	if _, _, err := copyObjTo(ctx, sbkt, dbkt, "plain", "a", keyA, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := copyObjTo(ctx, dbkt, dbkt, "a", "b", keyB, keyA, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := copyObjTo(ctx, dbkt, dbkt, "b", "decrypted", nil, keyB, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := testReadString(t, dbkt, "decrypted"); got != string(text) {
+		t.Error("re-encrypted object doesn't decrypt to the original")
+	}
+
+	// a transform that fails leaves nothing behind in the destination.
+	if _, _, err := copyObjTo(ctx, dbkt, dbkt, "b", "wrong-key", nil, keyA, nil); err == nil {
+		t.Error("expected an error decrypting with the wrong key")
+	}
+	if exists, err := dbkt.Exists(ctx, "wrong-key"); err != nil || exists {
+		t.Errorf("failed copy left an object behind, exists %v err %v", exists, err)
+	}
+}
+
+// the md5 copyObjTo returns is the md5 of what ended up in the destination.
+func TestCopyObjMD5(t *testing.T) {
+	ctx := context.Background()
+	sbkt, dbkt := testMemBuckets(t)
+	text := testRandomData(t)
+	if err := sbkt.WriteAll(ctx, "plain", text, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range [][]byte{nil, testAuthentication(t)} {
+		n, sum, err := copyObjTo(ctx, sbkt, dbkt, "plain", "copy", key, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		written, err := dbkt.ReadAll(ctx, "copy")
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := md5.Sum(written)
+		if !bytes.Equal(sum, want[:]) || n != len(written) {
+			t.Errorf("encrypted %v: got md5 %x size %d, want %x size %d", key != nil, sum, n, want, len(written))
+		}
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+)
+
+// reports whether a destination object has the same content as the source,
+// going by the md5s when both sides have one, and by size otherwise.
+func sameAttrs(sattrs, dattrs *blob.Attributes) bool {
+	if len(sattrs.MD5) == 0 || len(dattrs.MD5) == 0 {
+		return sattrs.Size == dattrs.Size
+	}
+	return string(sattrs.MD5) == string(dattrs.MD5)
+}
+
+const (
+	reasonMissing = "missing"
+	reasonExtra   = "only in destination"
+)
+
+// a source object that doesn't match its destination copy.
+type discrepancy struct {
+	Key    string `json:"key"`
+	DstKey string `json:"dst_key"`
+	Reason string `json:"reason"`
+}
+
+type verifyReport struct {
+	checked int
+	missing []discrepancy
+	differ  []discrepancy
+	// destination objects that aren't a copy of any source object.
+	// they don't make the report fail, the destination may hold other things.
+	extra    []discrepancy
+	problems int
+}
+
+func (r verifyReport) ok() bool {
+	return len(r.missing) == 0 && len(r.differ) == 0 && r.problems == 0
+}
+
+// how many objects are checked at once when opts.verifyWorkers isn't set.
+const defaultVerifyWorkers = 8
+
+// a progress line is logged every this many checked objects.
+const verifyProgressEvery = 1000
+
+// walks the source and checks that every object has an identical copy in the destination,
+// without copying anything. Destination keys and content are mapped through the
+// encryption keys the same way mirror maps them, so an encrypted backup can be audited
+// against its plain source, or a plain one against its encrypted source.
+// objects that can't be checked, e.g. because they can't be read, are sent to errs.
+// opts.verifyWorkers objects are read and compared at once. the report lists them in key order all the same.
+func verify(ctx context.Context, sbkt Bucket, dbkt Bucket, opts mirrorOpts, errs chan error) verifyReport {
+	var report verifyReport
+	// destination keys that belong to a source object.
+	dstKeys := map[string]bool{dedupeIndexKey: true}
+	if len(opts.nameEncrypt) != 0 {
+		if _, safetyKey, err := safetyName(opts.nameEncrypt); err == nil {
+			dstKeys[safetyKey] = true
+		}
+	}
+	workers := opts.verifyWorkers
+	if workers < 1 {
+		workers = defaultVerifyWorkers
+	}
+	// guards report and dstKeys.
+	var mu sync.Mutex
+	todo := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range todo {
+				var d *discrepancy
+				var dstKey string
+				var err error
+				runRecovered(func() {
+					d, dstKey, err = verifyObj(ctx, sbkt, dbkt, key, opts)
+				}, func(perr error) { err = perr })
+				// mirror skips it too.
+				if errors.Is(err, ErrNotEncryptedName) {
+					errLogger.Printf("warning: skipping %s: %v. -decrypt-passthrough checks objects like it as they are\n", key, err)
+					continue
+				}
+				if err != nil {
+					errs <- withKeys(fmt.Errorf("error verifying %s: %w", key, err), key, dstKey)
+				}
+				mu.Lock()
+				report.checked++
+				if dstKey != "" {
+					dstKeys[dstKey] = true
+				}
+				switch {
+				case err != nil:
+					report.problems++
+				case d == nil:
+					logCopied(report.checked, "%s ok\n", key)
+				case d.Reason == reasonMissing:
+					report.missing = append(report.missing, *d)
+				default:
+					report.differ = append(report.differ, *d)
+				}
+				if d != nil {
+					logf(logNormal, "%s [%s] %s\n", d.Key, d.DstKey, d.Reason)
+				}
+				if report.checked%verifyProgressEvery == 0 {
+					logf(logNormal, "verified %d objects, %d failed so far\n", report.checked, len(report.missing)+len(report.differ)+report.problems)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	iter := listObjects(sbkt, nil, opts.listRPS)
+	for {
+		obj, err := iter.Next(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			mu.Lock()
+			report.problems++
+			mu.Unlock()
+			errs <- fmt.Errorf("error iterating: %w", err)
+			continue
+		}
+		if !opts.filter.match(obj.Key) || !opts.shard.match(obj.Key) {
+			continue
+		}
+		todo <- obj.Key
+	}
+	close(todo)
+	wg.Wait()
+	byKey := func(ds []discrepancy) func(i, j int) bool {
+		return func(i, j int) bool { return ds[i].Key < ds[j].Key }
+	}
+	sort.Slice(report.missing, byKey(report.missing))
+	sort.Slice(report.differ, byKey(report.differ))
+
+	// the rest of the destination belongs to the other shards.
+	if opts.shard.count > 1 {
+		return report
+	}
+	dobjs, err := listAll(ctx, dbkt)
+	if err != nil {
+		report.problems++
+		errs <- fmt.Errorf("error listing destination: %w", err)
+		return report
+	}
+	keys := make([]string, 0, len(dobjs))
+	for key := range dobjs {
+		if !dstKeys[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		logf(logNormal, "[%s] %s\n", key, reasonExtra)
+		report.extra = append(report.extra, discrepancy{DstKey: key, Reason: reasonExtra})
+	}
+	return report
+}
+
+// compares one source object to its destination copy. nil means they match.
+// the destination key is returned too, once it's known.
+func verifyObj(ctx context.Context, sbkt, dbkt Bucket, key string, opts mirrorOpts) (*discrepancy, string, error) {
+	name, opts, err := opts.objectName(ctx, sbkt, key)
+	if err != nil {
+		return nil, "", err
+	}
+	dstKey, err := destName(name, opts)
+	if err != nil {
+		return nil, "", err
+	}
+	d, err := compareObj(ctx, sbkt, dbkt, key, dstKey, opts)
+	return d, dstKey, err
+}
+
+func compareObj(ctx context.Context, sbkt, dbkt Bucket, key, dstKey string, opts mirrorOpts) (*discrepancy, error) {
+	ts := opts.transforms()
+	// right after a copy, with -verify-after, the object may not be visible yet.
+	dattrs, err := opts.consistency.attributes(ctx, dbkt, dstKey)
+	if gcerrors.Code(err) == gcerrors.NotFound {
+		return &discrepancy{Key: key, DstKey: dstKey, Reason: reasonMissing}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if algo := opts.hashAlgo; algo != "" && algo != hashMD5 {
+		return compareSums(ctx, sbkt, dbkt, key, dstKey, dattrs, algo, opts)
+	}
+	if !opts.distrustMD5 {
+		preferRecordedMD5(dattrs)
+	}
+
+	var sattrs *blob.Attributes
+	switch {
+	case len(ts) == 0 && opts.distrustMD5:
+		sattrs, err = localAttrs(ctx, sbkt, key, nil)
+		if err != nil {
+			return nil, err
+		}
+	case len(ts) == 0:
+		sattrs, err = sbkt.Attributes(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		// the destination holds transformed bytes, so we need to know what the
+		// transformed source looks like. encryption and compression are deterministic,
+		// so it's exactly what mirror would have written.
+		text, err := readAll(ctx, sbkt, key)
+		if err != nil {
+			return nil, err
+		}
+		text, err = applyTransforms(text, ts)
+		if err != nil {
+			return nil, err
+		}
+		sum := md5.Sum(text)
+		sattrs = &blob.Attributes{MD5: sum[:], Size: int64(len(text))}
+	}
+
+	if sattrs.Size == dattrs.Size {
+		if sattrs, err = opts.multipartMD5(ctx, sbkt, key, sattrs, nil); err != nil {
+			return nil, err
+		}
+	}
+	if len(sattrs.MD5) > 0 && (len(dattrs.MD5) == 0 || opts.distrustMD5) {
+		// some backends don't keep md5s, or we don't trust them. read it to find out.
+		dattrs, err = localAttrs(ctx, dbkt, dstKey, opts.destReadOptions())
+		if err != nil {
+			return nil, err
+		}
+	}
+	if sattrs.Size != dattrs.Size {
+		return &discrepancy{Key: key, DstKey: dstKey, Reason: fmt.Sprintf("size differs: %d != %d", sattrs.Size, dattrs.Size)}, nil
+	}
+	if !sameAttrs(sattrs, dattrs) {
+		return &discrepancy{Key: key, DstKey: dstKey, Reason: "md5 differs"}, nil
+	}
+	return nil, nil
+}
+
+// like compareObj, going by algo sums rather than md5s. The ones the backends report are
+// used unless they aren't trusted, and the rest are read.
+func compareSums(ctx context.Context, sbkt, dbkt Bucket, key, dstKey string, dattrs *blob.Attributes, algo string, opts mirrorOpts) (*discrepancy, error) {
+	var ssum []byte
+	var size int64
+	if ts := opts.transforms(); len(ts) != 0 {
+		text, err := readAll(ctx, sbkt, key)
+		if err != nil {
+			return nil, err
+		}
+		text, err = applyTransforms(text, ts)
+		if err != nil {
+			return nil, err
+		}
+		h := newHash(algo)
+		h.Write(text)
+		ssum, size = h.Sum(nil), int64(len(text))
+	} else {
+		sattrs, err := sbkt.Attributes(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		size = sattrs.Size
+		// a size that differs says enough, without reading anything.
+		if size == dattrs.Size {
+			ssum, err = objectSum(ctx, sbkt, key, sattrs, algo, opts.distrustMD5)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if size != dattrs.Size {
+		return &discrepancy{Key: key, DstKey: dstKey, Reason: fmt.Sprintf("size differs: %d != %d", size, dattrs.Size)}, nil
+	}
+	dsum, err := objectSum(ctx, dbkt, dstKey, dattrs, algo, opts.distrustMD5)
+	if err != nil {
+		return nil, err
+	}
+	if string(ssum) != string(dsum) {
+		return &discrepancy{Key: key, DstKey: dstKey, Reason: algo + " differs"}, nil
+	}
+	return nil, nil
+}
+
+// reads an object to compute its md5 and size.
+func localAttrs(ctx context.Context, bkt Bucket, key string, ropts *blob.ReaderOptions) (*blob.Attributes, error) {
+	rdr, err := bkt.NewReader(ctx, key, ropts)
+	if err != nil {
+		return nil, err
+	}
+	defer rdr.Close()
+	h := md5.New()
+	n, err := io.Copy(h, rdr)
+	if err != nil {
+		return nil, err
+	}
+	return &blob.Attributes{MD5: h.Sum(nil), Size: n}, nil
+}
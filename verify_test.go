@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	ctx := context.Background()
+	sbkt, dbkt := testMemBuckets(t)
+	for _, key := range []string{"same", "changed", "missing"} {
+		if err := sbkt.WriteAll(ctx, key, testRandomData(t), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	errs := make(chan error)
+	go func() {
+		for err := range errs {
+			t.Error(err)
+		}
+	}()
+	mirror(ctx, sbkt, dbkt, nil, mirrorOpts{}, errs)
+	if report := verify(ctx, sbkt, dbkt, mirrorOpts{}, errs); !report.ok() || report.checked != 3 {
+		t.Fatalf("expected a clean report after mirroring, got %+v", report)
+	}
+
+	if err := dbkt.WriteAll(ctx, "changed", testRandomData(t), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := dbkt.Delete(ctx, "missing"); err != nil {
+		t.Fatal(err)
+	}
+	report := verify(ctx, sbkt, dbkt, mirrorOpts{}, errs)
+	if report.ok() {
+		t.Fatal("expected discrepancies")
+	}
+	if len(report.missing) != 1 || report.missing[0].Key != "missing" {
+		t.Errorf("unexpected missing objects %+v", report.missing)
+	}
+	if len(report.differ) != 1 || report.differ[0].Key != "changed" {
+		t.Errorf("unexpected differing objects %+v", report.differ)
+	}
+
+	if err := dbkt.WriteAll(ctx, "stray", []byte("x"), nil); err != nil {
+		t.Fatal(err)
+	}
+	report = verify(ctx, sbkt, dbkt, mirrorOpts{}, errs)
+	if len(report.extra) != 1 || report.extra[0].DstKey != "stray" {
+		t.Errorf("unexpected objects only in destination %+v", report.extra)
+	}
+}
+
+// an encrypted copy is verified against its plain source.
+func TestVerifyEncrypted(t *testing.T) {
+	ctx := context.Background()
+	sbkt, dbkt := testMemBuckets(t)
+	tmpBkt, _ := testMemBuckets(t)
+	encKey := testAuthentication(t)
+	if err := sbkt.WriteAll(ctx, "file", testRandomData(t), nil); err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error)
+	go func() {
+		for err := range errs {
+			t.Error(err)
+		}
+	}()
+	mirror(ctx, sbkt, dbkt, tmpBkt, mirrorOpts{bytesEncrypt: encKey, nameEncrypt: encKey}, errs)
+	if report := verify(ctx, sbkt, dbkt, mirrorOpts{bytesEncrypt: encKey, nameEncrypt: encKey}, errs); !report.ok() {
+		t.Errorf("expected the encrypted copy to verify, got %+v", report)
+	}
+	if report := verify(ctx, sbkt, dbkt, mirrorOpts{nameEncrypt: testAuthentication(t)}, errs); len(report.missing) != 1 {
+		t.Errorf("expected the object to be missing under a different key, got %+v", report)
+	}
+}
+
+// objects checked in parallel still end up in the report, in key order.
+func TestVerifyParallel(t *testing.T) {
+	ctx := context.Background()
+	sbkt, dbkt := testMemBuckets(t)
+	for i := 0; i < 50; i++ {
+		if err := sbkt.WriteAll(ctx, fmt.Sprintf("file%02d", i), testRandomData(t), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	errs := make(chan error)
+	go func() {
+		for err := range errs {
+			t.Error(err)
+		}
+	}()
+	mirror(ctx, sbkt, dbkt, nil, mirrorOpts{}, errs)
+	opts := mirrorOpts{verifyWorkers: 4}
+	if report := verify(ctx, sbkt, dbkt, opts, errs); !report.ok() || report.checked != 50 {
+		t.Fatalf("expected a clean report after mirroring, got %+v", report)
+	}
+
+	// corrupt a few copies without changing their size, and lose some others.
+	for _, key := range []string{"file31", "file07", "file19"} {
+		if err := dbkt.WriteAll(ctx, key, testRandomData(t), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, key := range []string{"file40", "file02"} {
+		if err := dbkt.Delete(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	report := verify(ctx, sbkt, dbkt, opts, errs)
+	if report.ok() || report.checked != 50 {
+		t.Fatalf("expected a failed report of 50 objects, got %+v", report)
+	}
+	var differ, missing []string
+	for _, d := range report.differ {
+		differ = append(differ, d.Key)
+	}
+	for _, d := range report.missing {
+		missing = append(missing, d.Key)
+	}
+	if got := strings.Join(differ, ","); got != "file07,file19,file31" {
+		t.Errorf("unexpected differing objects %s", got)
+	}
+	if got := strings.Join(missing, ","); got != "file02,file40" {
+		t.Errorf("unexpected missing objects %s", got)
+	}
+}
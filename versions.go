@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	awsv2 "github.com/aws/aws-sdk-go-v2/aws"
+	s3v2 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+	"google.golang.org/api/iterator"
+)
+
+var (
+	ErrVersionsUnsupported = errors.New("-all-versions needs an s3 or gcs source, other backends can't list versions")
+	ErrNotVersionKey       = errors.New("not the key of a version")
+)
+
+// with -all-versions, each version of a key is copied to key@version, where
+// version is the S3 version id or the GCS generation.
+const versionSep = "@"
+
+func versionKey(key, id string) string {
+	return key + versionSep + id
+}
+
+// the key and version of a versionKey. version ids have no @, keys might.
+func splitVersionKey(vkey string) (key, id string, ok bool) {
+	i := strings.LastIndex(vkey, versionSep)
+	if i < 0 || i == len(vkey)-len(versionSep) {
+		return "", "", false
+	}
+	return vkey[:i], vkey[i+len(versionSep):], true
+}
+
+// one version of an object, as a version listing has it.
+type objectVersion struct {
+	Key     string
+	ID      string
+	ModTime time.Time
+	Size    int64
+	// may be nil, like for S3 objects uploaded in parts.
+	MD5 []byte
+}
+
+// what a backend has to do for -all-versions. keys are the bucket's, without
+// the prefix= of the source url.
+type versionLister interface {
+	// a page of the versions under prefix, starting at token. "" is the first page,
+	// and next is "" after the last one. delete markers aren't versions.
+	listVersions(ctx context.Context, prefix, token string) (versions []objectVersion, next string, err error)
+	// reads length bytes of version id of key, or all of it with -1.
+	readVersion(ctx context.Context, key, id string, length int64) (*blob.Reader, error)
+}
+
+// the version lister of the backend of bkt, opened from bucketURL. The listing
+// is done with the backend's own client, over the same bucket and prefix.
+func newVersionLister(bkt *blob.Bucket, bucketURL string) (versionLister, error) {
+	name, prefix, err := urlBucket(bucketURL)
+	if err != nil {
+		return nil, err
+	}
+	base := versionBase{bkt: bkt, bucket: name, prefix: prefix}
+	var v1 *s3.S3
+	var v2 *s3v2.Client
+	var gcs *storage.Client
+	switch {
+	case bkt.As(&v1):
+		return &s3v1Versions{versionBase: base, client: v1}, nil
+	case bkt.As(&v2):
+		return &s3v2Versions{versionBase: base, client: v2}, nil
+	case bkt.As(&gcs):
+		return &gcsVersions{versionBase: base, client: gcs}, nil
+	}
+	return nil, ErrVersionsUnsupported
+}
+
+// what the listers have in common. reads go through the go-cloud bucket,
+// which knows about the prefix, and only pick the version.
+type versionBase struct {
+	bkt    *blob.Bucket
+	bucket string
+	prefix string
+}
+
+// the key in the source bucket of a key in the backend.
+func (b versionBase) bucketKey(key string) (string, bool) {
+	return strings.CutPrefix(key, b.prefix)
+}
+
+func (b versionBase) readVersion(ctx context.Context, key, id string, length int64) (*blob.Reader, error) {
+	opts := &blob.ReaderOptions{BeforeRead: func(as func(interface{}) bool) error {
+		var v1 *s3.GetObjectInput
+		var v2 *s3v2.GetObjectInput
+		var h **storage.ObjectHandle
+		switch {
+		case as(&v1):
+			v1.VersionId = aws.String(id)
+		case as(&v2):
+			v2.VersionId = awsv2.String(id)
+		case as(&h):
+			gen, err := strconv.ParseInt(id, 10, 64)
+			if err != nil {
+				return fmt.Errorf("bad generation %q: %w", id, err)
+			}
+			*h = (*h).Generation(gen)
+		}
+		return nil
+	}}
+	return b.bkt.NewRangeReader(ctx, key, 0, length, opts)
+}
+
+// S3 etags are the md5 of objects that weren't uploaded in parts.
+func etagMD5(etag string) []byte {
+	sum, err := hex.DecodeString(strings.Trim(etag, `"`))
+	if err != nil || len(sum) != 16 {
+		return nil
+	}
+	return sum
+}
+
+// S3 pages go by key and version id, which the token holds both of.
+func splitVersionToken(token string) (key, id string) {
+	key, id, _ = strings.Cut(token, "\x00")
+	return key, id
+}
+
+type s3v1Versions struct {
+	versionBase
+	client *s3.S3
+}
+
+func (l *s3v1Versions) listVersions(ctx context.Context, prefix, token string) ([]objectVersion, string, error) {
+	in := &s3.ListObjectVersionsInput{Bucket: aws.String(l.bucket), Prefix: aws.String(l.prefix + prefix), MaxKeys: aws.Int64(listPageSize)}
+	if token != "" {
+		key, id := splitVersionToken(token)
+		in.KeyMarker, in.VersionIdMarker = aws.String(key), aws.String(id)
+	}
+	out, err := l.client.ListObjectVersionsWithContext(ctx, in)
+	if err != nil {
+		return nil, "", err
+	}
+	var versions []objectVersion
+	for _, v := range out.Versions {
+		key, ok := l.bucketKey(aws.StringValue(v.Key))
+		if !ok {
+			continue
+		}
+		versions = append(versions, objectVersion{Key: key, ID: aws.StringValue(v.VersionId), ModTime: aws.TimeValue(v.LastModified), Size: aws.Int64Value(v.Size), MD5: etagMD5(aws.StringValue(v.ETag))})
+	}
+	next := ""
+	if aws.BoolValue(out.IsTruncated) {
+		next = aws.StringValue(out.NextKeyMarker) + "\x00" + aws.StringValue(out.NextVersionIdMarker)
+	}
+	return versions, next, nil
+}
+
+type s3v2Versions struct {
+	versionBase
+	client *s3v2.Client
+}
+
+func (l *s3v2Versions) listVersions(ctx context.Context, prefix, token string) ([]objectVersion, string, error) {
+	in := &s3v2.ListObjectVersionsInput{Bucket: awsv2.String(l.bucket), Prefix: awsv2.String(l.prefix + prefix), MaxKeys: listPageSize}
+	if token != "" {
+		key, id := splitVersionToken(token)
+		in.KeyMarker, in.VersionIdMarker = awsv2.String(key), awsv2.String(id)
+	}
+	out, err := l.client.ListObjectVersions(ctx, in)
+	if err != nil {
+		return nil, "", err
+	}
+	var versions []objectVersion
+	for _, v := range out.Versions {
+		key, ok := l.bucketKey(awsv2.ToString(v.Key))
+		if !ok {
+			continue
+		}
+		versions = append(versions, objectVersion{Key: key, ID: awsv2.ToString(v.VersionId), ModTime: awsv2.ToTime(v.LastModified), Size: v.Size, MD5: etagMD5(awsv2.ToString(v.ETag))})
+	}
+	next := ""
+	if out.IsTruncated {
+		next = awsv2.ToString(out.NextKeyMarker) + "\x00" + awsv2.ToString(out.NextVersionIdMarker)
+	}
+	return versions, next, nil
+}
+
+type gcsVersions struct {
+	versionBase
+	client *storage.Client
+}
+
+func (l *gcsVersions) listVersions(ctx context.Context, prefix, token string) ([]objectVersion, string, error) {
+	it := l.client.Bucket(l.bucket).Objects(ctx, &storage.Query{Prefix: l.prefix + prefix, Versions: true})
+	var attrs []*storage.ObjectAttrs
+	next, err := iterator.NewPager(it, listPageSize, token).NextPage(&attrs)
+	if err != nil {
+		return nil, "", err
+	}
+	var versions []objectVersion
+	for _, a := range attrs {
+		key, ok := l.bucketKey(a.Name)
+		if !ok {
+			continue
+		}
+		versions = append(versions, objectVersion{Key: key, ID: strconv.FormatInt(a.Generation, 10), ModTime: a.Updated, Size: a.Size, MD5: a.MD5})
+	}
+	return versions, next, nil
+}
+
+// a custom source with every version of every object of a bucket, under its versionKey.
+type versionSource struct {
+	lister versionLister
+	mu     sync.Mutex
+	// the md5s of the last page listed, which reads don't have.
+	md5s map[string][]byte
+}
+
+func openVersionSource(bkt *blob.Bucket, bucketURL string) (*blob.Bucket, error) {
+	lister, err := newVersionLister(bkt, bucketURL)
+	if err != nil {
+		return nil, err
+	}
+	return openCustomSource(&versionSource{lister: lister}), nil
+}
+
+// each page is in key order, but the versions of a key can be split over two.
+func (s *versionSource) List(ctx context.Context, prefix, token string) ([]customObject, string, error) {
+	versions, next, err := s.lister.listVersions(ctx, prefix, token)
+	if err != nil {
+		return nil, "", err
+	}
+	objs := make([]customObject, 0, len(versions))
+	md5s := make(map[string][]byte, len(versions))
+	for _, v := range versions {
+		vkey := versionKey(v.Key, v.ID)
+		objs = append(objs, customObject{Key: vkey, Size: v.Size, ModTime: v.ModTime, MD5: v.MD5})
+		md5s[vkey] = v.MD5
+	}
+	sort.Slice(objs, func(i, j int) bool { return objs[i].Key < objs[j].Key })
+	s.mu.Lock()
+	s.md5s = md5s
+	s.mu.Unlock()
+	return objs, next, nil
+}
+
+func (s *versionSource) open(ctx context.Context, vkey string, length int64) (*blob.Reader, error) {
+	key, id, ok := splitVersionKey(vkey)
+	if !ok {
+		return nil, fmt.Errorf("%s: %w: %w", vkey, ErrNotVersionKey, fs.ErrNotExist)
+	}
+	r, err := s.lister.readVersion(ctx, key, id, length)
+	if gcerrors.Code(err) == gcerrors.NotFound {
+		return nil, fmt.Errorf("%w: %w", err, fs.ErrNotExist)
+	}
+	return r, err
+}
+
+func (s *versionSource) Attributes(ctx context.Context, vkey string) (customObject, error) {
+	r, err := s.open(ctx, vkey, 0)
+	if err != nil {
+		return customObject{}, err
+	}
+	defer r.Close()
+	s.mu.Lock()
+	sum := s.md5s[vkey]
+	s.mu.Unlock()
+	return customObject{Key: vkey, Size: r.Size(), ModTime: r.ModTime(), ContentType: r.ContentType(), MD5: sum}, nil
+}
+
+func (s *versionSource) NewReader(ctx context.Context, vkey string) (io.ReadCloser, error) {
+	return s.open(ctx, vkey, -1)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"errors"
+	"strconv"
+	"testing"
+
+	"gocloud.dev/blob"
+)
+
+func TestSplitVersionKey(t *testing.T) {
+	for _, c := range []struct {
+		in, key, id string
+		ok          bool
+	}{
+		{"a@1", "a", "1", true},
+		{"dir/me@host@3HL4kqtJvjVBH40Nrjfkd", "dir/me@host", "3HL4kqtJvjVBH40Nrjfkd", true},
+		{"a", "", "", false},
+		{"a@", "", "", false},
+	} {
+		key, id, ok := splitVersionKey(c.in)
+		if key != c.key || id != c.id || ok != c.ok {
+			t.Errorf("%q: got %q %q %v", c.in, key, id, ok)
+		}
+	}
+	if key, id, _ := splitVersionKey(versionKey("x@y", "7")); key != "x@y" || id != "7" {
+		t.Errorf("round trip gave %q %q", key, id)
+	}
+}
+
+func TestEtagMD5(t *testing.T) {
+	sum := md5.Sum([]byte("hello"))
+	if got := etagMD5(`"5d41402abc4b2a76b9719d911017c592"`); string(got) != string(sum[:]) {
+		t.Errorf("got %x", got)
+	}
+	if got := etagMD5(`"d41d8cd98f00b204e9800998ecf8427e-2"`); got != nil {
+		t.Errorf("a multipart etag isn't an md5, got %x", got)
+	}
+}
+
+func TestVersionsUnsupported(t *testing.T) {
+	sbkt, _ := testMemBuckets(t)
+	if _, err := openVersionSource(sbkt, "mem://"); !errors.Is(err, ErrVersionsUnsupported) {
+		t.Errorf("expected ErrVersionsUnsupported, got %v", err)
+	}
+}
+
+// versions kept in a memory bucket under key#id, listed a page of pageSize at a time.
+type testVersions struct {
+	bkt      *blob.Bucket
+	versions []objectVersion
+	pageSize int
+}
+
+func (l *testVersions) add(t *testing.T, key, id string, text []byte) {
+	t.Helper()
+	if err := l.bkt.WriteAll(context.Background(), key+"#"+id, text, nil); err != nil {
+		t.Fatal(err)
+	}
+	sum := md5.Sum(text)
+	l.versions = append(l.versions, objectVersion{Key: key, ID: id, Size: int64(len(text)), MD5: sum[:]})
+}
+
+func (l *testVersions) listVersions(ctx context.Context, prefix, token string) ([]objectVersion, string, error) {
+	start := 0
+	if token != "" {
+		start, _ = strconv.Atoi(token)
+	}
+	end := start + l.pageSize
+	if end >= len(l.versions) {
+		return l.versions[start:], "", nil
+	}
+	return l.versions[start:end], strconv.Itoa(end), nil
+}
+
+func (l *testVersions) readVersion(ctx context.Context, key, id string, length int64) (*blob.Reader, error) {
+	return l.bkt.NewRangeReader(ctx, key+"#"+id, 0, length, nil)
+}
+
+func TestAllVersions(t *testing.T) {
+	ctx := context.Background()
+	mem, dbkt := testMemBuckets(t)
+	lister := &testVersions{bkt: mem, pageSize: 2}
+	texts := map[string][]byte{"a@1": []byte("first"), "a@2": []byte("second"), "b@9": []byte("only")}
+	lister.add(t, "a", "2", texts["a@2"])
+	lister.add(t, "a", "1", texts["a@1"])
+	lister.add(t, "b", "9", texts["b@9"])
+	sbkt := openCustomSource(&versionSource{lister: lister})
+	defer sbkt.Close()
+
+	errs := make(chan error)
+	go func() {
+		for err := range errs {
+			t.Error(err)
+		}
+	}()
+	if n := mirror(ctx, sbkt, dbkt, nil, mirrorOpts{}, errs); n != 3 {
+		t.Errorf("expected 3 versions copied, got %d", n)
+	}
+	for key, text := range texts {
+		if got := testReadString(t, dbkt, key); got != string(text) {
+			t.Errorf("%s: expected %q, got %q", key, text, got)
+		}
+	}
+	// the listed md5s match, so nothing is copied again.
+	if n := mirror(ctx, sbkt, dbkt, nil, mirrorOpts{}, errs); n != 0 {
+		t.Errorf("expected nothing copied again, got %d", n)
+	}
+	close(errs)
+
+	if _, err := sbkt.Attributes(ctx, "a"); err == nil {
+		t.Error("a key without a version shouldn't be found")
+	}
+	if _, err := sbkt.Attributes(ctx, "a@5"); err == nil {
+		t.Error("a version that isn't there shouldn't be found")
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// how events are sent to a -webhook.
+const (
+	// events waiting to be sent. once it's full, new ones are dropped rather than slowing down the copy.
+	webhookQueue = 10000
+	// events per POST.
+	webhookBatch = 100
+	// a partial batch is sent after this long.
+	webhookFlushEvery = time.Second
+	// a failed POST is tried this many more times, waiting twice as long each time.
+	webhookRetries    = 3
+	webhookRetryDelay = 500 * time.Millisecond
+	webhookTimeout    = 10 * time.Second
+)
+
+// the actions of webhook events.
+const (
+	actionCopied  = "copied"
+	actionSkipped = "skipped"
+	actionFailed  = "failed"
+)
+
+// what happened to one object. a POST has a json array of them.
+type webhookEvent struct {
+	Key    string    `json:"key"`
+	DstKey string    `json:"dst_key,omitempty"`
+	Size   int64     `json:"size"`
+	Action string    `json:"action"`
+	Time   time.Time `json:"timestamp"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// posts events to a url in batches, from its own goroutine, so a slow or
+// down endpoint never holds up copying. events that can't be delivered are
+// dropped and counted.
+type webhook struct {
+	url        string
+	client     *http.Client
+	batch      int
+	flushEvery time.Duration
+	retries    int
+	retryDelay time.Duration
+
+	events  chan webhookEvent
+	done    chan struct{}
+	sent    atomic.Int64
+	dropped atomic.Int64
+	// why the last batch that was dropped couldn't be sent. only set by run.
+	err error
+}
+
+func newWebhook(url string) *webhook {
+	w := &webhook{
+		url:        url,
+		client:     &http.Client{Timeout: webhookTimeout},
+		batch:      webhookBatch,
+		flushEvery: webhookFlushEvery,
+		retries:    webhookRetries,
+		retryDelay: webhookRetryDelay,
+		events:     make(chan webhookEvent, webhookQueue),
+		done:       make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// queues an event, or drops it if the queue is full.
+func (w *webhook) send(ev webhookEvent) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now().UTC()
+	}
+	select {
+	case w.events <- ev:
+	default:
+		w.dropped.Add(1)
+	}
+}
+
+func (w *webhook) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.flushEvery)
+	defer ticker.Stop()
+	var batch []webhookEvent
+	for {
+		select {
+		case ev, ok := <-w.events:
+			if !ok {
+				w.post(batch)
+				return
+			}
+			batch = append(batch, ev)
+			if len(batch) >= w.batch {
+				w.post(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			w.post(batch)
+			batch = nil
+		}
+	}
+}
+
+// a status that won't pass however often it's retried, like 400 or 404.
+type permanentError struct{ error }
+
+// sends a batch, retrying on errors and on statuses that may pass, like 503.
+// a permanentError isn't retried.
+func (w *webhook) post(batch []webhookEvent) {
+	if len(batch) == 0 {
+		return
+	}
+	body, err := json.Marshal(batch)
+	if err != nil {
+		w.err = err
+		w.dropped.Add(int64(len(batch)))
+		return
+	}
+	delay := w.retryDelay
+	for attempt := 0; ; attempt++ {
+		err = w.postOnce(body)
+		if err == nil {
+			w.sent.Add(int64(len(batch)))
+			return
+		}
+		var pe permanentError
+		if attempt == w.retries || errors.As(err, &pe) {
+			break
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+	w.err = err
+	w.dropped.Add(int64(len(batch)))
+}
+
+func (w *webhook) postOnce(body []byte) error {
+	resp, err := w.client.Post(w.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	err = fmt.Errorf("%s: %s", w.url, resp.Status)
+	// a timed out or rate limited request may pass later, other client errors won't.
+	if resp.StatusCode/100 == 4 && resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests {
+		return permanentError{err}
+	}
+	return err
+}
+
+// sends what's still queued, and says how many events were dropped, if any.
+// nothing can be sent after.
+func (w *webhook) close() error {
+	close(w.events)
+	<-w.done
+	dropped := w.dropped.Load()
+	if dropped == 0 {
+		return nil
+	}
+	if w.err == nil {
+		return fmt.Errorf("dropped %d of %d events, the queue was full", dropped, dropped+w.sent.Load())
+	}
+	return fmt.Errorf("dropped %d of %d events: %w", dropped, dropped+w.sent.Load(), w.err)
+}
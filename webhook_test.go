@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// a webhook like newWebhook's, but quick to flush and retry.
+func testWebhook(url string, queue int) *webhook {
+	w := &webhook{
+		url:        url,
+		client:     &http.Client{Timeout: time.Second},
+		batch:      2,
+		flushEvery: 10 * time.Millisecond,
+		retries:    2,
+		retryDelay: time.Millisecond,
+		events:     make(chan webhookEvent, queue),
+		done:       make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// collects the events posted to it. the first failN posts get a 503.
+type testEndpoint struct {
+	mu     sync.Mutex
+	failN  int
+	posts  int
+	events []webhookEvent
+}
+
+func (e *testEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.posts++
+	if e.posts <= e.failN {
+		http.Error(w, "busy", http.StatusServiceUnavailable)
+		return
+	}
+	var batch []webhookEvent
+	if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	e.events = append(e.events, batch...)
+}
+
+func TestWebhookEvents(t *testing.T) {
+	ctx := context.Background()
+	endpoint := &testEndpoint{failN: 1}
+	srv := httptest.NewServer(endpoint)
+	defer srv.Close()
+
+	sbkt, dbkt := testMemBuckets(t)
+	for _, key := range []string{"a", "b", "c"} {
+		if err := sbkt.WriteAll(ctx, key, []byte("content of "+key), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := dbkt.WriteAll(ctx, "b", []byte("content of b"), nil); err != nil {
+		t.Fatal(err)
+	}
+	fb := &faultBucket{bkt: sbkt, read: func(key string) error {
+		if key == "c" {
+			return errInjected
+		}
+		return nil
+	}}
+	src := testFaultBucket(t, fb)
+
+	wh := testWebhook(srv.URL, 100)
+	errs := make(chan error)
+	go func() {
+		for range errs {
+		}
+	}()
+	if n := mirror(ctx, src, dbkt, nil, mirrorOpts{verifymd5: true, webhook: wh}, errs); n != 1 {
+		t.Fatalf("expected 1 object copied, got %d", n)
+	}
+	close(errs)
+	if err := wh.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the batch that got a 503 was sent again.
+	actions := make(map[string]string)
+	for _, ev := range endpoint.events {
+		actions[ev.Key] = ev.Action
+		if ev.Time.IsZero() {
+			t.Errorf("%s: expected a timestamp", ev.Key)
+		}
+		if ev.Action == actionFailed && !strings.Contains(ev.Error, errInjected.Error()) {
+			t.Errorf("%s: unexpected error %q", ev.Key, ev.Error)
+		}
+	}
+	expected := map[string]string{"a": actionCopied, "b": actionSkipped, "c": actionFailed}
+	if len(endpoint.events) != 3 || len(actions) != 3 {
+		t.Fatalf("expected 3 events, got %+v", endpoint.events)
+	}
+	for key, action := range expected {
+		if actions[key] != action {
+			t.Errorf("%s: expected %s, got %s", key, action, actions[key])
+		}
+	}
+}
+
+// an endpoint that's down doesn't hold up sending, and the events are counted as dropped.
+func TestWebhookDown(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	url := srv.URL
+	srv.Close()
+
+	wh := testWebhook(url, 1)
+	start := time.Now()
+	for i := 0; i < 50; i++ {
+		wh.send(webhookEvent{Key: "k", Action: actionCopied})
+	}
+	if d := time.Since(start); d > 100*time.Millisecond {
+		t.Errorf("expected sending not to wait for the endpoint, took %v", d)
+	}
+	err := wh.close()
+	if err == nil || !strings.Contains(err.Error(), "dropped 50 of 50 events") {
+		t.Errorf("expected every event dropped, got %v", err)
+	}
+}
+
+// a client error isn't retried, it'd only fail again.
+func TestWebhookRejected(t *testing.T) {
+	var mu sync.Mutex
+	posts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		posts++
+		mu.Unlock()
+		http.Error(w, "bad request", http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	wh := testWebhook(srv.URL, 10)
+	wh.send(webhookEvent{Key: "k", Action: actionCopied})
+	err := wh.close()
+	if err == nil || !strings.Contains(err.Error(), "400") {
+		t.Errorf("expected the event dropped with a 400, got %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if posts != 1 {
+		t.Errorf("expected 1 request, got %d", posts)
+	}
+}